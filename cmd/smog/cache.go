@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// cacheDir is where run keeps the compiled bytecode of .smog files, so an
+// unchanged file runs without being parsed and compiled again. It is
+// $SMOG_CACHE_DIR if set, or smog under the user's cache directory (such
+// as ~/.cache/smog). Empty turns the cache off (set by run --no-cache).
+var cacheDir = defaultCacheDir()
+
+// defaultCacheDir returns the cache directory to use when run isn't told
+// otherwise, or "" if there is nowhere to put one.
+func defaultCacheDir() string {
+	if dir, ok := os.LookupEnv("SMOG_CACHE_DIR"); ok {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "smog")
+}
+
+// The cache is pruned each time an entry is written: entries unused for
+// maxCacheAge are removed, and then the least recently used ones beyond
+// maxCacheEntries. Every edit of a file makes a new entry, so without
+// this the cache would grow for as long as smog is used.
+var (
+	maxCacheEntries = 500
+	maxCacheAge     = 30 * 24 * time.Hour
+)
+
+// cacheTouchAge is how old an entry's modification time may get before a
+// cache hit refreshes it. The time records when the entry was last used,
+// so pruning drops the least recently used entries, but a hit doesn't
+// rewrite it more than once a day.
+const cacheTouchAge = 24 * time.Hour
+
+// cachePath returns the file the bytecode for source is cached in.
+//
+// The name is a hash of the source, so editing the file makes a new
+// entry rather than reusing a stale one. The hash also covers the smog
+// version, the bytecode format version and the size and modification
+// time of the smog executable, so rebuilding smog with a changed
+// compiler doesn't reuse bytecode the old compiler made.
+func cachePath(dir string, source []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "smog %s\x00", version)
+	binary.Write(h, binary.LittleEndian, bytecode.FormatVersion)
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			fmt.Fprintf(h, "%d %d\x00", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	h.Write(source)
+	return filepath.Join(dir, fmt.Sprintf("%x.sg", h.Sum(nil)))
+}
+
+// cachedBytecode returns the bytecode cached in dir for source, if there
+// is any that can still be read.
+func cachedBytecode(dir string, source []byte) (*bytecode.Bytecode, bool) {
+	if dir == "" {
+		return nil, false
+	}
+	path := cachePath(dir, source)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	bc, err := bytecode.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > cacheTouchAge {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	return bc, true
+}
+
+// cacheBytecode stores bc in dir as the compiled form of source. The
+// cache only saves time, so a failure to write it is ignored; the file
+// is written under a temporary name and renamed into place, so a run
+// reading it at the same moment never sees half of it.
+func cacheBytecode(dir string, source []byte, bc *bytecode.Bytecode) {
+	if dir == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := bytecode.Encode(bc, &buf); err != nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cachePath(dir, source))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	pruneCache(dir)
+}
+
+// pruneCache removes the entries in dir that haven't been used for
+// maxCacheAge, then the least recently used entries beyond
+// maxCacheEntries. Temporary files left by a run that died while writing
+// an entry are removed once they are as old as maxCacheAge too.
+func pruneCache(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		path    string
+		modTime time.Time
+	}
+	var entries []entry
+	for _, file := range files {
+		name := file.Name()
+		isEntry := strings.HasSuffix(name, ".sg")
+		if file.IsDir() || !isEntry && !strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if time.Since(info.ModTime()) > maxCacheAge {
+			os.Remove(path)
+		} else if isEntry {
+			entries = append(entries, entry{path, info.ModTime()})
+		}
+	}
+	if len(entries) <= maxCacheEntries {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+	for _, e := range entries[maxCacheEntries:] {
+		os.Remove(e.path)
+	}
+}
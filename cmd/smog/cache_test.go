@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/vm"
+)
+
+// runCompiled runs bc and returns the value it leaves on the stack.
+func runCompiled(t *testing.T, bc *bytecode.Bytecode) interface{} {
+	t.Helper()
+	v := vm.New()
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("Runtime error: %v", err)
+	}
+	return v.StackTop()
+}
+
+// compileCached compiles the file with the cache in dir, failing the test
+// on an error, and returns the bytecode and the warnings written.
+func compileCached(t *testing.T, dir, file string) (*bytecode.Bytecode, string) {
+	t.Helper()
+	saved := cacheDir
+	cacheDir = dir
+	defer func() { cacheDir = saved }()
+
+	var warnings strings.Builder
+	bc, err := compileSourceFile(file, &warnings)
+	if err != nil {
+		t.Fatalf("compileSourceFile failed: %v", err)
+	}
+	return bc, warnings.String()
+}
+
+func TestCompileCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "prog.smog")
+	source := []byte("| x | x := 6. x * 7")
+	if err := os.WriteFile(file, source, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+
+	// The first run compiles and saves the bytecode
+	bc, _ := compileCached(t, dir, file)
+	if got := runCompiled(t, bc); got != int64(42) {
+		t.Errorf("Expected 42, got %v", got)
+	}
+	cached := cachePath(dir, source)
+	info, err := os.Stat(cached)
+	if err != nil {
+		t.Fatalf("Expected the bytecode to be cached: %v", err)
+	}
+
+	// The second run reads the cached file without rewriting it
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(cached, past, past); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	bc, _ = compileCached(t, dir, file)
+	if got := runCompiled(t, bc); got != int64(42) {
+		t.Errorf("Expected 42 from the cache, got %v", got)
+	}
+	if info, err = os.Stat(cached); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("Expected the cached file not to be rewritten, got %v (%v)", info.ModTime(), err)
+	}
+
+	// What runs really is the cached bytecode: swap in another program's
+	other, _ := compileCached(t, "", writeSource(t, "'from the cache'"))
+	cacheBytecode(dir, source, other)
+	if bc, _ = compileCached(t, dir, file); runCompiled(t, bc) != "from the cache" {
+		t.Errorf("Expected the cached bytecode to be reused")
+	}
+
+	// Editing the source compiles it again, into a new entry
+	if err := os.WriteFile(file, []byte("| x | x := 6. x * 8"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	if bc, _ = compileCached(t, dir, file); runCompiled(t, bc) != int64(48) {
+		t.Errorf("Expected the edited source to be recompiled")
+	}
+	if _, err := os.Stat(cachePath(dir, []byte("| x | x := 6. x * 8"))); err != nil {
+		t.Errorf("Expected the edited source to be cached: %v", err)
+	}
+
+	// An unreadable cache file is ignored and replaced
+	if err := os.WriteFile(cached, []byte("not bytecode"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", cached, err)
+	}
+	if err := os.WriteFile(file, source, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	if bc, _ = compileCached(t, dir, file); runCompiled(t, bc) != int64(42) {
+		t.Errorf("Expected a corrupt cache entry to be recompiled")
+	}
+	if _, ok := cachedBytecode(dir, source); !ok {
+		t.Errorf("Expected the corrupt cache entry to be replaced")
+	}
+}
+
+func TestCompileCacheSkipsWarnings(t *testing.T) {
+	dir := t.TempDir()
+	source := "| count |\ncuont := 1.\ncount"
+	file := writeSource(t, source)
+
+	// A program with warnings is never cached, so every run reports them
+	for run := 1; run <= 2; run++ {
+		_, warnings := compileCached(t, dir, file)
+		if !strings.Contains(warnings, "assignment to undeclared variable 'cuont'") {
+			t.Errorf("Run %d: expected the warning, got %q", run, warnings)
+		}
+	}
+	if _, err := os.Stat(cachePath(dir, []byte(source))); !os.IsNotExist(err) {
+		t.Errorf("Expected no cache entry for a program with warnings, got %v", err)
+	}
+
+	// Nor is anything cached with the cache turned off
+	compileCached(t, "", writeSource(t, "3 + 4"))
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected an empty cache, got %d entries", len(entries))
+	}
+}
+
+func TestCompileCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	savedEntries, savedAge := maxCacheEntries, maxCacheAge
+	maxCacheEntries, maxCacheAge = 3, 30*24*time.Hour
+	defer func() { maxCacheEntries, maxCacheAge = savedEntries, savedAge }()
+
+	// Entries are made an hour apart, oldest first
+	sources := []string{"1", "2", "3", "4"}
+	for i, source := range sources[:3] {
+		compileCached(t, dir, writeSource(t, source))
+		when := time.Now().Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(cachePath(dir, []byte(source)), when, when); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	// Using an entry that hasn't been used for a day makes it the newest
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(cachePath(dir, []byte("1")), old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	compileCached(t, dir, writeSource(t, "1"))
+
+	// A fourth entry evicts the least recently used one, which is now 2
+	compileCached(t, dir, writeSource(t, "4"))
+	for _, source := range sources {
+		_, err := os.Stat(cachePath(dir, []byte(source)))
+		if cached := err == nil; cached != (source != "2") {
+			t.Errorf("Source %s: expected cached %v, got %v", source, source != "2", cached)
+		}
+	}
+
+	// Entries unused for maxCacheAge are removed on the next write, as are
+	// temporary files left behind by a run that died
+	stale := time.Now().Add(-maxCacheAge - time.Hour)
+	leftover := filepath.Join(dir, "123.tmp")
+	if err := os.WriteFile(leftover, []byte("partial"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", leftover, err)
+	}
+	for _, path := range []string{cachePath(dir, []byte("3")), leftover} {
+		if err := os.Chtimes(path, stale, stale); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+	compileCached(t, dir, writeSource(t, "5"))
+	for _, path := range []string{cachePath(dir, []byte("3")), leftover} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", filepath.Base(path), err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(entries))
+	}
+}
+
+// writeSource writes source to a new .smog file and returns its path.
+func writeSource(t *testing.T, source string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "source.smog")
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	return file
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/vm"
+)
+
+// pseudoVariables are the reserved names that can start any expression.
+var pseudoVariables = []string{"false", "nil", "self", "super", "true"}
+
+// replVariables returns the names that can start an expression in the REPL:
+// the variables and classes the session's compiler knows about, the
+// built-in classes, and the pseudo-variables.
+func replVariables(c *compiler.Compiler) []string {
+	names := append([]string{}, pseudoVariables...)
+	names = append(names, vm.BuiltinClassNames()...)
+	for _, symbol := range c.Symbols() {
+		names = append(names, symbol.Name)
+	}
+	return names
+}
+
+// completeREPL completes the word at the end of line.
+//
+// The text before the word decides what is being completed:
+//   - At the start of an expression (start of line, after a period,
+//     an opening bracket, an assignment, a keyword or a binary operator)
+//     the word is completed from variables.
+//   - After a receiver (an identifier, a literal, or a closing bracket)
+//     or a cascade semicolon it is completed from selectors. Keyword
+//     selectors complete one keyword at a time, so at:put: offers "at:".
+//
+// It returns the word being completed and the sorted, de-duplicated
+// candidates that start with it. Nothing is completed inside a string,
+// a comment, or a number.
+//
+// Example:
+//   completeREPL("coun", {"counter"}, nil)          -> "coun", ["counter"]
+//   completeREPL("3 padL", nil, {"padLeftTo:with:"}) -> "padL", ["padLeftTo:"]
+func completeREPL(line string, variables, selectors []string) (string, []string) {
+	if insideLiteral(line) {
+		return "", nil
+	}
+
+	start := len(line)
+	for start > 0 && isIdentChar(line[start-1]) {
+		start--
+	}
+	word := line[start:]
+	if word != "" && word[0] >= '0' && word[0] <= '9' {
+		return word, nil
+	}
+
+	var names []string
+	if completesSelector(strings.TrimRight(line[:start], " \t\n")) {
+		for _, selector := range selectors {
+			if selector == "" || !isIdentChar(selector[0]) {
+				continue // binary selectors aren't words
+			}
+			if i := strings.IndexByte(selector, ':'); i >= 0 {
+				selector = selector[:i+1]
+			}
+			names = append(names, selector)
+		}
+	} else {
+		names = variables
+	}
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return word, candidates
+}
+
+// completesSelector reports whether a word following before is a message
+// selector rather than the start of an expression.
+func completesSelector(before string) bool {
+	if before == "" {
+		return false
+	}
+	last := before[len(before)-1]
+	switch {
+	case last == ':':
+		// A keyword or := is followed by an argument expression
+		return false
+	case isIdentChar(last), last == ')', last == ']', last == '}', last == '\'', last == ';', last == '#':
+		return true
+	}
+	return false
+}
+
+// insideLiteral reports whether line ends inside an unterminated string
+// literal or comment.
+func insideLiteral(line string) bool {
+	var open byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case open != 0 && line[i] == open:
+			open = 0
+		case open == 0 && (line[i] == '\'' || line[i] == '"'):
+			open = line[i]
+		}
+	}
+	return open != 0
+}
+
+// isIdentChar reports whether b can appear in an identifier.
+func isIdentChar(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
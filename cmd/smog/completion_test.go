@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+func TestCompleteREPL(t *testing.T) {
+	variables := []string{"counter", "count", "total", "Bag", "self", "true"}
+	selectors := []string{"+", "at:", "at:put:", "padLeftTo:", "padLeftTo:with:", "println", "print", "size"}
+
+	tests := []struct {
+		line       string
+		word       string
+		candidates []string
+	}{
+		// Start of an expression completes variables
+		{"cou", "cou", []string{"count", "counter"}},
+		{"t", "t", []string{"total", "true"}},
+		{"x := cou", "cou", []string{"count", "counter"}},
+		{"3 + cou", "cou", []string{"count", "counter"}},
+		{"a at: cou", "cou", []string{"count", "counter"}},
+		{"x println. B", "B", []string{"Bag"}},
+		{"(cou", "cou", []string{"count", "counter"}},
+		{"[:x | cou", "cou", []string{"count", "counter"}},
+		{"^se", "se", []string{"self"}},
+		// After a receiver completes selectors, one keyword at a time
+		{"counter pr", "pr", []string{"print", "println"}},
+		{"3 padL", "padL", []string{"padLeftTo:"}},
+		{"#(1 2) a", "a", []string{"at:"}},
+		{"'abc' si", "si", []string{"size"}},
+		{"(3 + 4) pri", "pri", []string{"print", "println"}},
+		{"counter println; pr", "pr", []string{"print", "println"}},
+		{"counter ", "", []string{"at:", "padLeftTo:", "print", "println", "size"}},
+		// No match
+		{"zz", "zz", nil},
+		{"counter zz", "zz", nil},
+		// Nothing to complete in strings, comments or numbers
+		{"'cou", "", nil},
+		{"\"a comment cou", "", nil},
+		{"3 + 12", "12", nil},
+	}
+
+	for _, tt := range tests {
+		word, candidates := completeREPL(tt.line, variables, selectors)
+		if word != tt.word || !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("%q: expected %q %v, got %q %v", tt.line, tt.word, tt.candidates, word, candidates)
+		}
+	}
+}
+
+func TestCompleteREPLFromCompiler(t *testing.T) {
+	c := compiler.New()
+	for _, input := range []string{
+		"| counter |",
+		"Object subclass: #Account [ | balance | deposit: n [ balance := balance + n ] ]",
+		"total := 0.",
+	} {
+		program, err := parser.New(input).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", input, err)
+		}
+		if _, err := c.CompileIncremental(program); err != nil {
+			t.Fatalf("CompileIncremental failed for %q: %v", input, err)
+		}
+	}
+
+	tests := []struct {
+		line       string
+		candidates []string
+	}{
+		{"co", []string{"counter"}},
+		{"to", []string{"total"}},
+		{"A", []string{"Account", "Array"}},
+		{"Ba", []string{"Bag"}},
+		{"Account ne", []string{"negated", "new"}},
+		{"Account new dep", []string{"deposit:"}},
+	}
+	for _, tt := range tests {
+		_, candidates := completeREPL(tt.line, replVariables(c), c.Selectors())
+		if !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("%q: expected %v, got %v", tt.line, tt.candidates, candidates)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		words    []string
+		expected string
+	}{
+		{[]string{"println"}, "println"},
+		{[]string{"print", "println"}, "print"},
+		{[]string{"count", "counter", "total"}, ""},
+	}
+	for _, tt := range tests {
+		if prefix := commonPrefix(tt.words); prefix != tt.expected {
+			t.Errorf("%v: expected %q, got %q", tt.words, tt.expected, prefix)
+		}
+	}
+}
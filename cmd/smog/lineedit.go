@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineReader reads REPL input one line at a time.
+//
+// When stdin is a terminal the reader switches it out of canonical mode
+// while a line is being typed, so it sees each keystroke and can complete
+// the current word when Tab is pressed. The terminal is restored before
+// readLine returns, so programs run by the REPL (and the debugger) see a
+// normal terminal.
+//
+// When stdin is not a terminal, or the terminal can't be configured with
+// stty, the reader falls back to reading plain lines without completion.
+type lineReader struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+	complete func(line string) (word string, candidates []string)
+}
+
+// newLineReader creates a reader on stdin that completes with complete.
+func newLineReader(complete func(line string) (string, []string)) *lineReader {
+	r := &lineReader{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		complete: complete,
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		r.terminal = true
+	}
+	return r
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal, which
+// halt needs to start the debugger. Any character device but the null
+// device is taken to be one, so the check needs neither stty nor a
+// process of its own.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// readLine prints prompt and returns the next line without its newline.
+// It returns io.EOF at the end of input, or when Ctrl-D is typed on an
+// empty line or Ctrl-C is typed.
+func (r *lineReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if r.terminal {
+		saved, err := stty("-g")
+		if err == nil {
+			err = sttyRun("-icanon", "-echo", "-isig", "min", "1")
+		}
+		if err == nil {
+			defer sttyRun(strings.TrimSpace(saved))
+			return r.readEditedLine(prompt)
+		}
+		// Not a terminal stty understands; stop trying
+		r.terminal = false
+	}
+
+	line, err := r.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readEditedLine reads keystrokes until Enter, echoing them and handling
+// backspace, Ctrl-U, Ctrl-C, Ctrl-D and Tab completion.
+func (r *lineReader) readEditedLine(prompt string) (string, error) {
+	var line []byte
+	for {
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case b == '\n' || b == '\r':
+			fmt.Fprint(r.out, "\n")
+			return string(line), nil
+		case b == 3: // Ctrl-C
+			fmt.Fprint(r.out, "^C\n")
+			return "", io.EOF
+		case b == 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(r.out, "\n")
+				return "", io.EOF
+			}
+		case b == 127 || b == 8: // Backspace
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Fprint(r.out, "\b \b")
+			}
+		case b == 21: // Ctrl-U
+			fmt.Fprint(r.out, strings.Repeat("\b \b", utf8.RuneCount(line)))
+			line = line[:0]
+		case b == '\t':
+			line = r.completeLine(prompt, line)
+		case b == 27: // Escape sequence (arrow keys etc.), ignored
+			r.skipEscape()
+		case b < 32:
+			// Other control characters are ignored
+		default:
+			line = append(line, b)
+			r.out.Write([]byte{b})
+		}
+	}
+}
+
+// completeLine completes the word at the end of line. A unique candidate,
+// or a prefix shared by all candidates, is inserted; otherwise the
+// candidates are listed below the line and the line is redrawn.
+func (r *lineReader) completeLine(prompt string, line []byte) []byte {
+	word, candidates := r.complete(string(line))
+	if len(candidates) == 0 {
+		fmt.Fprint(r.out, "\a")
+		return line
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		suffix := prefix[len(word):]
+		fmt.Fprint(r.out, suffix)
+		return append(line, suffix...)
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(r.out, "\n%s\n%s%s", strings.Join(candidates, "  "), prompt, line)
+	}
+	return line
+}
+
+// skipEscape consumes the rest of an ANSI escape sequence such as the
+// ones sent by the arrow keys.
+func (r *lineReader) skipEscape() {
+	b, err := r.in.ReadByte()
+	if err != nil || b != '[' {
+		return
+	}
+	for {
+		b, err := r.in.ReadByte()
+		if err != nil || b >= 0x40 && b <= 0x7e {
+			return
+		}
+	}
+}
+
+// commonPrefix returns the longest prefix shared by all of words.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// stty runs stty on the terminal attached to stdin and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// sttyRun runs stty on stdin, discarding its output.
+func sttyRun(args ...string) error {
+	_, err := stty(args...)
+	return err
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/kristofer/smog/pkg/ast"
 	"github.com/kristofer/smog/pkg/bytecode"
 	"github.com/kristofer/smog/pkg/compiler"
 	"github.com/kristofer/smog/pkg/parser"
@@ -15,6 +17,25 @@ import (
 
 const version = "0.4.0"
 
+// warnSelectors enables compile-time warnings for messages sent to
+// literals that can't understand them (set by run --warn).
+var warnSelectors bool
+
+// strictMode turns every compile-time warning into a compile error (set
+// by run --strict).
+var strictMode bool
+
+// divisionMode selects what / answers for integers that don't divide
+// evenly (set by run --division=MODE).
+var divisionMode = vm.DivideTruncate
+
+// divisionModes maps --division flag values to VM division modes.
+var divisionModes = map[string]vm.DivisionMode{
+	"truncate": vm.DivideTruncate,
+	"exact":    vm.DivideExact,
+	"float":    vm.DivideFloat,
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		// No arguments - start REPL
@@ -30,12 +51,22 @@ func main() {
 	case "repl":
 		runREPL()
 	case "run":
-		if len(os.Args) < 3 {
+		args := runOptions(os.Args[2:])
+		if len(args) < 1 {
 			fmt.Println("Error: no file specified")
 			printUsage()
 			os.Exit(1)
 		}
-		runFile(os.Args[2])
+		runFile(args[0])
+	case "watch":
+		// Run a file again every time it or its directory changes
+		args := runOptions(os.Args[2:])
+		if len(args) < 1 {
+			fmt.Println("Error: no file specified")
+			fmt.Println("\nUsage: smog watch [options] <file.smog>")
+			os.Exit(1)
+		}
+		watchFile(args[0], os.Stdout, os.Stderr, watchInterval, nil)
 	case "debug":
 		// Run a file with the debugger enabled
 		if len(os.Args) < 3 {
@@ -65,21 +96,81 @@ func main() {
 			os.Exit(1)
 		}
 		disassembleFile(os.Args[2])
+	case "ast":
+		// Print the syntax tree of a .smog file
+		if len(os.Args) < 3 {
+			fmt.Println("Error: no file specified")
+			fmt.Println("\nUsage: smog ast <file.smog>")
+			os.Exit(1)
+		}
+		astFile(os.Args[2])
+	case "info":
+		// Summarize a .sg file without disassembling it
+		if len(os.Args) < 3 {
+			fmt.Println("Error: no file specified")
+			fmt.Println("\nUsage: smog info <file.sg>")
+			os.Exit(1)
+		}
+		infoFile(os.Args[2])
 	default:
 		// Assume it's a file to run
 		runFile(os.Args[1])
 	}
 }
 
+// runOptions applies the options at the front of args, the ones run and
+// watch share, and returns the arguments after them. An unknown option
+// prints the usage and exits.
+func runOptions(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch flag := args[0]; {
+		case flag == "--warn":
+			// Opt-in compile-time checks for obviously bogus selectors
+			warnSelectors = true
+		case flag == "--strict":
+			// Every compile-time warning is an error
+			strictMode = true
+		case flag == "--no-cache":
+			// Always compile, and leave the bytecode cache alone
+			cacheDir = ""
+		case strings.HasPrefix(flag, "--division="):
+			mode, ok := divisionModes[strings.TrimPrefix(flag, "--division=")]
+			if !ok {
+				fmt.Printf("Error: unknown division mode in %s (use truncate, exact or float)\n", flag)
+				os.Exit(1)
+			}
+			divisionMode = mode
+		default:
+			fmt.Printf("Error: unknown option %s\n", flag)
+			printUsage()
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+	return args
+}
+
 func printUsage() {
 	fmt.Println("smog - A simple object-oriented language")
 	fmt.Println("\nUsage:")
 	fmt.Println("  smog                       Start interactive REPL")
 	fmt.Println("  smog [file]                Run a .smog or .sg file")
 	fmt.Println("  smog run [file]            Run a .smog or .sg file")
+	fmt.Println("  smog run --warn [file]     Run, warning about selectors literals can't understand")
+	fmt.Println("  smog run --strict [file]   Run only if there are no compile-time warnings")
+	fmt.Println("                             (undeclared variables, unreachable code, selectors")
+	fmt.Println("                             literals can't understand)")
+	fmt.Println("  smog run --no-cache [file] Run without reusing or saving compiled bytecode")
+	fmt.Println("  smog run --division=MODE [file]")
+	fmt.Println("                             Run with 5 / 2 answering 2 (truncate, the default),")
+	fmt.Println("                             5/2 (exact) or 2.5 (float)")
+	fmt.Println("  smog watch [file]          Run a file, then again each time it or its directory")
+	fmt.Println("                             changes (takes the same options as run)")
 	fmt.Println("  smog debug [file]          Run a .smog file with debugger")
 	fmt.Println("  smog compile <in> [out]    Compile .smog to .sg bytecode")
 	fmt.Println("  smog disassemble <file>    Disassemble .sg bytecode file")
+	fmt.Println("  smog info <file>           Summarize .sg bytecode file (counts, opcodes)")
+	fmt.Println("  smog ast <file>            Print the syntax tree of a .smog file")
 	fmt.Println("  smog repl                  Start interactive REPL")
 	fmt.Println("  smog version               Show version")
 	fmt.Println("  smog help                  Show this help")
@@ -95,7 +186,8 @@ func printUsage() {
 //   - .smog files are parsed and compiled first (slower)
 //
 // This allows users to pre-compile frequently-used programs to .sg format
-// for faster startup time.
+// for faster startup time. Each file runs on a new VM, so no globals or
+// classes carry over from anything run before it.
 func runFile(filename string) {
 	ext := filepath.Ext(filename)
 	
@@ -112,38 +204,96 @@ func runFile(filename string) {
 // runSourceFile reads, parses, compiles, and executes a .smog source file.
 //
 // This is the traditional path: source → AST → bytecode → execution.
-// It's slower than runBytecodeFile because it includes parsing and compilation.
+// It's slower than runBytecodeFile because it includes parsing and compilation,
+// unless the file is unchanged since it was last run (see compileSourceFile).
 func runSourceFile(filename string) {
+	bc, err := compileSourceFile(filename, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Run the bytecode on the VM
+	v := newVM()
+	err = v.Run(bc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newVM creates the VM that run, watch and the REPL run programs on, with
+// the settings given on the command line. halt may start the debugger
+// when stdin is a terminal.
+func newVM() *vm.VM {
+	v := vm.New()
+	v.SetDivisionMode(divisionMode)
+	v.SetInteractive(stdinIsTerminal)
+	return v
+}
+
+// compileSourceFile reads and compiles a .smog source file for run,
+// writing any compile-time warnings to warnings.
+//
+// Bytecode for a file that compiles without a single warning, including
+// the ones --warn asks for, is kept in cacheDir and reused while the file
+// is unchanged, skipping the parser and compiler entirely. A file with
+// warnings is compiled every time, so they are reported on every run.
+func compileSourceFile(filename string, warnings io.Writer) (*bytecode.Bytecode, error) {
 	// Read the source file
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	if bc, ok := cachedBytecode(cacheDir, data); ok {
+		return bc, nil
 	}
 
 	// Parse the source code into an AST
 	p := parser.New(string(data))
 	program, err := p.Parse()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Parse error: %v", err)
+	}
+
+	selectorWarnings := compiler.CheckSelectors(program)
+	if warnSelectors && !strictMode {
+		for _, w := range selectorWarnings {
+			fmt.Fprintln(warnings, w)
+		}
 	}
 
 	// Compile the AST to bytecode
 	c := compiler.New()
+	c.SetStrict(strictMode)
 	bc, err := c.Compile(program)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Compile error: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Compile error: %v", err)
+	}
+	for _, w := range c.Warnings() {
+		fmt.Fprintln(warnings, w)
 	}
 
-	// Run the bytecode on the VM
-	v := vm.New()
-	err = v.Run(bc)
+	if len(c.Warnings()) == 0 && len(selectorWarnings) == 0 {
+		cacheBytecode(cacheDir, data, bc)
+	}
+	return bc, nil
+}
+
+// loadBytecodeFile reads a compiled .sg file for run, watch and
+// disassemble.
+func loadBytecodeFile(filename string) (*bytecode.Bytecode, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	defer file.Close()
+
+	bc, err := bytecode.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading bytecode: %v", err)
 	}
+	return bc, nil
 }
 
 // runBytecodeFile loads and executes a pre-compiled .sg bytecode file.
@@ -157,23 +307,14 @@ func runSourceFile(filename string) {
 //   - No bytecode compilation
 //   - Direct deserialization from binary format
 func runBytecodeFile(filename string) {
-	// Open the bytecode file
-	file, err := os.Open(filename)
+	bc, err := loadBytecodeFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
-	}
-	defer file.Close()
-
-	// Decode the bytecode from the file
-	bc, err := bytecode.Decode(file)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
 	// Run the bytecode on the VM
-	v := vm.New()
+	v := newVM()
 	err = v.Run(bc)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
@@ -212,6 +353,7 @@ func debugFile(filename string) {
 	// Run the bytecode on the VM with debugger enabled
 	v := vm.New()
 	debugger := v.EnableDebugger()
+	debugger.SetSource(string(data))
 	
 	fmt.Println("=== Smog Debugger ===")
 	fmt.Println("Type 'help' at the debug prompt for available commands")
@@ -315,18 +457,9 @@ func compileFile(inputFile, outputFile string) {
 //     1: SEND (1<<8)|0
 //     2: RETURN 0
 func disassembleFile(filename string) {
-	// Open the bytecode file
-	file, err := os.Open(filename)
+	bc, err := loadBytecodeFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
-	}
-	defer file.Close()
-
-	// Decode the bytecode
-	bc, err := bytecode.Decode(file)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
@@ -373,6 +506,87 @@ func disassembleFile(filename string) {
 	}
 }
 
+// astFile parses a .smog source file and prints its abstract syntax tree,
+// one node per line, indented to show what contains what. It is meant for
+// learning how source text maps onto the structures the compiler sees.
+//
+// Example:
+//   smog ast hello.smog
+//     Program
+//       ExpressionStatement
+//         MessageSend println
+//           receiver: StringLiteral 'Hello, World!'
+func astFile(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+
+	p := parser.New(string(data))
+	program, err := p.Parse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(ast.Dump(program))
+}
+
+// infoFile prints a summary of a .sg bytecode file: its header, the
+// number of constants and instructions, and how often each opcode is used.
+//
+// Unlike disassembleFile it doesn't list the code itself, so it stays
+// short for large programs.
+func infoFile(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	info, err := bytecode.ReadInfo(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("=== Bytecode Info: %s ===\n\n", filename)
+	printInfo(os.Stdout, info)
+}
+
+// printInfo writes the summary printed by infoFile to w. Opcodes are
+// listed from most to least used.
+func printInfo(w io.Writer, info *bytecode.FileInfo) {
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	fmt.Fprintf(w, "Format version: %d\n", info.Version)
+	fmt.Fprintf(w, "Flags:          0x%08X\n", info.Flags)
+	fmt.Fprintf(w, "Constants:      %d\n", info.Constants)
+	fmt.Fprintf(w, "Instructions:   %d (%d including blocks and methods)\n", info.Instructions, info.TotalInstructions())
+	fmt.Fprintf(w, "Line table:     %s\n", yesNo[info.HasLineTable])
+	fmt.Fprintf(w, "Checksum:       %s\n", yesNo[info.HasChecksum])
+
+	ops := make([]bytecode.Opcode, 0, len(info.OpcodeCounts))
+	for op := range info.OpcodeCounts {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if info.OpcodeCounts[ops[i]] != info.OpcodeCounts[ops[j]] {
+			return info.OpcodeCounts[ops[i]] > info.OpcodeCounts[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+
+	fmt.Fprintln(w, "\nOpcodes:")
+	if len(ops) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, op := range ops {
+		fmt.Fprintf(w, "  %-16s %d\n", op, info.OpcodeCounts[op])
+	}
+}
+
 // formatConstant returns a human-readable string representation of a constant.
 //
 // This helper function is used by disassembleFile to pretty-print constants.
@@ -390,6 +604,10 @@ func formatConstant(c interface{}, indent string) string {
 	case nil:
 		return "nil"
 	case *bytecode.ClassDefinition:
+		if v.SuperClass == "" {
+			// An extension, from "Name extend [ ... ]"
+			return fmt.Sprintf("class extension: %s (%d methods)", v.Name, len(v.Methods))
+		}
 		return fmt.Sprintf("class: %s (extends %s, %d fields, %d methods)",
 			v.Name, v.SuperClass, len(v.Fields), len(v.Methods))
 	case *bytecode.MethodDefinition:
@@ -426,30 +644,33 @@ func runREPL() {
 	fmt.Println()
 
 	// Create a persistent VM for the REPL session
-	v := vm.New()
+	v := newVM()
 	// Create a persistent compiler for the REPL session
 	// This maintains the symbol table across evaluations so that
 	// local variables declared in one input remain available in subsequent inputs
 	c := compiler.New()
-	scanner := bufio.NewScanner(os.Stdin)
+	// Tab completes variables and selectors from what the compiler has seen
+	reader := newLineReader(func(line string) (string, []string) {
+		return completeREPL(line, replVariables(c), c.Selectors())
+	})
 	
 	// Buffer for multi-line input
 	var inputBuffer strings.Builder
 	
 	for {
-		// Show prompt
-		if inputBuffer.Len() == 0 {
-			fmt.Print("smog> ")
-		} else {
-			fmt.Print("....> ")
+		// Show prompt and read input
+		prompt := "smog> "
+		if inputBuffer.Len() > 0 {
+			prompt = "....> "
 		}
-		
-		// Read input
-		if !scanner.Scan() {
+		line, err := reader.readLine(prompt)
+		if err == io.EOF {
 			break
 		}
-		
-		line := scanner.Text()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			return
+		}
 		
 		// Handle special commands
 		if inputBuffer.Len() == 0 {
@@ -490,10 +711,6 @@ func runREPL() {
 		// Clear buffer for next input
 		inputBuffer.Reset()
 	}
-	
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-	}
 }
 
 // evalREPL evaluates a single REPL input.
@@ -549,6 +766,7 @@ func printREPLHelp() {
 	fmt.Println("  - Statements should end with a period (.)")
 	fmt.Println("  - Use | vars | to declare variables")
 	fmt.Println("  - Variables persist across statements")
+	fmt.Println("  - Press Tab to complete variable names and selectors")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  smog> | x |")
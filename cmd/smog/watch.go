@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// watchInterval is how often watch looks for changes on disk.
+const watchInterval = 300 * time.Millisecond
+
+// watchFile runs a .smog or .sg file, then runs it again every time it or
+// anything else in its directory changes, until stop is closed. From the
+// command line stop is nil, so it runs until interrupted.
+//
+// Each run gets a new VM, prints under a header naming the file and the
+// time, and reports its errors to errOut without ending the watch, so a
+// learner can fix a mistake and save again.
+//
+// Changes are found by polling: every interval the names, sizes and
+// modification times of the files in the directory are compared with the
+// ones the last run saw. Polling needs nothing from the operating system,
+// and an edit loop doesn't need to notice a save any sooner.
+//
+// The watch's own writes don't count as changes. The snapshot is taken
+// after each run, so files the program writes into the directory don't
+// start another run, and the bytecode cache is left out if it is kept
+// there. A save to the watched file during a run stops the run, even one
+// stuck in a loop, and runs the file again.
+//
+// Example:
+//   smog watch hello.smog
+func watchFile(filename string, out, errOut io.Writer, interval time.Duration, stop <-chan struct{}) {
+	dir := filepath.Dir(filename)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		last, stopped := runAndSnapshot(filename, out, errOut, ticker.C, stop)
+		if stopped {
+			return
+		}
+		for changed := false; !changed; {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			changed = snapshotDir(dir) != last
+		}
+	}
+}
+
+// runAndSnapshot runs filename for watchFile and returns the snapshot of
+// its directory to compare later ones with. The program runs on its own
+// goroutine while the file is checked on every tick; if the file is saved
+// meanwhile, the run is cancelled and the snapshot is left empty so the
+// next poll runs it again. stopped is true if stop closed during the run.
+func runAndSnapshot(filename string, out, errOut io.Writer, tick <-chan time.Time, stop <-chan struct{}) (snapshot string, stopped bool) {
+	before := fileStamp(filename)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		runWatched(ctx, filename, out, errOut)
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			if fileStamp(filename) != before {
+				return "", false
+			}
+			return snapshotDir(filepath.Dir(filename)), false
+		case <-stop:
+			cancel()
+			<-done
+			return "", true
+		case <-tick:
+			if fileStamp(filename) != before {
+				cancel()
+				<-done
+				return "", false
+			}
+		}
+	}
+}
+
+// fileStamp describes a file by size and modification time, or is empty
+// if the file can't be read.
+func fileStamp(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+}
+
+// snapshotDir describes the files in dir by name, size and modification
+// time, so two snapshots differ when a file is added, removed or saved.
+// Hidden files and editor backups ending in ~ are left out, since editors
+// rewrite them while a file is being edited, and so is cacheDir, which
+// run writes to.
+func snapshotDir(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || isCacheDir(filepath.Join(dir, name)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// isCacheDir reports whether path is the bytecode cache directory.
+func isCacheDir(path string) bool {
+	if cacheDir == "" {
+		return false
+	}
+	cache, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	return err == nil && path == cache
+}
+
+// runWatched runs filename once for watchFile, writing its output to out
+// and any error to errOut. A run stopped by cancelling ctx reports no
+// error, since watchFile is about to run the file again.
+func runWatched(ctx context.Context, filename string, out, errOut io.Writer) {
+	fmt.Fprintf(out, "--- %s (%s) ---\n", filepath.Base(filename), time.Now().Format("15:04:05"))
+
+	var bc *bytecode.Bytecode
+	var err error
+	if filepath.Ext(filename) == ".sg" {
+		bc, err = loadBytecodeFile(filename)
+	} else {
+		bc, err = compileSourceFile(filename, errOut)
+	}
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return
+	}
+
+	v := newVM()
+	v.SetOutput(out)
+	if err := v.RunContext(ctx, bc); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(errOut, "Runtime error: %v\n", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a strings.Builder that the watcher goroutine can write to
+// while the test reads it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+// waitFor waits up to a few seconds for buf to contain text n times.
+func waitFor(t *testing.T, buf *syncBuffer, text string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(buf.String(), text) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d x %q, got %q", n, text, buf.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	saved := cacheDir
+	cacheDir = t.TempDir()
+	defer func() { cacheDir = saved }()
+
+	file := filepath.Join(t.TempDir(), "prog.smog")
+	write := func(source string) {
+		if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+	write("'first' println.")
+
+	var out, errs syncBuffer
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchFile(file, &out, &errs, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// The file runs once straight away
+	waitFor(t, &out, "first\n", 1)
+	waitFor(t, &out, "--- prog.smog", 1)
+
+	// Rewriting it runs it again
+	write("'second run' println.")
+	waitFor(t, &out, "second run\n", 1)
+	waitFor(t, &out, "--- prog.smog", 2)
+
+	// An error is reported, and the watch goes on
+	write("1 +.")
+	waitFor(t, &errs, "Parse error", 1)
+	write("nil foo.")
+	waitFor(t, &errs, "Runtime error", 1)
+	write("'fixed' println.")
+	waitFor(t, &out, "fixed\n", 1)
+
+	// A new file in the same directory counts as a change
+	if err := os.WriteFile(filepath.Join(filepath.Dir(file), "other.smog"), []byte("1"), 0644); err != nil {
+		t.Fatalf("Failed to write other.smog: %v", err)
+	}
+	waitFor(t, &out, "fixed\n", 2)
+
+	if strings.Count(out.String(), "first\n") != 1 {
+		t.Errorf("Expected the first version to run once, got %q", out.String())
+	}
+}
+
+// TestWatchIgnoresItsOwnWrites tests that the files a watched program
+// writes next to itself, and a bytecode cache kept in the same directory,
+// don't make the watch run it again
+func TestWatchIgnoresItsOwnWrites(t *testing.T) {
+	dir := t.TempDir()
+	saved := cacheDir
+	cacheDir = filepath.Join(dir, "cache")
+	defer func() { cacheDir = saved }()
+
+	file := filepath.Join(dir, "prog.smog")
+	output := filepath.Join(dir, "out.txt")
+	source := "nil fileWrite: '" + output + "' content: 'written'.\n'ran' println."
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+
+	var out, errs syncBuffer
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchFile(file, &out, &errs, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	waitFor(t, &out, "ran\n", 1)
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("Expected the program to write %s: %v", output, err)
+	}
+	if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) == 0 {
+		t.Fatalf("Expected the bytecode to be cached in %s: %v", cacheDir, err)
+	}
+
+	// Many polls later, it still ran only once
+	time.Sleep(200 * time.Millisecond)
+	if n := strings.Count(out.String(), "ran\n"); n != 1 {
+		t.Fatalf("Expected one run, got %d: %q", n, out.String())
+	}
+	if errs.String() != "" {
+		t.Errorf("Unexpected errors: %q", errs.String())
+	}
+
+	// Saving the program still runs it again
+	if err := os.WriteFile(file, []byte(source+"\n'again' println."), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	waitFor(t, &out, "again\n", 1)
+}
+
+// TestWatchRestartsARunawayProgram tests that saving a program stuck in a
+// loop stops it and runs the new version
+func TestWatchRestartsARunawayProgram(t *testing.T) {
+	saved := cacheDir
+	cacheDir = t.TempDir()
+	defer func() { cacheDir = saved }()
+
+	file := filepath.Join(t.TempDir(), "prog.smog")
+	if err := os.WriteFile(file, []byte("'looping' println.\n[true] whileTrue: [nil]."), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+
+	var out, errs syncBuffer
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchFile(file, &out, &errs, 10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	waitFor(t, &out, "looping\n", 1)
+	if err := os.WriteFile(file, []byte("'fixed' println."), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	waitFor(t, &out, "fixed\n", 1)
+	if errs.String() != "" {
+		t.Errorf("Expected the cancelled run to report nothing, got %q", errs.String())
+	}
+
+	// Stopping the watch during a run stops the run too
+	if err := os.WriteFile(file, []byte("[true] whileTrue: [nil]."), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	waitFor(t, &out, "--- prog.smog", 3)
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the watch to stop while the program was looping")
+	}
+}
@@ -24,6 +24,8 @@
 //         └─ MessageSend (receiver selector: arg)
 package ast
 
+import "math/big"
+
 // SourceLocation tracks the source position of an AST node.
 // This is used for error reporting and debugging.
 type SourceLocation struct {
@@ -222,6 +224,28 @@ type FloatLiteral struct {
 func (fl *FloatLiteral) TokenLiteral() string { return "" }
 func (fl *FloatLiteral) expressionNode()      {}
 
+// FractionLiteral represents an exact rational constant in the source code.
+//
+// Syntax: 1/2r, -3/4r, 10/4r (no spaces, and a trailing r, so 1/2 on its
+// own is still the division message)
+//
+// Fraction literals are stored as big.Rat values, already reduced to
+// lowest terms by the parser.
+//
+// Example:
+//   10/4r -> FractionLiteral{Value: 5/2}
+//
+// The compiler adds the value to the constant pool, or an integer if the
+// fraction reduces to a whole number (4/2r -> 2).
+type FractionLiteral struct {
+	Value *big.Rat       // The rational value, in lowest terms
+	Loc   SourceLocation // Source location of the literal
+}
+
+// TokenLiteral returns the fraction in num/den form.
+func (fl *FractionLiteral) TokenLiteral() string { return fl.Value.String() }
+func (fl *FractionLiteral) expressionNode()      {}
+
 // StringLiteral represents a string constant in the source code.
 //
 // Syntax: 'Hello, World!'
@@ -388,6 +412,25 @@ type ArrayLiteral struct {
 // TokenLiteral returns "array" to identify this as an array literal.
 func (al *ArrayLiteral) TokenLiteral() string { return "array" }
 func (al *ArrayLiteral) expressionNode()      {}
+
+// BraceArray represents a dynamic array.
+//
+// Syntax: { expression1. expression2. ... }
+//
+// Unlike an ArrayLiteral, whose elements are literals, each element of a
+// brace array is a full expression evaluated at runtime, in order, every
+// time the brace array is evaluated.
+//
+// Example:
+//   {1 + 1. x * 3. 'a' size}
+//     -> BraceArray{Elements: [(1 + 1), (x * 3), ('a' size)]}
+type BraceArray struct {
+	Elements []Expression // Element expressions, in order
+}
+
+// TokenLiteral returns "brace array" to identify this as a dynamic array.
+func (ba *BraceArray) TokenLiteral() string { return "brace array" }
+func (ba *BraceArray) expressionNode()      {}
 //
 // Syntax: SuperClass subclass: #ClassName [fields... methods...]
 //
@@ -417,6 +460,15 @@ func (al *ArrayLiteral) expressionNode()      {}
 //   - ClassVariables: ["totalCount"]
 //   - Methods: [initialize method]
 //   - ClassMethods: [incrementTotal method]
+//
+// A field may be declared with a default value, written | count = 0 |;
+// every new instance starts with the field set to it. FieldDefaults maps
+// such fields to their value expressions.
+//
+// An extension adds instance methods to a class that already exists,
+// including built-in types like Integer and String. It has no superclass,
+// fields or class methods of its own:
+//   Integer extend [ double [ ^self * 2 ] ]
 type Class struct {
 	Name           string    // Class name (without the # prefix)
 	SuperClass     string    // Name of the superclass
@@ -424,6 +476,9 @@ type Class struct {
 	ClassMethods   []*Method // List of class method definitions
 	Fields         []string  // List of instance variable names
 	ClassVariables []string  // List of class variable names
+	Extension      bool      // True for "Name extend [ ... ]"
+
+	FieldDefaults map[string]Expression // Default values of fields declared like | count = 0 |
 }
 
 // TokenLiteral returns "class" to identify this as a class definition.
@@ -451,10 +506,14 @@ func (c *Class) statementNode()       {}
 //
 //   at: index put: value [ ... ]
 //     -> Method{Name: "at:put:", Parameters: ["index", "value"], Body: [...]}
+//
+//   balance [ <category: 'accessing'> ^balance ]
+//     -> Method{Name: "balance", Protocol: "accessing", Body: [return statement]}
 type Method struct {
 	Name       string      // Method selector (e.g., "initialize", "at:put:")
 	Parameters []string    // Parameter names for the method
 	Body       []Statement // Statements in the method body
+	Protocol   string      // Category from a <category: '...'> annotation ("" if none)
 }
 
 // TokenLiteral returns "method" to identify this as a method definition.
@@ -0,0 +1,175 @@
+// Package ast - readable tree dumps
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dump renders node and everything beneath it as an indented tree, one
+// node per line, for seeing how source code is structured.
+//
+// Each line names the node type followed by its selector, name or literal
+// value. Children are indented two spaces below their parent, and where a
+// child's role isn't obvious from its position it is labelled (receiver:,
+// arg:, key:, value:).
+//
+// Example:
+//   3 + 4 println.
+//     -> Program
+//          ExpressionStatement
+//            MessageSend +
+//              receiver: IntegerLiteral 3
+//              arg: MessageSend println
+//                receiver: IntegerLiteral 4
+func Dump(node Node) string {
+	var d dumper
+	d.node("", node, 0)
+	return d.out.String()
+}
+
+// dumper accumulates the lines of a Dump.
+type dumper struct {
+	out strings.Builder
+}
+
+// line writes text indented to depth.
+func (d *dumper) line(depth int, text string) {
+	d.out.WriteString(strings.Repeat("  ", depth))
+	d.out.WriteString(text)
+	d.out.WriteString("\n")
+}
+
+// node writes n at depth, prefixed with label, followed by its children.
+func (d *dumper) node(label string, n Node, depth int) {
+	switch n := n.(type) {
+	case nil:
+		d.line(depth, label+"(none)")
+	case *Program:
+		d.line(depth, label+"Program")
+		d.statements(n.Statements, depth+1)
+	case *ExpressionStatement:
+		d.line(depth, label+"ExpressionStatement")
+		d.node("", n.Expression, depth+1)
+	case *VariableDeclaration:
+		d.line(depth, label+"VariableDeclaration "+strings.Join(n.Names, " "))
+	case *ReturnStatement:
+		d.line(depth, label+"ReturnStatement")
+		d.node("", n.Value, depth+1)
+	case *Class:
+		d.line(depth, label+"Class "+n.Name+" (superclass "+n.SuperClass+")")
+		if len(n.Fields) > 0 {
+			d.line(depth+1, "instance variables: "+strings.Join(n.Fields, " "))
+		}
+		for _, field := range n.Fields {
+			if value, ok := n.FieldDefaults[field]; ok {
+				d.node("default "+field+": ", value, depth+2)
+			}
+		}
+		if len(n.ClassVariables) > 0 {
+			d.line(depth+1, "class variables: "+strings.Join(n.ClassVariables, " "))
+		}
+		for _, m := range n.Methods {
+			d.method("Method", m, depth+1)
+		}
+		for _, m := range n.ClassMethods {
+			d.method("ClassMethod", m, depth+1)
+		}
+	case *Method:
+		d.method(label+"Method", n, depth)
+	case *Assignment:
+		d.line(depth, label+"Assignment "+n.Name)
+		d.node("", n.Value, depth+1)
+	case *IntegerLiteral:
+		d.line(depth, label+"IntegerLiteral "+strconv.FormatInt(n.Value, 10))
+	case *FloatLiteral:
+		d.line(depth, label+"FloatLiteral "+strconv.FormatFloat(n.Value, 'g', -1, 64))
+	case *FractionLiteral:
+		d.line(depth, label+"FractionLiteral "+n.Value.String())
+	case *StringLiteral:
+		d.line(depth, label+"StringLiteral '"+strings.ReplaceAll(n.Value, "'", "''")+"'")
+	case *BooleanLiteral:
+		d.line(depth, label+"BooleanLiteral "+n.TokenLiteral())
+	case *NilLiteral:
+		d.line(depth, label+"NilLiteral")
+	case *Identifier:
+		d.line(depth, label+"Identifier "+n.Name)
+	case *BlockLiteral:
+		d.line(depth, label+"BlockLiteral"+parameters(n.Parameters))
+		d.statements(n.Body, depth+1)
+	case *ArrayLiteral:
+		d.line(depth, label+"ArrayLiteral")
+		for _, elem := range n.Elements {
+			d.node("", elem, depth+1)
+		}
+	case *BraceArray:
+		d.line(depth, label+"BraceArray")
+		for _, elem := range n.Elements {
+			d.node("", elem, depth+1)
+		}
+	case *DictionaryLiteral:
+		d.line(depth, label+"DictionaryLiteral")
+		for _, pair := range n.Pairs {
+			d.line(depth+1, "pair:")
+			d.node("key: ", pair.Key, depth+2)
+			d.node("value: ", pair.Value, depth+2)
+		}
+	case *MessageSend:
+		d.message(label, n, depth)
+	case *CascadeExpression:
+		d.line(depth, label+"CascadeExpression")
+		if n.Receiver == nil && len(n.Messages) > 0 && n.Messages[0].IsSuper {
+			d.line(depth+1, "receiver: super")
+		} else {
+			d.node("receiver: ", n.Receiver, depth+1)
+		}
+		for i := range n.Messages {
+			d.message("", &n.Messages[i], depth+1)
+		}
+	default:
+		d.line(depth, label+fmt.Sprintf("%T", n))
+	}
+}
+
+// statements writes each statement at depth.
+func (d *dumper) statements(stmts []Statement, depth int) {
+	for _, stmt := range stmts {
+		d.node("", stmt, depth)
+	}
+}
+
+// method writes a method definition, its category and its body.
+func (d *dumper) method(kind string, m *Method, depth int) {
+	text := kind + " " + m.Name + parameters(m.Parameters)
+	if m.Protocol != "" {
+		text += " <category: '" + m.Protocol + "'>"
+	}
+	d.line(depth, text)
+	d.statements(m.Body, depth+1)
+}
+
+// message writes a message send with its receiver and arguments. A
+// cascaded message has no receiver of its own, and a super send shows
+// super as its receiver.
+func (d *dumper) message(label string, m *MessageSend, depth int) {
+	d.line(depth, label+"MessageSend "+m.Selector)
+	switch {
+	case m.IsSuper:
+		d.line(depth+1, "receiver: super")
+	case m.Receiver != nil:
+		d.node("receiver: ", m.Receiver, depth+1)
+	}
+	for _, arg := range m.Args {
+		d.node("arg: ", arg, depth+1)
+	}
+}
+
+// parameters renders a parameter list as " (parameters: x y)", or "" when
+// there are none.
+func parameters(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return " (parameters: " + strings.Join(names, " ") + ")"
+}
@@ -0,0 +1,94 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/kristofer/smog/pkg/ast"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// TestDumpGolden compares the dump of a small program with the expected
+// tree, line for line
+func TestDumpGolden(t *testing.T) {
+	source := `
+Object subclass: #Account [
+    | balance |
+    deposit: amount to: log [
+        <category: 'banking'>
+        balance := balance + amount.
+        log at: 1 put: #(1 'one' 2.5 nil).
+        ^self
+    ]
+]
+| a |
+a := Account new.
+a deposit: 3/4 to: {true}; yourself.
+[:x | x > 0] value: #{'k' -> false}.
+`
+	expected := `Program
+  Class Account (superclass Object)
+    instance variables: balance
+    Method deposit:to: (parameters: amount log) <category: 'banking'>
+      ExpressionStatement
+        Assignment balance
+          MessageSend +
+            receiver: Identifier balance
+            arg: Identifier amount
+      ExpressionStatement
+        MessageSend at:put:
+          receiver: Identifier log
+          arg: IntegerLiteral 1
+          arg: ArrayLiteral
+            IntegerLiteral 1
+            StringLiteral 'one'
+            FloatLiteral 2.5
+            NilLiteral
+      ReturnStatement
+        Identifier self
+  VariableDeclaration a
+  ExpressionStatement
+    Assignment a
+      MessageSend new
+        receiver: Identifier Account
+  ExpressionStatement
+    CascadeExpression
+      receiver: Identifier a
+      MessageSend deposit:to:
+        receiver: Identifier a
+        arg: MessageSend /
+          receiver: IntegerLiteral 3
+          arg: IntegerLiteral 4
+        arg: BraceArray
+          BooleanLiteral true
+      MessageSend yourself
+  ExpressionStatement
+    MessageSend value:
+      receiver: BlockLiteral (parameters: x)
+        ExpressionStatement
+          MessageSend >
+            receiver: Identifier x
+            arg: IntegerLiteral 0
+      arg: DictionaryLiteral
+        pair:
+          key: StringLiteral 'k'
+          value: BooleanLiteral false
+`
+
+	program, err := parser.New(source).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got := ast.Dump(program); got != expected {
+		t.Errorf("Dump mismatch\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestDumpSuperSend tests that a super send, which has no receiver
+// expression, shows super as its receiver
+func TestDumpSuperSend(t *testing.T) {
+	send := &ast.MessageSend{Selector: "initialize", IsSuper: true}
+	expected := "MessageSend initialize\n  receiver: super\n"
+	if got := ast.Dump(send); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
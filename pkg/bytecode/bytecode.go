@@ -47,6 +47,12 @@
 //   - Separation of concerns: bytecode describes "what to do", VM decides "how"
 package bytecode
 
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
 // Opcode represents a bytecode instruction operation.
 //
 // Each opcode tells the VM what operation to perform. Opcodes are
@@ -266,9 +272,16 @@ const (
 	OpDefineClass
 
 	// OpNewObject creates a new instance of a class.
-	// Operand: class identifier
+	// Operand: index of the class name in constant pool
+	//
+	// This is a fast path for `ClassName new` that skips the message send.
+	// The compiler only emits it when ClassName is a global naming a class
+	// defined earlier in the program. It allocates a new object with space
+	// for all instance variables (inherited ones included) and pushes it.
 	//
-	// Allocates a new object with space for instance variables.
+	// Globals can be reassigned at runtime, so if the name no longer refers
+	// to a class definition the VM falls back to sending `new` to whatever
+	// it does refer to. Either way the result matches `SEND new, 0`.
 	OpNewObject
 
 	// === Block/Closure Operations ===
@@ -318,7 +331,11 @@ const (
 	// Stack before: [block, arg1, arg2, ..., argN]
 	// Stack after:  [result]
 	//
-	// Executes the block with the given arguments.
+	// Executes the block with the given arguments. This is a fast path for
+	// `value`, `value:`, `value:value:`, ... that the compiler emits when
+	// the receiver is a block literal, so the VM can skip selector lookup.
+	// If the receiver isn't a block, the VM sends the equivalent value
+	// message instead (see BlockValueSelector).
 	OpCallBlock
 
 	// === Array Operations ===
@@ -342,6 +359,15 @@ const (
 	//
 	// Pops 2*N elements from the stack (N pairs) and creates a dictionary.
 	OpMakeDictionary
+
+	// OpExtendClass adds methods to an existing class or built-in type.
+	// Operand: index into constant pool for a ClassDefinition
+	//
+	// The ClassDefinition comes from "Name extend [ ... ]" and holds only
+	// the class name and the new instance methods. Methods replace any
+	// the class already has with the same selector. It comes last so that
+	// adding it didn't renumber the opcodes in existing .sg files.
+	OpExtendClass
 )
 
 // Instruction represents a single bytecode instruction.
@@ -359,9 +385,14 @@ const (
 //
 //   Instruction{Op: OpSend, Operand: (2 << 8) | 1}
 //     -> Send message with selector at constant[2] with 1 argument
+//
+// Line is the source line the instruction was compiled from (0 if unknown).
+// It is used by the debugger to map instructions back to source and is not
+// written to .sg files.
 type Instruction struct {
 	Op      Opcode // The operation to perform
 	Operand int    // Additional data for the instruction
+	Line    int    // Source line (1-based, 0 if unknown)
 }
 
 // Bytecode represents a complete compiled program or method.
@@ -410,6 +441,7 @@ type Bytecode struct {
 	Constants    []interface{}  // Pool of constant values
 	CapturedVars []CapturedVar  // Variables captured from outer scopes
 	LocalCount   int            // Number of local variables in this scope
+	LocalNames   []string       // Local variable names by slot (for debugging; not serialized)
 }
 
 // CapturedVar represents a variable captured from an outer scope.
@@ -469,8 +501,53 @@ const (
 	// ArgCountMask is the bitmask for extracting the argument count from
 	// the low 8 bits of the operand.
 	ArgCountMask = 0xFF
+
+	// MaxSelectorIndex is the largest selector index that can be packed
+	// into a send operand. Operands are serialized as signed 32-bit
+	// integers, leaving 23 bits for the selector once the argument count
+	// and sign bit are accounted for.
+	MaxSelectorIndex = math.MaxInt32 >> SelectorIndexShift
 )
 
+// PackSendOperand packs a selector index and argument count into a single
+// OpSend/OpSuperSend operand.
+//
+// It returns an error instead of silently producing an operand that would
+// overflow into the sign bit or corrupt the argument count.
+//
+// Example:
+//   PackSendOperand(5, 2)  -> 1282, nil
+//   PackSendOperand(MaxSelectorIndex+1, 0)  -> error
+func PackSendOperand(selectorIdx, argCount int) (int, error) {
+	if selectorIdx < 0 || selectorIdx > MaxSelectorIndex {
+		return 0, fmt.Errorf("selector index %d out of range (max %d)", selectorIdx, MaxSelectorIndex)
+	}
+	if argCount < 0 || argCount > ArgCountMask {
+		return 0, fmt.Errorf("argument count %d out of range (max %d)", argCount, ArgCountMask)
+	}
+	return (selectorIdx << SelectorIndexShift) | argCount, nil
+}
+
+// UnpackSendOperand splits an OpSend/OpSuperSend operand into its selector
+// index and argument count. It is the inverse of PackSendOperand and
+// returns an error for negative or oversized operands, which can only come
+// from corrupted bytecode.
+func UnpackSendOperand(operand int) (selectorIdx, argCount int, err error) {
+	if operand < 0 || operand > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("invalid send operand: %d", operand)
+	}
+	return operand >> SelectorIndexShift, operand & ArgCountMask, nil
+}
+
+// BlockValueSelector returns the selector that evaluates a block with
+// argCount arguments: value, value:, value:value:, and so on.
+func BlockValueSelector(argCount int) string {
+	if argCount == 0 {
+		return "value"
+	}
+	return strings.Repeat("value:", argCount)
+}
+
 // String returns a human-readable name for an opcode.
 //
 // This is primarily used for debugging, logging, and disassembling bytecode.
@@ -541,6 +618,8 @@ func (op Opcode) String() string {
 		return "MAKE_ARRAY"
 	case OpMakeDictionary:
 		return "MAKE_DICTIONARY"
+	case OpExtendClass:
+		return "EXTEND_CLASS"
 	default:
 		return "UNKNOWN"
 	}
@@ -565,6 +644,11 @@ func (op Opcode) String() string {
 //   - SuperClass: "Object"
 //   - Fields: ["count"]
 //   - Methods: [initialize, increment, value]
+//
+// FieldDefaults holds the default values of fields declared like
+// | count = 0 |, compiled as a method that assigns them. It runs on every
+// new instance, after the defaults of the superclasses, and is nil when
+// no field has a default.
 type ClassDefinition struct {
 	Name              string                 // Class name (e.g., "Counter")
 	SuperClass        string                 // Superclass name (e.g., "Object")
@@ -573,6 +657,7 @@ type ClassDefinition struct {
 	ClassVarValues    map[string]interface{} // Runtime storage for class variable values
 	Methods           []*MethodDefinition    // Instance method definitions
 	ClassMethods      []*MethodDefinition    // Class method definitions
+	FieldDefaults     *MethodDefinition      // Assigns the field defaults (nil if none)
 }
 
 // MethodDefinition represents a compiled method within a class.
@@ -588,8 +673,13 @@ type ClassDefinition struct {
 //   - Selector: "increment"
 //   - Parameters: []
 //   - Code: bytecode for "count := count + 1"
+//
+// Protocol is the category the method was filed under with a
+// <category: '...'> annotation, such as "accessing". It only organizes
+// methods for browsing and documentation; lookup ignores it.
 type MethodDefinition struct {
 	Selector   string    // Method name/selector (e.g., "increment", "at:put:")
 	Parameters []string  // Parameter names for the method
 	Code       *Bytecode // Compiled bytecode for the method body
+	Protocol   string    // Method category, e.g. "accessing" ("" if none)
 }
@@ -14,7 +14,7 @@
 //   [Header]
 //     Magic Number (4 bytes): "SMOG" (0x534D4F47)
 //     Version (4 bytes): Format version number (currently 1)
-//     Flags (4 bytes): Reserved for future use
+//     Flags (4 bytes): Optional sections present in this file (see below)
 //
 //   [Constants Section]
 //     Count (4 bytes): Number of constants
@@ -37,6 +37,16 @@
 //   0x06 = ClassDefinition (nested structure)
 //   0x07 = MethodDefinition (nested structure)
 //   0x08 = Bytecode (recursive structure for blocks/methods)
+//   0x09 = Fraction (*big.Rat, encoded like a String as "num/den")
+//
+// Flags:
+//   0x01 = Method protocols: each MethodDefinition is followed by its
+//          protocol (a string, empty when the method has none). Only set
+//          when some method in the constant pool has a protocol, so files
+//          without protocols are unchanged.
+//   0x02 = Field defaults: each ClassDefinition ends with a byte that is 1
+//          when its FieldDefaults method follows and 0 when it has none.
+//          Only set when some class in the constant pool has defaults.
 //
 // Example:
 //
@@ -80,6 +90,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 )
 
 // File format constants
@@ -90,8 +102,16 @@ const (
 	// FormatVersion is the current bytecode format version
 	FormatVersion uint32 = 1
 
-	// Reserved flags (currently unused, set to 0)
-	formatFlags uint32 = 0
+	// FlagMethodProtocols marks a file whose method definitions each
+	// end with the method's protocol
+	FlagMethodProtocols uint32 = 1 << 0
+
+	// FlagFieldDefaults marks a file whose class definitions each end
+	// with their field defaults method, if any
+	FlagFieldDefaults uint32 = 1 << 1
+
+	// knownFlags are the header flags this package can read
+	knownFlags = FlagMethodProtocols | FlagFieldDefaults
 )
 
 // Constant type identifiers for serialization
@@ -104,6 +124,7 @@ const (
 	constTypeClass     byte = 0x06
 	constTypeMethod    byte = 0x07
 	constTypeBytecode  byte = 0x08
+	constTypeFraction  byte = 0x09
 )
 
 // Encode serializes bytecode to binary format and writes it to w.
@@ -131,12 +152,13 @@ const (
 // unsupported types.
 func Encode(bc *Bytecode, w io.Writer) error {
 	// Write header
-	if err := writeHeader(w); err != nil {
+	flags := headerFlags(bc)
+	if err := writeHeader(w, flags); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
 
 	// Write constants section
-	if err := writeConstants(w, bc.Constants); err != nil {
+	if err := writeConstants(w, bc.Constants, flags); err != nil {
 		return fmt.Errorf("failed to write constants: %w", err)
 	}
 
@@ -176,7 +198,7 @@ func Encode(bc *Bytecode, w io.Writer) error {
 //   - Unexpected end of file
 func Decode(r io.Reader) (*Bytecode, error) {
 	// Read and validate header
-	version, err := readHeader(r)
+	version, flags, err := readHeader(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
@@ -185,9 +207,12 @@ func Decode(r io.Reader) (*Bytecode, error) {
 	if version != FormatVersion {
 		return nil, fmt.Errorf("unsupported bytecode version: %d (expected %d)", version, FormatVersion)
 	}
+	if flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("unsupported bytecode flags: 0x%08X", flags)
+	}
 
 	// Read constants section
-	constants, err := readConstants(r)
+	constants, err := readConstants(r, flags)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read constants: %w", err)
 	}
@@ -204,13 +229,133 @@ func Decode(r io.Reader) (*Bytecode, error) {
 	}, nil
 }
 
+// FileInfo summarizes a .sg file: its header and the size of its code,
+// without disassembling it. See ReadInfo.
+type FileInfo struct {
+	Version      uint32 // Format version from the header
+	Flags        uint32 // Header flags, such as FlagMethodProtocols
+	Constants    int    // Number of top-level constants
+	Instructions int    // Number of top-level instructions
+
+	// HasLineTable and HasChecksum report whether the file stores source
+	// line numbers and a checksum. Version 1 files store neither.
+	HasLineTable bool
+	HasChecksum  bool
+
+	// OpcodeCounts counts each opcode used anywhere in the file: the
+	// top-level code plus every nested block and method body.
+	OpcodeCounts map[Opcode]int
+}
+
+// TotalInstructions returns the number of instructions in the file,
+// including those in nested blocks and methods.
+func (info *FileInfo) TotalInstructions() int {
+	total := 0
+	for _, n := range info.OpcodeCounts {
+		total += n
+	}
+	return total
+}
+
+// ReadInfo reads a .sg file from r and summarizes it.
+//
+// The header is validated like Decode does, except that files of other
+// format versions are reported rather than rejected, as long as their
+// sections can still be read. Constants must be read to find the
+// instructions, but nothing is executed.
+//
+// Example:
+//
+//   file, _ := os.Open("program.sg")
+//   info, _ := bytecode.ReadInfo(file)
+//   fmt.Println(info.Constants, info.Instructions, info.OpcodeCounts[bytecode.OpSend])
+func ReadInfo(r io.Reader) (*FileInfo, error) {
+	version, flags, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	constants, err := readConstants(r, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read constants: %w", err)
+	}
+
+	instructions, err := readInstructions(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instructions: %w", err)
+	}
+
+	info := &FileInfo{
+		Version:      version,
+		Flags:        flags,
+		Constants:    len(constants),
+		Instructions: len(instructions),
+		OpcodeCounts: make(map[Opcode]int),
+	}
+	countOpcodes(&Bytecode{Instructions: instructions, Constants: constants}, info.OpcodeCounts)
+	return info, nil
+}
+
+// countOpcodes adds the opcodes of bc, and of the blocks and methods in
+// its constant pool, to counts.
+func countOpcodes(bc *Bytecode, counts map[Opcode]int) {
+	if bc == nil {
+		return
+	}
+	for _, inst := range bc.Instructions {
+		counts[inst.Op]++
+	}
+	for _, c := range bc.Constants {
+		switch v := c.(type) {
+		case *Bytecode:
+			countOpcodes(v, counts)
+		case *MethodDefinition:
+			countOpcodes(v.Code, counts)
+		case *ClassDefinition:
+			for _, method := range append(append([]*MethodDefinition{}, v.Methods...), v.ClassMethods...) {
+				countOpcodes(method.Code, counts)
+			}
+			if v.FieldDefaults != nil {
+				countOpcodes(v.FieldDefaults.Code, counts)
+			}
+		}
+	}
+}
+
+// headerFlags answers the flags for bc's header: FlagMethodProtocols when
+// a method defined in its constant pool has a protocol, and
+// FlagFieldDefaults when a class there has field defaults. Nested blocks
+// and methods are encoded with headers of their own, so they aren't
+// searched.
+func headerFlags(bc *Bytecode) uint32 {
+	var flags uint32
+	for _, c := range bc.Constants {
+		var methods []*MethodDefinition
+		switch v := c.(type) {
+		case *MethodDefinition:
+			methods = []*MethodDefinition{v}
+		case *ClassDefinition:
+			methods = append(append(methods, v.Methods...), v.ClassMethods...)
+			if v.FieldDefaults != nil {
+				flags |= FlagFieldDefaults
+			}
+		}
+		for _, method := range methods {
+			if method.Protocol != "" {
+				flags |= FlagMethodProtocols
+			}
+		}
+	}
+	return flags
+}
+
 // writeHeader writes the file header to w.
 //
 // Header format:
 //   - Magic number (4 bytes): File signature
 //   - Version (4 bytes): Format version
-//   - Flags (4 bytes): Reserved for future use
-func writeHeader(w io.Writer) error {
+//   - Flags (4 bytes): Optional sections present in the file
+func writeHeader(w io.Writer, flags uint32) error {
 	// Write magic number
 	if err := binary.Write(w, binary.LittleEndian, MagicNumber); err != nil {
 		return err
@@ -221,8 +366,8 @@ func writeHeader(w io.Writer) error {
 		return err
 	}
 
-	// Write flags (reserved, currently 0)
-	if err := binary.Write(w, binary.LittleEndian, formatFlags); err != nil {
+	// Write flags
+	if err := binary.Write(w, binary.LittleEndian, flags); err != nil {
 		return err
 	}
 
@@ -231,33 +376,31 @@ func writeHeader(w io.Writer) error {
 
 // readHeader reads and validates the file header from r.
 //
-// Returns the format version if successful, or an error if:
+// Returns the format version and flags if successful, or an error if:
 //   - Magic number doesn't match (wrong file type)
 //   - Read fails (corrupted file or I/O error)
-func readHeader(r io.Reader) (uint32, error) {
+func readHeader(r io.Reader) (version, flags uint32, err error) {
 	// Read and verify magic number
 	var magic uint32
 	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	if magic != MagicNumber {
-		return 0, fmt.Errorf("invalid magic number: 0x%08X (expected 0x%08X)", magic, MagicNumber)
+		return 0, 0, fmt.Errorf("invalid magic number: 0x%08X (expected 0x%08X)", magic, MagicNumber)
 	}
 
 	// Read version
-	var version uint32
 	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	// Read flags (currently ignored)
-	var flags uint32
+	// Read flags; the caller checks them
 	if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return version, nil
+	return version, flags, nil
 }
 
 // writeConstants writes the constants section to w.
@@ -275,7 +418,9 @@ func readHeader(r io.Reader) (uint32, error) {
 //   - ClassDefinition: nested structure
 //   - MethodDefinition: nested structure
 //   - *Bytecode: recursively encoded bytecode (for blocks/methods)
-func writeConstants(w io.Writer, constants []interface{}) error {
+//
+// flags are the header flags, which decide how methods are written.
+func writeConstants(w io.Writer, constants []interface{}, flags uint32) error {
 	// Write count
 	count := uint32(len(constants))
 	if err := binary.Write(w, binary.LittleEndian, count); err != nil {
@@ -284,7 +429,7 @@ func writeConstants(w io.Writer, constants []interface{}) error {
 
 	// Write each constant
 	for i, c := range constants {
-		if err := writeConstant(w, c); err != nil {
+		if err := writeConstant(w, c, flags); err != nil {
 			return fmt.Errorf("failed to write constant %d: %w", i, err)
 		}
 	}
@@ -297,7 +442,7 @@ func writeConstants(w io.Writer, constants []interface{}) error {
 // The format is: type byte followed by type-specific data.
 // This function handles all the constant types that can appear
 // in the constant pool.
-func writeConstant(w io.Writer, c interface{}) error {
+func writeConstant(w io.Writer, c interface{}, flags uint32) error {
 	switch v := c.(type) {
 	case int64:
 		// Integer: type byte + 8 bytes
@@ -325,6 +470,18 @@ func writeConstant(w io.Writer, c interface{}) error {
 		_, err := w.Write([]byte(v))
 		return err
 
+	case *big.Rat:
+		// Fraction: type byte + "num/den" encoded like a string
+		if err := binary.Write(w, binary.LittleEndian, constTypeFraction); err != nil {
+			return err
+		}
+		text := v.String()
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(text))); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte(text))
+		return err
+
 	case bool:
 		// Boolean: type byte + 1 byte (0 or 1)
 		if err := binary.Write(w, binary.LittleEndian, constTypeBoolean); err != nil {
@@ -345,14 +502,14 @@ func writeConstant(w io.Writer, c interface{}) error {
 		if err := binary.Write(w, binary.LittleEndian, constTypeClass); err != nil {
 			return err
 		}
-		return writeClassDefinition(w, v)
+		return writeClassDefinition(w, v, flags)
 
 	case *MethodDefinition:
 		// MethodDefinition: complex nested structure
 		if err := binary.Write(w, binary.LittleEndian, constTypeMethod); err != nil {
 			return err
 		}
-		return writeMethodDefinition(w, v)
+		return writeMethodDefinition(w, v, flags)
 
 	case *Bytecode:
 		// Bytecode (for blocks/methods): recursively encode
@@ -370,9 +527,12 @@ func writeConstant(w io.Writer, c interface{}) error {
 //
 // Returns a slice of constants that can contain:
 //   - int64, float64, string, bool, nil values
+//   - *big.Rat (fraction literals)
 //   - *ClassDefinition, *MethodDefinition
 //   - *Bytecode (for blocks/methods)
-func readConstants(r io.Reader) ([]interface{}, error) {
+//
+// flags are the header flags, which decide how methods are read.
+func readConstants(r io.Reader, flags uint32) ([]interface{}, error) {
 	// Read count
 	var count uint32
 	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
@@ -382,7 +542,7 @@ func readConstants(r io.Reader) ([]interface{}, error) {
 	// Read each constant
 	constants := make([]interface{}, count)
 	for i := uint32(0); i < count; i++ {
-		c, err := readConstant(r)
+		c, err := readConstant(r, flags)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read constant %d: %w", i, err)
 		}
@@ -396,7 +556,7 @@ func readConstants(r io.Reader) ([]interface{}, error) {
 //
 // Reads the type byte first, then reads the appropriate data
 // based on the type.
-func readConstant(r io.Reader) (interface{}, error) {
+func readConstant(r io.Reader, flags uint32) (interface{}, error) {
 	// Read type byte
 	var constType byte
 	if err := binary.Read(r, binary.LittleEndian, &constType); err != nil {
@@ -430,6 +590,21 @@ func readConstant(r io.Reader) (interface{}, error) {
 		}
 		return string(buf), nil
 
+	case constTypeFraction:
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		v, ok := new(big.Rat).SetString(string(buf))
+		if !ok {
+			return nil, fmt.Errorf("invalid fraction constant: %q", buf)
+		}
+		return v, nil
+
 	case constTypeBoolean:
 		var b byte
 		if err := binary.Read(r, binary.LittleEndian, &b); err != nil {
@@ -441,10 +616,10 @@ func readConstant(r io.Reader) (interface{}, error) {
 		return nil, nil
 
 	case constTypeClass:
-		return readClassDefinition(r)
+		return readClassDefinition(r, flags)
 
 	case constTypeMethod:
-		return readMethodDefinition(r)
+		return readMethodDefinition(r, flags)
 
 	case constTypeBytecode:
 		return Decode(r)
@@ -476,6 +651,10 @@ func writeInstructions(w io.Writer, instructions []Instruction) error {
 		}
 
 		// Write operand (4 bytes, signed)
+		// Operands that don't fit would be silently truncated, so reject them
+		if instr.Operand < math.MinInt32 || instr.Operand > math.MaxInt32 {
+			return fmt.Errorf("instruction %d operand %d does not fit in 32 bits", i, instr.Operand)
+		}
 		if err := binary.Write(w, binary.LittleEndian, int32(instr.Operand)); err != nil {
 			return fmt.Errorf("failed to write instruction %d operand: %w", i, err)
 		}
@@ -527,7 +706,9 @@ func readInstructions(r io.Reader) ([]Instruction, error) {
 //   - ClassVar count (4 bytes) + classvar names (strings)
 //   - Method count (4 bytes) + methods (MethodDefinitions)
 //   - ClassMethod count (4 bytes) + class methods (MethodDefinitions)
-func writeClassDefinition(w io.Writer, cd *ClassDefinition) error {
+//   - Field defaults, only when flags include FlagFieldDefaults: 1 byte,
+//     1 followed by a MethodDefinition or 0 for none
+func writeClassDefinition(w io.Writer, cd *ClassDefinition, flags uint32) error {
 	// Write name
 	if err := writeString(w, cd.Name); err != nil {
 		return err
@@ -549,20 +730,31 @@ func writeClassDefinition(w io.Writer, cd *ClassDefinition) error {
 	}
 
 	// Write methods
-	if err := writeMethodSlice(w, cd.Methods); err != nil {
+	if err := writeMethodSlice(w, cd.Methods, flags); err != nil {
 		return err
 	}
 
 	// Write class methods
-	if err := writeMethodSlice(w, cd.ClassMethods); err != nil {
+	if err := writeMethodSlice(w, cd.ClassMethods, flags); err != nil {
 		return err
 	}
 
+	// Write field defaults
+	if flags&FlagFieldDefaults != 0 {
+		if cd.FieldDefaults == nil {
+			return binary.Write(w, binary.LittleEndian, byte(0))
+		}
+		if err := binary.Write(w, binary.LittleEndian, byte(1)); err != nil {
+			return err
+		}
+		return writeMethodDefinition(w, cd.FieldDefaults, flags)
+	}
+
 	return nil
 }
 
 // readClassDefinition reads a ClassDefinition from r.
-func readClassDefinition(r io.Reader) (*ClassDefinition, error) {
+func readClassDefinition(r io.Reader, flags uint32) (*ClassDefinition, error) {
 	// Read name
 	name, err := readString(r)
 	if err != nil {
@@ -588,17 +780,31 @@ func readClassDefinition(r io.Reader) (*ClassDefinition, error) {
 	}
 
 	// Read methods
-	methods, err := readMethodSlice(r)
+	methods, err := readMethodSlice(r, flags)
 	if err != nil {
 		return nil, err
 	}
 
 	// Read class methods
-	classMethods, err := readMethodSlice(r)
+	classMethods, err := readMethodSlice(r, flags)
 	if err != nil {
 		return nil, err
 	}
 
+	// Read field defaults
+	var defaults *MethodDefinition
+	if flags&FlagFieldDefaults != 0 {
+		var present byte
+		if err := binary.Read(r, binary.LittleEndian, &present); err != nil {
+			return nil, err
+		}
+		if present == 1 {
+			if defaults, err = readMethodDefinition(r, flags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return &ClassDefinition{
 		Name:           name,
 		SuperClass:     superClass,
@@ -607,6 +813,7 @@ func readClassDefinition(r io.Reader) (*ClassDefinition, error) {
 		ClassVarValues: make(map[string]interface{}), // Initialize empty map
 		Methods:        methods,
 		ClassMethods:   classMethods,
+		FieldDefaults:  defaults,
 	}, nil
 }
 
@@ -616,7 +823,8 @@ func readClassDefinition(r io.Reader) (*ClassDefinition, error) {
 //   - Selector (string: 4-byte length + UTF-8)
 //   - Parameter count (4 bytes) + parameter names (strings)
 //   - Code (Bytecode, recursively encoded)
-func writeMethodDefinition(w io.Writer, md *MethodDefinition) error {
+//   - Protocol (string), only when flags include FlagMethodProtocols
+func writeMethodDefinition(w io.Writer, md *MethodDefinition, flags uint32) error {
 	// Write selector
 	if err := writeString(w, md.Selector); err != nil {
 		return err
@@ -628,11 +836,19 @@ func writeMethodDefinition(w io.Writer, md *MethodDefinition) error {
 	}
 
 	// Write code (bytecode)
-	return Encode(md.Code, w)
+	if err := Encode(md.Code, w); err != nil {
+		return err
+	}
+
+	// Write protocol
+	if flags&FlagMethodProtocols != 0 {
+		return writeString(w, md.Protocol)
+	}
+	return nil
 }
 
 // readMethodDefinition reads a MethodDefinition from r.
-func readMethodDefinition(r io.Reader) (*MethodDefinition, error) {
+func readMethodDefinition(r io.Reader, flags uint32) (*MethodDefinition, error) {
 	// Read selector
 	selector, err := readString(r)
 	if err != nil {
@@ -651,10 +867,19 @@ func readMethodDefinition(r io.Reader) (*MethodDefinition, error) {
 		return nil, err
 	}
 
+	// Read protocol
+	protocol := ""
+	if flags&FlagMethodProtocols != 0 {
+		if protocol, err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+
 	return &MethodDefinition{
 		Selector:   selector,
 		Parameters: params,
 		Code:       code,
+		Protocol:   protocol,
 	}, nil
 }
 
@@ -710,27 +935,27 @@ func readStringSlice(r io.Reader) ([]string, error) {
 	return slice, nil
 }
 
-func writeMethodSlice(w io.Writer, slice []*MethodDefinition) error {
+func writeMethodSlice(w io.Writer, slice []*MethodDefinition, flags uint32) error {
 	count := uint32(len(slice))
 	if err := binary.Write(w, binary.LittleEndian, count); err != nil {
 		return err
 	}
 	for _, md := range slice {
-		if err := writeMethodDefinition(w, md); err != nil {
+		if err := writeMethodDefinition(w, md, flags); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func readMethodSlice(r io.Reader) ([]*MethodDefinition, error) {
+func readMethodSlice(r io.Reader, flags uint32) ([]*MethodDefinition, error) {
 	var count uint32
 	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
 		return nil, err
 	}
 	slice := make([]*MethodDefinition, count)
 	for i := uint32(0); i < count; i++ {
-		md, err := readMethodDefinition(r)
+		md, err := readMethodDefinition(r, flags)
 		if err != nil {
 			return nil, err
 		}
@@ -2,6 +2,8 @@ package bytecode
 
 import (
 	"bytes"
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -79,6 +81,7 @@ func TestEncodeDecodeAllConstantTypes(t *testing.T) {
 			true,                // Boolean true
 			false,               // Boolean false
 			nil,                 // Nil
+			big.NewRat(-3, 4),   // Fraction
 		},
 	}
 
@@ -128,6 +131,11 @@ func TestEncodeDecodeAllConstantTypes(t *testing.T) {
 	if decoded.Constants[5] != nil {
 		t.Errorf("Nil constant mismatch: got %v, want nil", decoded.Constants[5])
 	}
+
+	// Fraction
+	if r, ok := decoded.Constants[6].(*big.Rat); !ok || r.Cmp(big.NewRat(-3, 4)) != 0 {
+		t.Errorf("Fraction constant mismatch: got %v, want -3/4", decoded.Constants[6])
+	}
 }
 
 // TestEncodeDecodeAllOpcodes tests encoding and decoding of all opcodes.
@@ -343,6 +351,134 @@ func TestEncodeDecodeClassDefinition(t *testing.T) {
 	}
 }
 
+// TestEncodeDecodeMethodProtocols tests that method protocols survive a
+// round trip behind FlagMethodProtocols, and that files without protocols
+// don't set the flag
+func TestEncodeDecodeMethodProtocols(t *testing.T) {
+	method := func(selector, protocol string) *MethodDefinition {
+		return &MethodDefinition{
+			Selector: selector,
+			Code:     &Bytecode{Instructions: []Instruction{{Op: OpPushSelf}, {Op: OpReturn}}},
+			Protocol: protocol,
+		}
+	}
+	classDef := &ClassDefinition{
+		Name:           "Account",
+		SuperClass:     "Object",
+		ClassVarValues: make(map[string]interface{}),
+		Methods:        []*MethodDefinition{method("balance", "accessing"), method("reset", "")},
+		ClassMethods:   []*MethodDefinition{method("named:", "instance creation")},
+	}
+	original := &Bytecode{
+		Instructions: []Instruction{{Op: OpDefineClass, Operand: 0}},
+		Constants:    []interface{}{classDef},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(original, &buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	info, err := ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.Flags != FlagMethodProtocols {
+		t.Errorf("Expected flags 0x%08X, got 0x%08X", FlagMethodProtocols, info.Flags)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	decodedClass := decoded.Constants[0].(*ClassDefinition)
+	for i, want := range []string{"accessing", ""} {
+		if got := decodedClass.Methods[i].Protocol; got != want {
+			t.Errorf("Method %s: expected protocol %q, got %q", decodedClass.Methods[i].Selector, want, got)
+		}
+	}
+	if got := decodedClass.ClassMethods[0].Protocol; got != "instance creation" {
+		t.Errorf("Expected class method protocol 'instance creation', got %q", got)
+	}
+
+	// Without protocols the file is written exactly as before
+	classDef.Methods[0].Protocol = ""
+	classDef.ClassMethods[0].Protocol = ""
+	buf.Reset()
+	if err := Encode(original, &buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if info, err := ReadInfo(bytes.NewReader(buf.Bytes())); err != nil || info.Flags != 0 {
+		t.Errorf("Expected no flags without protocols, got %v (%v)", info, err)
+	}
+}
+
+// TestEncodeDecodeFieldDefaults tests that field defaults survive a round
+// trip behind FlagFieldDefaults, for classes with and without them
+func TestEncodeDecodeFieldDefaults(t *testing.T) {
+	defaults := &MethodDefinition{
+		Selector: "fieldDefaults",
+		Code: &Bytecode{
+			Instructions: []Instruction{{Op: OpPush, Operand: 0}, {Op: OpStoreField, Operand: 0}, {Op: OpPushSelf}, {Op: OpReturn}},
+			Constants:    []interface{}{int64(0)},
+		},
+	}
+	counter := &ClassDefinition{
+		Name:           "Counter",
+		SuperClass:     "Object",
+		Fields:         []string{"count"},
+		ClassVarValues: make(map[string]interface{}),
+		FieldDefaults:  defaults,
+	}
+	plain := &ClassDefinition{Name: "Plain", SuperClass: "Object", ClassVarValues: make(map[string]interface{})}
+	original := &Bytecode{
+		Instructions: []Instruction{{Op: OpDefineClass, Operand: 0}, {Op: OpDefineClass, Operand: 1}},
+		Constants:    []interface{}{counter, plain},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(original, &buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	info, err := ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.Flags != FlagFieldDefaults {
+		t.Errorf("Expected flags 0x%08X, got 0x%08X", FlagFieldDefaults, info.Flags)
+	}
+	if info.OpcodeCounts[OpStoreField] != 1 {
+		t.Errorf("Expected the defaults' STORE_FIELD to be counted, got %v", info.OpcodeCounts)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	decodedCounter := decoded.Constants[0].(*ClassDefinition)
+	if decodedCounter.FieldDefaults == nil || len(decodedCounter.FieldDefaults.Code.Instructions) != 4 {
+		t.Fatalf("Expected Counter's field defaults to round trip, got %+v", decodedCounter.FieldDefaults)
+	}
+	if got := decodedCounter.FieldDefaults.Code.Constants[0]; got != int64(0) {
+		t.Errorf("Expected the default constant 0, got %v", got)
+	}
+	if decoded.Constants[1].(*ClassDefinition).FieldDefaults != nil {
+		t.Error("Expected Plain to have no field defaults")
+	}
+}
+
+// TestUnknownFlags tests that decoding rejects header flags it doesn't
+// know how to read.
+func TestUnknownFlags(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{
+		0x47, 0x4F, 0x4D, 0x53, // SMOG magic number
+		1, 0, 0, 0,             // version 1
+		0, 0, 0, 0x80,          // flags
+	})
+	if _, err := Decode(buf); err == nil {
+		t.Fatal("Expected error for unknown flags, got nil")
+	}
+}
+
 // TestInvalidMagicNumber tests that decoding fails with wrong magic number.
 func TestInvalidMagicNumber(t *testing.T) {
 	// Create buffer with wrong magic number
@@ -501,3 +637,77 @@ func TestUnicodeStrings(t *testing.T) {
 		}
 	}
 }
+
+// TestSendOperandAtSelectorLimit tests that the largest packable selector
+// index survives a round trip, and that indices past it are rejected.
+func TestSendOperandAtSelectorLimit(t *testing.T) {
+	operand, err := PackSendOperand(MaxSelectorIndex, ArgCountMask)
+	if err != nil {
+		t.Fatalf("PackSendOperand at limit failed: %v", err)
+	}
+
+	original := &Bytecode{
+		Instructions: []Instruction{
+			{Op: OpSend, Operand: operand},
+			{Op: OpSuperSend, Operand: operand},
+		},
+		Constants: []interface{}{},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(original, &buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	for i, instr := range decoded.Instructions {
+		selectorIdx, argCount, err := UnpackSendOperand(instr.Operand)
+		if err != nil {
+			t.Fatalf("Instruction %d: UnpackSendOperand failed: %v", i, err)
+		}
+		if selectorIdx != MaxSelectorIndex || argCount != ArgCountMask {
+			t.Errorf("Instruction %d: got selector=%d args=%d, want selector=%d args=%d",
+				i, selectorIdx, argCount, MaxSelectorIndex, ArgCountMask)
+		}
+	}
+
+	if _, err := PackSendOperand(MaxSelectorIndex+1, 0); err == nil {
+		t.Error("Expected error for selector index past the limit")
+	}
+	if _, err := PackSendOperand(-1, 0); err == nil {
+		t.Error("Expected error for negative selector index")
+	}
+	if _, err := PackSendOperand(0, ArgCountMask+1); err == nil {
+		t.Error("Expected error for argument count past the limit")
+	}
+}
+
+// TestUnpackCorruptSendOperand tests that sign-extended or oversized
+// operands are reported instead of decoding to a bogus selector.
+func TestUnpackCorruptSendOperand(t *testing.T) {
+	for _, operand := range []int{-1, -(1 << 8), math.MaxInt32 + 1} {
+		if _, _, err := UnpackSendOperand(operand); err == nil {
+			t.Errorf("Expected error unpacking operand %d", operand)
+		}
+	}
+}
+
+// TestEncodeRejectsOversizedOperand tests that operands wider than 32 bits
+// are rejected rather than truncated.
+func TestEncodeRejectsOversizedOperand(t *testing.T) {
+	bc := &Bytecode{
+		Instructions: []Instruction{
+			{Op: OpSend, Operand: (MaxSelectorIndex + 1) << SelectorIndexShift},
+		},
+		Constants: []interface{}{},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(bc, &buf); err == nil {
+		t.Error("Expected Encode to reject an operand that overflows int32")
+	}
+}
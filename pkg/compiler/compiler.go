@@ -58,6 +58,7 @@ package compiler
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/kristofer/smog/pkg/ast"
 	"github.com/kristofer/smog/pkg/bytecode"
@@ -75,6 +76,7 @@ import (
 //   - fields: Map of instance/class variable names to field indices (used in methods)
 //   - classVars: Class variable table
 //   - classes: Registry of compiled classes
+//   - globals: Names assigned as globals (reported by Symbols)
 //   - inBlock: True if currently compiling inside a block
 //
 // Lexical Scoping:
@@ -109,7 +111,12 @@ type Compiler struct {
 	fields       map[string]int                         // Field table: field name -> field index
 	classVars    map[string]int                         // Class variable table: name -> index
 	classes      map[string]*bytecode.ClassDefinition   // Registry of compiled classes
+	globals      map[string]bool                        // Globals assigned so far (shared with nested compilers)
+	extended     map[string]bool                        // "Type>>selector" of methods added with extend (shared with nested compilers)
 	inBlock      bool                                   // True if currently compiling inside a block
+	line         int                                    // Current source line, recorded on emitted instructions
+	checks       *checks                                // Problems found so far (shared with nested compilers)
+	strict       bool                                   // True to fail compilation when there are warnings
 }
 
 // New creates a new compiler instance.
@@ -131,17 +138,27 @@ func New() *Compiler {
 		fields:       make(map[string]int),
 		classVars:    make(map[string]int),
 		classes:      make(map[string]*bytecode.ClassDefinition),
+		globals:      make(map[string]bool),
+		extended:     make(map[string]bool),
+		checks:       newChecks(),
 	}
 }
 
 // Compile compiles an AST program into bytecode.
 //
 // This is the main entry point for compilation. It:
-//   1. Processes each statement in the program sequentially
-//   2. Emits bytecode for each statement
+//   1. Emits the program's class definitions, superclasses first
+//   2. Emits bytecode for the other statements, in order
 //   3. Adds a final RETURN instruction to end execution
 //   4. Returns the complete Bytecode with instructions and constants
 //
+// Undeclared variables and unreachable code found along the way are
+// available from Warnings afterwards; in strict mode (see SetStrict) they
+// fail the compilation instead.
+//
+// Class definitions are hoisted so that every class in a file is defined
+// before any top-level code runs, wherever the classes appear in the file.
+//
 // Example:
 //
 //   parser := parser.New("3 + 4.")
@@ -151,11 +168,27 @@ func New() *Compiler {
 //
 // The resulting bytecode can then be executed by the VM.
 //
-// Returns an error if any statement fails to compile (e.g., unknown node type).
+// Returns a *CompileError if any statement fails to compile (e.g., unknown
+// node type).
 func (c *Compiler) Compile(program *ast.Program) (*bytecode.Bytecode, error) {
-	// Compile each statement in order
-	for i, stmt := range program.Statements {
-		isLast := i == len(program.Statements)-1
+	bc, err := c.compileProgram(program)
+	return bc, asCompileError(err)
+}
+
+// compileProgram compiles a whole program for Compile.
+func (c *Compiler) compileProgram(program *ast.Program) (*bytecode.Bytecode, error) {
+	classes, statements := hoistClasses(program.Statements)
+	c.noteExtensions(program.Statements)
+	for _, class := range classes {
+		if err := c.compileClass(class); err != nil {
+			return nil, err
+		}
+	}
+
+	// Compile the remaining statements in order
+	c.checkReachable(statements)
+	for i, stmt := range statements {
+		isLast := i == len(statements)-1
 		if err := c.compileStatementWithContext(stmt, isLast); err != nil {
 			return nil, err
 		}
@@ -164,12 +197,68 @@ func (c *Compiler) Compile(program *ast.Program) (*bytecode.Bytecode, error) {
 	// Add final return instruction to end the program
 	c.emit(bytecode.OpReturn, 0)
 
+	if err := c.strictError(program); err != nil {
+		return nil, err
+	}
+
 	return &bytecode.Bytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
+		LocalNames:   append([]string{}, c.localVars...),
 	}, nil
 }
 
+// hoistClasses separates a program's class definitions from its other
+// statements. The classes are ordered so that a class defined in the
+// program comes after its superclass, letting the subclass inherit the
+// superclass's fields, and an extension comes after the class it extends;
+// otherwise they keep the order they were written in.
+func hoistClasses(stmts []ast.Statement) ([]*ast.Class, []ast.Statement) {
+	var classes []*ast.Class
+	var rest []ast.Statement
+	defined := make(map[string]bool)
+	for _, stmt := range stmts {
+		if class, ok := stmt.(*ast.Class); ok {
+			classes = append(classes, class)
+			if !class.Extension {
+				defined[class.Name] = true
+			}
+		} else {
+			rest = append(rest, stmt)
+		}
+	}
+
+	ordered := make([]*ast.Class, 0, len(classes))
+	placed := make(map[*ast.Class]bool, len(classes))
+	var place func(class *ast.Class)
+	place = func(class *ast.Class) {
+		if placed[class] {
+			return
+		}
+		placed[class] = true // Set first, so a cycle of superclasses can't recurse forever
+		if defined[class.SuperClass] {
+			for _, other := range classes {
+				if other.Name == class.SuperClass && !placed[other] {
+					place(other)
+				}
+			}
+		}
+		// An extension comes after the class it extends
+		if class.Extension && defined[class.Name] {
+			for _, other := range classes {
+				if other.Name == class.Name && !other.Extension && !placed[other] {
+					place(other)
+				}
+			}
+		}
+		ordered = append(ordered, class)
+	}
+	for _, class := range classes {
+		place(class)
+	}
+	return ordered, rest
+}
+
 // compileStatementWithContext compiles a single statement with context about its position.
 //
 // The isLast parameter indicates whether this is the last statement in the current scope.
@@ -315,6 +404,7 @@ func (c *Compiler) compileStatement(stmt ast.Statement) error {
 func (c *Compiler) compileExpression(expr ast.Expression) error {
 	switch e := expr.(type) {
 	case *ast.IntegerLiteral:
+		c.markLine(e.Loc)
 		// Integer literals are stored in the constant pool.
 		// We add the value to the pool and emit a PUSH instruction
 		// with the index.
@@ -336,6 +426,22 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		c.emit(bytecode.OpPush, idx)
 		return nil
 
+	case *ast.FractionLiteral:
+		// Fraction literals are stored in the constant pool as exact
+		// rationals. Whole numbers are pushed as plain integers, the same
+		// way fraction arithmetic normalizes its results.
+		//
+		// Example: 1/2r
+		//   -> constants = [1/2]
+		//   -> PUSH 0
+		c.markLine(e.Loc)
+		var value interface{} = e.Value
+		if e.Value.IsInt() && e.Value.Num().IsInt64() {
+			value = e.Value.Num().Int64()
+		}
+		c.emit(bytecode.OpPush, c.addConstant(value))
+		return nil
+
 	case *ast.StringLiteral:
 		// String literals are also stored in the constant pool.
 		//
@@ -389,6 +495,7 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		//   Example: SomeClass
 		//     -> constants = ["SomeClass"]
 		//     -> LOAD_GLOBAL name_index
+		c.markLine(e.Loc)
 		if e.Name == "self" {
 			// Special case: self keyword
 			c.emit(bytecode.OpPushSelf, 0)
@@ -403,6 +510,7 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 			c.emit(bytecode.OpLoadClassVar, idx)
 		} else {
 			// It's a global variable - add the name to constants
+			c.noteUndeclaredRead(e)
 			idx := c.addConstant(e.Name)
 			c.emit(bytecode.OpLoadGlobal, idx)
 		}
@@ -427,6 +535,7 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		if err := c.compileExpression(e.Value); err != nil {
 			return err
 		}
+		c.markLine(e.Loc)
 
 		// Step 2: Store to the variable
 		// Check if it's local, field, class variable, or global
@@ -442,6 +551,8 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 			// Store as global
 			nameIdx := c.addConstant(e.Name)
 			c.emit(bytecode.OpStoreGlobal, nameIdx)
+			c.globals[e.Name] = true
+			c.noteUndeclaredAssignment(e)
 		}
 		return nil
 
@@ -469,6 +580,59 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		// The SEND/SUPER_SEND instruction's operand encodes:
 		//   - Selector index (high bits): where to find the selector in constants
 		//   - Argument count (low 8 bits): how many args to pop from stack
+		if err := checkArgumentCount(e.Selector, len(e.Args), expressionLoc(e)); err != nil {
+			return err
+		}
+
+		// Fast path: `ClassName new` for a class defined earlier in the
+		// program allocates directly instead of sending a message.
+		//
+		// Example: Point new
+		//   -> constants = ["Point"]
+		//   -> NEW_OBJECT 0
+		if name, ok := c.newObjectClassName(e); ok {
+			c.markLine(e.Loc)
+			c.emit(bytecode.OpNewObject, c.addConstant(name))
+			return nil
+		}
+
+		// Fast path: value messages sent to a block literal call the
+		// block directly instead of going through selector lookup.
+		//
+		// Example: [:x | x + 1] value: 2
+		//   -> MAKE_CLOSURE ...  ; the block
+		//   -> PUSH 0            ; 2
+		//   -> CALL_BLOCK 1
+		if _, isBlock := e.Receiver.(*ast.BlockLiteral); isBlock && !e.IsSuper &&
+			len(e.Args) <= bytecode.ArgCountMask && e.Selector == bytecode.BlockValueSelector(len(e.Args)) {
+			if err := c.compileExpression(e.Receiver); err != nil {
+				return err
+			}
+			for _, arg := range e.Args {
+				if err := c.compileExpression(arg); err != nil {
+					return err
+				}
+			}
+			c.markLine(e.Loc)
+			c.emit(bytecode.OpCallBlock, len(e.Args))
+			return nil
+		}
+
+		// Constant folding: concatenating string literals needs no
+		// message send, so the result is computed at compile time.
+		// Not when a String extension seen by this compiler defines ,
+		// since the method has to run instead. An extension compiled by
+		// another compiler, and run on the same VM, can't be seen, so
+		// such a program should not rely on , between literals.
+		//
+		// Example: 'Hello, ' , 'world'
+		//   -> constants = ["Hello, world"]
+		//   -> PUSH 0
+		if value, ok := foldStringConcat(e); ok && !c.extended["String>>,"] {
+			c.markLine(e.Loc)
+			c.emit(bytecode.OpPush, c.addConstant(value))
+			return nil
+		}
 
 		// Step 1: Compile the receiver expression (unless it's a super send)
 		if e.IsSuper {
@@ -495,8 +659,12 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		// Pack selector index and arg count into a single operand
 		// High bits: selector index
 		// Low 8 bits: argument count
-		operand := (selectorIdx << bytecode.SelectorIndexShift) | argCount
+		operand, err := bytecode.PackSendOperand(selectorIdx, argCount)
+		if err != nil {
+			return errorAt(expressionLoc(e), "cannot compile send of %s: %v", e.Selector, err)
+		}
 		
+		c.markLine(e.Loc)
 		if e.IsSuper {
 			c.emit(bytecode.OpSuperSend, operand)
 		} else {
@@ -545,6 +713,24 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		c.emit(bytecode.OpMakeArray, len(e.Elements))
 		return nil
 
+	case *ast.BraceArray:
+		// Brace arrays compile exactly like array literals: each element
+		// expression is evaluated in order, then MAKE_ARRAY collects them.
+		//
+		// Example: {1 + 1. x}
+		//   -> PUSH 1
+		//   -> PUSH 1
+		//   -> SEND +
+		//   -> LOAD_LOCAL 0
+		//   -> MAKE_ARRAY 2
+		for _, elem := range e.Elements {
+			if err := c.compileExpression(elem); err != nil {
+				return err
+			}
+		}
+		c.emit(bytecode.OpMakeArray, len(e.Elements))
+		return nil
+
 	case *ast.DictionaryLiteral:
 		// Dictionary literals compile to a sequence of key-value pushes
 		// followed by a MAKE_DICTIONARY instruction.
@@ -607,13 +793,20 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 		//   POP            ; [point]
 		//   ; Final: point is on stack
 		
-		// Step 1: Compile and push the receiver
-		if err := c.compileExpression(e.Receiver); err != nil {
+		// Step 1: Compile and push the receiver, which is self for a
+		// cascade of super sends (super a; b)
+		if e.Receiver == nil && len(e.Messages) > 0 && e.Messages[0].IsSuper {
+			c.emit(bytecode.OpPushSelf, 0)
+		} else if err := c.compileExpression(e.Receiver); err != nil {
 			return err
 		}
 		
 		// Step 2: For each message in the cascade
 		for _, msg := range e.Messages {
+			if err := checkArgumentCount(msg.Selector, len(msg.Args), msg.Loc); err != nil {
+				return err
+			}
+
 			// Duplicate the receiver so we can send a message to it
 			c.emit(bytecode.OpDup, 0)
 			
@@ -627,7 +820,10 @@ func (c *Compiler) compileExpression(expr ast.Expression) error {
 			// Emit the SEND instruction
 			selectorIdx := c.addConstant(msg.Selector)
 			argCount := len(msg.Args)
-			operand := (selectorIdx << bytecode.SelectorIndexShift) | argCount
+			operand, err := bytecode.PackSendOperand(selectorIdx, argCount)
+			if err != nil {
+				return errorAt(msg.Loc, "cannot compile send of %s: %v", msg.Selector, err)
+			}
 			
 			if msg.IsSuper {
 				c.emit(bytecode.OpSuperSend, operand)
@@ -665,12 +861,16 @@ func (c *Compiler) compileBlockLiteral(block *ast.BlockLiteral) error {
 	
 	// Mark that we're compiling a block - this affects how return statements are compiled
 	blockCompiler.inBlock = true
+	blockCompiler.line = c.line
 	
 	// Blocks should have access to the same fields and class variables as the parent context
 	// This allows blocks to access instance variables and class variables
 	blockCompiler.fields = c.fields
 	blockCompiler.classVars = c.classVars
 	blockCompiler.classes = c.classes
+	blockCompiler.globals = c.globals
+	blockCompiler.extended = c.extended
+	blockCompiler.checks = c.checks
 	
 	// Copy parent's local variables to support closures
 	// NOTE: This is a temporary flat-copy approach that provides basic closure support
@@ -692,6 +892,7 @@ func (c *Compiler) compileBlockLiteral(block *ast.BlockLiteral) error {
 	}
 	
 	// Compile the block body statements
+	blockCompiler.checkReachable(block.Body)
 	for i, stmt := range block.Body {
 		isLast := i == len(block.Body)-1
 		if err := blockCompiler.compileStatementWithContext(stmt, isLast); err != nil {
@@ -707,6 +908,7 @@ func (c *Compiler) compileBlockLiteral(block *ast.BlockLiteral) error {
 	blockBytecode := &bytecode.Bytecode{
 		Instructions: blockCompiler.instructions,
 		Constants:    blockCompiler.constants,
+		LocalNames:   blockCompiler.localVars,
 	}
 	
 	// Add the block bytecode to the constant pool
@@ -738,9 +940,19 @@ func (c *Compiler) emit(op bytecode.Opcode, operand int) {
 	c.instructions = append(c.instructions, bytecode.Instruction{
 		Op:      op,
 		Operand: operand,
+		Line:    c.line,
 	})
 }
 
+// markLine records the source line of a node so that the instructions
+// emitted for it can be mapped back to the source (used by the debugger).
+// Nodes without location information (line 0) leave the current line as is.
+func (c *Compiler) markLine(loc ast.SourceLocation) {
+	if loc.Line > 0 {
+		c.line = loc.Line
+	}
+}
+
 // addConstant adds a value to the constant pool and returns its index.
 //
 // The constant pool stores all literal values and identifiers used in
@@ -765,6 +977,61 @@ func (c *Compiler) addConstant(obj interface{}) int {
 	return len(c.constants) - 1
 }
 
+// checkArgumentCount rejects a message with more arguments than a send
+// operand can encode. The count occupies the operand's low 8 bits, so a
+// 256th argument would spill into the selector index and the VM would
+// send the wrong message. The limit is far beyond anything written by
+// hand, but generated code can reach it. loc is where the send starts,
+// or the zero location for a method definition.
+func checkArgumentCount(selector string, argCount int, loc ast.SourceLocation) error {
+	if argCount <= bytecode.ArgCountMask {
+		return nil
+	}
+	// Long selectors are mostly repeated keywords; the start identifies them
+	if len(selector) > 40 {
+		selector = selector[:40] + "..."
+	}
+	return errorAt(loc, "message %s has %d arguments; a message can have at most %d",
+		selector, argCount, bytecode.ArgCountMask)
+}
+
+// noteExtensions records the methods the extensions among stmts add to
+// built-in types, before any statement is compiled, so an extension
+// further down the program still stops constant folding above it.
+func (c *Compiler) noteExtensions(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		if class, ok := stmt.(*ast.Class); ok && class.Extension {
+			for _, method := range class.Methods {
+				c.extended[class.Name+">>"+method.Name] = true
+			}
+		}
+	}
+}
+
+// foldStringConcat returns the string expr evaluates to when it is a string
+// literal or a chain of , sends between string literals, such as
+// 'a' , 'b' , 'c'. Any other operand, like a variable, prevents folding.
+func foldStringConcat(expr ast.Expression) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.StringLiteral:
+		return e.Value, true
+	case *ast.MessageSend:
+		if e.IsSuper || e.Selector != "," || len(e.Args) != 1 {
+			return "", false
+		}
+		left, ok := foldStringConcat(e.Receiver)
+		if !ok {
+			return "", false
+		}
+		right, ok := foldStringConcat(e.Args[0])
+		if !ok {
+			return "", false
+		}
+		return left + right, true
+	}
+	return "", false
+}
+
 // CompileIncremental compiles a program while preserving the symbol table.
 //
 // This method is designed for REPL usage where variable declarations and
@@ -795,12 +1062,13 @@ func (c *Compiler) CompileIncremental(program *ast.Program) (*bytecode.Bytecode,
 	// Use slice reuse pattern to preserve capacity for better performance
 	c.instructions = c.instructions[:0]
 	c.constants = c.constants[:0]
+	c.noteExtensions(program.Statements)
 	
 	// Compile each statement in order
 	for i, stmt := range program.Statements {
 		isLast := i == len(program.Statements)-1
 		if err := c.compileStatementWithContext(stmt, isLast); err != nil {
-			return nil, err
+			return nil, asCompileError(err)
 		}
 	}
 
@@ -810,9 +1078,120 @@ func (c *Compiler) CompileIncremental(program *ast.Program) (*bytecode.Bytecode,
 	return &bytecode.Bytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
+		LocalNames:   append([]string{}, c.localVars...),
 	}, nil
 }
 
+// SymbolKind classifies a name reported by Symbols.
+type SymbolKind int
+
+const (
+	SymbolLocal    SymbolKind = iota // Local variable, addressed by slot
+	SymbolField                      // Instance variable, addressed by field index
+	SymbolClassVar                   // Class variable, addressed by index
+	SymbolGlobal                     // Global variable, addressed by name
+	SymbolClass                      // Class compiled by this compiler, addressed by name
+)
+
+// String returns a lowercase name for the kind, e.g. "local".
+func (k SymbolKind) String() string {
+	switch k {
+	case SymbolLocal:
+		return "local"
+	case SymbolField:
+		return "field"
+	case SymbolClassVar:
+		return "classVar"
+	case SymbolGlobal:
+		return "global"
+	case SymbolClass:
+		return "class"
+	}
+	return fmt.Sprintf("SymbolKind(%d)", int(k))
+}
+
+// Symbol describes a name the compiler knows how to resolve.
+//
+// Slot is the local slot, field index or class variable index the name
+// compiles to. Globals and classes are looked up by name at runtime, so
+// their Slot is -1.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	Slot int
+}
+
+// Symbols returns the names currently visible to this compiler, for tools
+// such as REPL completion and inspectors.
+//
+// Symbols are reported in resolution order: locals by slot, then fields and
+// class variables by index, then globals and classes by name. Globals are
+// the names assigned without a declaration so far; globals defined only at
+// runtime (or built-in classes) are not included. The result is a fresh
+// slice, so callers may keep or modify it.
+//
+// With CompileIncremental the result accumulates across calls:
+//
+//   c.CompileIncremental(parse("| x y |"))
+//   c.CompileIncremental(parse("total := 0."))
+//   c.Symbols()  -> [x local 0, y local 1, total global -1]
+func (c *Compiler) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(c.localVars)+len(c.fields)+len(c.classVars)+len(c.globals)+len(c.classes))
+	for i, name := range c.localVars {
+		symbols = append(symbols, Symbol{Name: name, Kind: SymbolLocal, Slot: i})
+	}
+	symbols = appendIndexedSymbols(symbols, c.fields, SymbolField)
+	symbols = appendIndexedSymbols(symbols, c.classVars, SymbolClassVar)
+	symbols = appendNamedSymbols(symbols, c.globals, SymbolGlobal)
+	classNames := make(map[string]bool, len(c.classes))
+	for name := range c.classes {
+		classNames[name] = true
+	}
+	symbols = appendNamedSymbols(symbols, classNames, SymbolClass)
+	return symbols
+}
+
+// Selectors returns the selectors a receiver might understand: the VM's
+// built-in selectors (see KnownSelectors), new, and the instance and class
+// methods of every class this compiler has compiled, sorted alphabetically.
+func (c *Compiler) Selectors() []string {
+	seen := map[string]bool{"new": true}
+	for _, selector := range KnownSelectors() {
+		seen[selector] = true
+	}
+	for _, classDef := range c.classes {
+		for _, method := range classDef.Methods {
+			seen[method.Selector] = true
+		}
+		for _, method := range classDef.ClassMethods {
+			seen[method.Selector] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// appendIndexedSymbols appends the entries of table ordered by index.
+func appendIndexedSymbols(symbols []Symbol, table map[string]int, kind SymbolKind) []Symbol {
+	start := len(symbols)
+	for name, idx := range table {
+		symbols = append(symbols, Symbol{Name: name, Kind: kind, Slot: idx})
+	}
+	added := symbols[start:]
+	sort.Slice(added, func(i, j int) bool { return added[i].Slot < added[j].Slot })
+	return symbols
+}
+
+// appendNamedSymbols appends the names in set ordered alphabetically.
+func appendNamedSymbols(symbols []Symbol, set map[string]bool, kind SymbolKind) []Symbol {
+	start := len(symbols)
+	for name := range set {
+		symbols = append(symbols, Symbol{Name: name, Kind: kind, Slot: -1})
+	}
+	added := symbols[start:]
+	sort.Slice(added, func(i, j int) bool { return added[i].Name < added[j].Name })
+	return symbols
+}
+
 // compileClass compiles a class definition.
 //
 // A class definition consists of:
@@ -841,6 +1220,10 @@ func (c *Compiler) CompileIncremental(program *ast.Program) (*bytecode.Bytecode,
 //   4. Add ClassDefinition to constants at index N
 //   5. Emit DEFINE_CLASS N
 func (c *Compiler) compileClass(class *ast.Class) error {
+	if class.Extension {
+		return c.compileClassExtension(class)
+	}
+
 	// Collect all fields (inherited + own) for method compilation
 	allFields := c.getAllFields(class.SuperClass, class.Fields)
 	
@@ -864,6 +1247,12 @@ func (c *Compiler) compileClass(class *ast.Class) error {
 		classMethods = append(classMethods, methodDef)
 	}
 
+	// Compile the field defaults into a method that assigns them
+	fieldDefaults, err := c.compileFieldDefaults(class, allFields)
+	if err != nil {
+		return err
+	}
+
 	// Create the class definition
 	classDef := &bytecode.ClassDefinition{
 		Name:           class.Name,
@@ -873,6 +1262,7 @@ func (c *Compiler) compileClass(class *ast.Class) error {
 		ClassVarValues: make(map[string]interface{}), // Initialize class variable storage
 		Methods:        instanceMethods,
 		ClassMethods:   classMethods,
+		FieldDefaults:  fieldDefaults,
 	}
 
 	// Register this class so subclasses can access it
@@ -887,6 +1277,76 @@ func (c *Compiler) compileClass(class *ast.Class) error {
 	return nil
 }
 
+// compileFieldDefaults compiles the default values of a class's fields
+// into a method that assigns them in the order the fields are declared,
+// or answers nil if no field has a default. The VM runs it on every new
+// instance, so each instance gets values of its own.
+//
+// Example:
+//   Object subclass: #Counter [ | count = 0 items = Set new | ]
+//
+// compiles the same as a method "count := 0. items := Set new".
+func (c *Compiler) compileFieldDefaults(class *ast.Class, allFields []string) (*bytecode.MethodDefinition, error) {
+	if len(class.FieldDefaults) == 0 {
+		return nil, nil
+	}
+	var body []ast.Statement
+	for _, field := range class.Fields {
+		if value, ok := class.FieldDefaults[field]; ok {
+			body = append(body, &ast.ExpressionStatement{
+				Expression: &ast.Assignment{Name: field, Value: value},
+			})
+		}
+	}
+	method := &ast.Method{Name: "fieldDefaults", Body: body}
+	defaults, err := c.compileMethod(method, allFields, class.ClassVariables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile field defaults: %w", err)
+	}
+	return defaults, nil
+}
+
+// compileClassExtension compiles "Name extend [ ... ]" into a
+// ClassDefinition holding just the new methods, and emits EXTEND_CLASS.
+//
+// Extending a class defined in smog code gives the methods its fields and
+// class variables; a built-in type like Integer has neither.
+//
+// Example:
+//   Integer extend [ double [ ^self * 2 ] ]
+//
+// This compiles to:
+//   1. Create bytecode for the double method
+//   2. Create ClassDefinition{Name: "Integer"} with the method
+//   3. Emit EXTEND_CLASS N
+func (c *Compiler) compileClassExtension(class *ast.Class) error {
+	var fields, classVars []string
+	if existing, ok := c.classes[class.Name]; ok {
+		fields = c.getAllFields(existing.SuperClass, existing.Fields)
+		classVars = existing.ClassVariables
+	}
+
+	methods := make([]*bytecode.MethodDefinition, 0, len(class.Methods))
+	for _, method := range class.Methods {
+		methodDef, err := c.compileMethod(method, fields, classVars)
+		if err != nil {
+			return fmt.Errorf("failed to compile method %s: %w", method.Name, err)
+		}
+		methods = append(methods, methodDef)
+	}
+
+	extension := &bytecode.ClassDefinition{
+		Name:           class.Name,
+		Fields:         []string{},
+		ClassVariables: []string{},
+		ClassVarValues: make(map[string]interface{}),
+		Methods:        methods,
+		ClassMethods:   []*bytecode.MethodDefinition{},
+	}
+	c.emit(bytecode.OpExtendClass, c.addConstant(extension))
+	return nil
+}
+
 // getAllFields returns all fields for a class including inherited fields.
 // Fields are ordered from superclass to subclass to match runtime layout.
 func (c *Compiler) getAllFields(superClassName string, ownFields []string) []string {
@@ -926,8 +1386,17 @@ func (c *Compiler) getAllFields(superClassName string, ownFields []string) []str
 //   PUSH_SELF         ; implicit return self
 //   RETURN
 func (c *Compiler) compileMethod(method *ast.Method, fields []string, classVars []string) (*bytecode.MethodDefinition, error) {
+	// A method no send could reach is an error where it is defined
+	if err := checkArgumentCount(method.Name, len(method.Parameters), ast.SourceLocation{}); err != nil {
+		return nil, err
+	}
+
 	// Create a new compiler for the method body to have its own scope
 	methodCompiler := New()
+	methodCompiler.classes = c.classes
+	methodCompiler.globals = c.globals
+	methodCompiler.extended = c.extended
+	methodCompiler.checks = c.checks
 
 	// Parameters become local variables (in order)
 	for _, param := range method.Parameters {
@@ -954,6 +1423,7 @@ func (c *Compiler) compileMethod(method *ast.Method, fields []string, classVars
 	}
 
 	// Compile method body
+	methodCompiler.checkReachable(method.Body)
 	for i, stmt := range method.Body {
 		isLast := i == len(method.Body)-1
 		if err := methodCompiler.compileStatementWithContext(stmt, isLast); err != nil {
@@ -973,15 +1443,54 @@ func (c *Compiler) compileMethod(method *ast.Method, fields []string, classVars
 	methodDef := &bytecode.MethodDefinition{
 		Selector:   method.Name,
 		Parameters: method.Parameters,
+		Protocol:   method.Protocol,
 		Code: &bytecode.Bytecode{
 			Instructions: methodCompiler.instructions,
 			Constants:    methodCompiler.constants,
+			LocalNames:   methodCompiler.localVars,
 		},
 	}
 
 	return methodDef, nil
 }
 
+// CompileMethod compiles a single method outside of a class definition,
+// for adding or replacing a method on a class that already exists. fields
+// are the instance variable names of the class, superclass fields first
+// (nil for a class method), and classVars its class variable names, so
+// the method accesses them exactly as methods compiled with the class do.
+func (c *Compiler) CompileMethod(method *ast.Method, fields []string, classVars []string) (*bytecode.MethodDefinition, error) {
+	methodDef, err := c.compileMethod(method, fields, classVars)
+	return methodDef, asCompileError(err)
+}
+
+// newObjectClassName reports whether msg is `ClassName new` where ClassName
+// is a global referring to a class this compiler has already compiled, and
+// returns the class name if so. Names shadowed by locals, fields or class
+// variables don't qualify, since they don't compile to a global load.
+func (c *Compiler) newObjectClassName(msg *ast.MessageSend) (string, bool) {
+	if msg.IsSuper || msg.Selector != "new" || len(msg.Args) != 0 {
+		return "", false
+	}
+	ident, ok := msg.Receiver.(*ast.Identifier)
+	if !ok || ident.Name == "self" {
+		return "", false
+	}
+	if _, ok := c.findLocalVar(ident.Name); ok {
+		return "", false
+	}
+	if _, ok := c.fields[ident.Name]; ok {
+		return "", false
+	}
+	if _, ok := c.classVars[ident.Name]; ok {
+		return "", false
+	}
+	if _, ok := c.classes[ident.Name]; !ok {
+		return "", false
+	}
+	return ident.Name, true
+}
+
 // findLocalVar searches for a local variable by name and returns its index.
 // Returns the index and true if found, -1 and false otherwise.
 func (c *Compiler) findLocalVar(name string) (int, bool) {
@@ -1,6 +1,11 @@
 package compiler
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/kristofer/smog/pkg/bytecode"
@@ -436,6 +441,47 @@ t.Errorf("Expected MAKE_ARRAY operand 3, got %d", bc.Instructions[3].Operand)
 }
 }
 
+// TestCompileMixedNumberArrayLiteral tests that each element of a literal
+// array keeps its own constant type, so 2.0 isn't stored as an integer or
+// 1 as a float, including after a round trip through the bytecode format
+func TestCompileMixedNumberArrayLiteral(t *testing.T) {
+	program, err := parser.New("#(1 2.0 3 -4.5 0.0)").Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var encoded bytes.Buffer
+	if err := bytecode.Encode(bc, &encoded); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := bytecode.Decode(&encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	expected := []interface{}{int64(1), 2.0, int64(3), -4.5, 0.0}
+	for _, code := range []*bytecode.Bytecode{bc, decoded} {
+		var pushed []interface{}
+		for _, inst := range code.Instructions {
+			if inst.Op == bytecode.OpPush {
+				pushed = append(pushed, code.Constants[inst.Operand])
+			}
+		}
+		if len(pushed) != len(expected) {
+			t.Fatalf("Expected %d pushed constants, got %d: %v", len(expected), len(pushed), pushed)
+		}
+		for i, want := range expected {
+			if reflect.TypeOf(pushed[i]) != reflect.TypeOf(want) || pushed[i] != want {
+				t.Errorf("Element %d: expected %v (%T), got %v (%T)", i+1, want, want, pushed[i], pushed[i])
+			}
+		}
+	}
+}
+
 // TestCompileIncremental tests that CompileIncremental preserves the symbol table
 // across multiple compilations, which is needed for REPL functionality.
 func TestCompileIncremental(t *testing.T) {
@@ -592,3 +638,642 @@ if loadCount != 2 {
 t.Errorf("Expected 2 LOAD_LOCAL instructions, got %d", loadCount)
 }
 }
+
+// compiledMethod compiles source containing one class and returns the named instance method.
+func compiledMethod(t *testing.T, source, selector string) *bytecode.MethodDefinition {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	for _, constant := range bc.Constants {
+		if class, ok := constant.(*bytecode.ClassDefinition); ok {
+			for _, method := range class.Methods {
+				if method.Selector == selector {
+					return method
+				}
+			}
+		}
+	}
+	t.Fatalf("Method %s not found", selector)
+	return nil
+}
+
+// blocksOf returns the block bytecode stored in code's constant pool.
+func blocksOf(code *bytecode.Bytecode) []*bytecode.Bytecode {
+	var blocks []*bytecode.Bytecode
+	for _, constant := range code.Constants {
+		if block, ok := constant.(*bytecode.Bytecode); ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func TestCompileReturnInBothBranches(t *testing.T) {
+	method := compiledMethod(t, `Object subclass: #T [
+		pick: b [ b ifTrue: [^1] ifFalse: [^2] ]
+	]`, "pick:")
+
+	// Each ^ lives in its own block and must compile to a non-local return
+	blocks := blocksOf(method.Code)
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(blocks))
+	}
+	for i, block := range blocks {
+		count := 0
+		for _, inst := range block.Instructions {
+			if inst.Op == bytecode.OpNonLocalReturn {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("Block %d: expected 1 NON_LOCAL_RETURN, got %d", i, count)
+		}
+	}
+
+	// The method body falls through both branches, so it must still end in a return
+	last := method.Code.Instructions[len(method.Code.Instructions)-1]
+	if last.Op != bytecode.OpReturn {
+		t.Errorf("Expected method to end with RETURN, got %v", last.Op)
+	}
+}
+
+func TestCompileEarlyAndFinalReturn(t *testing.T) {
+	method := compiledMethod(t, `Object subclass: #T [
+		sign: x [
+			x < 0 ifTrue: [^-1].
+			x = 0 ifTrue: [^0].
+			^1
+		]
+	]`, "sign:")
+
+	nonLocal := 0
+	for _, block := range blocksOf(method.Code) {
+		for _, inst := range block.Instructions {
+			if inst.Op == bytecode.OpNonLocalReturn {
+				nonLocal++
+			}
+		}
+	}
+	if nonLocal != 2 {
+		t.Errorf("Expected 2 NON_LOCAL_RETURN instructions, got %d", nonLocal)
+	}
+
+	// The final ^1 is a local return; no implicit "^self" should follow it
+	instructions := method.Code.Instructions
+	if instructions[len(instructions)-1].Op != bytecode.OpReturn {
+		t.Errorf("Expected method to end with RETURN, got %v", instructions[len(instructions)-1].Op)
+	}
+	for _, inst := range instructions {
+		if inst.Op == bytecode.OpPushSelf {
+			t.Errorf("Unexpected implicit PUSH_SELF after explicit return")
+		}
+	}
+}
+
+func TestCompileConditionalAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		selector string
+		blocks   int
+	}{
+		{"| x y |\ny := (x > 0) ifTrue: [1] ifFalse: [-1]", "ifTrue:ifFalse:", 2},
+		{"| x y |\ny := (x > 0) ifTrue: [1]", "ifTrue:", 1},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(tt.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", tt.input, err)
+		}
+		bc, err := New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.input, err)
+		}
+
+		// The conditional is an ordinary send whose one result is stored
+		// in y, and is then the program's value
+		instructions := bc.Instructions
+		n := len(instructions)
+		if n < 3 || instructions[n-3].Op != bytecode.OpSend || instructions[n-2].Op != bytecode.OpStoreLocal ||
+			instructions[n-1].Op != bytecode.OpReturn {
+			t.Fatalf("%q: expected to end with SEND, STORE_LOCAL, RETURN, got %v", tt.input, instructions)
+		}
+		idx, argCount, err := bytecode.UnpackSendOperand(instructions[n-3].Operand)
+		if err != nil || bc.Constants[idx] != tt.selector || argCount != tt.blocks {
+			t.Errorf("%q: expected SEND %s with %d args, got %v/%d", tt.input, tt.selector, tt.blocks, bc.Constants[idx], argCount)
+		}
+		if instructions[n-2].Operand != 1 {
+			t.Errorf("%q: expected STORE_LOCAL 1 (y), got %d", tt.input, instructions[n-2].Operand)
+		}
+
+		// Each branch leaves exactly one value: push it, then return it
+		blocks := blocksOf(bc)
+		if len(blocks) != tt.blocks {
+			t.Fatalf("%q: expected %d blocks, got %d", tt.input, tt.blocks, len(blocks))
+		}
+		for i, block := range blocks {
+			if len(block.Instructions) != 2 || block.Instructions[1].Op != bytecode.OpReturn {
+				t.Errorf("%q: block %d: expected PUSH, RETURN, got %v", tt.input, i, block.Instructions)
+			}
+		}
+	}
+}
+
+// countOps returns how many instructions in code use op.
+func countOps(code *bytecode.Bytecode, op bytecode.Opcode) int {
+	count := 0
+	for _, inst := range code.Instructions {
+		if inst.Op == op {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCompileNewObjectFastPath(t *testing.T) {
+	input := `Object subclass: #Counter [
+    | count |
+]
+Object subclass: #Factory [
+    make [ ^Counter new ]
+]
+| c |
+c := Counter new.`
+
+	p := parser.New(input)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if n := countOps(bc, bytecode.OpNewObject); n != 1 {
+		t.Fatalf("Expected 1 NEW_OBJECT at top level, got %d", n)
+	}
+	for _, inst := range bc.Instructions {
+		if inst.Op == bytecode.OpNewObject && bc.Constants[inst.Operand] != "Counter" {
+			t.Errorf("Expected NEW_OBJECT operand to name Counter, got %v", bc.Constants[inst.Operand])
+		}
+	}
+
+	// Methods see classes compiled before them
+	method := compiledMethod(t, input, "make")
+	if n := countOps(method.Code, bytecode.OpNewObject); n != 1 {
+		t.Errorf("Expected NEW_OBJECT in method, got %d", n)
+	}
+}
+
+func TestCompileNewObjectOnlyForKnownClasses(t *testing.T) {
+	tests := []string{
+		// Class not defined (yet), or built in
+		"Counter new.",
+		"Bag new.",
+		// Receiver is not a global
+		"| Counter |\nCounter new.",
+		"[:Counter | Counter new] value: 1.",
+		// Not a plain unary new
+		"Object subclass: #Counter [ ]\nCounter new: 3.",
+		"Object subclass: #Counter [ ]\nCounter yourself new.",
+	}
+
+	for _, input := range tests {
+		p := parser.New(input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", input, err)
+		}
+		bc, err := New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", input, err)
+		}
+		n := countOps(bc, bytecode.OpNewObject)
+		for _, block := range blocksOf(bc) {
+			n += countOps(block, bytecode.OpNewObject)
+		}
+		if n != 0 {
+			t.Errorf("%q: expected a plain send, got %d NEW_OBJECT", input, n)
+		}
+	}
+}
+
+// TestCompileHoistsClassDefinitions tests that a program's classes are
+// defined before its other statements, each after its superclass
+func TestCompileHoistsClassDefinitions(t *testing.T) {
+	input := `
+x := B new.
+Object subclass: #B [ ]
+A subclass: #C [ ]
+Object subclass: #A [ | a | ]
+x`
+	program, err := parser.New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var names []string
+	for _, inst := range bc.Instructions[:3] {
+		if inst.Op != bytecode.OpDefineClass {
+			t.Fatalf("Expected the program to start with 3 DEFINE_CLASS, got %v", bc.Instructions[:3])
+		}
+		names = append(names, bc.Constants[inst.Operand].(*bytecode.ClassDefinition).Name)
+	}
+	if !reflect.DeepEqual(names, []string{"B", "A", "C"}) {
+		t.Errorf("Expected classes in the order B A C, got %v", names)
+	}
+	// B was known by the time x := B new was compiled
+	if countOps(bc, bytecode.OpNewObject) != 1 {
+		t.Errorf("Expected B new to compile to NEW_OBJECT")
+	}
+}
+
+// TestCompileClassExtensions tests that an extension compiles to
+// EXTEND_CLASS after the class it extends, with access to its fields
+func TestCompileClassExtensions(t *testing.T) {
+	input := `
+Counter extend [ bump [ count := count + 1 ] ]
+Integer extend [ double [ ^self * 2 ] ]
+Object subclass: #Counter [ | count | ]
+3 double`
+	program, err := parser.New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	expected := []struct {
+		op   bytecode.Opcode
+		name string
+	}{
+		{bytecode.OpDefineClass, "Counter"},
+		{bytecode.OpExtendClass, "Counter"},
+		{bytecode.OpExtendClass, "Integer"},
+	}
+	for i, want := range expected {
+		inst := bc.Instructions[i]
+		class, ok := bc.Constants[inst.Operand].(*bytecode.ClassDefinition)
+		if inst.Op != want.op || !ok || class.Name != want.name {
+			t.Fatalf("Instruction %d: expected %v %s, got %v", i, want.op, want.name, inst)
+		}
+	}
+
+	bump := compiledMethod(t, input, "bump")
+	if countOps(bump.Code, bytecode.OpStoreField) != 1 {
+		t.Errorf("Expected bump to store into the count field, got %v", bump.Code.Instructions)
+	}
+}
+
+func TestCompileCallBlockForBlockLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int // number of CALL_BLOCK instructions at top level
+		args     int // expected CALL_BLOCK operand
+	}{
+		{"[42] value.", 1, 0},
+		{"[:x | x] value: 1.", 1, 1},
+		{"[:a :b | a + b] value: 1 value: 2.", 1, 2},
+		// Receiver not statically a block
+		{"| b |\nb := [42].\nb value.", 0, 0},
+		// Other block messages still go through send
+		{"[:x | x] value: 1 foo: 2.", 0, 0},
+		{"[true] whileFalse: [nil].", 0, 0},
+		{"[42] valueWithTimeout: 10.", 0, 0},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", tt.input, err)
+		}
+		bc, err := New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.input, err)
+		}
+		if n := countOps(bc, bytecode.OpCallBlock); n != tt.expected {
+			t.Errorf("%q: expected %d CALL_BLOCK, got %d", tt.input, tt.expected, n)
+		}
+		for _, inst := range bc.Instructions {
+			if inst.Op == bytecode.OpCallBlock && inst.Operand != tt.args {
+				t.Errorf("%q: expected CALL_BLOCK %d, got %d", tt.input, tt.args, inst.Operand)
+			}
+		}
+	}
+}
+
+func TestCompileFoldsStringConcatenation(t *testing.T) {
+	tests := []struct {
+		input  string
+		folded string // expected single constant, or "" if not folded
+		sends  int    // expected number of SEND instructions
+	}{
+		{"'a' , 'b'.", "ab", 0},
+		{"'a' , 'b' , 'c'.", "abc", 0},
+		{"'a' , ('b' , 'c').", "abc", 0},
+		{"('a' , 'b') size.", "ab", 1},
+		// Variable and non-string operands are left alone
+		{"| x |\nx , 'b'.", "", 1},
+		{"| x |\n'a' , x.", "", 1},
+		{"| x |\n'a' , 'b' , x.", "", 1},
+		{"'a' , 3.", "", 1},
+		// Nor is a , that a String extension redefines
+		{"String extend [ , other [ ^other ] ]\n'a' , 'b'.", "", 1},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", tt.input, err)
+		}
+		bc, err := New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.input, err)
+		}
+		if n := countOps(bc, bytecode.OpSend); n != tt.sends {
+			t.Errorf("%q: expected %d SEND, got %d", tt.input, tt.sends, n)
+		}
+		if tt.folded == "" {
+			continue
+		}
+		if bc.Instructions[0].Op != bytecode.OpPush || bc.Constants[bc.Instructions[0].Operand] != tt.folded {
+			t.Errorf("%q: expected PUSH %q first, got %v", tt.input, tt.folded, bc.Instructions[0])
+		}
+		for _, constant := range bc.Constants {
+			if constant == "," {
+				t.Errorf("%q: expected no , selector in constants, got %v", tt.input, bc.Constants)
+			}
+		}
+	}
+}
+
+// keywordMessage generates a keyword selector with n parts and a matching
+// message, parameter list and method header: k1:k2:..., k1: 1 k2: 2 ...,
+// and k1: p1 k2: p2 ...
+func keywordMessage(n int) (selector, send, header string) {
+	var sel, msg, hdr strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sel, "k%d:", i)
+		fmt.Fprintf(&msg, " k%d: %d", i, i)
+		fmt.Fprintf(&hdr, " k%d: p%d", i, i)
+	}
+	return sel.String(), msg.String(), hdr.String()
+}
+
+// TestCompileArgumentCountLimit tests that messages and methods with more
+// arguments than a send operand can encode are rejected, and that the
+// largest allowed count is encoded intact
+func TestCompileArgumentCountLimit(t *testing.T) {
+	limit := bytecode.ArgCountMask
+	for _, n := range []int{limit, limit + 1} {
+		selector, send, header := keywordMessage(n)
+		sources := map[string]string{
+			"send":    "nil" + send + ".",
+			"cascade": "nil foo;" + send + ".",
+			"method":  "Object subclass: #Wide [\n" + header + " [ ^p1 ]\n]",
+		}
+
+		for kind, source := range sources {
+			program, err := parser.New(source).Parse()
+			if err != nil {
+				t.Fatalf("%s with %d arguments: parse failed: %v", kind, n, err)
+			}
+			bc, err := New().Compile(program)
+
+			if n > limit {
+				expected := fmt.Sprintf("has %d arguments; a message can have at most %d", n, limit)
+				if err == nil || !strings.Contains(err.Error(), expected) {
+					t.Errorf("%s with %d arguments: expected error containing %q, got %v", kind, n, expected, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s with %d arguments: compile failed: %v", kind, n, err)
+			}
+			if kind == "method" {
+				continue
+			}
+			found := false
+			for _, inst := range bc.Instructions {
+				if inst.Op != bytecode.OpSend {
+					continue
+				}
+				idx, argCount, err := bytecode.UnpackSendOperand(inst.Operand)
+				if err == nil && bc.Constants[idx] == selector {
+					found = argCount == n
+				}
+			}
+			if !found {
+				t.Errorf("%s with %d arguments: expected a SEND of the selector with %d arguments", kind, n, n)
+			}
+		}
+	}
+}
+
+// TestCompilerSymbols tests that Symbols reports names declared across
+// incremental compilations, in resolution order.
+func TestCompilerSymbols(t *testing.T) {
+	c := New()
+	if symbols := c.Symbols(); len(symbols) != 0 {
+		t.Fatalf("Expected no symbols in a fresh compiler, got %v", symbols)
+	}
+
+	inputs := []string{
+		"| x y |",
+		"x := 1. total := x + 1.",
+		"Object subclass: #Counter [ | count | increment [ count := count + 1. seen := true ] ]",
+		"| z |\n[:each | each] value: 3. accumulator := 0.",
+	}
+	for _, input := range inputs {
+		p := parser.New(input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", input, err)
+		}
+		if _, err := c.CompileIncremental(program); err != nil {
+			t.Fatalf("CompileIncremental failed for %q: %v", input, err)
+		}
+	}
+
+	expected := []Symbol{
+		{Name: "x", Kind: SymbolLocal, Slot: 0},
+		{Name: "y", Kind: SymbolLocal, Slot: 1},
+		{Name: "z", Kind: SymbolLocal, Slot: 2},
+		{Name: "accumulator", Kind: SymbolGlobal, Slot: -1},
+		{Name: "seen", Kind: SymbolGlobal, Slot: -1},
+		{Name: "total", Kind: SymbolGlobal, Slot: -1},
+		{Name: "Counter", Kind: SymbolClass, Slot: -1},
+	}
+	symbols := c.Symbols()
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Errorf("Expected symbols %v, got %v", expected, symbols)
+	}
+
+	// The result is a copy; changing it doesn't affect the compiler
+	symbols[0].Name = "changed"
+	if c.Symbols()[0].Name != "x" {
+		t.Error("Expected Symbols to return a copy")
+	}
+
+	// Reported slots match the compiled code
+	p := parser.New("z := 5.")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := c.CompileIncremental(program)
+	if err != nil {
+		t.Fatalf("CompileIncremental failed: %v", err)
+	}
+	if bc.Instructions[1].Op != bytecode.OpStoreLocal || bc.Instructions[1].Operand != 2 {
+		t.Errorf("Expected STORE_LOCAL 2 for z, got %v %d", bc.Instructions[1].Op, bc.Instructions[1].Operand)
+	}
+}
+
+func TestSymbolKindString(t *testing.T) {
+	kinds := map[SymbolKind]string{
+		SymbolLocal:    "local",
+		SymbolField:    "field",
+		SymbolClassVar: "classVar",
+		SymbolGlobal:   "global",
+		SymbolClass:    "class",
+	}
+	for kind, expected := range kinds {
+		if kind.String() != expected {
+			t.Errorf("Expected %q, got %q", expected, kind.String())
+		}
+	}
+}
+
+func TestCompilerSelectors(t *testing.T) {
+	c := New()
+	p := parser.New("Object subclass: #Account [ | balance | deposit: n [ balance := balance + n ] <named: s [ ^self new ]> ]")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, err := c.CompileIncremental(program); err != nil {
+		t.Fatalf("CompileIncremental failed: %v", err)
+	}
+
+	selectors := c.Selectors()
+	for _, expected := range []string{"deposit:", "named:", "new", "println", "padLeftTo:with:", "+"} {
+		found := false
+		for _, selector := range selectors {
+			if selector == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %q in selectors", expected)
+		}
+	}
+	for i := 1; i < len(selectors); i++ {
+		if selectors[i-1] >= selectors[i] {
+			t.Fatalf("Expected sorted, unique selectors, got %q before %q", selectors[i-1], selectors[i])
+		}
+	}
+}
+
+func TestCompileBraceArray(t *testing.T) {
+	p := parser.New("| x |\nx := 4.\n{1 + 1. x}")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	// The elements are computed before MAKE_ARRAY 2
+	expected := []bytecode.Opcode{
+		bytecode.OpPush, bytecode.OpStoreLocal, bytecode.OpPop,
+		bytecode.OpPush, bytecode.OpPush, bytecode.OpSend,
+		bytecode.OpLoadLocal, bytecode.OpMakeArray,
+	}
+	if len(bc.Instructions) < len(expected) {
+		t.Fatalf("Expected at least %d instructions, got %d", len(expected), len(bc.Instructions))
+	}
+	for i, op := range expected {
+		if bc.Instructions[i].Op != op {
+			t.Errorf("Instruction %d: expected %v, got %v", i, op, bc.Instructions[i].Op)
+		}
+	}
+	if bc.Instructions[7].Operand != 2 {
+		t.Errorf("Expected MAKE_ARRAY 2, got %d", bc.Instructions[7].Operand)
+	}
+}
+
+// TestCompileErrorPositions tests that compile errors are reported as a
+// *CompileError with the position of the problem, and that a strict mode
+// failure lists each warning with its own position
+func TestCompileErrorPositions(t *testing.T) {
+	_, send, header := keywordMessage(bytecode.ArgCountMask + 1)
+	program, err := parser.New("| x |\nx := 1.\n  x" + send + ".").Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	_, err = New().Compile(program)
+	var cerr *CompileError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected a *CompileError, got %T: %v", err, err)
+	}
+	if cerr.Line != 3 || cerr.Column != 3 || !strings.Contains(cerr.Message, "has 256 arguments") {
+		t.Errorf("Expected the argument count error at 3:3, got %d:%d %q", cerr.Line, cerr.Column, cerr.Message)
+	}
+
+	// A method definition records no position, but the error is still typed
+	program, _ = parser.New("Object subclass: #Wide [\n" + header + " [ ^p1 ]\n]").Parse()
+	_, err = New().Compile(program)
+	if !errors.As(err, &cerr) || cerr.Line != 0 || !strings.HasPrefix(cerr.Message, "failed to compile method k1:") {
+		t.Errorf("Expected a method compile error without a position, got %v", err)
+	}
+
+	program, _ = parser.New("| count |\ncuont := 1.\n3 fooBar").Parse()
+	c := New()
+	c.SetStrict(true)
+	_, err = c.Compile(program)
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected a *CompileError, got %T: %v", err, err)
+	}
+	if !strings.HasPrefix(err.Error(), "strict mode: 2 warning(s)\n") {
+		t.Errorf("Expected the strict mode message to be unchanged, got %q", err.Error())
+	}
+	expected := []Warning{
+		{Line: 2, Column: 1, Message: "assignment to undeclared variable 'cuont'"},
+		{Line: 3, Column: 3, Message: "an Integer does not understand #fooBar"},
+	}
+	all := cerr.Errors()
+	if len(all) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d", len(expected), len(all))
+	}
+	for i, want := range expected {
+		got := Warning{Line: all[i].Line, Column: all[i].Column, Message: all[i].Message}
+		if got != want {
+			t.Errorf("Error %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+	if cerr.Line != 2 || cerr.Column != 1 {
+		t.Errorf("Expected the first warning's position, got %d:%d", cerr.Line, cerr.Column)
+	}
+}
@@ -0,0 +1,75 @@
+// Package compiler - compile errors
+package compiler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kristofer/smog/pkg/ast"
+)
+
+// CompileError is an error found while compiling a parsed program, with
+// the position it was found at when the syntax tree records one.
+//
+// Compile, CompileIncremental and CompileMethod return every error as a
+// *CompileError. Most describe a single problem, such as a message with
+// too many arguments. In strict mode the warnings that failed the compile
+// are reported together: the fields describe the first one, and Errors
+// lists each of them with its own position.
+//
+// Example:
+//   _, err := compiler.New().Compile(program)
+//   var cerr *compiler.CompileError
+//   if errors.As(err, &cerr) {
+//       for _, e := range cerr.Errors() {
+//           fmt.Println(e.Line, e.Column, e.Message)
+//       }
+//   }
+type CompileError struct {
+	Line    int    // Source line of the error (0 if unknown)
+	Column  int    // Source column of the error (0 if unknown)
+	Message string // Description of the error, without its position
+
+	text   string          // The full error, if it says more than Message
+	errors []*CompileError // The individual problems, when there are several
+}
+
+// Errors returns the individual problems e reports, in source order: the
+// strict mode warnings that failed a compile, or just e itself.
+func (e *CompileError) Errors() []*CompileError {
+	if len(e.errors) == 0 {
+		return []*CompileError{e}
+	}
+	return e.errors
+}
+
+// Error returns the description of the error.
+func (e *CompileError) Error() string {
+	if e.text != "" {
+		return e.text
+	}
+	return e.Message
+}
+
+// errorAt answers a CompileError at loc.
+func errorAt(loc ast.SourceLocation, format string, args ...interface{}) *CompileError {
+	return &CompileError{Line: loc.Line, Column: loc.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// asCompileError answers err as a *CompileError, for the errors returned
+// by the compiler's public methods. An error that wraps a CompileError,
+// such as the one naming the method it was found in, keeps the wrapped
+// error's position and takes the fuller text as its message.
+func asCompileError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var cerr *CompileError
+	if errors.As(err, &cerr) {
+		if cerr == err {
+			return cerr
+		}
+		return &CompileError{Line: cerr.Line, Column: cerr.Column, Message: err.Error()}
+	}
+	return &CompileError{Message: err.Error()}
+}
@@ -0,0 +1,194 @@
+// Package compiler - strict mode checks
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kristofer/smog/pkg/ast"
+)
+
+// checks collects the problems the compiler notices while it compiles,
+// shared by a compiler and the nested compilers for its methods and
+// blocks.
+//
+// Two kinds of problem are collected:
+//   - Undeclared variables: a lowercase name that isn't a local, a field
+//     or a class variable compiles to a global. Assigning one is usually
+//     a typo for a declared variable, and reading one that is never
+//     assigned fails when it runs. Capitalized names are globals by
+//     convention (classes, mostly) and are never reported.
+//   - Unreachable code: statements after a ^ in the same method, block or
+//     program can never run.
+type checks struct {
+	warnings []Warning            // Problems reported unconditionally
+	reads    map[string][]Warning // Reads of undeclared names, reported unless the name is assigned somewhere
+	assigned map[string]bool      // Undeclared names whose assignment was already reported
+}
+
+// newChecks creates an empty set of checks.
+func newChecks() *checks {
+	return &checks{reads: make(map[string][]Warning), assigned: make(map[string]bool)}
+}
+
+// SetStrict turns strict mode on or off. In strict mode Compile fails if
+// the program has any warning: an undeclared variable, unreachable code,
+// or a message sent to a literal that can't understand it (see
+// CheckSelectors). Wrong argument counts are errors in every mode. The
+// default is lenient: the warnings are only reported by Warnings.
+//
+// Example:
+//   c := compiler.New()
+//   c.SetStrict(true)
+//   _, err := c.Compile(program)  -> error if the program has warnings
+func (c *Compiler) SetStrict(strict bool) {
+	c.strict = strict
+}
+
+// Warnings returns the undeclared variables and unreachable code found
+// by the last Compile, ordered by source position.
+func (c *Compiler) Warnings() []Warning {
+	warnings := append([]Warning{}, c.checks.warnings...)
+	for name, reads := range c.checks.reads {
+		if !c.globals[name] {
+			warnings = append(warnings, reads...)
+		}
+	}
+	sortWarnings(warnings)
+	return warnings
+}
+
+// strictError answers an error listing every warning in program if the
+// compiler is in strict mode and there are any, or nil otherwise. Each
+// warning is one of the error's Errors.
+func (c *Compiler) strictError(program *ast.Program) error {
+	if !c.strict {
+		return nil
+	}
+	warnings := append(c.Warnings(), CheckSelectors(program)...)
+	if len(warnings) == 0 {
+		return nil
+	}
+	sortWarnings(warnings)
+	lines := make([]string, len(warnings))
+	errs := make([]*CompileError, len(warnings))
+	for i, w := range warnings {
+		lines[i] = w.String()
+		errs[i] = &CompileError{Line: w.Line, Column: w.Column, Message: w.Message}
+	}
+	return &CompileError{
+		Line:    warnings[0].Line,
+		Column:  warnings[0].Column,
+		Message: warnings[0].Message,
+		text:    fmt.Sprintf("strict mode: %d warning(s)\n%s", len(warnings), strings.Join(lines, "\n")),
+		errors:  errs,
+	}
+}
+
+// sortWarnings orders warnings by source position.
+func sortWarnings(warnings []Warning) {
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Line != warnings[j].Line {
+			return warnings[i].Line < warnings[j].Line
+		}
+		return warnings[i].Column < warnings[j].Column
+	})
+}
+
+// isVariableName reports whether name is spelled like a variable rather
+// than a global: it starts with a lowercase letter or an underscore.
+// thisContext is a pseudo-variable, so it doesn't count.
+func isVariableName(name string) bool {
+	if name == "" || name == "thisContext" {
+		return false
+	}
+	first := []rune(name)[0]
+	return unicode.IsLower(first) || first == '_'
+}
+
+// noteUndeclaredRead records a read of a name that compiles to a global.
+func (c *Compiler) noteUndeclaredRead(ident *ast.Identifier) {
+	if !isVariableName(ident.Name) {
+		return
+	}
+	c.checks.reads[ident.Name] = append(c.checks.reads[ident.Name], Warning{
+		Line:    ident.Loc.Line,
+		Column:  ident.Loc.Column,
+		Message: fmt.Sprintf("undeclared variable '%s'", ident.Name),
+	})
+}
+
+// noteUndeclaredAssignment records the first assignment to a name that
+// compiles to a global.
+func (c *Compiler) noteUndeclaredAssignment(assign *ast.Assignment) {
+	if !isVariableName(assign.Name) || c.checks.assigned[assign.Name] {
+		return
+	}
+	c.checks.assigned[assign.Name] = true
+	c.checks.warnings = append(c.checks.warnings, Warning{
+		Line:    assign.Loc.Line,
+		Column:  assign.Loc.Column,
+		Message: fmt.Sprintf("assignment to undeclared variable '%s'", assign.Name),
+	})
+}
+
+// checkReachable records the first statement after a ^ in stmts, which
+// can never run.
+func (c *Compiler) checkReachable(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		if _, ok := stmt.(*ast.ReturnStatement); !ok || i == len(stmts)-1 {
+			continue
+		}
+		loc := statementLoc(stmts[i+1])
+		c.checks.warnings = append(c.checks.warnings, Warning{
+			Line:    loc.Line,
+			Column:  loc.Column,
+			Message: "unreachable code after ^",
+		})
+		return
+	}
+}
+
+// statementLoc answers where stmt starts, as near as the syntax tree
+// records it, or the zero location if it doesn't.
+func statementLoc(stmt ast.Statement) ast.SourceLocation {
+	switch s := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if loc := expressionLoc(s.Expression); loc.Line > 0 {
+			return loc
+		}
+		return s.Loc
+	case *ast.ReturnStatement:
+		return expressionLoc(s.Value)
+	case *ast.VariableDeclaration:
+		return s.Loc
+	}
+	return ast.SourceLocation{}
+}
+
+// expressionLoc answers where expr starts, as near as the syntax tree
+// records it.
+func expressionLoc(expr ast.Expression) ast.SourceLocation {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		return e.Loc
+	case *ast.Assignment:
+		return e.Loc
+	case *ast.IntegerLiteral:
+		return e.Loc
+	case *ast.FractionLiteral:
+		return e.Loc
+	case *ast.MessageSend:
+		if e.Receiver != nil {
+			if loc := expressionLoc(e.Receiver); loc.Line > 0 {
+				return loc
+			}
+		}
+		return e.Loc
+	case *ast.CascadeExpression:
+		return expressionLoc(e.Receiver)
+	}
+	return ast.SourceLocation{}
+}
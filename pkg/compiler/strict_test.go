@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// compileWarnings compiles input and returns the warnings found.
+func compileWarnings(t *testing.T, input string) []string {
+	t.Helper()
+
+	program, err := parser.New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	c := New()
+	if _, err := c.Compile(program); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var warnings []string
+	for _, w := range c.Warnings() {
+		warnings = append(warnings, w.String())
+	}
+	return warnings
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected []string
+	}{
+		// Declared names, globals and classes are fine
+		{"| x | x := 1. x", nil},
+		{"Transcript := 3. Transcript", nil},
+		{"Object subclass: #A [ | n | run: k [ | t | t := n + k. ^[:e | e + t] ] ]\nA new", nil},
+		{"Object subclass: #A [ <| total |> bump [ total := 1 ] ]", nil},
+		{"thisContext", nil},
+		// A name assigned without a declaration is reported once
+		{"| count |\ncuont := 1.\ncuont := 2", []string{"Line 2, Column 1: warning: assignment to undeclared variable 'cuont'"}},
+		{"Object subclass: #A [ | count | bump [ cuont := count ] ]", []string{"Line 1, Column 40: warning: assignment to undeclared variable 'cuont'"}},
+		// A name read but never assigned anywhere
+		{"| total |\ntotal := 1.\ntotl println", []string{"Line 3, Column 1: warning: undeclared variable 'totl'"}},
+		{"Object subclass: #A [ run [ ^limit ] ]\nlimit := 3", []string{"Line 2, Column 1: warning: assignment to undeclared variable 'limit'"}},
+		// Statements after a ^
+		{"Object subclass: #A [ run [ ^1. 2 ] ]", []string{"Line 1, Column 33: warning: unreachable code after ^"}},
+		{"| x |\nx := [:a | ^a.\n  a println]", []string{"Line 3, Column 3: warning: unreachable code after ^"}},
+		{"^1.\n2.\n3", []string{"Line 2, Column 1: warning: unreachable code after ^"}},
+	}
+	for _, tt := range tests {
+		got := compileWarnings(t, tt.source)
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%s: expected warnings %q, got %q", tt.source, tt.expected, got)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	source := "| count |\ncuont := 1.\n3 fooBar"
+	program, err := parser.New(source).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Lenient by default
+	if _, err := New().Compile(program); err != nil {
+		t.Fatalf("Expected a lenient compile to succeed, got %v", err)
+	}
+
+	c := New()
+	c.SetStrict(true)
+	_, err = c.Compile(program)
+	expected := "strict mode: 2 warning(s)\n" +
+		"Line 2, Column 1: warning: assignment to undeclared variable 'cuont'\n" +
+		"Line 3, Column 3: warning: an Integer does not understand #fooBar"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	program, _ = parser.New("| count | count := 1").Parse()
+	c = New()
+	c.SetStrict(true)
+	if _, err := c.Compile(program); err != nil {
+		t.Errorf("Expected a clean program to compile in strict mode, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+// Package compiler - compile-time warnings
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kristofer/smog/pkg/ast"
+)
+
+// Warning is a non-fatal problem found while compiling.
+//
+// Warnings never stop compilation; they point at code that is valid
+// syntax but will almost certainly fail when it runs, such as a typo in
+// a selector sent to a literal.
+type Warning struct {
+	Line    int    // Source line of the offending message send (0 if unknown)
+	Column  int    // Source column of the offending message send (0 if unknown)
+	Message string // Human-readable description
+}
+
+// String formats the warning with its source position.
+func (w Warning) String() string {
+	if w.Line > 0 {
+		return fmt.Sprintf("Line %d, Column %d: warning: %s", w.Line, w.Column, w.Message)
+	}
+	return "warning: " + w.Message
+}
+
+// universalSelectors are understood by every receiver, because the VM
+// handles them in its generic primitive table regardless of receiver type.
+var universalSelectors = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "//": true, "%": true, "\\\\": true,
+	"<": true, ">": true, "<=": true, ">=": true,
+	"=": true, "~=": true, "==": true, "@": true,
+	"println": true, "print": true, "yourself": true, "halt": true, "printString": true, "printOn:": true,
+	"deepCopy": true, "copy": true, "shallowCopy": true, "displayString": true, "identityHash": true,
+	"inspect": true, "inspectString": true,
+	"caseOf:": true, "caseOf:otherwise:": true,
+	"isInteger": true, "isFloat": true, "isNumber": true, "isFraction": true, "isString": true,
+	"isNil": true, "notNil": true, "isBoolean": true, "isArray": true,
+	"ifNil:": true, "ifNotNil:": true, "ifNil:ifNotNil:": true, "ifNotNil:ifNil:": true,
+	"httpGet:": true, "httpPost:body:": true,
+	"urlEncode:": true, "urlDecode:": true, "queryString:": true,
+	"aesEncrypt:key:": true, "aesDecrypt:key:": true, "aesGenerateKey": true,
+	"sha256:": true, "sha256Bytes:": true, "sha512:": true, "md5:": true,
+	"hmacSha256:key:": true, "pbkdf2:salt:iterations:length:": true,
+	"base64Encode:": true, "base64Decode:": true, "base64DecodeBytes:": true,
+	"zipCompress:": true, "zipDecompress:": true,
+	"gzipCompress:": true, "gzipDecompress:": true,
+	"fileRead:": true, "fileWrite:content:": true, "fileExists:": true, "fileDelete:": true,
+	"fileReadBytes:": true, "fileWriteBytes:content:": true,
+	"jsonParse:": true, "jsonGenerate:": true, "jsonGeneratePretty:": true, "jsonGenerate:indent:": true,
+	"asInteger:base:":  true,
+	"regexMatch:text:": true, "regexFindAll:text:": true, "regexReplace:text:with:": true,
+	"randomInt:max:": true, "randomFloat": true, "randomBytes:": true,
+	"dateNow": true, "dateFormat:format:": true, "dateParse:format:": true,
+	"timeYear:": true, "timeMonth:": true, "timeDay:": true,
+	"timeHour:": true, "timeMinute:": true, "timeSecond:": true,
+}
+
+// literalSelectors lists the extra selectors each kind of literal
+// receiver understands on top of universalSelectors.
+var literalSelectors = map[string]map[string]bool{
+	"an Integer": {
+		"timesRepeat:": true, "timesCollect:": true, "asHexString": true,
+		"digits": true, "printStringWithThousands": true,
+		"to:": true, "to:by:": true, "to:do:": true, "to:by:do:": true,
+	},
+	"a Float":    {},
+	"a Fraction": {"numerator": true, "denominator": true, "asFloat": true, "reciprocal": true, "negated": true},
+	"a String": {
+		"hexStringAsInteger": true, ",": true, "truncateTo:": true, "center:": true, "center:with:": true,
+		"padLeftTo:": true, "padRightTo:": true, "padLeftTo:with:": true, "padRightTo:with:": true,
+		"copyReplaceAll:with:": true, "insert:at:": true, "removeFrom:to:": true,
+		"repeat:": true, "*": true, "asUppercaseFirst": true, "asByteArray": true,
+		"copyFrom:to:": true, "size": true, "at:": true,
+		"asUppercase": true, "asLowercase": true, "reversed": true,
+		"lines": true, "asLines": true, "withLineNumbersDo:": true,
+	},
+	"a Boolean": {"ifTrue:": true, "ifFalse:": true, "ifTrue:ifFalse:": true},
+	"nil":       {},
+	"an Array": {
+		"size": true, "at:": true, "at:put:": true, "do:": true, "do:separatedBy:": true, "reduce:": true,
+		"keysAndValuesDo:": true, "doWithIndex:": true, "withIndexDo:": true, "keysDo:": true, "valuesDo:": true,
+		",": true, "repeat:": true, "sort": true, "sort:": true, "copyFrom:to:": true,
+		"collect:": true, "select:": true, "reject:": true, "inject:into:": true, "withIndexCollect:": true,
+		"select:thenCollect:": true, "collect:thenSelect:": true, "groupBy:": true,
+		"max": true, "min": true, "sum": true, "average": true,
+	},
+	"a Block": {
+		"valueWithTimeout:": true, "whileTrue:": true, "whileFalse:": true,
+		"ensure:": true, "ifCurtailed:": true, "on:do:": true,
+	},
+}
+
+// KnownSelectors returns the built-in selectors the VM understands for
+// some receiver, sorted alphabetically. It is the union of the tables
+// CheckSelectors uses, so it doesn't include methods defined in smog code.
+func KnownSelectors() []string {
+	seen := make(map[string]bool, len(universalSelectors))
+	for selector := range universalSelectors {
+		seen[selector] = true
+	}
+	for _, selectors := range literalSelectors {
+		for selector := range selectors {
+			seen[selector] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// sortedKeys returns the keys of set in alphabetical order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CheckSelectors walks a program looking for messages sent to literal
+// receivers that the VM cannot understand, such as `3 fooBar`.
+//
+// The check is best-effort and opt-in: it only looks at receivers whose
+// type is known at compile time (number, fraction, string, boolean, nil,
+// array and block literals), and it never rejects a program. Messages to
+// variables or objects are not checked, since their class isn't known
+// until runtime.
+//
+// Example:
+//   3 fooBar.         -> warning: an Integer does not understand #fooBar
+//   3 timesRepeat: [] -> no warning
+func CheckSelectors(program *ast.Program) []Warning {
+	extended := extendedSelectors(program)
+	var warnings []Warning
+	for _, stmt := range program.Statements {
+		warnings = checkStatement(stmt, extended, warnings)
+	}
+	return warnings
+}
+
+// extensions maps a literal kind, such as "an Integer", to the selectors
+// the program adds to it with "Integer extend [ ... ]".
+type extensions map[string]map[string]bool
+
+// extensionKinds maps the built-in types a program can extend to the
+// literal kinds CheckSelectors knows them by.
+var extensionKinds = map[string]string{
+	"Integer": "an Integer", "Float": "a Float", "Fraction": "a Fraction",
+	"String": "a String", "Boolean": "a Boolean", "UndefinedObject": "nil",
+	"Array": "an Array", "Block": "a Block",
+}
+
+// extendedSelectors collects the methods program adds to built-in types,
+// so sending them to a literal isn't reported.
+func extendedSelectors(program *ast.Program) extensions {
+	extended := extensions{}
+	for _, stmt := range program.Statements {
+		class, ok := stmt.(*ast.Class)
+		if !ok || !class.Extension {
+			continue
+		}
+		kind, ok := extensionKinds[class.Name]
+		if !ok {
+			continue
+		}
+		if extended[kind] == nil {
+			extended[kind] = map[string]bool{}
+		}
+		for _, method := range class.Methods {
+			extended[kind][method.Name] = true
+		}
+	}
+	return extended
+}
+
+// checkStatement appends warnings for stmt to warnings.
+func checkStatement(stmt ast.Statement, extended extensions, warnings []Warning) []Warning {
+	switch s := stmt.(type) {
+	case *ast.ExpressionStatement:
+		return checkExpression(s.Expression, extended, warnings)
+	case *ast.ReturnStatement:
+		return checkExpression(s.Value, extended, warnings)
+	case *ast.Class:
+		for _, field := range s.Fields {
+			if value, ok := s.FieldDefaults[field]; ok {
+				warnings = checkExpression(value, extended, warnings)
+			}
+		}
+		for _, method := range append(append([]*ast.Method{}, s.Methods...), s.ClassMethods...) {
+			for _, bodyStmt := range method.Body {
+				warnings = checkStatement(bodyStmt, extended, warnings)
+			}
+		}
+	}
+	return warnings
+}
+
+// checkExpression appends warnings for expr and its subexpressions to warnings.
+func checkExpression(expr ast.Expression, extended extensions, warnings []Warning) []Warning {
+	switch e := expr.(type) {
+	case *ast.MessageSend:
+		warnings = checkSend(e.Receiver, e, extended, warnings)
+		if e.Receiver != nil {
+			warnings = checkExpression(e.Receiver, extended, warnings)
+		}
+		for _, arg := range e.Args {
+			warnings = checkExpression(arg, extended, warnings)
+		}
+	case *ast.CascadeExpression:
+		warnings = checkExpression(e.Receiver, extended, warnings)
+		for i := range e.Messages {
+			warnings = checkSend(e.Receiver, &e.Messages[i], extended, warnings)
+			for _, arg := range e.Messages[i].Args {
+				warnings = checkExpression(arg, extended, warnings)
+			}
+		}
+	case *ast.Assignment:
+		warnings = checkExpression(e.Value, extended, warnings)
+	case *ast.BlockLiteral:
+		for _, stmt := range e.Body {
+			warnings = checkStatement(stmt, extended, warnings)
+		}
+	case *ast.ArrayLiteral:
+		for _, elem := range e.Elements {
+			warnings = checkExpression(elem, extended, warnings)
+		}
+	case *ast.BraceArray:
+		for _, elem := range e.Elements {
+			warnings = checkExpression(elem, extended, warnings)
+		}
+	case *ast.DictionaryLiteral:
+		for _, pair := range e.Pairs {
+			warnings = checkExpression(pair.Key, extended, warnings)
+			warnings = checkExpression(pair.Value, extended, warnings)
+		}
+	}
+	return warnings
+}
+
+// checkSend appends a warning if msg is sent to a literal receiver that
+// doesn't understand it.
+func checkSend(receiver ast.Expression, msg *ast.MessageSend, extended extensions, warnings []Warning) []Warning {
+	if msg.IsSuper {
+		return warnings
+	}
+	kind := literalKind(receiver)
+	if kind == "" || understands(kind, msg.Selector) || extended[kind][msg.Selector] {
+		return warnings
+	}
+	return append(warnings, Warning{
+		Line:    msg.Loc.Line,
+		Column:  msg.Loc.Column,
+		Message: fmt.Sprintf("%s does not understand #%s", kind, msg.Selector),
+	})
+}
+
+// understands reports whether a literal of the given kind responds to selector.
+func understands(kind, selector string) bool {
+	if universalSelectors[selector] || literalSelectors[kind][selector] {
+		return true
+	}
+	// Blocks accept value, value:, value:value:, ... for any arity
+	return kind == "a Block" && (selector == "value" || strings.HasPrefix(selector, "value:"))
+}
+
+// literalKind describes the receiver's type if it is a literal, or
+// returns "" when the type isn't known at compile time.
+func literalKind(expr ast.Expression) string {
+	switch expr.(type) {
+	case *ast.IntegerLiteral:
+		return "an Integer"
+	case *ast.FloatLiteral:
+		return "a Float"
+	case *ast.FractionLiteral:
+		return "a Fraction"
+	case *ast.StringLiteral:
+		return "a String"
+	case *ast.BooleanLiteral:
+		return "a Boolean"
+	case *ast.NilLiteral:
+		return "nil"
+	case *ast.ArrayLiteral, *ast.BraceArray:
+		return "an Array"
+	case *ast.BlockLiteral:
+		return "a Block"
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// checkSource parses input and returns its selector warnings.
+func checkSource(t *testing.T, input string) []Warning {
+	t.Helper()
+
+	p := parser.New(input)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return CheckSelectors(program)
+}
+
+func TestCheckSelectorsBogusSelectorOnInteger(t *testing.T) {
+	warnings := checkSource(t, "| x |\nx := 3 fooBar.")
+
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	w := warnings[0]
+	if w.Line != 2 {
+		t.Errorf("Expected warning on line 2, got %d", w.Line)
+	}
+	if !strings.Contains(w.Message, "an Integer does not understand #fooBar") {
+		t.Errorf("Unexpected warning message: %s", w.Message)
+	}
+}
+
+func TestCheckSelectorsNestedLocations(t *testing.T) {
+	input := `Object subclass: #Thing [
+    run [ ^#(1 2) sizee ]
+]
+[:x | 'abc' frob] value: 1.
+#(1 2) do: [:each | nil bogus].
+3 + true wat.
+'a' println; shout.
+1/2r sqrt`
+
+	warnings := checkSource(t, input)
+
+	expected := []string{
+		"an Array does not understand #sizee",
+		"a String does not understand #frob",
+		"nil does not understand #bogus",
+		"a Boolean does not understand #wat",
+		"a String does not understand #shout",
+		"a Fraction does not understand #sqrt",
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, want := range expected {
+		if warnings[i].Message != want {
+			t.Errorf("Warning %d: expected %q, got %q", i, want, warnings[i].Message)
+		}
+	}
+}
+
+func TestCheckSelectorsValidSelectorsProduceNoWarnings(t *testing.T) {
+	input := `| x |
+3 + 4.
+3 timesRepeat: ['hi' println].
+255 asHexString.
+'ff' hexStringAsInteger.
+'42' padLeftTo: 5 with: '0'.
+3/4r numerator.
+1/2r + 1/3r.
+2.5 * 2.0.
+(3 @ 4) x.
+true ifTrue: [1] ifFalse: [2].
+#(1 2 3) reduce: [:a :b | a + b].
+#(1 2 3) at: 1 put: 5.
+[:a :b | a + b] value: 1 value: 2.
+[x < 10] whileTrue: [x := x + 1].
+nil sha256: 'abc'.
+x fooBar.
+Object subclass: #Thing [
+    foo [ ^super foo ]
+]`
+
+	if warnings := checkSource(t, input); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+}
+
+// TestCheckSelectorsExtensions tests that methods a program adds to a
+// built-in type aren't reported, for that type only
+func TestCheckSelectorsExtensions(t *testing.T) {
+	input := `Integer extend [ double [ ^self * 2 ] ]
+3 double.
+2.5 double.`
+
+	warnings := checkSource(t, input)
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "a Float does not understand #double") {
+		t.Errorf("Unexpected warning message: %s", warnings[0].Message)
+	}
+}
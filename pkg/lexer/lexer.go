@@ -60,6 +60,18 @@ const (
 	TokenGreaterEq // >=
 	TokenEqual    // =
 	TokenNotEqual // ~=
+	TokenAt       // @
+	TokenIdentical // ==
+
+	// Exact rational literal, e.g. 1/2r (written without spaces)
+	TokenFraction
+
+	TokenDoubleSlash // //
+
+	TokenComma // , (concatenation)
+
+	// Any other binary selector, such as << or & (user-defined operators)
+	TokenBinary
 )
 
 // Token represents a lexical token
@@ -151,6 +163,18 @@ func (tt TokenType) String() string {
 		return "EQUAL"
 	case TokenNotEqual:
 		return "NOT_EQUAL"
+	case TokenAt:
+		return "AT"
+	case TokenIdentical:
+		return "IDENTICAL"
+	case TokenFraction:
+		return "FRACTION"
+	case TokenDoubleSlash:
+		return "DOUBLE_SLASH"
+	case TokenComma:
+		return "COMMA"
+	case TokenBinary:
+		return "BINARY"
 	default:
 		return "UNKNOWN"
 	}
@@ -164,6 +188,8 @@ type Lexer struct {
 	ch           byte // current char under examination
 	line         int
 	column       int
+	prevType     TokenType // type of the last token returned
+	prevEnd      int       // input position just after the last token
 }
 
 // New creates a new lexer for the given input
@@ -199,6 +225,14 @@ func (l *Lexer) peekChar() byte {
 
 // NextToken returns the next token from the input
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	l.prevType = tok.Type
+	l.prevEnd = l.position
+	return tok
+}
+
+// nextToken scans the next token without recording it as the previous one.
+func (l *Lexer) nextToken() Token {
 	var tok Token
 
 	l.skipWhitespace()
@@ -206,13 +240,23 @@ func (l *Lexer) NextToken() Token {
 	tok.Line = l.line
 	tok.Column = l.column
 
+	// Two or more operator characters in a row make one binary selector,
+	// as in << or ==>, unless they are one of the operators below
+	if isBinaryChar(l.ch) && isBinaryChar(l.peekChar()) {
+		if op := l.readBinarySelector(); op != "" {
+			tok.Type = TokenBinary
+			tok.Literal = op
+			return tok
+		}
+	}
+
 	switch l.ch {
 	case 0:
 		tok.Type = TokenEOF
 		tok.Literal = ""
 	case '"':
 		l.skipComment()
-		return l.NextToken()
+		return l.nextToken()
 	case '\'':
 		tok.Type = TokenString
 		tok.Literal = l.readString()
@@ -291,7 +335,7 @@ func (l *Lexer) NextToken() Token {
 			tok.Type = TokenArrow
 			tok.Literal = string(ch) + string(l.ch)
 			l.readChar()
-		} else if unicode.IsDigit(rune(l.peekChar())) {
+		} else if unicode.IsDigit(rune(l.peekChar())) && !l.followsOperand() {
 			l.readChar() // consume the minus
 			tok.Type, tok.Literal = l.readNumber()
 			tok.Literal = "-" + tok.Literal
@@ -306,9 +350,17 @@ func (l *Lexer) NextToken() Token {
 		tok.Literal = "*"
 		l.readChar()
 	case '/':
-		tok.Type = TokenSlash
-		tok.Literal = "/"
-		l.readChar()
+		if l.peekChar() == '/' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = TokenDoubleSlash
+			tok.Literal = string(ch) + string(l.ch)
+			l.readChar()
+		} else {
+			tok.Type = TokenSlash
+			tok.Literal = "/"
+			l.readChar()
+		}
 	case '%':
 		tok.Type = TokenPercent
 		tok.Literal = "%"
@@ -338,13 +390,29 @@ func (l *Lexer) NextToken() Token {
 			l.readChar()
 		}
 	case '=':
-		tok.Type = TokenEqual
-		tok.Literal = "="
-		l.readChar()
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = TokenIdentical
+			tok.Literal = string(ch) + string(l.ch)
+			l.readChar()
+		} else {
+			tok.Type = TokenEqual
+			tok.Literal = "="
+			l.readChar()
+		}
 	case ';':
 		tok.Type = TokenSemicolon
 		tok.Literal = ";"
 		l.readChar()
+	case '@':
+		tok.Type = TokenAt
+		tok.Literal = "@"
+		l.readChar()
+	case ',':
+		tok.Type = TokenComma
+		tok.Literal = ","
+		l.readChar()
 	case '~':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -365,6 +433,11 @@ func (l *Lexer) NextToken() Token {
 		} else if unicode.IsDigit(rune(l.ch)) {
 			tok.Type, tok.Literal = l.readNumber()
 			return tok
+		} else if isBinaryChar(l.ch) {
+			// A one-character selector with no token of its own, like &
+			tok.Type = TokenBinary
+			tok.Literal = string(l.ch)
+			l.readChar()
 		} else {
 			tok.Type = TokenIllegal
 			tok.Literal = string(l.ch)
@@ -375,6 +448,33 @@ func (l *Lexer) NextToken() Token {
 	return tok
 }
 
+// fixedOperators are the two-character operators that have tokens of
+// their own, so readBinarySelector leaves them to nextToken.
+var fixedOperators = map[string]bool{
+	"<=": true, ">=": true, "==": true, "~=": true, "//": true,
+}
+
+// readBinarySelector reads a run of operator characters as one binary
+// selector, such as << or ->>, and answers it. It answers "" without
+// reading anything if the run is one of the fixedOperators.
+//
+// Minus and | are not operator characters here, so x<-1 is still x < -1
+// and block and temporary bars are never swallowed.
+func (l *Lexer) readBinarySelector() string {
+	end := l.position
+	for end < len(l.input) && isBinaryChar(l.input[end]) {
+		end++
+	}
+	op := l.input[l.position:end]
+	if fixedOperators[op] {
+		return ""
+	}
+	for l.position < end {
+		l.readChar()
+	}
+	return op
+}
+
 // skipWhitespace skips whitespace characters
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
@@ -436,7 +536,7 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// readNumber reads a number (integer or float)
+// readNumber reads a number (integer, float or fraction)
 func (l *Lexer) readNumber() (TokenType, string) {
 	position := l.position
 	hasDecimal := false
@@ -452,6 +552,16 @@ func (l *Lexer) readNumber() (TokenType, string) {
 		l.readChar()
 	}
 
+	if !hasDecimal && l.ch == '/' {
+		if end := l.fractionEnd(); end > 0 {
+			// Fraction literal such as 1/2r (no spaces, ending in r)
+			for l.position < end {
+				l.readChar()
+			}
+			return TokenFraction, l.input[position:l.position]
+		}
+	}
+
 	literal := l.input[position:l.position]
 	if hasDecimal {
 		return TokenFloat, literal
@@ -459,6 +569,57 @@ func (l *Lexer) readNumber() (TokenType, string) {
 	return TokenInteger, literal
 }
 
+// fractionEnd looks ahead from the slash after a fraction's numerator. If
+// the slash begins the rest of a fraction literal - digits, then an r that
+// doesn't start a longer name - it answers the input position just after
+// the r; otherwise it answers 0 and the slash is the division message.
+//
+// Example:
+//   1/2r   -> a fraction literal
+//   1/2    -> 1, /, 2 (division)
+//   1/2rem -> 1, /, 2, rem
+func (l *Lexer) fractionEnd() int {
+	end := l.readPosition
+	for end < len(l.input) && unicode.IsDigit(rune(l.input[end])) {
+		end++
+	}
+	if end == l.readPosition || end >= len(l.input) || l.input[end] != 'r' {
+		return 0
+	}
+	end++
+	if end < len(l.input) && (isLetter(l.input[end]) || unicode.IsDigit(rune(l.input[end])) || l.input[end] == ':') {
+		return 0
+	}
+	return end
+}
+
+// followsOperand reports whether the current character comes right after
+// a token that can end an operand, with no whitespace in between. A minus
+// sign there is the binary operator (x-1, (a)-2), not the start of a
+// negative literal (x - -1, foo: -3, #(1 -2)).
+func (l *Lexer) followsOperand() bool {
+	if l.position != l.prevEnd {
+		return false
+	}
+	switch l.prevType {
+	case TokenInteger, TokenFloat, TokenFraction, TokenString, TokenIdentifier,
+		TokenTrue, TokenFalse, TokenNil, TokenSelf, TokenSuper,
+		TokenRParen, TokenRBracket, TokenRBrace:
+		return true
+	}
+	return false
+}
+
+// isBinaryChar reports whether ch can be part of a binary selector made
+// of several characters.
+func isBinaryChar(ch byte) bool {
+	switch ch {
+	case '+', '*', '/', '\\', '%', '<', '>', '=', '~', '@', ',', '&', '?':
+		return true
+	}
+	return false
+}
+
 // isLetter checks if a character is a letter
 func isLetter(ch byte) bool {
 	return unicode.IsLetter(rune(ch)) || ch == '_'
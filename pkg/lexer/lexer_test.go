@@ -43,7 +43,7 @@ func TestNextToken_BasicTokens(t *testing.T) {
 }
 
 func TestNextToken_Operators(t *testing.T) {
-	input := `+ - * / % < > <= >= = ~=`
+	input := `+ - * / % < > <= >= = ~= @ == // 7//2 'a','b'`
 
 	tests := []struct {
 		expectedType    TokenType
@@ -60,6 +60,15 @@ func TestNextToken_Operators(t *testing.T) {
 		{TokenGreaterEq, ">="},
 		{TokenEqual, "="},
 		{TokenNotEqual, "~="},
+		{TokenAt, "@"},
+		{TokenIdentical, "=="},
+		{TokenDoubleSlash, "//"},
+		{TokenInteger, "7"},
+		{TokenDoubleSlash, "//"},
+		{TokenInteger, "2"},
+		{TokenString, "a"},
+		{TokenComma, ","},
+		{TokenString, "b"},
 		{TokenEOF, ""},
 	}
 
@@ -463,3 +472,189 @@ func TestNextToken_NumberBeforePeriod(t *testing.T) {
 		}
 	}
 }
+
+func TestNextToken_Fractions(t *testing.T) {
+	input := `1/2r -3/4r 10/4r+1/3r x-1/2r 1/2rem: 1/2 r 1/r`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenFraction, "1/2r"},
+		{TokenFraction, "-3/4r"},
+		{TokenFraction, "10/4r"},
+		{TokenPlus, "+"},
+		{TokenFraction, "1/3r"},
+		// After an operand, - is subtraction
+		{TokenIdentifier, "x"},
+		{TokenMinus, "-"},
+		{TokenFraction, "1/2r"},
+		// The r must end the literal, so 2rem: is a keyword after 2
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenInteger, "2"},
+		{TokenIdentifier, "rem"},
+		{TokenColon, ":"},
+		// With a space before the r, or no denominator, it is division
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenInteger, "2"},
+		{TokenIdentifier, "r"},
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenIdentifier, "r"},
+		{TokenEOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_SlashBetweenNumbers(t *testing.T) {
+	input := `1/2 -3/4 1 / 2 1/x 2.5/2`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		// Without a trailing r, / is the division operator, with or
+		// without spaces
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenInteger, "2"},
+		{TokenInteger, "-3"},
+		{TokenSlash, "/"},
+		{TokenInteger, "4"},
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenInteger, "2"},
+		{TokenInteger, "1"},
+		{TokenSlash, "/"},
+		{TokenIdentifier, "x"},
+		{TokenFloat, "2.5"},
+		{TokenSlash, "/"},
+		{TokenInteger, "2"},
+		{TokenEOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_MinusAfterOperand(t *testing.T) {
+	input := `x-3 (a)-2 foo: -3 y - -1 #(1 -2)`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		// Directly after an operand, - is the binary operator
+		{TokenIdentifier, "x"},
+		{TokenMinus, "-"},
+		{TokenInteger, "3"},
+		{TokenLParen, "("},
+		{TokenIdentifier, "a"},
+		{TokenRParen, ")"},
+		{TokenMinus, "-"},
+		{TokenInteger, "2"},
+		// Elsewhere it starts a negative literal
+		{TokenIdentifier, "foo"},
+		{TokenColon, ":"},
+		{TokenInteger, "-3"},
+		{TokenIdentifier, "y"},
+		{TokenMinus, "-"},
+		{TokenInteger, "-1"},
+		{TokenHashLParen, "#("},
+		{TokenInteger, "1"},
+		{TokenInteger, "-2"},
+		{TokenRParen, ")"},
+		{TokenEOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_BinarySelectors(t *testing.T) {
+	input := `s << 'a' ==> b & c <= d x<-1 a , b`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		// Runs of operator characters are one selector
+		{TokenIdentifier, "s"},
+		{TokenBinary, "<<"},
+		{TokenString, "a"},
+		{TokenBinary, "==>"},
+		{TokenIdentifier, "b"},
+		{TokenBinary, "&"},
+		{TokenIdentifier, "c"},
+		// The operators with their own tokens keep them
+		{TokenLessEq, "<="},
+		{TokenIdentifier, "d"},
+		// - never joins a run, so it can still start a negative number
+		{TokenIdentifier, "x"},
+		{TokenLess, "<"},
+		{TokenInteger, "-1"},
+		{TokenIdentifier, "a"},
+		{TokenComma, ","},
+		{TokenIdentifier, "b"},
+		{TokenEOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package parser - syntax errors
+package parser
+
+import (
+	"fmt"
+)
+
+// ParseError is a syntax error with the position it was found at, for
+// tools that show diagnostics next to the source, such as an editor.
+//
+// Parse and ParseMethod report every syntax error in the source as one
+// *ParseError. Its fields describe the first error, and Errors lists all
+// of them in the order they were found. The message of Error is the same
+// text the parser has always reported, with the offending source line
+// and a pointer under the column.
+//
+// Example:
+//   _, err := parser.New("x := (3 + 4").Parse()
+//   var perr *parser.ParseError
+//   if errors.As(err, &perr) {
+//       for _, e := range perr.Errors() {
+//           fmt.Println(e.Line, e.Column, e.Message)
+//       }
+//   }
+type ParseError struct {
+	Line       int    // Source line of the error, counting from 1
+	Column     int    // Source column of the error, counting from 1
+	Message    string // Description of the error, without its position
+	Suggestion string // How the error might be fixed, or "" if there is no hint
+
+	text   string        // The formatted error, with source context
+	errors []*ParseError // Every error from the same parse, this one first
+}
+
+// Errors returns every syntax error found in the same parse as e, in
+// source order, starting with e itself.
+func (e *ParseError) Errors() []*ParseError {
+	if len(e.errors) == 0 {
+		return []*ParseError{e}
+	}
+	return e.errors
+}
+
+// Error returns all the errors in the parse, formatted as
+// "parser errors: [...]".
+func (e *ParseError) Error() string {
+	texts := make([]string, 0, len(e.errors))
+	for _, err := range e.Errors() {
+		texts = append(texts, err.text)
+	}
+	return fmt.Sprintf("parser errors: %v", texts)
+}
+
+// parseError answers the errors accumulated so far as a single
+// *ParseError, or nil if there are none.
+func (p *Parser) parseError() error {
+	if len(p.diagnostics) == 0 {
+		return nil
+	}
+	first := *p.diagnostics[0]
+	first.errors = p.diagnostics
+	return &first
+}
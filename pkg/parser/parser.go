@@ -61,7 +61,9 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/kristofer/smog/pkg/ast"
 	"github.com/kristofer/smog/pkg/lexer"
@@ -90,6 +92,7 @@ type Parser struct {
 	peekTok       lexer.Token     // Next token (1st lookahead)
 	peekTok2      lexer.Token     // Token after next (2nd lookahead)
 	errors        []string        // Accumulated error messages
+	diagnostics   []*ParseError   // The same errors with their positions
 	source        string          // Original source code (for error context)
 	hasVarDecl    bool            // True if we've seen a variable declaration
 	hasNonVarStmt bool            // True if we've seen a non-variable statement
@@ -196,12 +199,31 @@ func (p *Parser) Parse() (*ast.Program, error) {
 
 	// If there were any parsing errors, return them
 	if len(p.errors) > 0 {
-		return program, fmt.Errorf("parser errors: %v", p.errors)
+		return program, p.parseError()
 	}
 
 	return program, nil
 }
 
+// ParseMethod parses the source of a single method definition, written as
+// it would appear inside a class body, and reports whether it is a class
+// method (<name [ body ]>). It is used to add or replace a method on a
+// class while a program runs.
+//
+// Example:
+//   parser.New("increment [ count := count + 2 ]").ParseMethod()
+//     -> Method{Name: "increment", Body: [...]}, false, nil
+func (p *Parser) ParseMethod() (*ast.Method, bool, error) {
+	method, isClassMethod := p.parseMethod()
+	if method != nil && p.curTok.Type != lexer.TokenEOF {
+		p.addError("unexpected text after method definition")
+	}
+	if len(p.errors) > 0 {
+		return nil, false, p.parseError()
+	}
+	return method, isClassMethod, nil
+}
+
 // parseStatement parses a single statement.
 //
 // Statements are the top-level constructs in smog. This function determines
@@ -276,6 +298,9 @@ func (p *Parser) parseStatement() ast.Statement {
 	if p.isClassDefinition() {
 		return p.parseClass()
 	}
+	if p.isClassExtension() {
+		return p.parseClassExtension()
+	}
 
 	// Mark that we've seen a non-variable statement (expression statements)
 	p.hasNonVarStmt = true
@@ -402,8 +427,9 @@ func (p *Parser) parseExpression() ast.Expression {
 //
 // Note: The caller has already verified curTok is IDENT and peekTok is ASSIGN.
 func (p *Parser) parseAssignment() ast.Expression {
-	// Get the variable name
+	// Get the variable name, which is where the assignment starts
 	name := p.curTok.Literal
+	loc := ast.SourceLocation{Line: p.curTok.Line, Column: p.curTok.Column}
 	p.nextToken() // consume identifier
 
 	// Verify := operator (should always be true given caller's check)
@@ -423,10 +449,7 @@ func (p *Parser) parseAssignment() ast.Expression {
 	return &ast.Assignment{
 		Name:  name,
 		Value: value,
-		Loc: ast.SourceLocation{
-			Line:   p.curTok.Line,
-			Column: p.curTok.Column,
-		},
+		Loc:   loc,
 	}
 }
 
@@ -453,7 +476,7 @@ func (p *Parser) parseAssignment() ast.Expression {
 func (p *Parser) parseMessageSend() ast.Expression {
 	// Check for super message send
 	if p.curTok.Type == lexer.TokenSuper {
-		return p.parseSuperMessageSend()
+		return p.checkForCascade(p.parseSuperMessageSend())
 	}
 	
 	// Start with keyword messages (lowest precedence)
@@ -652,6 +675,9 @@ func (p *Parser) checkForCascade(expr ast.Expression) ast.Expression {
 		// Parse the next message (without the receiver)
 		msg := p.parseMessageWithoutReceiver()
 		if msg != nil {
+			// In super a; b both messages start their lookup above the
+			// current class
+			msg.IsSuper = firstMsg.IsSuper
 			messages = append(messages, *msg)
 		}
 	}
@@ -826,6 +852,10 @@ func (p *Parser) parseSuperMessageSend() ast.Expression {
 // Supported binary operators:
 //   Arithmetic: + - * / %
 //   Comparison: < > <= >= = ~=
+//   Identity: ==
+//   Point construction: @
+//   Concatenation: ,
+//   Any other run of operator characters a class defines, such as << or &
 //
 // Returns true if the token type is one of these operators.
 func (p *Parser) isBinaryOperator(tt lexer.TokenType) bool {
@@ -833,13 +863,18 @@ func (p *Parser) isBinaryOperator(tt lexer.TokenType) bool {
 		tt == lexer.TokenMinus ||
 		tt == lexer.TokenStar ||
 		tt == lexer.TokenSlash ||
+		tt == lexer.TokenDoubleSlash ||
 		tt == lexer.TokenPercent ||
 		tt == lexer.TokenLess ||
 		tt == lexer.TokenGreater ||
 		tt == lexer.TokenLessEq ||
 		tt == lexer.TokenGreaterEq ||
 		tt == lexer.TokenEqual ||
-		tt == lexer.TokenNotEqual
+		tt == lexer.TokenNotEqual ||
+		tt == lexer.TokenAt ||
+		tt == lexer.TokenIdentical ||
+		tt == lexer.TokenComma ||
+		tt == lexer.TokenBinary
 }
 
 // parsePrimaryExpression parses a primary expression (literals and identifiers).
@@ -871,6 +906,8 @@ func (p *Parser) parsePrimaryExpression() ast.Expression {
 		return p.parseIntegerLiteral()
 	case lexer.TokenFloat:
 		return p.parseFloatLiteral()
+	case lexer.TokenFraction:
+		return p.parseFractionLiteral()
 	case lexer.TokenString:
 		return p.parseStringLiteral()
 	case lexer.TokenTrue:
@@ -904,6 +941,9 @@ func (p *Parser) parsePrimaryExpression() ast.Expression {
 	case lexer.TokenHashLBrace:
 		// Dictionary literal #{...}
 		return p.parseDictionaryLiteral()
+	case lexer.TokenLBrace:
+		// Dynamic array {...}
+		return p.parseBraceArray()
 	case lexer.TokenLParen:
 		// Parenthesized expression (...)
 		return p.parseParenthesizedExpression()
@@ -958,6 +998,29 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	return &ast.FloatLiteral{Value: value}
 }
 
+// parseFractionLiteral parses an exact fraction literal.
+//
+// Example:
+//   Token{Type: TokenFraction, Literal: "2/4r"}
+//     -> FractionLiteral{Value: 1/2}
+//
+// Error handling:
+//   A zero denominator is recorded as an error.
+func (p *Parser) parseFractionLiteral() ast.Expression {
+	value, ok := new(big.Rat).SetString(strings.TrimSuffix(p.curTok.Literal, "r"))
+	if !ok {
+		p.addError(fmt.Sprintf("invalid fraction %q: denominator must not be zero", p.curTok.Literal))
+		return nil
+	}
+	return &ast.FractionLiteral{
+		Value: value,
+		Loc: ast.SourceLocation{
+			Line:   p.curTok.Line,
+			Column: p.curTok.Column,
+		},
+	}
+}
+
 // parseStringLiteral parses a string literal.
 //
 // The lexer has already removed the quotes, so we just extract the value.
@@ -991,6 +1054,13 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 //            ^
 //   Error: expected argument after binary operator
 func (p *Parser) addError(msg string) {
+	p.recordError(msg, "")
+}
+
+// recordError adds an error at the current token, both as the formatted
+// message returned by Errors and as a ParseError. suggestion is a hint for
+// fixing the error, or "" if there is none.
+func (p *Parser) recordError(msg, suggestion string) {
 	line := p.curTok.Line
 	column := p.curTok.Column
 	
@@ -1009,6 +1079,10 @@ func (p *Parser) addError(msg string) {
 	}
 	
 	// Build formatted error message with context
+	fullMsg := msg
+	if suggestion != "" {
+		fullMsg = fmt.Sprintf("%s\nSuggestion: %s", msg, suggestion)
+	}
 	var errorMsg string
 	if sourceLine != "" {
 		// Create a pointer to show exact error location
@@ -1018,13 +1092,20 @@ func (p *Parser) addError(msg string) {
 		}
 		
 		errorMsg = fmt.Sprintf("Line %d, Column %d:\n  %s\n  %s\nError: %s",
-			line, column, sourceLine, pointer, msg)
+			line, column, sourceLine, pointer, fullMsg)
 	} else {
 		// Fallback if we can't get the source line
-		errorMsg = fmt.Sprintf("Line %d, Column %d: %s", line, column, msg)
+		errorMsg = fmt.Sprintf("Line %d, Column %d: %s", line, column, fullMsg)
 	}
 	
 	p.errors = append(p.errors, errorMsg)
+	p.diagnostics = append(p.diagnostics, &ParseError{
+		Line:       line,
+		Column:     column,
+		Message:    msg,
+		Suggestion: suggestion,
+		text:       errorMsg,
+	})
 }
 
 // getSourceLine extracts a specific line from the source code.
@@ -1079,11 +1160,7 @@ func splitLines(s string) []string {
 //   - msg: The error message
 //   - suggestion: A helpful suggestion for fixing the error
 func (p *Parser) addErrorWithSuggestion(msg, suggestion string) {
-	fullMsg := msg
-	if suggestion != "" {
-		fullMsg = fmt.Sprintf("%s\nSuggestion: %s", msg, suggestion)
-	}
-	p.addError(fullMsg)
+	p.recordError(msg, suggestion)
 }
 
 // parseBlockLiteral parses a block literal.
@@ -1149,13 +1226,10 @@ func (p *Parser) parseBlockLiteral() ast.Expression {
 		if stmt != nil {
 			body = append(body, stmt)
 		}
-		// If we're at a period, skip it and continue
-		if p.curTok.Type == lexer.TokenPeriod {
-			p.nextToken()
-		} else if p.curTok.Type != lexer.TokenRBracket {
-			// If not at ] and not at period, move forward
-			p.nextToken()
-		}
+		// curTok is the last token of the statement (or its period);
+		// move past it. The statement itself may end with a nested block's ]
+		// so we can't stop early just because curTok is a ].
+		p.nextToken()
 	}
 	
 	// Restore parser state
@@ -1197,6 +1271,12 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 		return nil
 	}
 
+	// Skip the optional period, as for expression statements, so that
+	// statements can follow: ^7. 99.
+	if p.peekTok.Type == lexer.TokenPeriod {
+		p.nextToken()
+	}
+
 	return &ast.ReturnStatement{Value: value}
 }
 
@@ -1233,6 +1313,50 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	return &ast.ArrayLiteral{Elements: elements}
 }
 
+// parseBraceArray parses a dynamic array.
+//
+// Syntax: { expression1. expression2. ... }
+//
+// Each element is a full expression, separated from the next by a period.
+// A period after the last element is allowed, and {} is an empty array.
+//
+// Example:
+//   {1 + 1. 2 * 3}
+//     -> BraceArray{Elements: [(1 + 1), (2 * 3)]}
+func (p *Parser) parseBraceArray() ast.Expression {
+	// curTok is {
+	p.nextToken() // move past {
+
+	var elements []ast.Expression
+
+	// Parse period-separated expressions until }
+	for p.curTok.Type != lexer.TokenRBrace && p.curTok.Type != lexer.TokenEOF {
+		elem := p.parseExpression()
+		if elem == nil {
+			return nil
+		}
+		elements = append(elements, elem)
+
+		p.nextToken() // move past the element's last token
+		if p.curTok.Type == lexer.TokenPeriod {
+			p.nextToken()
+		} else if p.curTok.Type != lexer.TokenRBrace {
+			p.addErrorWithSuggestion(
+				"expected . or } after brace array element",
+				"Separate the elements of a brace array with periods. Example: {1 + 1. 2 * 3}")
+			return nil
+		}
+	}
+
+	// Expect closing }
+	if p.curTok.Type != lexer.TokenRBrace {
+		p.addError("expected } to close brace array")
+		return nil
+	}
+
+	return &ast.BraceArray{Elements: elements}
+}
+
 // parseDictionaryLiteral parses a dictionary literal.
 //
 // Syntax: #{key1 -> value1. key2 -> value2. ...}
@@ -1424,12 +1548,31 @@ func (p *Parser) parseClass() *ast.Class {
 	
 	p.nextToken() // move into the class body
 	
-	// Parse instance variables if present (| var1 var2 |)
+	// Parse instance variables if present (| var1 var2 = default |)
 	if p.curTok.Type == lexer.TokenPipe {
 		p.nextToken() // skip opening |
 		for p.curTok.Type == lexer.TokenIdentifier {
-			class.Fields = append(class.Fields, p.curTok.Literal)
+			field := p.curTok.Literal
+			class.Fields = append(class.Fields, field)
 			p.nextToken()
+			if p.curTok.Type == lexer.TokenEqual {
+				// A default value: a literal, a name or a parenthesized
+				// expression, so the next field name can't be mistaken
+				// for a unary message sent to the value
+				p.nextToken() // skip =
+				value := p.parsePrimaryExpression()
+				if value == nil {
+					p.addErrorWithSuggestion(
+						fmt.Sprintf("expected a default value for instance variable '%s'", field),
+						"Give an instance variable a default like this: | count = 0 items = (Set new) |")
+					return nil
+				}
+				if class.FieldDefaults == nil {
+					class.FieldDefaults = make(map[string]ast.Expression)
+				}
+				class.FieldDefaults[field] = value
+				p.nextToken() // move past the value
+			}
 		}
 		if p.curTok.Type != lexer.TokenPipe {
 			p.addError("expected '|' to close instance variables")
@@ -1463,20 +1606,18 @@ func (p *Parser) parseClass() *ast.Class {
 	
 	// Parse methods until we hit the closing bracket
 	for p.curTok.Type != lexer.TokenRBracket && p.curTok.Type != lexer.TokenEOF {
-		// Check if this is a class method (starts with <)
-		isClassMethod := false
-		if p.curTok.Type == lexer.TokenLess {
-			isClassMethod = true
-			// Don't consume the < yet, let parseMethod handle it
+		// parseMethod reports whether this was a class method (<...>),
+		// since a leading < may also be a binary < instance method
+		method, isClassMethod := p.parseMethod()
+		if method == nil {
+			// parseMethod reported the error without consuming the bad
+			// tokens, so parsing on from here would loop forever
+			return nil
 		}
-		
-		method := p.parseMethod()
-		if method != nil {
-			if isClassMethod {
-				class.ClassMethods = append(class.ClassMethods, method)
-			} else {
-				class.Methods = append(class.Methods, method)
-			}
+		if isClassMethod {
+			class.ClassMethods = append(class.ClassMethods, method)
+		} else {
+			class.Methods = append(class.Methods, method)
 		}
 	}
 	
@@ -1489,18 +1630,92 @@ func (p *Parser) parseClass() *ast.Class {
 	return class
 }
 
+// isClassExtension checks if the current position is at the start of a
+// class extension: Identifier "extend" "[".
+func (p *Parser) isClassExtension() bool {
+	return p.curTok.Type == lexer.TokenIdentifier &&
+		p.peekTok.Type == lexer.TokenIdentifier &&
+		p.peekTok.Literal == "extend" &&
+		p.peekTok2.Type == lexer.TokenLBracket
+}
+
+// parseClassExtension parses a class extension, which adds instance
+// methods to an existing class or built-in type.
+//
+// Syntax: ClassName extend [
+//           method1 [ body ]
+//         ]
+//
+// Example:
+//   Integer extend [
+//       double [ ^self * 2 ]
+//   ]
+func (p *Parser) parseClassExtension() *ast.Class {
+	class := &ast.Class{
+		Name:           p.curTok.Literal,
+		Fields:         []string{},
+		ClassVariables: []string{},
+		Methods:        []*ast.Method{},
+		ClassMethods:   []*ast.Method{},
+		Extension:      true,
+	}
+
+	p.nextToken() // skip the class name
+	p.nextToken() // skip "extend"
+	p.nextToken() // move into the body past [
+
+	if p.curTok.Type == lexer.TokenPipe {
+		p.addError(fmt.Sprintf("cannot add instance variables to %s in an extension", class.Name))
+		return nil
+	}
+
+	for p.curTok.Type != lexer.TokenRBracket && p.curTok.Type != lexer.TokenEOF {
+		method, isClassMethod := p.parseMethod()
+		if method == nil {
+			return nil
+		}
+		if isClassMethod {
+			p.addError(fmt.Sprintf("cannot add class method %s to %s in an extension", method.Name, class.Name))
+			return nil
+		}
+		class.Methods = append(class.Methods, method)
+	}
+
+	if p.curTok.Type != lexer.TokenRBracket {
+		p.addError("expected ']' to close class extension")
+		return nil
+	}
+
+	return class
+}
+
 // parseMethod parses a method definition within a class.
 //
 // Syntax: methodSelector [ body ]
 //        or: keyword: param [ body ]
+//        or: + param [ body ]
 //        or: <classMethod [ body ]>
 //
-// Returns a Method with name, parameters, and body.
-func (p *Parser) parseMethod() *ast.Method {
+// The body may start with a <category: 'name'> annotation filing the
+// method under a protocol, as in GNU Smalltalk:
+//
+//   balance [ <category: 'accessing'> ^balance ]
+//
+// A leading < is ambiguous: "<name [ body ]>" is a unary class method but
+// "< other [ body ]" is a binary < instance method. Both start with the same
+// tokens, so we parse the body first and decide by whether a closing >
+// follows it.
+//
+// Returns a Method with name, parameters, and body, and whether it is a
+// class method.
+func (p *Parser) parseMethod() (*ast.Method, bool) {
 	// Check for class method (starts with <)
 	isClassMethod := false
+	maybeBinaryLess := false
 	if p.curTok.Type == lexer.TokenLess {
 		isClassMethod = true
+		maybeBinaryLess = p.peekTok.Type == lexer.TokenIdentifier &&
+			p.peekTok2.Type == lexer.TokenLBracket
 		p.nextToken() // skip <
 	}
 	
@@ -1521,7 +1736,7 @@ func (p *Parser) parseMethod() *ast.Method {
 				// Get parameter name
 				if p.curTok.Type != lexer.TokenIdentifier {
 					p.addError("expected parameter name after ':'")
-					return nil
+					return nil, false
 				}
 				params = append(params, p.curTok.Literal)
 				p.nextToken()
@@ -1539,21 +1754,30 @@ func (p *Parser) parseMethod() *ast.Method {
 		// Binary methods have one parameter
 		if p.curTok.Type != lexer.TokenIdentifier {
 			p.addError("expected parameter name for binary method")
-			return nil
+			return nil, false
 		}
 		params = append(params, p.curTok.Literal)
 		p.nextToken()
 	} else {
 		p.addError("expected method selector")
-		return nil
+		return nil, false
 	}
 	
 	// Expect opening bracket for method body
 	if p.curTok.Type != lexer.TokenLBracket {
 		p.addError("expected '[' to start method body")
-		return nil
+		return nil, false
 	}
 	p.nextToken() // skip [
+
+	// Optional protocol annotation: <category: 'accessing'>
+	protocol := ""
+	if p.curTok.Type == lexer.TokenLess {
+		var ok bool
+		if protocol, ok = p.parseMethodCategory(); !ok {
+			return nil, false
+		}
+	}
 	
 	// Save parser state for this new scope
 	savedHasVarDecl := p.hasVarDecl
@@ -1578,28 +1802,58 @@ func (p *Parser) parseMethod() *ast.Method {
 	// Expect closing bracket
 	if p.curTok.Type != lexer.TokenRBracket {
 		p.addError("expected ']' to close method body")
-		return nil
+		return nil, false
 	}
 	p.nextToken() // skip ]
 	
 	// If class method, expect closing >
 	if isClassMethod {
-		if p.curTok.Type != lexer.TokenGreater {
+		if p.curTok.Type == lexer.TokenGreater {
+			p.nextToken() // skip >
+		} else if maybeBinaryLess {
+			// "< other [ body ]" - a binary < instance method
+			isClassMethod = false
+			params = []string{selector}
+			selector = "<"
+		} else {
 			p.addError("expected '>' to close class method")
-			return nil
+			return nil, false
 		}
-		p.nextToken() // skip >
 	}
 	
 	method := &ast.Method{
 		Name:       selector,
 		Parameters: params,
 		Body:       body,
+		Protocol:   protocol,
 	}
 	
-	// Note: We don't distinguish class methods from instance methods in the AST yet
-	// This would need to be added to the Method struct or handled separately
-	// For now, all methods go into the Methods slice
-	
-	return method
+	// The caller (parseClass) files the method under Methods or ClassMethods
+	return method, isClassMethod
+}
+
+// parseMethodCategory parses a <category: 'name'> annotation at the start
+// of a method body and returns the category name. The current token is
+// the opening <.
+func (p *Parser) parseMethodCategory() (string, bool) {
+	p.nextToken() // skip <
+	if p.curTok.Type != lexer.TokenIdentifier || p.curTok.Literal != "category" || p.peekTok.Type != lexer.TokenColon {
+		p.addErrorWithSuggestion("expected 'category:' in method annotation",
+			"Annotate a method with its protocol like this: balance [ <category: 'accessing'> ^balance ]")
+		return "", false
+	}
+	p.nextToken() // skip category
+	p.nextToken() // skip :
+	if p.curTok.Type != lexer.TokenString {
+		p.addError("expected a string naming the category after 'category:'")
+		return "", false
+	}
+	category := p.curTok.Literal
+	p.nextToken() // skip the string
+	if p.curTok.Type != lexer.TokenGreater {
+		p.addError("expected '>' to close the method annotation")
+		return "", false
+	}
+	p.nextToken() // skip >
+	return category, true
 }
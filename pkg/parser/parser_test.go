@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/kristofer/smog/pkg/ast"
@@ -608,6 +610,35 @@ func TestParseBlockLiteralWithMultipleParameters(t *testing.T) {
 	}
 }
 
+func TestParseBlockEndingWithNestedBlock(t *testing.T) {
+	input := "[ :x | x > 2 ifTrue: [ 'big' ] ]. 42"
+
+	p := New(input)
+	program, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected ExpressionStatement, got %T", program.Statements[0])
+	}
+
+	block, ok := stmt.Expression.(*ast.BlockLiteral)
+	if !ok {
+		t.Fatalf("Expected BlockLiteral, got %T", stmt.Expression)
+	}
+
+	if len(block.Body) != 1 {
+		t.Fatalf("Expected 1 statement in block body, got %d", len(block.Body))
+	}
+}
+
 func TestParseReturnStatement(t *testing.T) {
 	input := "^42"
 
@@ -637,6 +668,24 @@ func TestParseReturnStatement(t *testing.T) {
 	}
 }
 
+// TestParseStatementsAfterReturn tests that a return statement can end
+// with a period and be followed by more statements
+func TestParseStatementsAfterReturn(t *testing.T) {
+	program, err := New("^7. 99.").Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*ast.ReturnStatement); !ok {
+		t.Errorf("Expected ReturnStatement, got %T", program.Statements[0])
+	}
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement); !ok {
+		t.Errorf("Expected ExpressionStatement, got %T", program.Statements[1])
+	}
+}
+
 func TestParseArrayLiteral(t *testing.T) {
 	input := "#(1 2 3 4 5)"
 
@@ -1230,6 +1279,108 @@ t.Errorf("Expected class method name 'new', got '%s'", method.Name)
 }
 }
 
+// TestParseClassExtension tests parsing "Name extend [ ... ]", which adds
+// instance methods to an existing class or built-in type
+func TestParseClassExtension(t *testing.T) {
+	input := `Integer extend [
+    double [ ^self * 2 ]
+    + other [ ^self ]
+]
+3 double`
+
+	program, err := New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+	class, ok := program.Statements[0].(*ast.Class)
+	if !ok {
+		t.Fatalf("Expected *ast.Class, got %T", program.Statements[0])
+	}
+	if !class.Extension || class.Name != "Integer" || class.SuperClass != "" {
+		t.Errorf("Expected an extension of Integer, got %+v", class)
+	}
+	if len(class.Methods) != 2 || class.Methods[0].Name != "double" || class.Methods[1].Name != "+" {
+		t.Errorf("Expected methods double and +, got %+v", class.Methods)
+	}
+
+	// An identifier followed by an extend message is still a send
+	program, err = New("x extend").Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if _, ok := program.Statements[0].(*ast.ExpressionStatement); !ok {
+		t.Errorf("Expected x extend to be an expression, got %T", program.Statements[0])
+	}
+
+	for _, bad := range []string{
+		"Integer extend [ | a | foo [ ^a ] ]",
+		"Integer extend [ <zero [ ^0 ]> ]",
+		"Integer extend [ foo [ ^1 ]",
+	} {
+		if _, err := New(bad).Parse(); err == nil {
+			t.Errorf("Expected a parse error for %q", bad)
+		}
+	}
+}
+
+// TestParseMethodCategory tests parsing <category: '...'> annotations that
+// file methods under a protocol
+func TestParseMethodCategory(t *testing.T) {
+	input := `Object subclass: #Account [
+    | balance |
+    balance [ <category: 'accessing'> ^balance ]
+    deposit: amount [
+        <category: 'operations'>
+        | previous |
+        previous := balance.
+        balance := balance + amount
+    ]
+    reset [ balance := 0 ]
+    <named: aName [ <category: 'instance creation'> ^self new ]>
+]`
+
+	program, err := New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	class := program.Statements[0].(*ast.Class)
+
+	expected := []struct {
+		name, protocol string
+		statements     int
+	}{
+		{"balance", "accessing", 1},
+		{"deposit:", "operations", 3},
+		{"reset", "", 1},
+	}
+	if len(class.Methods) != len(expected) {
+		t.Fatalf("Expected %d methods, got %d", len(expected), len(class.Methods))
+	}
+	for i, want := range expected {
+		method := class.Methods[i]
+		if method.Name != want.name || method.Protocol != want.protocol || len(method.Body) != want.statements {
+			t.Errorf("Expected %s in %q with %d statements, got %s in %q with %d statements",
+				want.name, want.protocol, want.statements, method.Name, method.Protocol, len(method.Body))
+		}
+	}
+	if len(class.ClassMethods) != 1 || class.ClassMethods[0].Protocol != "instance creation" {
+		t.Errorf("Expected the class method in 'instance creation', got %+v", class.ClassMethods)
+	}
+
+	for _, bad := range []string{
+		"Object subclass: #A [ foo [ <author: 'me'> ^1 ] ]",
+		"Object subclass: #A [ foo [ <category: accessing> ^1 ] ]",
+		"Object subclass: #A [ foo [ <category: 'accessing' ^1 ] ]",
+	} {
+		if _, err := New(bad).Parse(); err == nil {
+			t.Errorf("Expected a parse error for %q", bad)
+		}
+	}
+}
+
 // TestParseClassWithMultipleMethods tests parsing a class with multiple methods
 func TestParseClassWithMultipleMethods(t *testing.T) {
 input := `Object subclass: #Counter [
@@ -1335,3 +1486,387 @@ if class.ClassMethods[0].Name != "incrementTotal" {
 t.Errorf("Expected class method 'incrementTotal', got '%s'", class.ClassMethods[0].Name)
 }
 }
+
+func TestParseCascadeOnKeywordMessage(t *testing.T) {
+	p := New("OrderedCollection new add: 1; add: 2 + 3; size; yourself")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	cascade, ok := stmt.Expression.(*ast.CascadeExpression)
+	if !ok {
+		t.Fatalf("Expected CascadeExpression, got %T", stmt.Expression)
+	}
+
+	// Every segment goes to the receiver of the first keyword message,
+	// i.e. the new collection, not to the result of add: 1
+	receiver, ok := cascade.Receiver.(*ast.MessageSend)
+	if !ok || receiver.Selector != "new" {
+		t.Fatalf("Expected cascade receiver 'OrderedCollection new', got %#v", cascade.Receiver)
+	}
+	if ident, ok := receiver.Receiver.(*ast.Identifier); !ok || ident.Name != "OrderedCollection" {
+		t.Errorf("Expected 'new' to be sent to OrderedCollection, got %#v", receiver.Receiver)
+	}
+
+	selectors := []string{"add:", "add:", "size", "yourself"}
+	if len(cascade.Messages) != len(selectors) {
+		t.Fatalf("Expected %d cascade messages, got %d", len(selectors), len(cascade.Messages))
+	}
+	for i, want := range selectors {
+		if cascade.Messages[i].Selector != want {
+			t.Errorf("Message %d: expected %s, got %s", i, want, cascade.Messages[i].Selector)
+		}
+	}
+
+	// The second add: keeps its whole binary argument
+	if arg, ok := cascade.Messages[1].Args[0].(*ast.MessageSend); !ok || arg.Selector != "+" {
+		t.Errorf("Expected second add: argument to be 2 + 3, got %#v", cascade.Messages[1].Args[0])
+	}
+}
+
+func TestParseCascadeAfterUnaryThenKeyword(t *testing.T) {
+	tests := []struct {
+		input     string
+		unaries   []string // selectors of the receiver chain, innermost first
+		selectors []string // selectors of the cascade messages
+	}{
+		{"obj foo bar: 1; baz: 2", []string{"foo"}, []string{"bar:", "baz:"}},
+		{"obj foo qux bar: 1 with: 2; baz: 3; size", []string{"foo", "qux"}, []string{"bar:with:", "baz:", "size"}},
+	}
+
+	for _, tt := range tests {
+		program, err := New(tt.input).Parse()
+		if err != nil {
+			t.Fatalf("%s: Parse returned error: %v", tt.input, err)
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		cascade, ok := stmt.Expression.(*ast.CascadeExpression)
+		if !ok {
+			t.Fatalf("%s: expected CascadeExpression, got %T", tt.input, stmt.Expression)
+		}
+
+		// The cascade receiver is the unary chain, so every segment goes to
+		// the result of obj foo rather than to obj or to the result of bar:
+		receiver := cascade.Receiver
+		for i := len(tt.unaries) - 1; i >= 0; i-- {
+			send, ok := receiver.(*ast.MessageSend)
+			if !ok || send.Selector != tt.unaries[i] || len(send.Args) != 0 {
+				t.Fatalf("%s: expected unary %s in cascade receiver, got %#v", tt.input, tt.unaries[i], receiver)
+			}
+			receiver = send.Receiver
+		}
+		if ident, ok := receiver.(*ast.Identifier); !ok || ident.Name != "obj" {
+			t.Errorf("%s: expected the unary chain to start at obj, got %#v", tt.input, receiver)
+		}
+
+		if len(cascade.Messages) != len(tt.selectors) {
+			t.Fatalf("%s: expected %d cascade messages, got %d", tt.input, len(tt.selectors), len(cascade.Messages))
+		}
+		for i, want := range tt.selectors {
+			if cascade.Messages[i].Selector != want {
+				t.Errorf("%s: message %d: expected %s, got %s", tt.input, i, want, cascade.Messages[i].Selector)
+			}
+		}
+	}
+}
+
+func TestParseFractionLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1/2r", "1/2"},
+		{"-3/4r", "-3/4"},
+		{"10/4r", "5/2"}, // reduced to lowest terms
+		{"4/2r", "2/1"},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%s: Parse returned error: %v", tt.input, err)
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.FractionLiteral)
+		if !ok {
+			t.Fatalf("%s: expected FractionLiteral, got %T", tt.input, stmt.Expression)
+		}
+		if lit.Value.String() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, lit.Value.String())
+		}
+	}
+
+	if _, err := New("1/0r").Parse(); err == nil {
+		t.Error("Expected error for zero denominator")
+	}
+}
+
+func TestParseDivisionWithoutSpaces(t *testing.T) {
+	// Without a trailing r, / between integers is the division message,
+	// with or without spaces, so 10/0 parses and only fails when it runs
+	for _, input := range []string{"7/2", "7 / 2", "-7/2", "10/0"} {
+		program, err := New(input).Parse()
+		if err != nil {
+			t.Fatalf("%s: Parse returned error: %v", input, err)
+		}
+		msg, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MessageSend)
+		if !ok || msg.Selector != "/" {
+			t.Fatalf("%s: expected a / message send, got %#v", input, program.Statements[0])
+		}
+		if _, ok := msg.Receiver.(*ast.IntegerLiteral); !ok {
+			t.Errorf("%s: expected an integer receiver, got %#v", input, msg.Receiver)
+		}
+		if _, ok := msg.Args[0].(*ast.IntegerLiteral); !ok {
+			t.Errorf("%s: expected an integer argument, got %#v", input, msg.Args[0])
+		}
+	}
+}
+
+func TestParseNegativeKeywordArguments(t *testing.T) {
+	p := New("point x: -3 y: -1/2")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	msg := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MessageSend)
+	if msg.Selector != "x:y:" {
+		t.Fatalf("Expected selector 'x:y:', got %s", msg.Selector)
+	}
+	if arg, ok := msg.Args[0].(*ast.IntegerLiteral); !ok || arg.Value != -3 {
+		t.Errorf("Expected first argument -3, got %#v", msg.Args[0])
+	}
+	if arg, ok := msg.Args[1].(*ast.MessageSend); !ok || arg.Selector != "/" {
+		t.Errorf("Expected second argument -1 / 2, got %#v", msg.Args[1])
+	}
+}
+
+func TestParseSubtractionWithoutSpaces(t *testing.T) {
+	p := New("x-3")
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	msg, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MessageSend)
+	if !ok || msg.Selector != "-" {
+		t.Fatalf("Expected x - 3 message send, got %#v", program.Statements[0])
+	}
+	if arg, ok := msg.Args[0].(*ast.IntegerLiteral); !ok || arg.Value != 3 {
+		t.Errorf("Expected argument 3, got %#v", msg.Args[0])
+	}
+}
+
+func TestParseBraceArray(t *testing.T) {
+	tests := []struct {
+		input     string
+		selectors []string // selector of each element, "" if not a message send
+	}{
+		{"{1 + 1. 2 * 3}", []string{"+", "*"}},
+		{"{x. y foo. a at: 1 put: 2.}", []string{"", "foo", "at:put:"}},
+		{"{x := 3. [:e | e]. {1}}", []string{"", "", ""}},
+		{"{}", nil},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse returned error for %q: %v", tt.input, err)
+		}
+		arr, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.BraceArray)
+		if !ok {
+			t.Fatalf("%q: expected BraceArray, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if len(arr.Elements) != len(tt.selectors) {
+			t.Fatalf("%q: expected %d elements, got %d", tt.input, len(tt.selectors), len(arr.Elements))
+		}
+		for i, elem := range arr.Elements {
+			selector := ""
+			if msg, ok := elem.(*ast.MessageSend); ok {
+				selector = msg.Selector
+			}
+			if selector != tt.selectors[i] {
+				t.Errorf("%q: expected element %d to send %q, got %T %q", tt.input, i, tt.selectors[i], elem, selector)
+			}
+		}
+	}
+
+	// #(...) still holds literals only
+	if _, err := New("#(1 + 1)").Parse(); err == nil {
+		t.Error("Expected #(1 + 1) to be a parse error")
+	}
+}
+
+func TestParseBraceArrayErrors(t *testing.T) {
+	for _, input := range []string{"{1 2}", "{1 + 1", "{1. 2"} {
+		p := New(input)
+		if _, err := p.Parse(); err == nil {
+			t.Errorf("%q: expected parse error", input)
+		}
+	}
+}
+
+// TestParseBinarySelectorCascade tests cascading a user-defined binary
+// selector, to an object and to super
+func TestParseBinarySelectorCascade(t *testing.T) {
+	tests := []struct {
+		input   string
+		isSuper bool
+	}{
+		{"stream << 'a'; << 'b'", false},
+		{"super << 'a'; << 'b'", true},
+	}
+
+	for _, tt := range tests {
+		program, err := New(tt.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse returned error for %q: %v", tt.input, err)
+		}
+		cascade, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CascadeExpression)
+		if !ok {
+			t.Fatalf("%q: expected CascadeExpression, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if tt.isSuper != (cascade.Receiver == nil) {
+			t.Errorf("%q: unexpected receiver %v", tt.input, cascade.Receiver)
+		}
+		if len(cascade.Messages) != 2 {
+			t.Fatalf("%q: expected 2 messages, got %d", tt.input, len(cascade.Messages))
+		}
+		for i, msg := range cascade.Messages {
+			arg, ok := msg.Args[0].(*ast.StringLiteral)
+			if msg.Selector != "<<" || len(msg.Args) != 1 || !ok || arg.Value != []string{"a", "b"}[i] {
+				t.Errorf("%q: unexpected message %d: %s %v", tt.input, i, msg.Selector, msg.Args)
+			}
+			if msg.IsSuper != tt.isSuper {
+				t.Errorf("%q: expected message %d to have IsSuper %v", tt.input, i, tt.isSuper)
+			}
+		}
+	}
+}
+
+// TestParseBinarySelectorMethod tests defining and sending a user-defined
+// binary selector
+func TestParseBinarySelectorMethod(t *testing.T) {
+	input := `Object subclass: #Buffer [
+    << item [ ^super << item ]
+]
+Buffer new << 1 << 2`
+
+	program, err := New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	class := program.Statements[0].(*ast.Class)
+	if len(class.Methods) != 1 || class.Methods[0].Name != "<<" || len(class.Methods[0].Parameters) != 1 {
+		t.Fatalf("Expected a << method with 1 parameter, got %+v", class.Methods)
+	}
+	send, ok := class.Methods[0].Body[0].(*ast.ReturnStatement).Value.(*ast.MessageSend)
+	if !ok || !send.IsSuper || send.Selector != "<<" {
+		t.Errorf("Expected ^super << item, got %+v", class.Methods[0].Body[0])
+	}
+
+	outer, ok := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.MessageSend)
+	if !ok || outer.Selector != "<<" {
+		t.Fatalf("Expected an outer << send, got %+v", program.Statements[1])
+	}
+	if inner, ok := outer.Receiver.(*ast.MessageSend); !ok || inner.Selector != "<<" {
+		t.Errorf("Expected << to chain left to right, got %+v", outer.Receiver)
+	}
+}
+
+// TestParseFieldDefaults tests instance variables declared with default
+// values
+func TestParseFieldDefaults(t *testing.T) {
+	input := `Object subclass: #Counter [
+    | count = 0 label items = (Set new) offset = -1 |
+    count [ ^count ]
+]`
+	program, err := New(input).Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	class := program.Statements[0].(*ast.Class)
+	if got := strings.Join(class.Fields, " "); got != "count label items offset" {
+		t.Errorf("Expected fields count label items offset, got %s", got)
+	}
+	if len(class.FieldDefaults) != 3 {
+		t.Fatalf("Expected 3 defaults, got %v", class.FieldDefaults)
+	}
+	if lit, ok := class.FieldDefaults["count"].(*ast.IntegerLiteral); !ok || lit.Value != 0 {
+		t.Errorf("Expected count to default to 0, got %v", class.FieldDefaults["count"])
+	}
+	if _, ok := class.FieldDefaults["label"]; ok {
+		t.Error("Expected label to have no default")
+	}
+	if send, ok := class.FieldDefaults["items"].(*ast.MessageSend); !ok || send.Selector != "new" {
+		t.Errorf("Expected items to default to Set new, got %v", class.FieldDefaults["items"])
+	}
+	if lit, ok := class.FieldDefaults["offset"].(*ast.IntegerLiteral); !ok || lit.Value != -1 {
+		t.Errorf("Expected offset to default to -1, got %v", class.FieldDefaults["offset"])
+	}
+	if len(class.Methods) != 1 {
+		t.Errorf("Expected 1 method after the fields, got %d", len(class.Methods))
+	}
+
+	if _, err := New("Object subclass: #Bad [ | count = | ]").Parse(); err == nil ||
+		!strings.Contains(err.Error(), "expected a default value for instance variable 'count'") {
+		t.Errorf("Expected a missing default error, got %v", err)
+	}
+}
+
+// TestParseErrorPositions tests that syntax errors are reported as a
+// *ParseError carrying the position of every error in the source
+func TestParseErrorPositions(t *testing.T) {
+	_, err := New("x := (3 + 4").Parse()
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected a *ParseError, got %T: %v", err, err)
+	}
+	if perr.Line != 1 || perr.Column != 12 || perr.Message != "expected ')' to close parenthesized expression" {
+		t.Errorf("Expected the error at 1:12, got %d:%d %q", perr.Line, perr.Column, perr.Message)
+	}
+	if !strings.HasPrefix(err.Error(), "parser errors: [Line 1, Column 12:") {
+		t.Errorf("Expected the formatted message to be unchanged, got %q", err.Error())
+	}
+
+	_, err = New("| a |\na := 3 +.\nb := ]").Parse()
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected a *ParseError, got %T: %v", err, err)
+	}
+	expected := []struct {
+		line, column int
+		message      string
+		suggestion   bool
+	}{
+		{2, 9, "unexpected token: PERIOD", false},
+		{2, 9, "expected argument after binary operator", true},
+		{3, 6, "unexpected token: RBRACKET", false},
+	}
+	all := perr.Errors()
+	if len(all) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(all), err)
+	}
+	for i, want := range expected {
+		got := all[i]
+		if got.Line != want.line || got.Column != want.column || got.Message != want.message {
+			t.Errorf("Error %d: expected %d:%d %q, got %d:%d %q",
+				i+1, want.line, want.column, want.message, got.Line, got.Column, got.Message)
+		}
+		if (got.Suggestion != "") != want.suggestion {
+			t.Errorf("Error %d: unexpected suggestion %q", i+1, got.Suggestion)
+		}
+	}
+	if perr.Line != 2 || perr.Column != 9 {
+		t.Errorf("Expected the first error's position, got %d:%d", perr.Line, perr.Column)
+	}
+
+	_, _, err = New("foo [ ^1 ] bar").ParseMethod()
+	if !errors.As(err, &perr) || perr.Message != "unexpected text after method definition" {
+		t.Errorf("Expected ParseMethod to return a *ParseError, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestArrayCollectSelectRejectInject tests the core functional protocol on
+// arrays: collect:, select:, reject: and inject:into:
+func TestArrayCollectSelectRejectInject(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// collect: keeps the order
+		{"#(1 2 3) collect: [:x | x * 2]", ints(2, 4, 6)},
+		{"#(3 1 2) collect: [:x | x * x]", ints(9, 1, 4)},
+		{"#() collect: [:x | x * 2]", ints()},
+		// select: and reject: split the elements between them
+		{"#(1 2 3 4 5) select: [:x | (x % 2) = 1]", ints(1, 3, 5)},
+		{"#(1 2 3 4 5) reject: [:x | (x % 2) = 1]", ints(2, 4)},
+		{"#(1 2 3) reject: [:x | true]", ints()},
+		{"#(1 2 3) reject: [:x | false]", ints(1, 2, 3)},
+		{"(Set new add: 1; add: 2; yourself) reject: [:x | x = 1]", ints(2)},
+		// inject:into: passes the accumulator first and the element second
+		{"#(1 2 3 4) inject: 0 into: [:a :b | a + b]", int64(10)},
+		{"#(1 2 3) inject: 0 into: [:acc :x | (acc * 10) + x]", int64(123)},
+		{"#() inject: 7 into: [:a :b | a + b]", int64(7)},
+		// The original array is left alone
+		{"| a | a := #(1 2 3). a collect: [:x | 0]. a reject: [:x | true]. a", ints(1, 2, 3)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"#(1 2) reject: [:x | nil]", "reject: block must answer a Boolean"},
+		{"#(1 2) reject: [:x :y | x]", "reject: block must take 1 argument(s)"},
+		{"#(1 2) collect: [:x :y | x]", "collect: block must take 1 argument(s)"},
+		{"#(1 2) inject: 0 into: [:x | x]", "inject:into: block must take 2 argument(s)"},
+		{"#(1 2) reject: 3", "reject: argument must be a block"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestArrayCollectNonLocalReturn tests that a ^ inside the block returns
+// from the enclosing method, leaving the enumeration early
+func TestArrayCollectNonLocalReturn(t *testing.T) {
+	classes := `Object subclass: #Finder [
+    collectUntil: n [ #(1 2 3 4) collect: [:x | x = n ifTrue: [^x * 100]. x]. ^0 ]
+    selectUntil: n [ #(1 2 3 4) select: [:x | x = n ifTrue: [^x * 100]. true]. ^0 ]
+    rejectUntil: n [ #(1 2 3 4) reject: [:x | x = n ifTrue: [^x * 100]. false]. ^0 ]
+    injectUntil: n [ ^#(1 2 3 4) inject: 0 into: [:sum :x | sum >= n ifTrue: [^sum]. sum + x] ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"Finder new collectUntil: 2", int64(200)},
+		{"Finder new selectUntil: 3", int64(300)},
+		{"Finder new rejectUntil: 4", int64(400)},
+		{"Finder new rejectUntil: 5", int64(0)},
+		{"Finder new injectUntil: 3", int64(3)},
+		{"Finder new injectUntil: 99", int64(10)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package vm - built-in classes
+package vm
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// BuiltinClass is a class object implemented natively by the VM.
+//
+// Built-in classes (such as Hasher and Bag) are not stored in the globals map.
+// OpLoadGlobal falls back to them only when no global of the same name
+// exists, so smog programs can still define a class with the same name
+// and it will shadow the built-in one.
+//
+// Example:
+//   Hasher sha256
+//     -> LOAD_GLOBAL "Hasher" resolves to builtinClasses["Hasher"]
+//     -> SEND sha256 is handled by sendBuiltinClass
+type BuiltinClass struct {
+	Name string // The global name of the class
+}
+
+// String returns the class name, so printing a class shows its name.
+func (c *BuiltinClass) String() string {
+	return c.Name
+}
+
+// builtinClasses holds the class objects available to every program.
+var builtinClasses = map[string]*BuiltinClass{
+	"Hasher":             {Name: "Hasher"},
+	"Bag":                {Name: "Bag"},
+	"Set":                {Name: "Set"},
+	"IdentitySet":        {Name: "IdentitySet"},
+	"Dictionary":         {Name: "Dictionary"},
+	"IdentityDictionary": {Name: "IdentityDictionary"},
+	"String":             {Name: "String"},
+	"WriteStream":        {Name: "WriteStream"},
+	"ReadStream":         {Name: "ReadStream"},
+	"ByteArray":          {Name: "ByteArray"},
+	"Array":              {Name: "Array"},
+	"Error":              {Name: "Error"},
+	"ZeroDivide":         {Name: "ZeroDivide"},
+	"Timeout":            {Name: "Timeout"},
+}
+
+// maxElements is the largest size a program can ask for when it makes an
+// array, byte array or string of a given length. Larger sizes are a runtime
+// error rather than a Go panic or an attempt to exhaust memory.
+const maxElements = 1 << 28
+
+// lookupBuiltinClass returns the built-in class with the given name, if any.
+func lookupBuiltinClass(name string) (*BuiltinClass, bool) {
+	class, ok := builtinClasses[name]
+	return class, ok
+}
+
+// BuiltinClassNames returns the names of the built-in classes, sorted
+// alphabetically.
+func BuiltinClassNames() []string {
+	names := make([]string, 0, len(builtinClasses))
+	for name := range builtinClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sendBuiltinClass dispatches a class-side message to a built-in class.
+// The handled result is false when the class does not implement the selector,
+// letting send() fall through to the generic primitives.
+func (vm *VM) sendBuiltinClass(class *BuiltinClass, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch class.Name {
+	case "Hasher":
+		switch selector {
+		case "sha256", "sha512", "md5":
+			hasher, err := newHasher(selector)
+			return hasher, true, err
+		}
+	case "Bag":
+		if selector == "new" {
+			return newBag(), true, nil
+		}
+	case "Set":
+		if selector == "new" {
+			return newSet(), true, nil
+		}
+	case "IdentitySet":
+		if selector == "new" {
+			return newIdentitySet(), true, nil
+		}
+	case "Dictionary":
+		if selector == "new" {
+			return newDictionary(), true, nil
+		}
+	case "IdentityDictionary":
+		if selector == "new" {
+			return newIdentityDictionary(), true, nil
+		}
+	case "String":
+		if selector == "streamContents:" {
+			result, err := vm.streamContents(args)
+			return result, true, err
+		}
+	case "WriteStream":
+		if selector == "new" {
+			return newWriteStream(), true, nil
+		}
+	case "ReadStream":
+		if selector == "on:" {
+			stream, err := readStreamOn(args)
+			return stream, true, err
+		}
+	case "ByteArray":
+		switch selector {
+		case "new":
+			return &ByteArray{Bytes: []byte{}}, true, nil
+		case "new:":
+			bytes, err := newByteArray(args)
+			return bytes, true, err
+		case "fromHex:":
+			bytes, err := byteArrayFromHex(args)
+			return bytes, true, err
+		}
+	case "Array":
+		switch selector {
+		case "new", "new:", "new:withAll:", "new:with:":
+			array, err := vm.newArray(selector, args)
+			return array, true, err
+		}
+	case "Error", "ZeroDivide", "Timeout":
+		return vm.sendExceptionClass(class, selector, args)
+	}
+	return nil, false, nil
+}
+
+// newArray answers a new Array for Array new, new: n, new: n withAll:
+// value, or new: n with: aBlock. new: fills the array with nil, withAll:
+// with one value, and with: with the block's answer for each index from
+// 1 to n.
+//
+// Example:
+//   Array new: 3                      "#(nil nil nil)"
+//   Array new: 3 withAll: 0           "#(0 0 0)"
+//   Array new: 4 with: [:i | i * i]   "#(1 4 9 16)"
+func (vm *VM) newArray(selector string, args []interface{}) (*Array, error) {
+	if selector == "new" {
+		return &Array{Elements: []interface{}{}}, nil
+	}
+	size, ok := args[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("%s size must be a non-negative integer, got %s", selector, describeValue(args[0]))
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("%s size must be a non-negative integer, got %d", selector, size)
+	}
+	if size > maxElements {
+		return nil, fmt.Errorf("%s size %d is larger than the maximum of %d", selector, size, maxElements)
+	}
+	elements := make([]interface{}, size)
+	switch selector {
+	case "new:withAll:":
+		for i := range elements {
+			elements[i] = args[1]
+		}
+	case "new:with:":
+		block, err := blockArg(selector, args[1:], 1)
+		if err != nil {
+			return nil, err
+		}
+		for i := range elements {
+			elem, err := vm.executeBlock(block, []interface{}{int64(i + 1)})
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// Hasher incrementally computes a cryptographic hash.
+//
+// Data is fed in chunks with update: and the hex digest of everything
+// seen so far is returned by digest, so large inputs never need to be
+// held in memory at once.
+//
+// Example:
+//   | h |
+//   h := Hasher sha256.
+//   h update: 'hello '.
+//   h update: 'world'.
+//   h digest  "same as (nil sha256: 'hello world')"
+type Hasher struct {
+	Algorithm string    // Name of the hash algorithm (sha256, sha512, md5)
+	hash      hash.Hash // Underlying streaming hash state
+}
+
+// newHasher creates a Hasher for the named algorithm.
+func newHasher(algorithm string) (*Hasher, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algorithm)
+	}
+	return &Hasher{Algorithm: algorithm, hash: h}, nil
+}
+
+// Update adds data to the running hash.
+func (h *Hasher) Update(data string) {
+	h.hash.Write([]byte(data))
+}
+
+// Digest returns the hex digest of all data written so far.
+// It does not reset the hasher, so more data can still be added.
+func (h *Hasher) Digest() string {
+	return fmt.Sprintf("%x", h.hash.Sum(nil))
+}
+
+// Reset clears the hasher back to its initial state.
+func (h *Hasher) Reset() {
+	h.hash.Reset()
+}
+
+// String returns a printable description of the hasher.
+func (h *Hasher) String() string {
+	return fmt.Sprintf("a Hasher (%s)", h.Algorithm)
+}
+
+// sendHasher handles messages sent to a Hasher instance.
+func (vm *VM) sendHasher(h *Hasher, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "update:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("update: expects 1 argument, got %d", len(args))
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, true, fmt.Errorf("update: argument must be a string")
+		}
+		h.Update(data)
+		return h, true, nil
+	case "digest":
+		return h.Digest(), true, nil
+	case "reset":
+		h.Reset()
+		return h, true, nil
+	case "algorithm":
+		return h.Algorithm, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,166 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// runSource compiles and runs smog source, returning the value left on the stack.
+func runSource(t *testing.T, source string) interface{} {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	c := compiler.New()
+	bc, err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	vm := New()
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	return vm.StackTop()
+}
+
+// runSourceError compiles and runs smog source, returning the runtime error.
+func runSourceError(t *testing.T, source string) error {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	c := compiler.New()
+	bc, err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	return New().Run(bc)
+}
+
+// TestHasherChunksMatchOneShot tests that streaming hashes equal one-shot hashes
+func TestHasherChunksMatchOneShot(t *testing.T) {
+	vm := &VM{}
+
+	for _, algorithm := range []string{"sha256", "sha512", "md5"} {
+		h, err := newHasher(algorithm)
+		if err != nil {
+			t.Fatalf("newHasher(%s) failed: %v", algorithm, err)
+		}
+		h.Update("hello ")
+		h.Update("")
+		h.Update("world")
+
+		var expected string
+		switch algorithm {
+		case "sha256":
+			expected = vm.sha256Hash("hello world")
+		case "sha512":
+			expected = vm.sha512Hash("hello world")
+		case "md5":
+			expected = vm.md5Hash("hello world")
+		}
+		if digest := h.Digest(); digest != expected {
+			t.Errorf("%s chunked digest = %s, want %s", algorithm, digest, expected)
+		}
+	}
+
+	if _, err := newHasher("crc32"); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
+
+// TestHasherFromSource tests the Hasher class from smog code
+func TestHasherFromSource(t *testing.T) {
+	result := runSource(t, `
+		| h |
+		h := Hasher sha256.
+		h update: 'hello '.
+		h update: 'world'.
+		h digest = (nil sha256: 'hello world')
+	`)
+	if result != true {
+		t.Errorf("Expected chunked digest to equal sha256:, got %v", result)
+	}
+
+	// update: returns the hasher so calls can be chained
+	result = runSource(t, `((Hasher md5) update: 'abc') digest`)
+	if result != (&VM{}).md5Hash("abc") {
+		t.Errorf("Unexpected md5 digest: %v", result)
+	}
+
+	// reset starts over
+	result = runSource(t, `| h | h := Hasher sha256. h update: 'junk'. h reset. h update: 'abc'. h digest`)
+	if result != (&VM{}).sha256Hash("abc") {
+		t.Errorf("Unexpected digest after reset: %v", result)
+	}
+}
+
+// TestBuiltinClassShadowedByUserClass tests that user classes take precedence
+func TestBuiltinClassShadowedByUserClass(t *testing.T) {
+	result := runSource(t, `
+		Object subclass: #Hasher [
+			<sha256 [ ^'mine' ]>
+		]
+		Hasher sha256
+	`)
+	if result != "mine" {
+		t.Errorf("Expected user-defined Hasher to shadow built-in, got %v", result)
+	}
+}
+
+func TestArrayNew(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"Array new", ints()},
+		{"Array new: 0", ints()},
+		{"Array new: 3", &Array{Elements: []interface{}{nil, nil, nil}}},
+		{"Array new: 0 withAll: 7", ints()},
+		{"Array new: 3 withAll: 7", ints(7, 7, 7)},
+		{"Array new: 2 withAll: 'x'", &Array{Elements: []interface{}{"x", "x"}}},
+		{"Array new: 0 with: [:i | i]", ints()},
+		{"Array new: 4 with: [:i | i * i]", ints(1, 4, 9, 16)},
+		{"| a | a := Array new: 3. a at: 2 put: 5. a", &Array{Elements: []interface{}{nil, int64(5), nil}}},
+		{"(Array new: 3 with: [:i | i]) inject: 0 into: [:sum :i | sum + i]", int64(6)},
+		// withAll: shares the one value; each block answer is its own
+		{"| a | a := Array new: 2 withAll: #(1). (a at: 1) == (a at: 2)", true},
+		{"Array printString", "Array"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"Array new: -1", "new: size must be a non-negative integer, got -1"},
+		{"Array new: 1000000000000", "new: size 1000000000000 is larger than the maximum of 268435456"},
+		{"Array new: 1000000000000 withAll: 0", "new:withAll: size 1000000000000 is larger than the maximum"},
+		{"Array new: 'a' withAll: 0", "new:withAll: size must be a non-negative integer, got a String"},
+		{"Array new: 2 with: 3", "new:with: argument must be a block"},
+		{"Array new: 2 with: [:a :b | a]", "new:with: block must take 1 argument(s), got 2"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package vm - raw bytes
+package vm
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ByteArray holds raw bytes, such as the contents of a binary file.
+//
+// Strings in smog are text, so reading a binary file with fileRead:
+// would mangle bytes that aren't valid UTF-8. fileReadBytes: answers a
+// ByteArray instead, and fileWriteBytes:content: writes one back
+// unchanged; randomBytes: answers one too. Its elements are the integers
+// 0 to 255, and it understands the enumeration messages like any other
+// collection. asString and String asByteArray convert to and from text
+// as UTF-8, and asHex and ByteArray fromHex: to and from hexadecimal.
+//
+// Example:
+//   | bytes |
+//   bytes := ByteArray new: 3.
+//   bytes at: 1 put: 104; at: 2 put: 105; at: 3 put: 33.
+//   bytes asString      "'hi!'"
+//   bytes asBase64      "'aGkh'"
+//   bytes asHex         "'686921'"
+//   ByteArray fromHex: '686921'   "#[104 105 33]"
+//   'é' asByteArray     "#[195 169]"
+type ByteArray struct {
+	Bytes []byte // The bytes, in order
+}
+
+// String prints the bytes the way a ByteArray literal is written in
+// Smalltalk, as in #[1 2 255].
+func (b *ByteArray) String() string {
+	parts := make([]string, len(b.Bytes))
+	for i, value := range b.Bytes {
+		parts[i] = strconv.Itoa(int(value))
+	}
+	return "#[" + strings.Join(parts, " ") + "]"
+}
+
+// forEach visits each byte as an integer.
+func (b *ByteArray) forEach(visit func(elem interface{}) error) error {
+	for _, value := range b.Bytes {
+		if err := visit(int64(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendByteArray handles messages sent to a ByteArray.
+func (vm *VM) sendByteArray(b *ByteArray, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "size":
+		return int64(len(b.Bytes)), true, nil
+	case "isEmpty":
+		return len(b.Bytes) == 0, true, nil
+	case "at:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("at: expects 1 argument, got %d", len(args))
+		}
+		index, err := b.index(selector, args[0])
+		if err != nil {
+			return nil, true, err
+		}
+		return int64(b.Bytes[index]), true, nil
+	case "at:put:":
+		// Replace a byte in place, answering the value stored
+		if len(args) != 2 {
+			return nil, true, fmt.Errorf("at:put: expects 2 arguments, got %d", len(args))
+		}
+		index, err := b.index(selector, args[0])
+		if err != nil {
+			return nil, true, err
+		}
+		value, ok := args[1].(int64)
+		if !ok || value < 0 || value > 255 {
+			return nil, true, fmt.Errorf("at:put: value must be an integer from 0 to 255, got %s", vm.printString(args[1]))
+		}
+		b.Bytes[index] = byte(value)
+		return value, true, nil
+	case "asString":
+		// Decode the bytes as UTF-8 text
+		if !utf8.Valid(b.Bytes) {
+			return nil, true, fmt.Errorf("asString: bytes are not valid UTF-8")
+		}
+		return string(b.Bytes), true, nil
+	case "asBase64":
+		return base64.StdEncoding.EncodeToString(b.Bytes), true, nil
+	case "asHex":
+		// Two lowercase hex digits per byte, as sha256: writes a hash
+		return hex.EncodeToString(b.Bytes), true, nil
+	case "asArray":
+		elements := make([]interface{}, len(b.Bytes))
+		for i, value := range b.Bytes {
+			elements[i] = int64(value)
+		}
+		return &Array{Elements: elements}, true, nil
+	}
+	return nil, false, nil
+}
+
+// index checks a 1-based at: or at:put: index and answers the 0-based
+// position it refers to.
+func (b *ByteArray) index(selector string, arg interface{}) (int64, error) {
+	index, ok := arg.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s index must be an integer, got %s", selector, describeValue(arg))
+	}
+	if index < 1 || index > int64(len(b.Bytes)) {
+		return 0, fmt.Errorf("byte array index out of bounds: %d", index)
+	}
+	return index - 1, nil
+}
+
+// newByteArray answers ByteArray new: size, a ByteArray of size zero bytes.
+func newByteArray(args []interface{}) (*ByteArray, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("new: expects 1 argument, got %d", len(args))
+	}
+	size, ok := args[0].(int64)
+	if !ok || size < 0 {
+		return nil, fmt.Errorf("new: size must be a non-negative integer, got %s", describeValue(args[0]))
+	}
+	if size > maxElements {
+		return nil, fmt.Errorf("new: size %d is larger than the maximum of %d", size, maxElements)
+	}
+	return &ByteArray{Bytes: make([]byte, size)}, nil
+}
+
+// byteArrayFromHex answers ByteArray fromHex: text, the bytes written as
+// pairs of hex digits in either case, the reverse of asHex.
+func byteArrayFromHex(args []interface{}) (*ByteArray, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("fromHex: expects 1 argument, got %d", len(args))
+	}
+	text, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("fromHex: argument must be a string, got %s", describeValue(args[0]))
+	}
+	if len(text)%2 != 0 {
+		return nil, fmt.Errorf("fromHex: '%s' has an odd number of digits", text)
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("fromHex: '%s' is not hexadecimal", text)
+	}
+	return &ByteArray{Bytes: data}, nil
+}
+
+// bytesArg converts a message argument to raw bytes. It accepts a
+// ByteArray, or an Array of integers from 0 to 255, so a program can
+// write bytes it built itself.
+func bytesArg(selector string, arg interface{}) ([]byte, error) {
+	switch val := arg.(type) {
+	case *ByteArray:
+		return val.Bytes, nil
+	case *Array:
+		data := make([]byte, len(val.Elements))
+		for i, elem := range val.Elements {
+			n, ok := elem.(int64)
+			if !ok || n < 0 || n > 255 {
+				return nil, fmt.Errorf("%s element %d must be an integer from 0 to 255, got %s", selector, i+1, describeValue(elem))
+			}
+			data[i] = byte(n)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s content must be a ByteArray or an Array of bytes, got %s", selector, describeValue(arg))
+}
+
+// textOrBytes answers the data an encoding or hashing message works on:
+// a String's UTF-8 bytes, or a ByteArray's bytes unchanged.
+func textOrBytes(arg interface{}) (string, bool) {
+	switch val := arg.(type) {
+	case string:
+		return val, true
+	case *ByteArray:
+		return string(val.Bytes), true
+	}
+	return "", false
+}
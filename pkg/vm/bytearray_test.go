@@ -0,0 +1,187 @@
+package vm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBinaryFileRoundTrip tests that bytes that aren't valid UTF-8 survive
+// being written and read back
+func TestBinaryFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	prefix := "Object subclass: #File [ ]\n| f | f := File new.\n"
+
+	runSource(t, prefix+"f fileWriteBytes: '"+path+"' content: #(0 255 128 192 10 67)")
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	expected := []byte{0, 255, 128, 192, 10, 67}
+	if !bytes.Equal(written, expected) {
+		t.Fatalf("Expected the file to hold %v, got %v", expected, written)
+	}
+
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"f fileReadBytes: '" + path + "'", &ByteArray{Bytes: expected}},
+		{"(f fileReadBytes: '" + path + "') size", int64(6)},
+		{"(f fileReadBytes: '" + path + "') at: 2", int64(255)},
+		{"(f fileReadBytes: '" + path + "') printString", "#[0 255 128 192 10 67]"},
+		{"(f fileReadBytes: '" + path + "') asArray", &Array{Elements: []interface{}{
+			int64(0), int64(255), int64(128), int64(192), int64(10), int64(67)}}},
+		{"(f fileReadBytes: '" + path + "') inject: 0 into: [:sum :b | sum + b]", int64(652)},
+		// Writing a ByteArray back gives the same bytes
+		{"f fileWriteBytes: '" + path + "' content: (f fileReadBytes: '" + path + "'). (f fileReadBytes: '" + path + "') = (f fileReadBytes: '" + path + "')", true},
+		// base64 keeps the bytes intact in both directions
+		{"f base64Encode: (f fileReadBytes: '" + path + "')", "AP+AwApD"},
+		{"(f base64DecodeBytes: 'AP+AwApD') = (f fileReadBytes: '" + path + "')", true},
+		// Hashes of bytes and of the same text agree
+		{"(f sha256: (f base64DecodeBytes: 'c21vZw==')) = (f sha256: 'smog')", true},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, prefix+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	// A file written with fileWriteBytes: is unchanged by the round trip
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, expected) {
+		t.Errorf("Expected the file to still hold %v, got %v", expected, after)
+	}
+}
+
+func TestByteArrayErrors(t *testing.T) {
+	v := New()
+	b := &ByteArray{Bytes: []byte{1, 2}}
+	errorTests := []struct {
+		selector string
+		args     []interface{}
+		message  string
+	}{
+		{"at:", []interface{}{int64(3)}, "byte array index out of bounds: 3"},
+		{"at:", []interface{}{int64(0)}, "byte array index out of bounds: 0"},
+		{"at:", []interface{}{"x"}, "at: index must be an integer"},
+	}
+	for _, tt := range errorTests {
+		_, err := v.send(b, tt.selector, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.selector, tt.message, err)
+		}
+	}
+
+	badContent := []struct {
+		content interface{}
+		message string
+	}{
+		{&Array{Elements: []interface{}{int64(256)}}, "element 1 must be an integer from 0 to 255"},
+		{&Array{Elements: []interface{}{int64(1), int64(-1)}}, "element 2 must be an integer from 0 to 255"},
+		{"text", "content must be a ByteArray or an Array of bytes"},
+	}
+	path := filepath.Join(t.TempDir(), "never.bin")
+	for _, tt := range badContent {
+		_, err := v.send(nil, "fileWriteBytes:content:", []interface{}{path, tt.content})
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%v: expected error containing %q, got %v", tt.content, tt.message, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written for invalid content")
+	}
+}
+
+func TestByteArrayAccess(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"ByteArray new: 3", &ByteArray{Bytes: []byte{0, 0, 0}}},
+		{"ByteArray new size", int64(0)},
+		{"| b | b := ByteArray new: 2. b at: 1 put: 200. b at: 1", int64(200)},
+		{"| b | b := ByteArray new: 2. b at: 2 put: 255", int64(255)},
+		{"| b | b := ByteArray new: 3. b at: 1 put: 104; at: 2 put: 105; at: 3 put: 33. b asString", "hi!"},
+		{"| b | b := ByteArray new: 3. b at: 1 put: 104; at: 2 put: 105; at: 3 put: 33. b asBase64", "aGkh"},
+		// UTF-8 in both directions
+		{"'é' asByteArray", &ByteArray{Bytes: []byte{195, 169}}},
+		{"'日本' asByteArray size", int64(6)},
+		{"'héllo' asByteArray asString", "héllo"},
+		{"'' asByteArray asString", ""},
+		// Bytes compare by value
+		{"'ab' asByteArray = 'ab' asByteArray", true},
+		{"'ab' asByteArray = 'ba' asByteArray", false},
+		{"'ab' asByteArray = #(97 98)", false},
+		{"(Random new randomBytes: 8) size", int64(8)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, "Object subclass: #Random [ ]\n"+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"(ByteArray new: 2) at: 1 put: 256", "at:put: value must be an integer from 0 to 255, got 256"},
+		{"(ByteArray new: 2) at: 1 put: -1", "at:put: value must be an integer from 0 to 255, got -1"},
+		{"(ByteArray new: 2) at: 1 put: 'a'", "at:put: value must be an integer from 0 to 255, got 'a'"},
+		{"(ByteArray new: 2) at: 3 put: 1", "byte array index out of bounds: 3"},
+		{"(ByteArray new: 2) at: 0", "byte array index out of bounds: 0"},
+		{"ByteArray new: -1", "new: size must be a non-negative integer"},
+		{"ByteArray new: 1000000000000", "new: size 1000000000000 is larger than the maximum of 268435456"},
+		{"nil randomBytes: 1000000000000", "randomBytes: length 1000000000000 is larger than the maximum"},
+		{"| b | b := ByteArray new: 1. b at: 1 put: 255. b asString", "asString: bytes are not valid UTF-8"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+func TestByteArrayHex(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| b | b := ByteArray new: 3. b at: 1 put: 0; at: 2 put: 171; at: 3 put: 255. b asHex", "00abff"},
+		{"ByteArray new asHex", ""},
+		{"ByteArray fromHex: '00abff'", &ByteArray{Bytes: []byte{0, 171, 255}}},
+		{"ByteArray fromHex: '00ABFF'", &ByteArray{Bytes: []byte{0, 171, 255}}},
+		{"ByteArray fromHex: ''", &ByteArray{Bytes: []byte{}}},
+		// Round trip
+		{"(ByteArray fromHex: 'deadbeef') asHex", "deadbeef"},
+		{"(ByteArray fromHex: 'smog' asByteArray asHex) asString", "smog"},
+		// sha256Bytes: is the digest sha256: writes in hex
+		{"(nil sha256Bytes: 'abc') size", int64(32)},
+		{"(nil sha256Bytes: 'abc') asHex = (nil sha256: 'abc')", true},
+		{"(nil sha256Bytes: 'abc' asByteArray) asHex = (nil sha256: 'abc')", true},
+		{"nil sha256: 'abc'", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"ByteArray fromHex: 'abc'", "fromHex: 'abc' has an odd number of digits"},
+		{"ByteArray fromHex: 'zz'", "fromHex: 'zz' is not hexadecimal"},
+		{"ByteArray fromHex: 12", "fromHex: argument must be a string"},
+		{"nil sha256Bytes: 3", "sha256Bytes: argument must be a string or a ByteArray"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
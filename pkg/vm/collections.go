@@ -0,0 +1,668 @@
+// Package vm - Bag, Set and Dictionary collections
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// valueTable is an insertion-ordered collection of distinct values,
+// compared with the same value equality as the = message, or with the
+// identity of the == message for identity collections.
+//
+// Most values (numbers, strings, Points, objects) are Go-comparable and
+// are found through a hash index. Numbers are indexed under numberKey, so
+// 1, 1.0 and a fraction of the same value, which are =, share a slot.
+// Arrays and dictionaries compare by contents, so they can't be used as
+// Go map keys and are found with a linear scan instead. An identity table
+// indexes every value, since == compares them all by address or by Go
+// equality.
+type valueTable struct {
+	keys     []interface{}       // Distinct values in insertion order
+	index    map[interface{}]int // Position in keys for hashable values
+	identity bool                // Compare with == instead of =
+}
+
+// newValueTable creates an empty table comparing values with =.
+func newValueTable() valueTable {
+	return valueTable{index: make(map[interface{}]int)}
+}
+
+// newIdentityValueTable creates an empty table comparing values with ==.
+func newIdentityValueTable() valueTable {
+	return valueTable{index: make(map[interface{}]int), identity: true}
+}
+
+// empty returns an empty table that compares values the way t does.
+func (t *valueTable) empty() valueTable {
+	return valueTable{index: make(map[interface{}]int), identity: t.identity}
+}
+
+// indexKey returns the key v is indexed under, or false if v must be
+// found by scanning.
+func (t *valueTable) indexKey(v interface{}) (interface{}, bool) {
+	if t.identity {
+		return identityKey(v), true
+	}
+	if !isHashable(v) {
+		return nil, false
+	}
+	return numberKey(v), true
+}
+
+// fractionKey indexes a number that isn't a whole number, written as the
+// exact fraction it equals.
+type fractionKey string
+
+// numberKey answers the key a number is indexed under: an int64 for
+// whole numbers, whatever their type, and a fractionKey for the rest.
+// Every finite Float is an exact fraction, so 0.5 and 1/2 share a key.
+// Other values, and the non-finite Floats, are their own key.
+//
+// Example:
+//   numberKey(int64(2))        -> int64(2)
+//   numberKey(2.0)             -> int64(2)
+//   numberKey(0.5)             -> fractionKey("1/2")
+//   numberKey(big.NewRat(1,2)) -> fractionKey("1/2")
+func numberKey(v interface{}) interface{} {
+	var r *big.Rat
+	switch n := v.(type) {
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n)
+		}
+		r = new(big.Rat).SetFloat64(n)
+	case *big.Rat:
+		r = n
+	}
+	if r == nil {
+		return v
+	}
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return fractionKey(r.RatString())
+}
+
+// find returns the position of v in the table, or -1 if absent.
+// A value that can't be compared is never in the table.
+func (t *valueTable) find(v interface{}) int {
+	if !isComparable(v) {
+		return -1
+	}
+	if key, ok := t.indexKey(v); ok {
+		if i, ok := t.index[key]; ok {
+			return i
+		}
+		return -1
+	}
+	for i, key := range t.keys {
+		if valuesEqual(key, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert adds v if it is not already present and returns its position.
+// It fails, leaving the table unchanged, if v can't be compared.
+func (t *valueTable) insert(v interface{}) (position int, added bool, err error) {
+	if !isComparable(v) {
+		return -1, false, fmt.Errorf("cannot use %s as a key: its values can't be compared", describeValue(v))
+	}
+	if i := t.find(v); i >= 0 {
+		return i, false, nil
+	}
+	t.keys = append(t.keys, v)
+	if key, ok := t.indexKey(v); ok {
+		t.index[key] = len(t.keys) - 1
+	}
+	return len(t.keys) - 1, true, nil
+}
+
+// remove deletes the value at position i, keeping the remaining order.
+func (t *valueTable) remove(i int) {
+	t.keys = append(t.keys[:i], t.keys[i+1:]...)
+	t.index = make(map[interface{}]int)
+	for j, v := range t.keys {
+		if key, ok := t.indexKey(v); ok {
+			t.index[key] = j
+		}
+	}
+}
+
+// isComparable reports whether v can be compared with = and ==, and so
+// stored in a table. Every smog value can; the exceptions are Go values
+// such as slices and functions that an embedder might hand to the VM,
+// which would make a Go map lookup panic. Go maps are compared by
+// address, so they are allowed.
+func isComparable(v interface{}) bool {
+	if v == nil || isMap(v) {
+		return true
+	}
+	return reflect.ValueOf(v).Comparable()
+}
+
+// isHashable reports whether v can be indexed by a Go map key with the
+// same meaning as =. Collections compare by contents, but are compared by
+// address as Go map keys, so they can't.
+func isHashable(v interface{}) bool {
+	switch v.(type) {
+	case *Array, *Dictionary, map[interface{}]interface{}, map[string]interface{}:
+		return false
+	}
+	return true
+}
+
+// Bag is an unordered collection that counts how many times each
+// distinct element was added (a multiset).
+//
+// Example:
+//   | b |
+//   b := Bag new.
+//   b add: 'the'; add: 'cat'; add: 'the'.
+//   b occurrencesOf: 'the'   "2"
+//   b size                   "3"
+//   b asSet size             "2"
+type Bag struct {
+	table  valueTable // Distinct elements
+	counts []int64    // Occurrences of each element, parallel to table.keys
+}
+
+// newBag creates an empty Bag.
+func newBag() *Bag {
+	return &Bag{table: newValueTable()}
+}
+
+// Add records count more occurrences of v. It fails if v can't be
+// compared.
+func (b *Bag) Add(v interface{}, count int64) error {
+	i, added, err := b.table.insert(v)
+	if err != nil {
+		return err
+	}
+	if added {
+		b.counts = append(b.counts, 0)
+	}
+	b.counts[i] += count
+	return nil
+}
+
+// OccurrencesOf returns how many times v has been added.
+func (b *Bag) OccurrencesOf(v interface{}) int64 {
+	if i := b.table.find(v); i >= 0 {
+		return b.counts[i]
+	}
+	return 0
+}
+
+// Size returns the total number of occurrences of all elements.
+func (b *Bag) Size() int64 {
+	var total int64
+	for _, n := range b.counts {
+		total += n
+	}
+	return total
+}
+
+// String returns a printable description listing each element and its count.
+func (b *Bag) String() string {
+	var path identitySet
+	return b.format(&path, printLimits{})
+}
+
+// format renders the Bag, showing collections already on path, or nested
+// deeper than limits allow, as "...".
+func (b *Bag) format(path *identitySet, limits printLimits) string {
+	if limits.tooDeep(path) || !path.add(b) {
+		return "a Bag(...)"
+	}
+	defer path.remove(b)
+	parts := make([]string, 0, len(b.table.keys))
+	for i, key := range b.table.keys[:limits.shown(len(b.table.keys))] {
+		parts = append(parts, fmt.Sprintf("%s:%d", formatElement(key, path, limits), b.counts[i]))
+	}
+	return "a Bag(" + limits.join(parts, len(b.table.keys)) + ")"
+}
+
+// sendBag handles messages sent to a Bag.
+func (vm *VM) sendBag(b *Bag, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "add:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("add: expects 1 argument, got %d", len(args))
+		}
+		if err := b.Add(args[0], 1); err != nil {
+			return nil, true, err
+		}
+		return args[0], true, nil
+	case "add:withOccurrences:":
+		if len(args) != 2 {
+			return nil, true, fmt.Errorf("add:withOccurrences: expects 2 arguments, got %d", len(args))
+		}
+		count, ok := args[1].(int64)
+		if !ok || count < 1 {
+			return nil, true, fmt.Errorf("add:withOccurrences: count must be a positive integer")
+		}
+		if err := b.Add(args[0], count); err != nil {
+			return nil, true, err
+		}
+		return args[0], true, nil
+	case "occurrencesOf:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("occurrencesOf: expects 1 argument, got %d", len(args))
+		}
+		return b.OccurrencesOf(args[0]), true, nil
+	case "includes:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("includes: expects 1 argument, got %d", len(args))
+		}
+		return b.OccurrencesOf(args[0]) > 0, true, nil
+	case "size":
+		return b.Size(), true, nil
+	case "isEmpty":
+		return len(b.table.keys) == 0, true, nil
+	case "asSet":
+		set := newSet()
+		for _, key := range b.table.keys {
+			set.table.insert(key)
+		}
+		return set, true, nil
+	}
+	return nil, false, nil
+}
+
+// Set is an unordered collection of distinct elements.
+//
+// Elements are compared with =, so adding an element that is already
+// present has no effect. Iteration follows insertion order.
+//
+// Example:
+//   | s |
+//   s := Set new.
+//   s add: 1; add: 2; add: 1.
+//   s size          "2"
+//   s includes: 2   "true"
+type Set struct {
+	table valueTable // The distinct elements
+}
+
+// newSet creates an empty Set.
+func newSet() *Set {
+	return &Set{table: newValueTable()}
+}
+
+// newIdentitySet creates an empty IdentitySet: a Set whose elements are
+// compared with == rather than =, so equal but distinct objects are
+// separate elements.
+//
+// Example:
+//   | s |
+//   s := IdentitySet new.
+//   s add: {1. 2}; add: {1. 2}.
+//   s size   "2, where a Set would have 1"
+func newIdentitySet() *Set {
+	return &Set{table: newIdentityValueTable()}
+}
+
+// className answers "IdentitySet" or "Set", depending on how elements
+// are compared.
+func (s *Set) className() string {
+	if s.table.identity {
+		return "IdentitySet"
+	}
+	return "Set"
+}
+
+// String returns a printable description listing the elements.
+func (s *Set) String() string {
+	var path identitySet
+	return s.format(&path, printLimits{})
+}
+
+// format renders the Set, showing collections already on path, or nested
+// deeper than limits allow, as "...".
+func (s *Set) format(path *identitySet, limits printLimits) string {
+	name := withArticle(s.className())
+	if limits.tooDeep(path) || !path.add(s) {
+		return name + "(...)"
+	}
+	defer path.remove(s)
+	parts := make([]string, 0, len(s.table.keys))
+	for _, key := range s.table.keys[:limits.shown(len(s.table.keys))] {
+		parts = append(parts, formatElement(key, path, limits))
+	}
+	return name + "(" + limits.join(parts, len(s.table.keys)) + ")"
+}
+
+// sendSet handles messages sent to a Set.
+func (vm *VM) sendSet(s *Set, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "add:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("add: expects 1 argument, got %d", len(args))
+		}
+		if _, _, err := s.table.insert(args[0]); err != nil {
+			return nil, true, err
+		}
+		return args[0], true, nil
+	case "remove:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("remove: expects 1 argument, got %d", len(args))
+		}
+		i := s.table.find(args[0])
+		if i < 0 {
+			return nil, true, fmt.Errorf("remove: element not found: %v", args[0])
+		}
+		s.table.remove(i)
+		return args[0], true, nil
+	case "includes:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("includes: expects 1 argument, got %d", len(args))
+		}
+		return s.table.find(args[0]) >= 0, true, nil
+	case "size":
+		return int64(len(s.table.keys)), true, nil
+	case "isEmpty":
+		return len(s.table.keys) == 0, true, nil
+	}
+	return nil, false, nil
+}
+
+// Dictionary maps keys to values, remembering the order in which keys
+// were first added.
+//
+// Keys are compared with =, like Set elements, so equal strings, numbers,
+// Points or arrays find the same entry. Iteration, printing and JSON
+// generation all follow insertion order, so they are reproducible from run
+// to run. Storing a value under an existing key replaces the value but
+// keeps the key's position.
+//
+// Dictionary literals (#{...}) and parsed JSON objects are Dictionaries.
+//
+// Example:
+//   | d |
+//   d := #{'b' -> 2. 'a' -> 1}.
+//   d keysAndValuesDo: [:k :v | k print. v println]   "b2 then a1"
+//   d at: 'c' put: 3                                   "3"
+type Dictionary struct {
+	table  valueTable    // Distinct keys in insertion order
+	values []interface{} // Values, parallel to table.keys
+}
+
+// newDictionary creates an empty Dictionary.
+func newDictionary() *Dictionary {
+	return &Dictionary{table: newValueTable()}
+}
+
+// newIdentityDictionary creates an empty IdentityDictionary: a Dictionary
+// whose keys are compared with == rather than =, so equal but distinct
+// objects are separate keys. It is useful for attaching data to objects
+// without depending on how they compare.
+//
+// Example:
+//   | d a b |
+//   d := IdentityDictionary new.
+//   a := {1}. b := {1}.
+//   d at: a put: 'first'; at: b put: 'second'.
+//   d at: a   "'first'"
+func newIdentityDictionary() *Dictionary {
+	return &Dictionary{table: newIdentityValueTable()}
+}
+
+// className answers "IdentityDictionary" or "Dictionary", depending on
+// how keys are compared.
+func (d *Dictionary) className() string {
+	if d.table.identity {
+		return "IdentityDictionary"
+	}
+	return "Dictionary"
+}
+
+// AtPut stores value under key. It fails, leaving the dictionary
+// unchanged, if key can't be compared.
+func (d *Dictionary) AtPut(key, value interface{}) error {
+	i, added, err := d.table.insert(key)
+	if err != nil {
+		return err
+	}
+	if added {
+		d.values = append(d.values, nil)
+	}
+	d.values[i] = value
+	return nil
+}
+
+// At returns the value stored under key, if any.
+func (d *Dictionary) At(key interface{}) (interface{}, bool) {
+	if i := d.table.find(key); i >= 0 {
+		return d.values[i], true
+	}
+	return nil, false
+}
+
+// RemoveKey deletes key and returns the value that was stored under it,
+// if any. The remaining entries keep their order.
+func (d *Dictionary) RemoveKey(key interface{}) (interface{}, bool) {
+	i := d.table.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	value := d.values[i]
+	d.table.remove(i)
+	d.values = append(d.values[:i], d.values[i+1:]...)
+	return value, true
+}
+
+// Keys returns the keys in insertion order. The slice is shared with the
+// dictionary and must not be modified.
+func (d *Dictionary) Keys() []interface{} {
+	return d.table.keys
+}
+
+// Len returns the number of entries.
+func (d *Dictionary) Len() int {
+	return len(d.table.keys)
+}
+
+// String returns a printable description listing the entries in order.
+func (d *Dictionary) String() string {
+	var path identitySet
+	return d.format(&path, printLimits{})
+}
+
+// format renders the Dictionary, showing collections already on path, or
+// nested deeper than limits allow, as "...".
+func (d *Dictionary) format(path *identitySet, limits printLimits) string {
+	name := withArticle(d.className())
+	if limits.tooDeep(path) || !path.add(d) {
+		return name + "(...)"
+	}
+	defer path.remove(d)
+	parts := make([]string, 0, len(d.table.keys))
+	for i, key := range d.table.keys[:limits.shown(len(d.table.keys))] {
+		parts = append(parts, formatElement(key, path, limits)+"->"+formatElement(d.values[i], path, limits))
+	}
+	return name + "(" + limits.join(parts, len(d.table.keys)) + ")"
+}
+
+// sendDictionary handles messages sent to a Dictionary.
+func (vm *VM) sendDictionary(d *Dictionary, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "at:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("at: expects 1 argument, got %d", len(args))
+		}
+		value, ok := d.At(args[0])
+		if !ok {
+			return nil, true, fmt.Errorf("at: key not found: %v", args[0])
+		}
+		return value, true, nil
+	case "at:ifAbsent:":
+		// The block runs only when the key is missing, and its value is
+		// the answer
+		if len(args) != 2 {
+			return nil, true, fmt.Errorf("at:ifAbsent: expects 2 arguments, got %d", len(args))
+		}
+		if value, ok := d.At(args[0]); ok {
+			return value, true, nil
+		}
+		block, err := blockArg(selector, args[1:], 0)
+		if err != nil {
+			return nil, true, err
+		}
+		result, err := vm.executeBlock(block, nil)
+		return result, true, err
+	case "at:put:":
+		// Like Array at:put:, answers the stored value, not the dictionary
+		if len(args) != 2 {
+			return nil, true, fmt.Errorf("at:put: expects 2 arguments, got %d", len(args))
+		}
+		if err := d.AtPut(args[0], args[1]); err != nil {
+			return nil, true, err
+		}
+		return args[1], true, nil
+	case "removeKey:":
+		// Answers the value that was stored under the key
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("removeKey: expects 1 argument, got %d", len(args))
+		}
+		value, ok := d.RemoveKey(args[0])
+		if !ok {
+			return nil, true, fmt.Errorf("removeKey: key not found: %v", args[0])
+		}
+		return value, true, nil
+	case "includesKey:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("includesKey: expects 1 argument, got %d", len(args))
+		}
+		return d.table.find(args[0]) >= 0, true, nil
+	case "keys":
+		// A new Array, so changing it leaves the dictionary alone
+		return &Array{Elements: append([]interface{}{}, d.table.keys...)}, true, nil
+	case "values":
+		return &Array{Elements: append([]interface{}{}, d.values...)}, true, nil
+	case "size":
+		return int64(d.Len()), true, nil
+	case "isEmpty":
+		return d.Len() == 0, true, nil
+	case "keysAndValuesDo:", "keysDo:", "valuesDo:":
+		paramCount := 1
+		if selector == "keysAndValuesDo:" {
+			paramCount = 2
+		}
+		block, err := blockArg(selector, args, paramCount)
+		if err != nil {
+			return nil, true, err
+		}
+		// Iterate over a snapshot, so the block may add entries safely
+		keys := append([]interface{}{}, d.table.keys...)
+		values := append([]interface{}{}, d.values...)
+		for i, key := range keys {
+			var blockArgs []interface{}
+			switch selector {
+			case "keysAndValuesDo:":
+				blockArgs = []interface{}{key, values[i]}
+			case "keysDo:":
+				blockArgs = []interface{}{key}
+			default: // valuesDo: visits the values, like do:
+				blockArgs = []interface{}{values[i]}
+			}
+			if _, err := vm.executeBlock(block, blockArgs); err != nil {
+				return nil, true, err
+			}
+		}
+		return d, true, nil
+	}
+	return nil, false, nil
+}
+
+// collectionElements returns the elements of a collection in iteration
+// order: an Array's elements, a Bag's elements once per occurrence, a Set's
+// elements, or a Dictionary's values. It reports false for non-collections.
+// The result may share storage with the collection and must not be modified.
+func collectionElements(v interface{}) ([]interface{}, bool) {
+	if array, ok := v.(*Array); ok {
+		return array.Elements, true
+	}
+	c, ok := v.(Enumerable)
+	if !ok {
+		return nil, false
+	}
+	elements := []interface{}{}
+	c.forEach(func(elem interface{}) error {
+		elements = append(elements, elem)
+		return nil
+	})
+	return elements, true
+}
+
+// selectCollect answers select:thenCollect: and collect:thenSelect:,
+// fused pipelines that filter and map elements in a single pass. Each
+// element goes through both blocks before the next is looked at, so no
+// intermediate collection is built. The result is always an Array.
+//
+// Example:
+//   #(1 2 3 4) select: [:x | x > 2] thenCollect: [:x | x * 10]   "#(30 40)"
+//   #(1 2 3 4) collect: [:x | x * 10] thenSelect: [:x | x > 20]  "#(30 40)"
+func (vm *VM) selectCollect(c Enumerable, selector string, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s expects 2 arguments (blocks), got %d", selector, len(args))
+	}
+	first, err := blockArg(selector, args[:1], 1)
+	if err != nil {
+		return nil, err
+	}
+	second, err := blockArg(selector, args[1:], 1)
+	if err != nil {
+		return nil, err
+	}
+	selectFirst := selector == "select:thenCollect:"
+	selectBlock, collectBlock := first, second
+	if !selectFirst {
+		selectBlock, collectBlock = second, first
+	}
+
+	results := &Array{Elements: []interface{}{}}
+	err = c.forEach(func(elem interface{}) error {
+		value := elem
+		var err error
+		if !selectFirst {
+			if value, err = vm.executeBlock(collectBlock, []interface{}{elem}); err != nil {
+				return err
+			}
+		}
+		keep, err := vm.testElement(selector, selectBlock, value)
+		if err != nil || !keep {
+			return err
+		}
+		if selectFirst {
+			if value, err = vm.executeBlock(collectBlock, []interface{}{elem}); err != nil {
+				return err
+			}
+		}
+		results.Elements = append(results.Elements, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// blockArg checks that args holds a single block taking paramCount arguments.
+func blockArg(selector string, args []interface{}, paramCount int) (*Block, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument (block), got %d", selector, len(args))
+	}
+	block, ok := args[0].(*Block)
+	if !ok {
+		return nil, fmt.Errorf("%s argument must be a block", selector)
+	}
+	if block.ParamCount != paramCount {
+		return nil, fmt.Errorf("%s block must take %d argument(s), got %d", selector, paramCount, block.ParamCount)
+	}
+	return block, nil
+}
@@ -0,0 +1,451 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// TestBagCountsOccurrences tests add: and occurrencesOf:
+func TestBagCountsOccurrences(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`| b | b := Bag new. b add: 'the'; add: 'cat'; add: 'the'. b occurrencesOf: 'the'`, int64(2)},
+		{`| b | b := Bag new. b add: 'the'; add: 'cat'; add: 'the'. b occurrencesOf: 'cat'`, int64(1)},
+		{`| b | b := Bag new. b add: 'the'. b occurrencesOf: 'dog'`, int64(0)},
+		{`| b | b := Bag new. b add: 'the'; add: 'cat'; add: 'the'. b size`, int64(3)},
+		{`Bag new size`, int64(0)},
+		{`Bag new isEmpty`, true},
+		{`| b | b := Bag new. b add: 1; add: 1.0. b occurrencesOf: 1`, int64(2)},
+		{`| b | b := Bag new. b add: 3 @ 4; add: 3 @ 4. b occurrencesOf: 3 @ 4`, int64(2)},
+		{`| b | b := Bag new. b add: #(1 2); add: #(1 2). b occurrencesOf: #(1 2)`, int64(2)},
+		{`| b | b := Bag new. b add: 'x'. b includes: 'x'`, true},
+		{`(Bag new add: 'x') = 'x'`, true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestBagAddWithOccurrences tests explicit multiplicity
+func TestBagAddWithOccurrences(t *testing.T) {
+	result := runSource(t, `
+		| b |
+		b := Bag new.
+		b add: 'dog' withOccurrences: 3.
+		b add: 'dog'.
+		b add: 'cat' withOccurrences: 2.
+		((b occurrencesOf: 'dog') * 100) + ((b occurrencesOf: 'cat') * 10) + b size
+	`)
+	if result != int64(400+20+6) {
+		t.Errorf("Expected 426, got %v", result)
+	}
+
+	for _, source := range []string{
+		`Bag new add: 'x' withOccurrences: 0`,
+		`Bag new add: 'x' withOccurrences: 'lots'`,
+	} {
+		if err := runSourceError(t, source); err == nil {
+			t.Errorf("%s: expected error, got nil", source)
+		}
+	}
+}
+
+// TestBagDo tests that do: visits each element once per occurrence
+func TestBagDo(t *testing.T) {
+	out := runSourceOutput(t, `| b | b := Bag new. b add: 'a' withOccurrences: 2; add: 'b'. b do: [:each | each print]`)
+	if out != "aab" {
+		t.Errorf("Expected do: to print aab, got %q", out)
+	}
+}
+
+// TestBagAsSet tests that converting to a set drops the counts
+func TestBagAsSet(t *testing.T) {
+	result := runSource(t, `
+		| b |
+		b := Bag new.
+		b add: 'the'; add: 'cat'; add: 'the'; add: 'dog' withOccurrences: 5.
+		b asSet
+	`)
+	set, ok := result.(*Set)
+	if !ok {
+		t.Fatalf("Expected *Set, got %T", result)
+	}
+	if len(set.table.keys) != 3 {
+		t.Errorf("Expected 3 distinct elements, got %d: %v", len(set.table.keys), set)
+	}
+
+	if result := runSource(t, `| b | b := Bag new. b add: 1; add: 1; add: 2. b asSet size`); result != int64(2) {
+		t.Errorf("Expected set of size 2, got %v", result)
+	}
+}
+
+// TestSetBasics tests the Set operations used by asSet results
+func TestSetBasics(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`| s | s := Set new. s add: 1; add: 2; add: 1. s size`, int64(2)},
+		{`| s | s := Set new. s add: 'a'. s includes: 'a'`, true},
+		{`| s | s := Set new. s add: 'a'. s includes: 'b'`, false},
+		{`| s | s := Set new. s add: 'a'; add: 'b'. s remove: 'a'. s includes: 'a'`, false},
+		{`| s | s := Set new. s add: 'a'; add: 'b'. s remove: 'a'. s includes: 'b'`, true},
+		{`Set new isEmpty`, true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, `Set new remove: 1`); err == nil {
+		t.Error("Expected error removing a missing element")
+	}
+}
+
+// TestIdentityCollections tests that IdentitySet and IdentityDictionary
+// tell equal but distinct objects apart, where Set and Dictionary don't
+func TestIdentityCollections(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Two equal arrays are one element of a Set but two of an IdentitySet
+		{`| s a b | a := {1. 2}. b := {1. 2}. s := Set new. s add: a; add: b. s size`, int64(1)},
+		{`| s a b | a := {1. 2}. b := {1. 2}. s := IdentitySet new. s add: a; add: b. s size`, int64(2)},
+		{`| s a | a := {1. 2}. s := IdentitySet new. s add: a; add: a. s size`, int64(1)},
+		{`| s a | a := {1. 2}. s := IdentitySet new. s add: a. s includes: {1. 2}`, false},
+		{`| s a | a := {1. 2}. s := IdentitySet new. s add: a. s includes: a`, true},
+		{`| s a b | a := {1}. b := {1}. s := IdentitySet new. s add: a; add: b. s remove: a. {s includes: a. s includes: b}`, &Array{Elements: []interface{}{false, true}}},
+		// Values that are == stay one element
+		{`| s | s := IdentitySet new. s add: 3; add: 3; add: 'x'; add: 'x'. s size`, int64(2)},
+		// Two equal arrays are one key of a Dictionary but two of an IdentityDictionary
+		{`| d a b | a := {1}. b := {1}. d := #{}. d at: a put: 'first'; at: b put: 'second'. d size`, int64(1)},
+		{`| d a b | a := {1}. b := {1}. d := IdentityDictionary new. d at: a put: 'first'; at: b put: 'second'. d size`, int64(2)},
+		{`| d a b | a := {1}. b := {1}. d := IdentityDictionary new. d at: a put: 'first'; at: b put: 'second'. d at: a`, "first"},
+		{`| d a b | a := {1}. b := {1}. d := IdentityDictionary new. d at: a put: 'first'; at: b put: 'second'. d at: b`, "second"},
+		{`| d | d := IdentityDictionary new. d at: #{} put: 1. d at: #{} put: 2. d size`, int64(2)},
+		// Copies keep comparing by identity
+		{`| s c | s := IdentitySet new. s add: {1}. c := s deepCopy. c add: {1}. c size`, int64(2)},
+		{`IdentitySet new printString`, "an IdentitySet()"},
+		{`| d | d := IdentityDictionary new. d at: 1 put: 2. d printString`, "an IdentityDictionary(1->2)"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, `IdentityDictionary new at: {1}`); err == nil {
+		t.Error("Expected error reading a missing key")
+	}
+}
+
+// TestCollectionsPrintElementsLikeArrays tests that Dictionaries, Sets and
+// Bags print the values they hold the same way an Array does
+func TestCollectionsPrintElementsLikeArrays(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`#{'a' -> 1.0} printString`, "a Dictionary('a'->1.0)"},
+		{`#{2.5 -> 'b c'} printString`, "a Dictionary(2.5->'b c')"},
+		{`#{'k' -> #(1.0 'v')} printString`, "a Dictionary('k'->#(1.0 'v'))"},
+		{`(Set new add: 'x'; add: 3.0; yourself) printString`, "a Set('x' 3.0)"},
+		{`(IdentitySet new add: 0.5; yourself) printString`, "an IdentitySet(0.5)"},
+		{`(Bag new add: 'x'; add: 'x'; add: 1.0; yourself) printString`, "a Bag('x':2 1.0:1)"},
+		{`| d | d := #{}. d at: nil put: 1.5. d printString`, "a Dictionary(nil->1.5)"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestKeysThatCannotBeCompared tests that arrays work as dictionary
+// keys, and that Go values that can't be compared at all, such as a slice
+// handed to the VM by an embedder, give an error instead of a panic
+func TestKeysThatCannotBeCompared(t *testing.T) {
+	if result := runSource(t, `#{#(1 2) -> 'x'} at: #(1 2)`); result != "x" {
+		t.Errorf("Expected an array key to find its value, got %v", result)
+	}
+
+	// runWithSlice runs source with the global items bound to a Go slice
+	runWithSlice := func(source string) (interface{}, error) {
+		program, err := parser.New(source).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		v := New()
+		v.globals["items"] = []interface{}{int64(1), int64(2)}
+		err = v.Run(bc)
+		return v.StackTop(), err
+	}
+
+	for _, source := range []string{
+		`#{items -> 'x'}`,
+		`#{} at: items put: 'x'`,
+		`Set new add: items`,
+		`Bag new add: items`,
+		`IdentityDictionary new at: items put: 'x'`,
+	} {
+		if _, err := runWithSlice(source); err == nil || !strings.Contains(err.Error(), "can't be compared") {
+			t.Errorf("%s: expected an error about a key that can't be compared, got %v", source, err)
+		}
+	}
+
+	// Looking such a value up finds nothing rather than failing
+	result, err := runWithSlice(`{Set new includes: items. Bag new includes: items}`)
+	if expected := (&Array{Elements: []interface{}{false, false}}); err != nil || !valuesEqual(result, expected) {
+		t.Errorf("Expected lookups to answer false, got %v (error %v)", result, err)
+	}
+}
+
+// TestFusedSelectCollect tests select:thenCollect: and collect:thenSelect:
+// and that they make a single pass over the elements
+func TestFusedSelectCollect(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`#(1 2 3 4) select: [:x | x > 2] thenCollect: [:x | x * 10]`, []interface{}{int64(30), int64(40)}},
+		{`#(1 2 3 4) collect: [:x | x * 10] thenSelect: [:x | x > 20]`, []interface{}{int64(30), int64(40)}},
+		{`#() select: [:x | true] thenCollect: [:x | x]`, []interface{}{}},
+		{`#(1 2) select: [:x | false] thenCollect: [:x | x]`, []interface{}{}},
+		// Other collections work too, and answer an Array
+		{`| s | s := Set new. s add: 1; add: 2; add: 3. s select: [:x | x ~= 2] thenCollect: [:x | x * x]`, []interface{}{int64(1), int64(9)}},
+		{`#{'a' -> 1. 'b' -> 2} collect: [:v | v + 1] thenSelect: [:v | v > 2]`, []interface{}{int64(3)}},
+		// The select block sees the original element, the collect block's result is kept
+		{`#('a' 'bb' 'ccc') select: [:s | s ~= 'a'] thenCollect: [:s | s , '!']`, []interface{}{"bb!", "ccc!"}},
+	}
+	for _, tt := range tests {
+		expected := &Array{Elements: tt.expected.([]interface{})}
+		if result := runSource(t, tt.source); !valuesEqual(result, expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, expected, result)
+		}
+	}
+
+	// The fused messages agree with the same pipeline written as two
+	// passes with an intermediate array
+	fused := runSource(t, `#(5 8 1 9 4) select: [:x | x > 4] thenCollect: [:x | x * 2]`)
+	twoPasses := runSource(t, `| middle result |
+middle := {}. result := {}.
+#(5 8 1 9 4) do: [:x | x > 4 ifTrue: [middle := middle , {x}]].
+middle do: [:x | result := result , {x * 2}].
+result`)
+	if !valuesEqual(fused, twoPasses) {
+		t.Errorf("select:thenCollect: answered %v, the two-pass pipeline %v", fused, twoPasses)
+	}
+
+	// Each element passes through both blocks before the next one is
+	// looked at, so no intermediate collection of all selected elements
+	// is built
+	order := runSource(t, `String streamContents: [:log |
+    #(1 2 3) select: [:x | log print: x. true] thenCollect: [:x | log nextPutAll: 'c'. x]]`)
+	if order != "1c2c3c" {
+		t.Errorf("Expected the blocks to alternate (1c2c3c), got %v", order)
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{`#(1 2) select: [:x | x] thenCollect: [:x | x]`, "must answer a Boolean"},
+		{`#(1 2) select: [:x | true] thenCollect: 3`, "argument must be a block"},
+		{`3 select: [:x | true] thenCollect: [:x | x]`, "unknown message"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestMutatorsAnswerStoredValue pins the convention that mutating
+// messages answer the value that was stored or removed, not the
+// collection, so cascades ending in yourself are needed to get the
+// collection back
+func TestMutatorsAnswerStoredValue(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Array
+		{`#(1 2 3) at: 2 put: 99`, int64(99)},
+		{`| a | a := #(1 2 3). a at: 2 put: 99. a at: 2`, int64(99)},
+		{`(#(1 2 3) at: 1 put: 7; at: 2 put: 8; yourself) isArray`, true},
+		// Dictionary
+		{`#{'a' -> 1} at: 'b' put: 2`, int64(2)},
+		{`#{'a' -> 1} at: 'a' put: 5`, int64(5)},
+		{`| d | d := #{}. d at: 'a' put: 1; at: 'b' put: 2. d size`, int64(2)},
+		{`(#{} at: 'a' put: 1; at: 'b' put: 2; yourself) at: 'b'`, int64(2)},
+		// Bag and Set
+		{`Bag new add: 'x'`, "x"},
+		{`Bag new add: 'x' withOccurrences: 3`, "x"},
+		{`Set new add: 'x'`, "x"},
+		{`| s | s := Set new. s add: 'x'. s remove: 'x'`, "x"},
+		{`(Set new add: 1; add: 2; yourself) size`, int64(2)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, `#{'a' -> 1} at: 'b'`); err == nil {
+		t.Error("Expected error reading a missing key")
+	}
+}
+
+// TestDictionaryKeepsInsertionOrder tests that dictionary literals iterate
+// in the order their keys were written
+func TestDictionaryKeepsInsertionOrder(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`#{'zebra' -> 1. 'apple' -> 2. 'mango' -> 3} keysAndValuesDo: [:k :v | k print. v print]`, "zebra1apple2mango3"},
+		{`#{3 -> 'c'. 1 -> 'a'. 2 -> 'b'} keysDo: [:k | k print]`, "312"},
+		{`#{3 -> 'c'. 1 -> 'a'. 2 -> 'b'} valuesDo: [:v | v print]`, "cab"},
+		{`#{3 -> 'c'. 1 -> 'a'. 2 -> 'b'} do: [:v | v print]`, "cab"},
+		// A repeated key keeps its first position and its last value
+		{`#{'a' -> 1. 'b' -> 2. 'a' -> 3} keysAndValuesDo: [:k :v | k print. v print]`, "a3b2"},
+	}
+
+	for _, tt := range tests {
+		// Run each several times: Go map order would differ between runs
+		for run := 0; run < 5; run++ {
+			if out := runSourceOutput(t, tt.source); out != tt.expected {
+				t.Fatalf("%s: expected %q, got %q", tt.source, tt.expected, out)
+			}
+		}
+	}
+
+	if result := runSource(t, `#{'a' -> 1. 'b' -> 2} size`); result != int64(2) {
+		t.Errorf("Expected size 2, got %v", result)
+	}
+	if result := runSource(t, `#{'a' -> 1. 'b' -> 2} = #{'b' -> 2. 'a' -> 1}`); result != true {
+		t.Errorf("Expected dictionaries with the same entries to be equal, got %v", result)
+	}
+}
+
+// TestDictionaryProtocol tests looking up, listing and removing keys,
+// including that removing a key keeps the order of the others
+func TestDictionaryProtocol(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`#{'b' -> 2. 'a' -> 1. 'c' -> 3} keys`, strs("b", "a", "c")},
+		{`#{'b' -> 2. 'a' -> 1. 'c' -> 3} values`, ints(2, 1, 3)},
+		{`#{} keys`, ints()},
+		{`| d | d := #{}. d at: 3 put: 'x'; at: 1 put: 'y'. d keys`, ints(3, 1)},
+		{`(nil jsonParse: '{"z":1,"a":2}') keys`, strs("z", "a")},
+		// keys and values are copies
+		{`| d | d := #{'a' -> 1}. d keys at: 1 put: 'z'. d keys`, strs("a")},
+		// at:ifAbsent: runs the block only for a missing key
+		{`#{'a' -> 1} at: 'a' ifAbsent: [0]`, int64(1)},
+		{`#{'a' -> 1} at: 'b' ifAbsent: [0]`, int64(0)},
+		{`#{'a' -> nil} at: 'a' ifAbsent: [0]`, nil},
+		{`| ran | ran := false. #{'a' -> 1} at: 'a' ifAbsent: [ran := true]. ran`, false},
+		{`#{'a' -> 1} includesKey: 'a'`, true},
+		{`#{'a' -> 1} includesKey: 1`, false},
+		// removeKey: answers the removed value and keeps the rest in order
+		{`#{'a' -> 1. 'b' -> 2} removeKey: 'a'`, int64(1)},
+		{`| d | d := #{'a' -> 1. 'b' -> 2. 'c' -> 3}. d removeKey: 'b'. d keys`, strs("a", "c")},
+		{`| d | d := #{'a' -> 1. 'b' -> 2. 'c' -> 3}. d removeKey: 'a'. d values`, ints(2, 3)},
+		{`| d | d := #{'a' -> 1. 'b' -> 2}. d removeKey: 'a'. d at: 'a' put: 3. d keys`, strs("b", "a")},
+		{`| d | d := #{'a' -> 1. 'b' -> 2}. d removeKey: 'b'. (d at: 'a') + d size`, int64(2)},
+		// Dictionary new is the same as #{}
+		{`(Dictionary new at: 'a' put: 1; yourself) at: 'a'`, int64(1)},
+		{`(Dictionary new at: 'a' put: 1; yourself) printString`, "a Dictionary('a'->1)"},
+		{`Dictionary new isEmpty`, true},
+		{`Dictionary new = #{}`, true},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{`#{'a' -> 1} removeKey: 'b'`, "removeKey: key not found: b"},
+		{`#{'a' -> 1} at: 'b' ifAbsent: 0`, "at:ifAbsent: argument must be a block"},
+		{`#{'a' -> 1} at: 'b' ifAbsent: [:k | k]`, "at:ifAbsent: block must take 0 argument(s)"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestJSONPreservesKeyOrder tests that parsing and generating JSON keeps
+// object keys in document order
+func TestJSONPreservesKeyOrder(t *testing.T) {
+	doc := `{"zebra":1,"apple":{"y":true,"x":null},"mango":[1.5,{"b":"B","a":"A"}],"kiwi":"k"}`
+	source := `| d |
+		d := nil jsonParse: '` + doc + `'.
+		d keysDo: [:k | k print. ' ' print].
+		(nil jsonGenerate: d) print`
+
+	expected := "zebra apple mango kiwi " + doc
+	for run := 0; run < 5; run++ {
+		if out := runSourceOutput(t, source); out != expected {
+			t.Fatalf("Expected %q, got %q", expected, out)
+		}
+	}
+
+	pretty := runSourceOutput(t, `(nil jsonGeneratePretty: (nil jsonParse: '{"b":1,"a":{"d":2,"c":3}}')) print`)
+	if pretty != "{\n  \"b\": 1,\n  \"a\": {\n    \"d\": 2,\n    \"c\": 3\n  }\n}" {
+		t.Errorf("Unexpected pretty JSON:\n%s", pretty)
+	}
+
+	if err := runSourceError(t, `nil jsonParse: '{"a":1} x'`); err == nil {
+		t.Error("Expected error for trailing data after the JSON value")
+	}
+}
+
+// runSourceOutput runs source and returns what it printed
+func runSourceOutput(t *testing.T, source string) string {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	var out strings.Builder
+	vm := New()
+	vm.SetOutput(&out)
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	return out.String()
+}
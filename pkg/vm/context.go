@@ -0,0 +1,65 @@
+// Package vm - reified method activations (thisContext)
+package vm
+
+import (
+	"fmt"
+)
+
+// Context is the value of thisContext: the method activation a ^ written
+// at the same place would return from. Inside a block that is the method
+// the block was written in, and at the top level it is the program itself.
+//
+// A context can be stored and returned from later, from anywhere deeper in
+// the call stack, which unwinds every method and block call in between
+// the same way a ^ inside a block does. That is enough to write exception
+// handling in smog itself: a handler method records thisContext, and
+// signaling an exception finds the handler and returns from its context.
+//
+// Example:
+//   Object subclass: #Finder [
+//       find: n in: items [
+//           | here |
+//           here := thisContext.
+//           items do: [:x | x = n ifTrue: [here return: 'found']].
+//           ^'missing'
+//       ]
+//   ]
+type Context struct {
+	vm *VM // The VM running the activation
+}
+
+// String returns a printable description of the context.
+func (c *Context) String() string {
+	return "a Context"
+}
+
+// thisContext answers the activation that a ^ in the running code would
+// return from.
+func (vm *VM) thisContext() *Context {
+	if vm.homeContext != nil {
+		return &Context{vm: vm.homeContext}
+	}
+	return &Context{vm: vm}
+}
+
+// sendContext handles messages sent to a Context.
+func (vm *VM) sendContext(c *Context, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "return:":
+		// Unwind to the activation and make it answer the argument
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("return: expects 1 argument, got %d", len(args))
+		}
+		if !c.vm.running {
+			return nil, true, fmt.Errorf("return: cannot return from a context that has already returned")
+		}
+		return nil, true, &NonLocalReturn{Value: args[0], HomeContext: c.vm}
+	case "isActive":
+		// Whether the activation is still running and can be returned from
+		return c.vm.running, true, nil
+	case "receiver":
+		// The activation's self (nil at the top level)
+		return c.vm.self, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThisContext(t *testing.T) {
+	classes := `
+Object subclass: #Finder [
+    | saved |
+    find: n in: items [
+        | here |
+        here := thisContext.
+        items do: [:x | x = n ifTrue: [self giveUp: here with: 'found']].
+        ^'missing'
+    ]
+    giveUp: aContext with: aValue [ aContext return: aValue. ^'not reached' ]
+    save [ saved := thisContext. ^saved isActive ]
+    saved [ ^saved ]
+    blockContext [ ^[thisContext] value ]
+    me [ ^thisContext receiver ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// return: unwinds through the methods and blocks in between
+		{"Finder new find: 2 in: #(1 2 3)", "found"},
+		{"Finder new find: 9 in: #(1 2 3)", "missing"},
+		// A context is active until its method returns
+		{"Finder new save", true},
+		{"| f | f := Finder new. f save. f saved isActive", false},
+		// Inside a block, thisContext is the method the block was written in
+		{"| f | f := Finder new. f blockContext receiver == f", true},
+		{"| f | f := Finder new. f me == f", true},
+		// At the top level it is the program, so return: ends it
+		{"thisContext return: 5. 6", int64(5)},
+		{"thisContext printString", "a Context"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, classes+"| f | f := Finder new. f save. f saved return: 1")
+	if err == nil || !strings.Contains(err.Error(), "already returned") {
+		t.Errorf("Expected returning to a finished context to fail, got %v", err)
+	}
+}
+
+// TestUserDefinedExceptions tests that exceptions can be written in smog:
+// handlers are kept in a registry of smog objects, and signaling one
+// unwinds to the handler's frame with thisContext return:
+func TestUserDefinedExceptions(t *testing.T) {
+	library := `
+Object subclass: #Exception [
+    | messageText |
+    messageText [ ^messageText ]
+    isA: aClass [ ^aClass == Exception ]
+    signal: aString [ messageText := aString. ^Handlers signal: self ]
+]
+
+Exception subclass: #NotFound [
+    isA: aClass [ aClass == NotFound ifTrue: [^true]. ^super isA: aClass ]
+]
+
+Exception subclass: #Timeout [
+    isA: aClass [ aClass == Timeout ifTrue: [^true]. ^super isA: aClass ]
+]
+
+Object subclass: #HandlerRegistry [
+    | handlers |
+    run: aBlock on: anExceptionClass do: aHandler [
+        | result |
+        handlers := {anExceptionClass. aHandler. thisContext. handlers}.
+        result := aBlock value.
+        handlers := handlers at: 4.
+        ^result
+    ]
+    signal: anException [
+        | entry |
+        entry := handlers.
+        [entry isNil] whileFalse: [
+            (anException isA: (entry at: 1)) ifTrue: [
+                handlers := entry at: 4.
+                (entry at: 3) return: ((entry at: 2) value: anException)].
+            entry := entry at: 4].
+        ^nil
+    ]
+]
+
+Object subclass: #Library [
+    lookUp: title [ ^NotFound new signal: title , ' is not here' ]
+    wait [ ^Timeout new signal: 'too slow' ]
+]
+
+Handlers := HandlerRegistry new.
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// The handler's value becomes the value of run:on:do:
+		{"Handlers run: [Library new lookUp: 'Dune'. 'unreachable'] on: NotFound do: [:e | e messageText]",
+			"Dune is not here"},
+		// A handler for a superclass catches subclasses
+		{"Handlers run: [Library new lookUp: 'Dune'] on: Exception do: [:e | 'caught']", "caught"},
+		// Without an exception the block's value is answered
+		{"Handlers run: [42] on: NotFound do: [:e | 0]", int64(42)},
+		// A handler that doesn't match lets the exception reach an outer one
+		{`Handlers
+    run: [Handlers run: [Library new wait] on: NotFound do: [:e | 'inner']]
+    on: Timeout do: [:e | 'outer: ' , e messageText]`, "outer: too slow"},
+		// The registry is unwound along with the stack
+		{`Handlers run: [Library new lookUp: 'x'] on: NotFound do: [:e | 1].
+Handlers run: [Library new wait] on: NotFound do: [:e | 'stale handler']`, nil},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, library+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
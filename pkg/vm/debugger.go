@@ -4,32 +4,80 @@ package vm
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
 )
 
 // Debugger provides interactive debugging capabilities for the VM.
+//
+// The debugger follows execution into blocks and methods: every VM created
+// while debugging shares the same Debugger, and vm always refers to the VM
+// executing the current instruction. Breakpoints belong to a specific piece
+// of code, so "breakpoint 3" set while paused inside a block pauses at
+// instruction 3 of that block rather than of the main program.
 type Debugger struct {
-	vm          *VM                        // The VM being debugged
-	breakpoints map[int]bool               // Instruction positions where execution should pause
-	stepMode    bool                       // If true, pause after each instruction
-	enabled     bool                       // If true, debugger is active
-	bytecode    *bytecode.Bytecode         // Current bytecode being executed
+	vm          *VM                                         // The VM executing the current instruction
+	root        *VM                                         // The VM the debugger was created for
+	main        *bytecode.Bytecode                          // The main program run by the root VM
+	breakpoints map[breakpointLocation]bool                 // Instruction positions where execution should pause
+	conditions  map[breakpointLocation]*breakpointCondition // Optional conditions for breakpoints
+	stepMode    bool                                        // If true, pause after each instruction
+	stepOver    bool                                        // If true, pause at the next instruction no deeper than stepDepth
+	stepDepth   int                                         // Call depth of the instruction being stepped over
+	enabled     bool                                        // If true, debugger is active
+	bytecode    *bytecode.Bytecode                          // Current bytecode being executed
+	sourceLines []string                                    // Source code lines for 'list' (nil if unavailable)
+	in          *bufio.Scanner                              // Command input
+	out         io.Writer                                   // Output for prompts and displays
+}
+
+// breakpointLocation identifies an instruction in a piece of code.
+// A nil code refers to the main program.
+type breakpointLocation struct {
+	code *bytecode.Bytecode
+	ip   int
+}
+
+// breakpointCondition is a smog boolean expression guarding a breakpoint.
+type breakpointCondition struct {
+	source string             // The expression as entered
+	code   *bytecode.Bytecode // Compiled expression (nil until first evaluated)
 }
 
 // NewDebugger creates a new debugger instance.
 func NewDebugger(vm *VM) *Debugger {
 	return &Debugger{
 		vm:          vm,
-		breakpoints: make(map[int]bool),
+		root:        vm,
+		breakpoints: make(map[breakpointLocation]bool),
+		conditions:  make(map[breakpointLocation]*breakpointCondition),
 		stepMode:    false,
 		enabled:     false,
+		in:          bufio.NewScanner(os.Stdin),
+		out:         os.Stdout,
 	}
 }
 
+// SetIO redirects the debugger's command input and output.
+// By default the debugger reads from os.Stdin and writes to os.Stdout.
+func (d *Debugger) SetIO(in io.Reader, out io.Writer) {
+	d.in = bufio.NewScanner(in)
+	d.out = out
+}
+
+// SetSource provides the program's source code so the 'list' command
+// can show the lines around the current instruction.
+func (d *Debugger) SetSource(source string) {
+	d.sourceLines = strings.Split(source, "\n")
+}
+
 // Enable activates the debugger.
 func (d *Debugger) Enable() {
 	d.enabled = true
@@ -46,23 +94,96 @@ func (d *Debugger) SetStepMode(enabled bool) {
 	d.stepMode = enabled
 }
 
-// AddBreakpoint adds a breakpoint at the specified instruction position.
+// StepOver resumes execution until the current instruction has finished,
+// pausing at the next instruction in the same method or block (or in its
+// caller, if the instruction returns). A send is stepped over as a whole:
+// the method or block it runs isn't paused in, except at a breakpoint.
+//
+// The debugger pauses at the first instruction run by a VM nested no
+// deeper than the current one, like a temporary breakpoint on the return
+// from any call the instruction makes.
+func (d *Debugger) StepOver() {
+	d.stepMode = false
+	d.stepOver = true
+	d.stepDepth = d.vm.depth
+}
+
+// setFrame records which VM and bytecode are executing the next instruction.
+// It is called by Run before each instruction while debugging.
+func (d *Debugger) setFrame(vm *VM, bc *bytecode.Bytecode) {
+	d.vm = vm
+	d.bytecode = bc
+	if vm == d.root {
+		d.main = bc
+	}
+}
+
+// location returns the breakpoint location of ip in the current code.
+func (d *Debugger) location(ip int) breakpointLocation {
+	if d.bytecode == nil || d.bytecode == d.main {
+		return breakpointLocation{ip: ip}
+	}
+	return breakpointLocation{code: d.bytecode, ip: ip}
+}
+
+// AddBreakpoint adds a breakpoint at the specified instruction position
+// of the current code (the main program if execution hasn't started).
 func (d *Debugger) AddBreakpoint(ip int) {
-	d.breakpoints[ip] = true
+	d.addBreakpoint(d.location(ip), "")
+}
+
+// AddConditionalBreakpoint adds a breakpoint that only pauses when the
+// smog expression condition evaluates to true in the paused frame.
+// The condition may refer to local variables, self, and globals.
+//
+// Example:
+//   d.AddConditionalBreakpoint(4, "i = 5")
+func (d *Debugger) AddConditionalBreakpoint(ip int, condition string) error {
+	return d.addBreakpoint(d.location(ip), condition)
+}
+
+// AddBreakpointIn adds a breakpoint at instruction ip of a specific piece of
+// code, such as a block or method body found in the program's constants.
+// An empty condition makes the breakpoint unconditional.
+func (d *Debugger) AddBreakpointIn(code *bytecode.Bytecode, ip int, condition string) error {
+	loc := breakpointLocation{code: code, ip: ip}
+	if code == d.main {
+		loc.code = nil
+	}
+	return d.addBreakpoint(loc, condition)
+}
+
+// addBreakpoint records a breakpoint, validating its condition if present.
+func (d *Debugger) addBreakpoint(loc breakpointLocation, condition string) error {
+	if condition == "" {
+		d.breakpoints[loc] = true
+		delete(d.conditions, loc)
+		return nil
+	}
+	if _, err := parser.New(condition).Parse(); err != nil {
+		return fmt.Errorf("invalid breakpoint condition: %v", err)
+	}
+	d.breakpoints[loc] = true
+	d.conditions[loc] = &breakpointCondition{source: condition}
+	return nil
 }
 
 // RemoveBreakpoint removes a breakpoint at the specified instruction position.
 func (d *Debugger) RemoveBreakpoint(ip int) {
-	delete(d.breakpoints, ip)
+	loc := d.location(ip)
+	delete(d.breakpoints, loc)
+	delete(d.conditions, loc)
 }
 
 // ClearBreakpoints removes all breakpoints.
 func (d *Debugger) ClearBreakpoints() {
-	d.breakpoints = make(map[int]bool)
+	d.breakpoints = make(map[breakpointLocation]bool)
+	d.conditions = make(map[breakpointLocation]*breakpointCondition)
 }
 
 // ShouldPause checks if execution should pause at the current instruction.
-// Returns true if we're in step mode or at a breakpoint.
+// Returns true if we're in step mode or at a breakpoint whose condition
+// (if any) holds.
 func (d *Debugger) ShouldPause() bool {
 	if !d.enabled {
 		return false
@@ -71,21 +192,79 @@ func (d *Debugger) ShouldPause() bool {
 	if d.stepMode {
 		return true
 	}
+
+	if d.stepOver && d.vm.depth <= d.stepDepth {
+		return true
+	}
 	
-	return d.breakpoints[d.vm.ip]
+	loc := d.location(d.vm.ip)
+	if !d.breakpoints[loc] {
+		return false
+	}
+	if cond, ok := d.conditions[loc]; ok {
+		return d.evaluateCondition(cond)
+	}
+	return true
+}
+
+// evaluateCondition runs a breakpoint condition against the current frame.
+//
+// The condition is compiled with the current code's local variable names
+// declared in the same order, so each name maps to the same slot, and then
+// run on a VM sharing the paused frame's locals, globals, and self.
+// Errors and non-boolean results pause execution so the problem is visible.
+func (d *Debugger) evaluateCondition(cond *breakpointCondition) bool {
+	if cond.code == nil {
+		source := cond.source
+		if d.bytecode != nil && len(d.bytecode.LocalNames) > 0 {
+			source = "| " + strings.Join(d.bytecode.LocalNames, " ") + " |\n" + source
+		}
+		program, err := parser.New(source).Parse()
+		if err != nil {
+			fmt.Fprintf(d.out, "Breakpoint condition error: %v\n", err)
+			return true
+		}
+		code, err := compiler.New().Compile(program)
+		if err != nil {
+			fmt.Fprintf(d.out, "Breakpoint condition error: %v\n", err)
+			return true
+		}
+		cond.code = code
+	}
+
+	// The condition runs in the paused frame, but isn't debugged itself
+	evalVM := d.vm.child(d.vm.self, d.vm.currentClass)
+	evalVM.locals = d.vm.locals
+	evalVM.scope = d.vm.scope
+	evalVM.base = d.vm.base
+	evalVM.debugging = &debugging{root: evalVM}
+	if err := evalVM.Run(cond.code); err != nil {
+		fmt.Fprintf(d.out, "Breakpoint condition error: %v\n", err)
+		return true
+	}
+
+	result, ok := evalVM.StackTop().(bool)
+	if !ok {
+		fmt.Fprintf(d.out, "Breakpoint condition '%s' did not return a boolean\n", cond.source)
+		return true
+	}
+	return result
 }
 
 // ShowCurrentInstruction displays the current instruction being executed.
 func (d *Debugger) ShowCurrentInstruction() {
 	if d.bytecode == nil || d.vm.ip >= len(d.bytecode.Instructions) {
-		fmt.Println("No current instruction")
+		fmt.Fprintln(d.out, "No current instruction")
 		return
 	}
 	
 	inst := d.bytecode.Instructions[d.vm.ip]
-	fmt.Printf("  %4d: %s", d.vm.ip, inst.Op)
+	fmt.Fprintf(d.out, "  %4d: %s", d.vm.ip, inst.Op)
 	d.formatInstructionOperand(inst, d.bytecode.Constants)
-	fmt.Println()
+	if inst.Line > 0 {
+		fmt.Fprintf(d.out, "  (line %d)", inst.Line)
+	}
+	fmt.Fprintln(d.out)
 }
 
 // formatInstructionOperand formats the operand of an instruction based on its opcode.
@@ -94,96 +273,174 @@ func (d *Debugger) formatInstructionOperand(inst bytecode.Instruction, constants
 	case bytecode.OpSend, bytecode.OpSuperSend:
 		selectorIdx := inst.Operand >> bytecode.SelectorIndexShift
 		argCount := inst.Operand & bytecode.ArgCountMask
-		fmt.Printf(" selector=%d args=%d", selectorIdx, argCount)
+		fmt.Fprintf(d.out, " selector=%d args=%d", selectorIdx, argCount)
 		if selectorIdx < len(constants) {
 			if sel, ok := constants[selectorIdx].(string); ok {
-				fmt.Printf(" (%s)", sel)
+				fmt.Fprintf(d.out, " (%s)", sel)
 			}
 		}
 	case bytecode.OpMakeClosure:
 		codeIdx := inst.Operand >> bytecode.SelectorIndexShift
 		paramCount := inst.Operand & bytecode.ArgCountMask
-		fmt.Printf(" code=%d params=%d", codeIdx, paramCount)
+		fmt.Fprintf(d.out, " code=%d params=%d", codeIdx, paramCount)
 	default:
 		if inst.Operand != 0 {
-			fmt.Printf(" %d", inst.Operand)
+			fmt.Fprintf(d.out, " %d", inst.Operand)
 		}
 	}
 }
 
 // ShowStack displays the current VM stack.
 func (d *Debugger) ShowStack() {
-	fmt.Println("Stack (top to bottom):")
+	fmt.Fprintln(d.out, "Stack (top to bottom):")
 	if d.vm.sp == 0 {
-		fmt.Println("  (empty)")
+		fmt.Fprintln(d.out, "  (empty)")
 		return
 	}
 	
 	for i := d.vm.sp - 1; i >= 0; i-- {
-		fmt.Printf("  [%d] %v (%T)\n", i, d.vm.stack[i], d.vm.stack[i])
+		fmt.Fprintf(d.out, "  [%d] %v (%T)\n", i, d.vm.stack[i], d.vm.stack[i])
 	}
 }
 
 // ShowLocals displays the current local variables.
 func (d *Debugger) ShowLocals() {
-	fmt.Println("Local variables:")
+	fmt.Fprintln(d.out, "Local variables:")
 	hasAny := false
-	for i, val := range d.vm.locals {
+	for i := range d.vm.locals {
+		val := d.vm.localFrame(i).locals[i]
 		if val != nil {
 			hasAny = true
-			fmt.Printf("  [%d] %v (%T)\n", i, val, val)
+			fmt.Fprintf(d.out, "  [%d] %v (%T)\n", i, val, val)
 		}
 	}
 	if !hasAny {
-		fmt.Println("  (none set)")
+		fmt.Fprintln(d.out, "  (none set)")
 	}
 }
 
 // ShowGlobals displays all global variables.
 func (d *Debugger) ShowGlobals() {
-	fmt.Println("Global variables:")
+	fmt.Fprintln(d.out, "Global variables:")
 	if len(d.vm.globals) == 0 {
-		fmt.Println("  (none)")
+		fmt.Fprintln(d.out, "  (none)")
 		return
 	}
 	
 	for name, val := range d.vm.globals {
-		fmt.Printf("  %s = %v (%T)\n", name, val, val)
+		fmt.Fprintf(d.out, "  %s = %v (%T)\n", name, val, val)
 	}
 }
 
 // ShowCallStack displays the current call stack.
 func (d *Debugger) ShowCallStack() {
-	fmt.Println("Call stack (top to bottom):")
+	fmt.Fprintln(d.out, "Call stack (top to bottom):")
 	if len(d.vm.callStack) == 0 {
-		fmt.Println("  (empty)")
+		fmt.Fprintln(d.out, "  (empty)")
 		return
 	}
 	
 	for i := len(d.vm.callStack) - 1; i >= 0; i-- {
 		frame := d.vm.callStack[i]
-		fmt.Printf("  %s", frame.Name)
+		fmt.Fprintf(d.out, "  %s", frame.Name)
 		if frame.Selector != "" {
-			fmt.Printf(" (selector: %s)", frame.Selector)
+			fmt.Fprintf(d.out, " (selector: %s)", frame.Selector)
 		}
 		if frame.IP >= 0 {
-			fmt.Printf(" [IP: %d]", frame.IP)
+			fmt.Fprintf(d.out, " [IP: %d]", frame.IP)
 		}
-		fmt.Println()
+		fmt.Fprintln(d.out)
 	}
 }
 
+// Inspect displays the value of a variable in the current frame.
+// The name may be a local variable, self, or a global. Instances show
+// each field name and value; arrays and dictionaries show their elements.
+func (d *Debugger) Inspect(name string) {
+	value, ok := d.lookupVariable(name)
+	if !ok {
+		fmt.Fprintf(d.out, "Unknown variable: %s\n", name)
+		return
+	}
+
+	switch v := value.(type) {
+	case *Instance:
+		fmt.Fprintf(d.out, "%s: an instance of %s\n", name, v.Class.Name)
+		fieldNames := d.vm.allFieldNames(v.Class)
+		if len(v.Fields) == 0 {
+			fmt.Fprintln(d.out, "  (no fields)")
+		}
+		for i, field := range v.Fields {
+			fieldName := fmt.Sprintf("field%d", i)
+			if i < len(fieldNames) {
+				fieldName = fieldNames[i]
+			}
+			fmt.Fprintf(d.out, "  %s = %v (%T)\n", fieldName, field, field)
+		}
+	case *Array:
+		fmt.Fprintf(d.out, "%s: an Array (%d elements)\n", name, len(v.Elements))
+		for i, elem := range v.Elements {
+			fmt.Fprintf(d.out, "  [%d] %v (%T)\n", i+1, elem, elem)
+		}
+	case *Dictionary:
+		fmt.Fprintf(d.out, "%s: %s (%d entries)\n", name, withArticle(v.className()), v.Len())
+		for i, k := range v.Keys() {
+			fmt.Fprintf(d.out, "  %v -> %v (%T)\n", k, v.values[i], v.values[i])
+		}
+	case map[interface{}]interface{}:
+		fmt.Fprintf(d.out, "%s: a Dictionary (%d entries)\n", name, len(v))
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			fmt.Fprintf(d.out, "  %v -> %v (%T)\n", k, v[k], v[k])
+		}
+	case map[string]interface{}:
+		fmt.Fprintf(d.out, "%s: a Dictionary (%d entries)\n", name, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(d.out, "  %s -> %v (%T)\n", k, v[k], v[k])
+		}
+	default:
+		fmt.Fprintf(d.out, "%s = %v (%T)\n", name, v, v)
+	}
+}
+
+// lookupVariable finds a variable by name in the current frame:
+// self, then locals of the current code, then globals.
+func (d *Debugger) lookupVariable(name string) (interface{}, bool) {
+	if name == "self" {
+		return d.vm.self, true
+	}
+	if d.bytecode != nil {
+		for i := len(d.bytecode.LocalNames) - 1; i >= 0; i-- {
+			if frame := d.vm.localFrame(i); d.bytecode.LocalNames[i] == name && i < len(frame.locals) {
+				return frame.locals[i], true
+			}
+		}
+	}
+	value, ok := d.vm.globals[name]
+	return value, ok
+}
+
 // InteractivePrompt provides an interactive debugger prompt.
 // This is called when execution pauses at a breakpoint or in step mode.
 func (d *Debugger) InteractivePrompt(bc *bytecode.Bytecode) (continueExecution bool) {
 	d.bytecode = bc
-	scanner := bufio.NewScanner(os.Stdin)
+	d.stepOver = false // Pausing for any reason ends a step over
+	scanner := d.in
 	
-	fmt.Println("\n=== Debugger Paused ===")
+	fmt.Fprintln(d.out, "\n=== Debugger Paused ===")
 	d.ShowCurrentInstruction()
 	
 	for {
-		fmt.Print("debug> ")
+		fmt.Fprint(d.out, "debug> ")
 		if !scanner.Scan() {
 			return false
 		}
@@ -209,7 +466,8 @@ func (d *Debugger) InteractivePrompt(bc *bytecode.Bytecode) (continueExecution b
 			return true
 			
 		case "next", "n":
-			// Step one instruction
+			// Step over the current instruction, including any call it makes
+			d.StepOver()
 			return true
 			
 		case "stack", "st":
@@ -224,78 +482,236 @@ func (d *Debugger) InteractivePrompt(bc *bytecode.Bytecode) (continueExecution b
 		case "callstack", "cs":
 			d.ShowCallStack()
 			
+		case "inspect", "in":
+			if len(parts) < 2 {
+				fmt.Fprintln(d.out, "Usage: inspect <variable>")
+				continue
+			}
+			d.Inspect(parts[1])
+			
 		case "instruction", "i":
 			d.ShowCurrentInstruction()
 			
-		case "breakpoint", "b":
+		case "breakpoint", "break", "b":
 			if len(parts) < 2 {
-				fmt.Println("Usage: breakpoint <instruction_number>")
+				fmt.Fprintln(d.out, "Usage: breakpoint <instruction_number> [if <condition>]")
 				continue
 			}
 			ip, err := strconv.Atoi(parts[1])
 			if err != nil {
-				fmt.Println("Invalid instruction number")
+				fmt.Fprintln(d.out, "Invalid instruction number")
+				continue
+			}
+			if len(parts) > 2 {
+				// Conditional breakpoint: break <ip> if <expr>
+				if parts[2] != "if" || len(parts) < 4 {
+					fmt.Fprintln(d.out, "Usage: breakpoint <instruction_number> [if <condition>]")
+					continue
+				}
+				condition := strings.TrimSpace(line[strings.Index(line, " if ")+len(" if "):])
+				if err := d.AddConditionalBreakpoint(ip, condition); err != nil {
+					fmt.Fprintln(d.out, err)
+					continue
+				}
+				fmt.Fprintf(d.out, "Breakpoint added at instruction %d if %s\n", ip, condition)
 				continue
 			}
 			d.AddBreakpoint(ip)
-			fmt.Printf("Breakpoint added at instruction %d\n", ip)
+			fmt.Fprintf(d.out, "Breakpoint added at instruction %d\n", ip)
 			
 		case "delete", "d":
 			if len(parts) < 2 {
-				fmt.Println("Usage: delete <instruction_number>")
+				fmt.Fprintln(d.out, "Usage: delete <instruction_number>")
 				continue
 			}
 			ip, err := strconv.Atoi(parts[1])
 			if err != nil {
-				fmt.Println("Invalid instruction number")
+				fmt.Fprintln(d.out, "Invalid instruction number")
 				continue
 			}
 			d.RemoveBreakpoint(ip)
-			fmt.Printf("Breakpoint removed at instruction %d\n", ip)
+			fmt.Fprintf(d.out, "Breakpoint removed at instruction %d\n", ip)
 			
 		case "list", "ls":
-			d.listInstructions(bc)
+			d.listSource(bc)
+			
+		case "disasm", "da":
+			radius := 5
+			if len(parts) >= 2 {
+				n, err := strconv.Atoi(parts[1])
+				if err != nil || n < 0 {
+					fmt.Fprintln(d.out, "Usage: disasm [context_lines]")
+					continue
+				}
+				radius = n
+			}
+			d.disassembleAround(bc, radius)
 			
 		case "quit", "q":
 			return false
 			
 		default:
-			fmt.Printf("Unknown command: %s (type 'help' for commands)\n", command)
+			fmt.Fprintf(d.out, "Unknown command: %s (type 'help' for commands)\n", command)
 		}
 	}
 }
 
 // printHelp displays available debugger commands.
 func (d *Debugger) printHelp() {
-	fmt.Println("Debugger Commands:")
-	fmt.Println("  help, h, ?           Show this help")
-	fmt.Println("  continue, c          Continue execution")
-	fmt.Println("  step, s              Enable step mode (pause after each instruction)")
-	fmt.Println("  next, n              Execute next instruction")
-	fmt.Println("  stack, st            Show VM stack")
-	fmt.Println("  locals, l            Show local variables")
-	fmt.Println("  globals, g           Show global variables")
-	fmt.Println("  callstack, cs        Show call stack")
-	fmt.Println("  instruction, i       Show current instruction")
-	fmt.Println("  breakpoint <n>, b    Add breakpoint at instruction n")
-	fmt.Println("  delete <n>, d        Remove breakpoint at instruction n")
-	fmt.Println("  list, ls             List all instructions")
-	fmt.Println("  quit, q              Quit debugging (abort execution)")
+	fmt.Fprintln(d.out, "Debugger Commands:")
+	fmt.Fprintln(d.out, "  help, h, ?           Show this help")
+	fmt.Fprintln(d.out, "  continue, c          Continue execution")
+	fmt.Fprintln(d.out, "  step, s              Enable step mode (pause after each instruction)")
+	fmt.Fprintln(d.out, "  next, n              Execute the current instruction, stepping over any")
+	fmt.Fprintln(d.out, "                       method or block it calls")
+	fmt.Fprintln(d.out, "  stack, st            Show VM stack")
+	fmt.Fprintln(d.out, "  locals, l            Show local variables")
+	fmt.Fprintln(d.out, "  globals, g           Show global variables")
+	fmt.Fprintln(d.out, "  callstack, cs        Show call stack")
+	fmt.Fprintln(d.out, "  instruction, i       Show current instruction")
+	fmt.Fprintln(d.out, "  inspect <var>, in    Show an object's fields or a collection's elements")
+	fmt.Fprintln(d.out, "  breakpoint <n>, b    Add breakpoint at instruction n")
+	fmt.Fprintln(d.out, "  b <n> if <expr>      Add breakpoint that pauses only when expr is true")
+	fmt.Fprintln(d.out, "  delete <n>, d        Remove breakpoint at instruction n")
+	fmt.Fprintln(d.out, "  list, ls             Show source lines around the current line")
+	fmt.Fprintln(d.out, "                       (lists all instructions if no source is available)")
+	fmt.Fprintln(d.out, "  disasm [n], da       Disassemble n instructions either side of the current one")
+	fmt.Fprintln(d.out, "  quit, q              Quit debugging (abort execution)")
 }
 
 // listInstructions displays all instructions in the bytecode.
 func (d *Debugger) listInstructions(bc *bytecode.Bytecode) {
-	fmt.Println("Instructions:")
+	fmt.Fprintln(d.out, "Instructions:")
 	for i, inst := range bc.Instructions {
 		marker := "  "
 		if i == d.vm.ip {
 			marker = "->"
-		} else if d.breakpoints[i] {
+		} else if d.breakpoints[d.location(i)] {
 			marker = "*"
 		}
 		
-		fmt.Printf("%s %4d: %s", marker, i, inst.Op)
+		fmt.Fprintf(d.out, "%s %4d: %s", marker, i, inst.Op)
 		d.formatInstructionOperand(inst, bc.Constants)
-		fmt.Println()
+		fmt.Fprintln(d.out)
+	}
+}
+
+// disassembleAround displays the instructions within radius of the current IP.
+func (d *Debugger) disassembleAround(bc *bytecode.Bytecode, radius int) {
+	start := d.vm.ip - radius
+	if start < 0 {
+		start = 0
+	}
+	end := d.vm.ip + radius
+	if end >= len(bc.Instructions) {
+		end = len(bc.Instructions) - 1
+	}
+
+	fmt.Fprintf(d.out, "Disassembly (instructions %d-%d):\n", start, end)
+	for i := start; i <= end; i++ {
+		inst := bc.Instructions[i]
+		marker := "  "
+		if i == d.vm.ip {
+			marker = "->"
+		} else if d.breakpoints[d.location(i)] {
+			marker = "*"
+		}
+
+		fmt.Fprintf(d.out, "%s %4d: %s", marker, i, inst.Op)
+		d.formatInstructionOperand(inst, bc.Constants)
+		if inst.Line > 0 {
+			fmt.Fprintf(d.out, "  (line %d)", inst.Line)
+		}
+		fmt.Fprintln(d.out)
+	}
+}
+
+// listSource displays the source lines around the line of the current
+// instruction. Without source or line information it lists all instructions.
+func (d *Debugger) listSource(bc *bytecode.Bytecode) {
+	line := 0
+	if d.vm.ip < len(bc.Instructions) {
+		line = bc.Instructions[d.vm.ip].Line
+	}
+	if d.sourceLines == nil || line < 1 || line > len(d.sourceLines) {
+		d.listInstructions(bc)
+		return
+	}
+
+	const radius = 3
+	start := line - radius
+	if start < 1 {
+		start = 1
+	}
+	end := line + radius
+	if end > len(d.sourceLines) {
+		end = len(d.sourceLines)
+	}
+
+	fmt.Fprintln(d.out, "Source:")
+	for n := start; n <= end; n++ {
+		marker := "  "
+		if n == line {
+			marker = "->"
+		}
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, n, d.sourceLines[n-1])
+	}
+}
+
+// debugging is the debugger state a VM shares with the VMs it runs blocks
+// and methods in, so a debugger one of them enables - halt inside a method,
+// say - is the one all of them use, and it outlives the method.
+type debugging struct {
+	root        *VM         // The VM the program runs on, which a new debugger is made for
+	debugger    *Debugger   // The debugger, or nil if there isn't one
+	interactive func() bool // Whether halt may start a debugger (see SetInteractive)
+}
+
+// debugState answers the VM's debugger state, creating it for a VM that
+// doesn't have one yet, which makes that VM the root.
+func (vm *VM) debugState() *debugging {
+	if vm.debugging == nil {
+		vm.debugging = &debugging{root: vm}
+	}
+	return vm.debugging
+}
+
+// SetInteractive tells the VM how to find out whether someone is at a
+// terminal to debug with, so that halt can start a debugger when none is
+// attached. The VM asks only when a halt needs to know. Without it, or if
+// it answers false, such a halt prints a warning and is ignored.
+//
+// Example:
+//   v := vm.New()
+//   v.SetInteractive(func() bool { return term.IsTerminal(int(os.Stdin.Fd())) })
+func (vm *VM) SetInteractive(isInteractive func() bool) {
+	vm.debugState().interactive = isInteractive
+}
+
+// halt implements the halt message: it stops the program in the debugger
+// right after the halt send, like a breakpoint written into the source.
+//
+// A program already running under a debugger (smog debug, or an embedder
+// that called EnableDebugger) pauses there, even if the debugger has been
+// disabled. Otherwise a debugger is created on the fly for the root VM when
+// the embedder says there is an interactive terminal (see SetInteractive);
+// when there isn't, there is nobody to talk to, so halt prints a warning
+// and the program carries on. halt answers its receiver.
+//
+// Example:
+//   x := 42.
+//   x halt.         "debug> prompt appears before the next statement"
+//   x := x + 1.
+func (vm *VM) halt(receiver interface{}) interface{} {
+	state := vm.debugState()
+	if state.debugger == nil {
+		if state.interactive == nil || !state.interactive() {
+			fmt.Fprintln(os.Stderr, "warning: halt ignored, no interactive terminal to debug on")
+			return receiver
+		}
+		state.root.EnableDebugger()
 	}
+	state.debugger.Enable()
+	state.debugger.SetStepMode(true)
+	return receiver
 }
@@ -0,0 +1,397 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// compileForDebug compiles source for a debugger test.
+func compileForDebug(t *testing.T, source string) *bytecode.Bytecode {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	return bc
+}
+
+// firstInstructionOnLine returns the index of the first instruction compiled from line.
+func firstInstructionOnLine(t *testing.T, bc *bytecode.Bytecode, line int) int {
+	t.Helper()
+
+	for i, inst := range bc.Instructions {
+		if inst.Line == line {
+			return i
+		}
+	}
+	t.Fatalf("No instruction found for line %d", line)
+	return -1
+}
+
+// runDebugSession runs bc with a breakpoint at ip, feeding commands to the prompt.
+func runDebugSession(t *testing.T, bc *bytecode.Bytecode, source string, ip int, commands string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	v := New()
+	d := v.EnableDebugger()
+	d.SetIO(strings.NewReader(commands), &out)
+	d.SetSource(source)
+	d.AddBreakpoint(ip)
+
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v\nOutput:\n%s", err, out.String())
+	}
+	return out.String()
+}
+
+func TestDebuggerDisasmShowsCurrentInstruction(t *testing.T) {
+	source := "| x y |\nx := 10.\ny := x + 5.\ny"
+	bc := compileForDebug(t, source)
+	ip := firstInstructionOnLine(t, bc, 3)
+
+	output := runDebugSession(t, bc, source, ip, "disasm 1\ncontinue\n")
+
+	current := fmt.Sprintf("-> %4d: %s", ip, bc.Instructions[ip].Op)
+	if !strings.Contains(output, current) {
+		t.Errorf("Expected disasm output to mark current instruction %q, got:\n%s", current, output)
+	}
+	if !strings.Contains(output, fmt.Sprintf("%4d: %s", ip-1, bc.Instructions[ip-1].Op)) {
+		t.Errorf("Expected disasm output to include previous instruction, got:\n%s", output)
+	}
+	if strings.Contains(output, fmt.Sprintf("%4d: %s", ip+2, bc.Instructions[ip+2].Op)) {
+		t.Errorf("Expected disasm 1 to show only one instruction either side, got:\n%s", output)
+	}
+}
+
+func TestDebuggerListShowsCurrentSourceLine(t *testing.T) {
+	source := "| x y |\nx := 10.\ny := x + 5.\ny"
+	bc := compileForDebug(t, source)
+	ip := firstInstructionOnLine(t, bc, 3)
+
+	output := runDebugSession(t, bc, source, ip, "list\ncontinue\n")
+
+	if !strings.Contains(output, "->    3  y := x + 5.") {
+		t.Errorf("Expected list output to mark line 3, got:\n%s", output)
+	}
+	if !strings.Contains(output, "      2  x := 10.") {
+		t.Errorf("Expected list output to include surrounding lines, got:\n%s", output)
+	}
+}
+
+func TestDebuggerListFallsBackToInstructions(t *testing.T) {
+	source := "| x |\nx := 10.\nx"
+	bc := compileForDebug(t, source)
+
+	var out bytes.Buffer
+	v := New()
+	d := v.EnableDebugger()
+	d.SetIO(strings.NewReader("list\ncontinue\n"), &out)
+	d.AddBreakpoint(0)
+
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Instructions:") {
+		t.Errorf("Expected instruction listing without source, got:\n%s", out.String())
+	}
+}
+
+func TestDebuggerConditionalBreakpointInLoop(t *testing.T) {
+	source := `| i hits |
+i := 0.
+hits := 0.
+[i < 10] whileTrue: [
+	i := i + 1.
+	hits := hits + 1
+].
+i`
+	bc := compileForDebug(t, source)
+
+	// The loop body is the second block in the program's constant pool
+	var blocks []*bytecode.Bytecode
+	for _, c := range bc.Constants {
+		if code, ok := c.(*bytecode.Bytecode); ok {
+			blocks = append(blocks, code)
+		}
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(blocks))
+	}
+
+	var out bytes.Buffer
+	v := New()
+	d := v.EnableDebugger()
+	d.SetIO(strings.NewReader("locals\ncontinue\n"), &out)
+	if err := d.AddBreakpointIn(blocks[1], 0, "i = 5"); err != nil {
+		t.Fatalf("AddBreakpointIn failed: %v", err)
+	}
+
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v\nOutput:\n%s", err, out.String())
+	}
+	if v.StackTop() != int64(10) {
+		t.Errorf("Expected loop to finish with 10, got %v", v.StackTop())
+	}
+
+	output := out.String()
+	if n := strings.Count(output, "=== Debugger Paused ==="); n != 1 {
+		t.Fatalf("Expected exactly 1 pause, got %d:\n%s", n, output)
+	}
+	if !strings.Contains(output, "[0] 5 (int64)") || !strings.Contains(output, "[1] 5 (int64)") {
+		t.Errorf("Expected to pause with i = 5, got:\n%s", output)
+	}
+}
+
+func TestDebuggerBreakCommandWithCondition(t *testing.T) {
+	source := "| x |\nx := 3.\nx := x + 1.\nx"
+	bc := compileForDebug(t, source)
+	ip := firstInstructionOnLine(t, bc, 3)
+
+	// Pause at the start, then add conditional breakpoints from the prompt:
+	// the false one must be skipped and the true one must pause.
+	commands := fmt.Sprintf("break %d if x = 99\nbreak %d if x = 3\ncontinue\nlocals\ncontinue\n", ip, ip+1)
+	output := runDebugSession(t, bc, source, 0, commands)
+
+	if !strings.Contains(output, fmt.Sprintf("Breakpoint added at instruction %d if x = 3", ip+1)) {
+		t.Errorf("Expected conditional breakpoint confirmation, got:\n%s", output)
+	}
+	if n := strings.Count(output, "=== Debugger Paused ==="); n != 2 {
+		t.Errorf("Expected 2 pauses (start and true condition), got %d:\n%s", n, output)
+	}
+
+	if err := New().EnableDebugger().AddConditionalBreakpoint(0, "x :="); err == nil {
+		t.Error("Expected error for malformed condition")
+	}
+}
+
+func TestDebuggerInspectInstance(t *testing.T) {
+	source := `Object subclass: #Point [
+	| x y |
+	x: ax y: ay [ x := ax. y := ay ]
+]
+Point subclass: #Point3 [
+	| z |
+	z: az [ z := az ]
+]
+| p arr |
+p := Point3 new.
+p x: 3 y: 4.
+p z: 'deep'.
+arr := #(7 8).
+p`
+	bc := compileForDebug(t, source)
+	ip := firstInstructionOnLine(t, bc, 14)
+
+	output := runDebugSession(t, bc, source, ip, "inspect p\ninspect arr\ninspect nope\ncontinue\n")
+
+	for _, want := range []string{
+		"p: an instance of Point3",
+		"  x = 3 (int64)",
+		"  y = 4 (int64)",
+		"  z = deep (string)",
+		"arr: an Array (2 elements)",
+		"  [1] 7 (int64)",
+		"  [2] 8 (int64)",
+		"Unknown variable: nope",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected inspect output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestHaltPausesInDebugger(t *testing.T) {
+	source := "| x |\nx := 42.\nx halt.\nx := x + 1.\nx"
+	bc := compileForDebug(t, source)
+
+	// The debugger is attached but disabled, so only halt can pause it
+	var out bytes.Buffer
+	v := New()
+	d := v.EnableDebugger()
+	d.SetIO(strings.NewReader("list\nlocals\ncontinue\n"), &out)
+	d.SetSource(source)
+	d.Disable()
+
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v\nOutput:\n%s", err, out.String())
+	}
+	output := out.String()
+	if strings.Count(output, "=== Debugger Paused ===") != 1 {
+		t.Fatalf("Expected halt to pause exactly once, got:\n%s", output)
+	}
+	if !strings.Contains(output, "->    3  x halt.") {
+		t.Errorf("Expected the pause to be at the halt line, got:\n%s", output)
+	}
+	if !strings.Contains(output, "42") {
+		t.Errorf("Expected locals to show x = 42, got:\n%s", output)
+	}
+	if result := v.StackTop(); result != int64(43) {
+		t.Errorf("Expected execution to continue to 43, got %v", result)
+	}
+}
+
+func TestHaltWithoutTerminalIsIgnored(t *testing.T) {
+	bc := compileForDebug(t, "3 halt + 4")
+
+	// Without SetInteractive, or when it answers false, nobody is there
+	v := New()
+	v.SetInteractive(func() bool { return false })
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	if v.GetDebugger() != nil {
+		t.Error("Expected halt not to create a debugger without a terminal")
+	}
+	if result := v.StackTop(); result != int64(7) {
+		t.Errorf("Expected halt to answer its receiver, got %v", result)
+	}
+}
+
+func TestHaltInMethodDebugsWholeProgram(t *testing.T) {
+	source := `Object subclass: #Probe [
+    check: x [ x halt. ^x + 1 ]
+]
+| y |
+y := Probe new check: 41.
+y := y * 2.
+y`
+	bc := compileForDebug(t, source)
+
+	// A debugger halt starts talks on stdin and stdout
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := os.WriteFile(in, []byte(strings.Repeat("step\n", 20)+"continue\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", in, err)
+	}
+	stdin, err := os.Open(in)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", in, err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(out)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", out, err)
+	}
+	defer stdout.Close()
+	savedIn, savedOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	defer func() { os.Stdin, os.Stdout = savedIn, savedOut }()
+
+	v := New()
+	v.SetInteractive(func() bool { return true })
+	err = v.Run(bc)
+	os.Stdin, os.Stdout = savedIn, savedOut
+	if err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	if result := v.StackTop(); result != int64(84) {
+		t.Errorf("Expected 84, got %v", result)
+	}
+
+	// The debugger belongs to the program, not the method that halted, so
+	// stepping carries on into the main program after the method returns
+	d := v.GetDebugger()
+	if d == nil {
+		t.Fatal("Expected halt in a method to enable the program's debugger")
+	}
+	if d.main != bc {
+		t.Error("Expected stepping to reach the main program after the method returned")
+	}
+	output, _ := os.ReadFile(out)
+	if strings.Count(string(output), "=== Debugger Paused ===") < 5 {
+		t.Errorf("Expected to step through the rest of the program, got:\n%s", output)
+	}
+}
+
+func TestDebuggerNextStepsOverMethodCall(t *testing.T) {
+	source := `Object subclass: #Counter [
+    | n |
+    bump [ n := 1. n := n + 1. ^n ]
+]
+| c y |
+c := Counter new.
+y := c bump.
+y`
+	bc := compileForDebug(t, source)
+	send := -1
+	for i, inst := range bc.Instructions {
+		if inst.Op == bytecode.OpSend && inst.Line == 7 {
+			send = i
+		}
+	}
+	if send < 0 {
+		t.Fatal("No SEND found on line 7")
+	}
+	afterSend := fmt.Sprintf("  %4d: %s", send+1, bc.Instructions[send+1].Op)
+
+	// next lands on the instruction after the SEND, back in the main program
+	output := runDebugSession(t, bc, source, send, "next\ncontinue\n")
+	paused := strings.Split(output, "=== Debugger Paused ===")
+	if len(paused) != 3 {
+		t.Fatalf("Expected to pause twice, got:\n%s", output)
+	}
+	if !strings.Contains(paused[2], afterSend) || !strings.Contains(paused[2], "(line 7)") {
+		t.Errorf("Expected next to pause at %q on line 7, got:\n%s", afterSend, paused[2])
+	}
+
+	// step, by contrast, descends into the method
+	output = runDebugSession(t, bc, source, send, "step\ncontinue\n")
+	paused = strings.Split(output, "=== Debugger Paused ===")
+	if len(paused) != 3 || !strings.Contains(paused[2], "(line 3)") {
+		t.Errorf("Expected step to pause inside the method on line 3, got:\n%s", output)
+	}
+}
+
+func TestDebuggerNextStopsAtBreakpointInsideCall(t *testing.T) {
+	source := `Object subclass: #Counter [
+    bump [ ^1 + 1 ]
+]
+| c |
+c := Counter new.
+c bump.
+c`
+	bc := compileForDebug(t, source)
+	send := firstInstructionOnLine(t, bc, 6) + 1
+	var method *bytecode.Bytecode
+	for _, c := range bc.Constants {
+		if class, ok := c.(*bytecode.ClassDefinition); ok {
+			method = class.Methods[0].Code
+		}
+	}
+	if method == nil {
+		t.Fatal("No class definition found")
+	}
+
+	var out bytes.Buffer
+	v := New()
+	d := v.EnableDebugger()
+	d.SetIO(strings.NewReader("next\ncontinue\n"), &out)
+	d.AddBreakpoint(send)
+	if err := d.AddBreakpointIn(method, 0, ""); err != nil {
+		t.Fatalf("AddBreakpointIn failed: %v", err)
+	}
+
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	paused := strings.Split(out.String(), "=== Debugger Paused ===")
+	if len(paused) != 3 || !strings.Contains(paused[2], "(line 2)") {
+		t.Errorf("Expected the breakpoint inside the method to interrupt next, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,276 @@
+// Package vm - the shared enumeration protocol
+package vm
+
+import (
+	"fmt"
+)
+
+// Enumerable is implemented by the collections whose elements can be
+// visited one at a time: Array, Bag, Set and Dictionary (its values).
+//
+// The messages that only need to walk the elements in order (do:,
+// collect:, select:, reject:, inject:into:, withIndexCollect:, the fused
+// select:thenCollect: and collect:thenSelect:, groupBy:, and the
+// aggregates max, min, sum and average) are written once, in sendEnumerable, against this
+// interface. A new collection type gets all
+// of them by implementing forEach; its own handler only needs the
+// messages specific to it, such as add: or at:.
+type Enumerable interface {
+	// forEach calls visit with each element in iteration order, stopping
+	// at and returning the first error visit returns.
+	forEach(visit func(elem interface{}) error) error
+}
+
+// forEach visits the array's elements in order.
+func (a *Array) forEach(visit func(elem interface{}) error) error {
+	for _, elem := range a.Elements {
+		if err := visit(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach visits each element once per occurrence.
+func (b *Bag) forEach(visit func(elem interface{}) error) error {
+	for i, key := range b.table.keys {
+		for n := int64(0); n < b.counts[i]; n++ {
+			if err := visit(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// forEach visits the elements in insertion order.
+func (s *Set) forEach(visit func(elem interface{}) error) error {
+	for _, key := range s.table.keys {
+		if err := visit(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach visits the values in key insertion order. It walks a snapshot,
+// so visit may add entries safely.
+func (d *Dictionary) forEach(visit func(elem interface{}) error) error {
+	for _, value := range append([]interface{}{}, d.values...) {
+		if err := visit(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEnumerable handles the enumeration messages every Enumerable
+// understands. Messages that build a new collection answer an Array,
+// whatever kind of collection the receiver is.
+//
+// Example:
+//   #(1 2 3) collect: [:x | x * x]                     "#(1 4 9)"
+//   (Set new add: 1; add: 2; yourself) select: [:x | x > 1]   "#(2)"
+//   #(1 2 3) reject: [:x | x > 1]                      "#(1)"
+//   #(1 2 3) inject: 0 into: [:sum :x | sum + x]       "6"
+//   #('a' 'b') withIndexCollect: [:e :i | e , i printString]  "#('a1' 'b2')"
+//   #(1 2 3 4) average                                 "2.5"
+//   #(1 2 3 4) groupBy: [:x | x > 2]                   "a Dictionary(false->#(1 2) true->#(3 4))"
+func (vm *VM) sendEnumerable(c Enumerable, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "do:":
+		block, err := blockArg(selector, args, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		err = c.forEach(func(elem interface{}) error {
+			_, err := vm.executeBlock(block, []interface{}{elem})
+			return err
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return c, true, nil
+	case "collect:":
+		block, err := blockArg(selector, args, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		results := &Array{Elements: []interface{}{}}
+		err = c.forEach(func(elem interface{}) error {
+			value, err := vm.executeBlock(block, []interface{}{elem})
+			results.Elements = append(results.Elements, value)
+			return err
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return results, true, nil
+	case "select:", "reject:":
+		// select: keeps the elements the block answers true for, reject:
+		// the ones it answers false for
+		block, err := blockArg(selector, args, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		results := &Array{Elements: []interface{}{}}
+		err = c.forEach(func(elem interface{}) error {
+			test, err := vm.testElement(selector, block, elem)
+			if err == nil && test == (selector == "select:") {
+				results.Elements = append(results.Elements, elem)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return results, true, nil
+	case "inject:into:":
+		// Fold with a running accumulator, passed as the block's first argument:
+		//   #(1 2 3) inject: 0 into: [:sum :x | sum + x]  -> ((0 + 1) + 2) + 3
+		if len(args) != 2 {
+			return nil, true, fmt.Errorf("inject:into: expects 2 arguments, got %d", len(args))
+		}
+		block, err := blockArg(selector, args[1:], 2)
+		if err != nil {
+			return nil, true, err
+		}
+		acc := args[0]
+		err = c.forEach(func(elem interface{}) error {
+			var err error
+			acc, err = vm.executeBlock(block, []interface{}{acc, elem})
+			return err
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return acc, true, nil
+	case "withIndexCollect:":
+		// Like collect:, also passing each element's 1-based position
+		block, err := blockArg(selector, args, 2)
+		if err != nil {
+			return nil, true, err
+		}
+		results := &Array{Elements: []interface{}{}}
+		err = c.forEach(func(elem interface{}) error {
+			index := int64(len(results.Elements) + 1)
+			value, err := vm.executeBlock(block, []interface{}{elem, index})
+			results.Elements = append(results.Elements, value)
+			return err
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return results, true, nil
+	case "select:thenCollect:", "collect:thenSelect:":
+		result, err := vm.selectCollect(c, selector, args)
+		return result, true, err
+	case "groupBy:":
+		result, err := vm.groupBy(c, args)
+		return result, true, err
+	case "max", "min", "sum", "average":
+		result, err := vm.aggregate(c, selector)
+		return result, true, err
+	}
+	return nil, false, nil
+}
+
+// groupBy answers a Dictionary that maps each key the block answers for
+// an element to an Array of the elements with that key. Keys are in the
+// order they were first seen, and each Array keeps the collection's
+// order.
+func (vm *VM) groupBy(c Enumerable, args []interface{}) (*Dictionary, error) {
+	block, err := blockArg("groupBy:", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	groups := newDictionary()
+	err = c.forEach(func(elem interface{}) error {
+		key, err := vm.executeBlock(block, []interface{}{elem})
+		if err != nil {
+			return err
+		}
+		if group, ok := groups.At(key); ok {
+			group.(*Array).Elements = append(group.(*Array).Elements, elem)
+			return nil
+		}
+		return groups.AtPut(key, &Array{Elements: []interface{}{elem}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// aggregate answers the max, min, sum or average of a collection's
+// elements. Elements are compared and added with the same > , < and +
+// messages a program would send, so any numbers that mix under those
+// work. An empty collection has no aggregate and is an error.
+//
+// An average is never truncated: when the elements are integers whose
+// sum doesn't divide evenly it answers a Float, or a Fraction when the
+// VM divides exactly.
+func (vm *VM) aggregate(c Enumerable, selector string) (interface{}, error) {
+	var result interface{}
+	count := int64(0)
+	err := c.forEach(func(elem interface{}) error {
+		count++
+		if count == 1 {
+			result = elem
+			return nil
+		}
+		switch selector {
+		case "max", "min":
+			comparison := ">"
+			if selector == "min" {
+				comparison = "<"
+			}
+			better, err := vm.send(elem, comparison, []interface{}{result})
+			if err != nil {
+				return err
+			}
+			if better == true {
+				result = elem
+			}
+		default:
+			sum, err := vm.send(result, "+", []interface{}{elem})
+			if err != nil {
+				return err
+			}
+			result = sum
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("%s needs a non-empty collection", selector)
+	}
+	if selector != "average" {
+		return result, nil
+	}
+	switch sum := result.(type) {
+	case int64:
+		if sum%count != 0 && vm.division == DivideTruncate {
+			return float64(sum) / float64(count), nil
+		}
+	case float64:
+		return sum / float64(count), nil
+	}
+	return vm.divide(result, count)
+}
+
+// testElement runs a select: style block on elem and answers its verdict,
+// which must be a Boolean.
+func (vm *VM) testElement(selector string, block *Block, elem interface{}) (bool, error) {
+	result, err := vm.executeBlock(block, []interface{}{elem})
+	if err != nil {
+		return false, err
+	}
+	keep, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s block must answer a Boolean, got %s", selector, describeValue(result))
+	}
+	return keep, nil
+}
@@ -0,0 +1,214 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+func TestEnumerationProtocol(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"#(1 2 3) collect: [:x | x * x]", &Array{Elements: []interface{}{int64(1), int64(4), int64(9)}}},
+		{"#() collect: [:x | x]", &Array{Elements: []interface{}{}}},
+		{"#(1 2 3 4) select: [:x | x > 2]", &Array{Elements: []interface{}{int64(3), int64(4)}}},
+		{"#(1 2 3 4) inject: 0 into: [:sum :x | sum + x]", int64(10)},
+		{"#() inject: 5 into: [:sum :x | sum + x]", int64(5)},
+		{"#('a' 'b') withIndexCollect: [:e :i | e , i printString]", &Array{Elements: []interface{}{"a1", "b2"}}},
+		// Every collection understands the whole protocol and answers Arrays
+		{"(Set new add: 1; add: 2; add: 1; yourself) collect: [:x | x * 10]", &Array{Elements: []interface{}{int64(10), int64(20)}}},
+		{"(Bag new add: 3 withOccurrences: 2; yourself) inject: 0 into: [:sum :x | sum + x]", int64(6)},
+		{"#{'a' -> 1. 'b' -> 2} select: [:v | v > 1]", &Array{Elements: []interface{}{int64(2)}}},
+		{"#{'a' -> 1. 'b' -> 2} withIndexCollect: [:v :i | v * i]", &Array{Elements: []interface{}{int64(1), int64(4)}}},
+		// do: answers the receiver
+		{"| s | s := Set new. (s do: [:x | x]) == s", true},
+		// A ^ inside the block still returns from the enclosing context
+		{"#(1 2 3) collect: [:x | x = 2 ifTrue: [^'early']. x]", "early"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"#(1 2) select: [:x | 3]", "select: block must answer a Boolean"},
+		{"#(1 2) collect: 3", "collect: argument must be a block"},
+		{"#(1 2) inject: 0 into: [:x | x]", "inject:into: block must take 2 argument(s)"},
+		{"#(1 2) withIndexCollect: [:x | x]", "withIndexCollect: block must take 2 argument(s)"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"#(3 1 2) max", int64(3)},
+		{"#(3 1 2) min", int64(1)},
+		{"#(1 2 3 4) sum", int64(10)},
+		{"#(1 2 3 4) average", 2.5},
+		{"#(1 2 3) average", int64(2)},
+		{"#(1.5 2.5 3.5) average", 2.5},
+		{"#(-4 7 -9) min", int64(-9)},
+		{"#(2.5 0.5) max", 2.5},
+		// A single element is its own aggregate
+		{"#(7) max", int64(7)},
+		{"#(7) min", int64(7)},
+		{"#(7) sum", int64(7)},
+		{"#(7) average", int64(7)},
+		// Every collection has them
+		{"(Set new add: 4; add: 9; yourself) max", int64(9)},
+		{"(Bag new add: 3 withOccurrences: 2; yourself) sum", int64(6)},
+		{"#{'a' -> 1. 'b' -> 2} average", 1.5},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"#() max", "max needs a non-empty collection"},
+		{"#() min", "min needs a non-empty collection"},
+		{"#() sum", "sum needs a non-empty collection"},
+		{"Set new average", "average needs a non-empty collection"},
+		{"#(1 nil) sum", "cannot add"},
+		{"#(true false) max", "not understood by"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestAverageDivisionMode tests that an average follows exact division
+// but is never truncated
+func TestAverageDivisionMode(t *testing.T) {
+	program, err := parser.New("#(1 2 3 4) average").Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	expected := map[DivisionMode]string{DivideTruncate: "2.5", DivideExact: "5/2", DivideFloat: "2.5"}
+	for mode, want := range expected {
+		v := New()
+		v.SetDivisionMode(mode)
+		if err := v.Run(bc); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got := v.printString(v.StackTop()); got != want {
+			t.Errorf("mode %d: expected %s, got %s", mode, want, got)
+		}
+	}
+}
+
+// countdown is a collection type that exists only in this test, to show
+// that implementing forEach is all a new collection needs
+type countdown struct {
+	from int64
+}
+
+func (c *countdown) forEach(visit func(elem interface{}) error) error {
+	for n := c.from; n > 0; n-- {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestNewCollectionGetsEnumerationProtocol tests that a collection type
+// the VM knows nothing else about understands the enumeration messages
+func TestNewCollectionGetsEnumerationProtocol(t *testing.T) {
+	square := runSource(t, "[:x | x * x]")
+	odd := runSource(t, "[:x | x // 2 * 2 ~= x]")
+	sum := runSource(t, "[:acc :x | acc + x]")
+
+	tests := []struct {
+		selector string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"collect:", []interface{}{square}, &Array{Elements: []interface{}{int64(9), int64(4), int64(1)}}},
+		{"select:", []interface{}{odd}, &Array{Elements: []interface{}{int64(3), int64(1)}}},
+		{"inject:into:", []interface{}{int64(100), sum}, int64(106)},
+		{"select:thenCollect:", []interface{}{odd, square}, &Array{Elements: []interface{}{int64(9), int64(1)}}},
+	}
+	v := New()
+	for _, tt := range tests {
+		result, err := v.send(&countdown{from: 3}, tt.selector, tt.args)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.selector, err)
+			continue
+		}
+		if !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.selector, tt.expected, result)
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Several groups, keys in the order first seen, elements in order
+		{"(#(1 2 3 4) groupBy: [:x | x - (x // 2 * 2)]) printString", "a Dictionary(1->#(1 3) 0->#(2 4))"},
+		{"(#(1 2 3 4) groupBy: [:x | x - (x // 2 * 2)]) at: 0", ints(2, 4)},
+		{"(#('apple' 'fig' 'avocado' 'banana' 'blueberry') groupBy: [:s | s copyFrom: 1 to: 1]) at: 'b'", &Array{Elements: []interface{}{"banana", "blueberry"}}},
+		{"(#('apple' 'fig' 'avocado' 'banana' 'blueberry') groupBy: [:s | s copyFrom: 1 to: 1]) printString",
+			"a Dictionary('a'->#('apple' 'avocado') 'f'->#('fig') 'b'->#('banana' 'blueberry'))"},
+		// A single group
+		{"(#(1 2 3) groupBy: [:x | 'all']) printString", "a Dictionary('all'->#(1 2 3))"},
+		{"(#(1 2 3) groupBy: [:x | nil]) size", int64(1)},
+		// An empty collection has no groups
+		{"(#() groupBy: [:x | x]) size", int64(0)},
+		{"(#() groupBy: [:x | x]) isEmpty", true},
+		// Any enumerable collection, and keys compare with =
+		{"((1 to: 6) groupBy: [:x | x > 3]) at: true", ints(4, 5, 6)},
+		{"((Set new add: 3; add: 4; yourself) groupBy: [:x | x < 4]) at: false", ints(4)},
+		{"(#(1 2 3) groupBy: [:x | {x > 1}]) at: #(true)", ints(2, 3)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"#(1 2) groupBy: 3", "groupBy: argument must be a block"},
+		{"#(1 2) groupBy: [:a :b | a]", "groupBy: block must take 1 argument(s), got 2"},
+		{"#(1 2) groupBy: [:x | x foo]", "unknown message: foo"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
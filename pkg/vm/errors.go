@@ -3,7 +3,11 @@ package vm
 
 import (
 	"fmt"
+	"math/big"
 	"strings"
+	"time"
+
+	"github.com/kristofer/smog/pkg/bytecode"
 )
 
 // StackFrame represents a single frame in the call stack.
@@ -18,9 +22,24 @@ type StackFrame struct {
 
 // RuntimeError represents a runtime error with stack trace information.
 // This provides detailed context about where an error occurred.
+//
+// Every error Run returns, other than an InvariantError for malformed
+// bytecode, is a *RuntimeError, so a program embedding the VM can inspect
+// it without parsing the message. An error raised inside a method or block
+// reaches the caller as a single RuntimeError whose stack trace runs from
+// the top level down to the failing send. The typed error that caused it,
+// such as a *ZeroDivideError, stays reachable with errors.As.
+//
+// Example:
+//   var rtErr *vm.RuntimeError
+//   if errors.As(v.Run(bc), &rtErr) {
+//     fmt.Println(rtErr.Selector, len(rtErr.StackTrace))
+//   }
 type RuntimeError struct {
 	Message    string       // Error message
+	Selector   string       // The message whose send failed, if any
 	StackTrace []StackFrame // Call stack at time of error
+	Cause      error        // Underlying error, if any (for errors.As/errors.Is)
 }
 
 // Error implements the error interface.
@@ -49,6 +68,11 @@ func (e *RuntimeError) Error() string {
 	return b.String()
 }
 
+// Unwrap returns the underlying error so callers can use errors.As/errors.Is.
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
 // newRuntimeError creates a new RuntimeError with the given message.
 func newRuntimeError(message string, stack []StackFrame) *RuntimeError {
 	return &RuntimeError{
@@ -56,3 +80,94 @@ func newRuntimeError(message string, stack []StackFrame) *RuntimeError {
 		StackTrace: stack,
 	}
 }
+
+// TimeoutError is returned when a block run with valueWithTimeout:
+// does not finish within its time limit.
+type TimeoutError struct {
+	Limit time.Duration // The time limit that was exceeded
+}
+
+// Error implements the error interface.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout: block did not finish within %dms", e.Limit.Milliseconds())
+}
+
+// TypeError is returned when an arithmetic or comparison message is sent
+// to a receiver that isn't a number, such as true + 1 or nil < 3.
+type TypeError struct {
+	Selector string      // The message that was sent, e.g. "+"
+	Receiver interface{} // The receiver that doesn't understand it
+}
+
+// Error implements the error interface.
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%s not understood by %s", e.Selector, describeValue(e.Receiver))
+}
+
+// ZeroDivideError is returned when a number is divided by zero, with /,
+// // or reciprocal. Integer, Fraction and Float division all signal it, so
+// a Float division by zero never answers infinity or NaN.
+type ZeroDivideError struct {
+	Selector string      // The message that was sent, e.g. "/"
+	Dividend interface{} // The number that was divided
+}
+
+// Error implements the error interface.
+func (e *ZeroDivideError) Error() string {
+	return "division by zero"
+}
+
+// describeValue names a value for an error message: nil and booleans by
+// their literal, anything else by its smog class, as in "a String" or
+// "an Integer".
+func describeValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprintf("%t", val)
+	case int64, *big.Int:
+		return "an Integer"
+	case float64:
+		return "a Float"
+	case *big.Rat:
+		return "a Fraction"
+	case string:
+		return "a String"
+	case *Array:
+		return "an Array"
+	case *Block:
+		return "a Block"
+	case *Dictionary:
+		return withArticle(val.className())
+	case map[interface{}]interface{}:
+		return "a Dictionary"
+	case Point:
+		return "a Point"
+	case Interval:
+		return "an Interval"
+	case *Bag:
+		return "a Bag"
+	case *Set:
+		return withArticle(val.className())
+	case *Hasher:
+		return "a Hasher"
+	case *WriteStream:
+		return "a WriteStream"
+	case *ReadStream:
+		return "a ReadStream"
+	case *ByteArray:
+		return "a ByteArray"
+	case *Context:
+		return "a Context"
+	case *Exception:
+		return val.String()
+	case *Instance:
+		return "an instance of " + val.Class.Name
+	case *bytecode.ClassDefinition:
+		return "the class " + val.Name
+	case *BuiltinClass:
+		return "the class " + val.Name
+	}
+	return fmt.Sprintf("a %T", v)
+}
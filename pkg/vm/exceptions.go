@@ -0,0 +1,345 @@
+// Package vm - exceptions (on:do: and signal:)
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// exceptionParents maps each built-in exception class to its superclass,
+// "" for Error, the root. A handler for a class also catches the
+// exceptions of its subclasses, so on: Error catches a ZeroDivide.
+// Classes defined in smog with "Error subclass: #Name" join the same
+// hierarchy through their superclass (see exceptionParent).
+var exceptionParents = map[string]string{
+	"Error":      "",
+	"ZeroDivide": "Error",
+	"Timeout":    "Error",
+}
+
+// Exception is the value a handler block receives: what went wrong, and
+// the class a handler has to name to catch it.
+//
+// Smog code raises one with signal or signal:, and a runtime error such as
+// a division by zero or an unknown message becomes one when it reaches an
+// on:do: handler. A division by zero is a ZeroDivide, a block that runs
+// past its valueWithTimeout: limit is a Timeout, and every other runtime
+// error is an Error whose messageText is the error message.
+//
+// An instance of a smog subclass of Error is signaled the same way. Its
+// Exception holds the exception state, and its handler receives the
+// instance itself, so the subclass's own methods and fields work there.
+//
+// Example:
+//   [10 / 0] on: ZeroDivide do: [:e | e messageText]   "'division by zero'"
+//   [Error signal: 'no fuel'] on: Error do: [:e | e return: 0]   "0"
+//   Error subclass: #OutOfFuel [ ]
+//   [OutOfFuel new signal: 'empty'] on: Error do: [:e | e class]   "OutOfFuel"
+type Exception struct {
+	Class       *BuiltinClass // Error or one of its built-in subclasses (nil for Instance)
+	Instance    *Instance     // The signaled instance of a smog subclass of Error (nil otherwise)
+	MessageText string        // Description of what went wrong ("" if none was given)
+	Cause       error         // The runtime error it was made from, if any
+
+	handlerHome *VM // Where return: unwinds to while a handler runs (nil otherwise)
+}
+
+// String returns a printable description of the exception.
+func (e *Exception) String() string {
+	return withArticle(e.className())
+}
+
+// className answers the name of the exception's class.
+func (e *Exception) className() string {
+	if e.Instance != nil {
+		return e.Instance.Class.Name
+	}
+	return e.Class.Name
+}
+
+// value answers what a handler receives for the exception: the instance
+// for a smog subclass of Error, otherwise the Exception itself.
+func (e *Exception) value() interface{} {
+	if e.Instance != nil {
+		return e.Instance
+	}
+	return e
+}
+
+// description answers the class name and message text, as in
+// "Error: no fuel", or just the class name if there is no text.
+func (e *Exception) description() string {
+	if e.MessageText == "" {
+		return e.className()
+	}
+	return e.className() + ": " + e.MessageText
+}
+
+// SmogException is the error that carries a signaled Exception up the
+// call stack until an on:do: handler catches it. If nothing does, Run
+// returns it inside a RuntimeError, so an embedder can recover the
+// exception with errors.As.
+type SmogException struct {
+	Exception *Exception // The exception that was signaled
+}
+
+// Error implements the error interface.
+func (e *SmogException) Error() string {
+	return e.Exception.description()
+}
+
+// Unwrap returns the runtime error the exception was made from, so a
+// ZeroDivide passed on by a handler is still a *ZeroDivideError to
+// errors.As.
+func (e *SmogException) Unwrap() error {
+	return e.Exception.Cause
+}
+
+// exceptionParent answers the name of the superclass of the exception
+// class called name, looking at the classes defined in smog before the
+// built-in ones. ok is false when name is not a class.
+func (vm *VM) exceptionParent(name string) (parent string, ok bool) {
+	if class, defined := vm.classes[name]; defined {
+		return class.SuperClass, true
+	}
+	parent, ok = exceptionParents[name]
+	return parent, ok
+}
+
+// inheritsFrom reports whether the class called name is ancestor or one
+// of its subclasses. Chains of superclasses longer than the number of
+// classes there are can only be cycles, so they stop the walk.
+func (vm *VM) inheritsFrom(name, ancestor string) bool {
+	limit := len(vm.classes) + len(exceptionParents) + 1
+	for i := 0; name != "" && i < limit; i++ {
+		if name == ancestor {
+			return true
+		}
+		parent, ok := vm.exceptionParent(name)
+		if !ok {
+			return false
+		}
+		name = parent
+	}
+	return false
+}
+
+// exceptionClassName answers the name of class if it is Error or one of
+// its subclasses, built in or defined in smog, and false otherwise.
+func (vm *VM) exceptionClassName(class interface{}) (string, bool) {
+	var name string
+	switch c := class.(type) {
+	case *BuiltinClass:
+		name = c.Name
+	case *bytecode.ClassDefinition:
+		name = c.Name
+	default:
+		return "", false
+	}
+	return name, vm.inheritsFrom(name, "Error")
+}
+
+// exceptionOf answers the exception state of an instance of a smog
+// subclass of Error, creating it the first time, or nil for an instance
+// of any other class.
+func (vm *VM) exceptionOf(instance *Instance) *Exception {
+	if instance.exception == nil && vm.inheritsFrom(instance.Class.Name, "Error") {
+		instance.exception = &Exception{Instance: instance}
+	}
+	return instance.exception
+}
+
+// handles reports whether a handler for the class called name catches e:
+// whether e's class is that class or inherits from it.
+func (vm *VM) handles(e *Exception, name string) bool {
+	return vm.inheritsFrom(e.className(), name)
+}
+
+// exceptionFrom answers the exception a handler sees for err, or false if
+// err isn't one a handler can catch. A ^ leaving a block and malformed
+// bytecode pass through every handler.
+//
+// A timeout is caught as a Timeout once valueWithTimeout: has stopped the
+// block and reported it. Inside the block the deadline passes through
+// every handler, so a handler there can't keep the block running.
+//
+// Example:
+//   [[[true] whileTrue] valueWithTimeout: 50] on: Timeout do: [:e | 'too slow']
+func exceptionFrom(err error) (*Exception, bool) {
+	var signaled *SmogException
+	if errors.As(err, &signaled) {
+		return signaled.Exception, true
+	}
+	var invariant *InvariantError
+	if _, ok := err.(*NonLocalReturn); ok || errors.As(err, &invariant) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return nil, false
+	}
+
+	message := err.Error()
+	var rtErr *RuntimeError
+	if errors.As(err, &rtErr) {
+		message = rtErr.Message
+	}
+	class := builtinClasses["Error"]
+	var zeroDivide *ZeroDivideError
+	var timeout *TimeoutError
+	if errors.As(err, &zeroDivide) {
+		class = builtinClasses["ZeroDivide"]
+	} else if errors.As(err, &timeout) {
+		class = builtinClasses["Timeout"]
+	}
+	return &Exception{Class: class, MessageText: message, Cause: err}, true
+}
+
+// signal raises e, for signal and signal:. text replaces the message text
+// unless it is nil.
+func signal(e *Exception, selector string, args []interface{}) error {
+	if len(args) > 0 {
+		text, ok := args[0].(string)
+		if !ok {
+			return fmt.Errorf("%s argument must be a String, got %s", selector, describeValue(args[0]))
+		}
+		e.MessageText = text
+	}
+	return &SmogException{Exception: e}
+}
+
+// sendExceptionClass handles the class-side messages of Error and its
+// built-in subclasses: new answers an exception to signal later, and
+// signal and signal: raise a new one straight away.
+func (vm *VM) sendExceptionClass(class *BuiltinClass, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "new":
+		return &Exception{Class: class}, true, nil
+	case "signal", "signal:":
+		return nil, true, signal(&Exception{Class: class}, selector, args)
+	}
+	return nil, false, nil
+}
+
+// sendExceptionSubclass handles signal and signal: sent to a smog
+// subclass of Error that doesn't define them itself, signaling a new
+// instance, made the way new makes one.
+func (vm *VM) sendExceptionSubclass(class *bytecode.ClassDefinition, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "signal", "signal:":
+		instance, err := vm.newInstance(class)
+		if err != nil {
+			return nil, true, err
+		}
+		return nil, true, signal(vm.exceptionOf(instance), selector, args)
+	}
+	return nil, false, nil
+}
+
+// sendException handles messages sent to an Exception, and the messages
+// an instance of a smog subclass of Error inherits from Error.
+func (vm *VM) sendException(e *Exception, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "messageText":
+		// Like description when no text was given
+		if e.MessageText == "" {
+			return e.description(), true, nil
+		}
+		return e.MessageText, true, nil
+	case "messageText:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("messageText: expects 1 argument, got %d", len(args))
+		}
+		text, ok := args[0].(string)
+		if !ok {
+			return nil, true, fmt.Errorf("messageText: argument must be a String, got %s", describeValue(args[0]))
+		}
+		e.MessageText = text
+		return e, true, nil
+	case "description":
+		return e.description(), true, nil
+	case "class":
+		if e.Instance != nil {
+			return e.Instance.Class, true, nil
+		}
+		return e.Class, true, nil
+	case "signal", "signal:", "pass":
+		// pass signals the exception again, for a handler further out
+		return nil, true, signal(e, selector, args)
+	case "return", "return:":
+		// Leave the handler, making on:do: answer the argument
+		var value interface{}
+		if selector == "return:" {
+			if len(args) != 1 {
+				return nil, true, fmt.Errorf("return: expects 1 argument, got %d", len(args))
+			}
+			value = args[0]
+		}
+		if e.handlerHome == nil {
+			return nil, true, fmt.Errorf("%s sent to %s outside its handler", selector, e)
+		}
+		return nil, true, &NonLocalReturn{Value: value, HomeContext: e.handlerHome}
+	}
+	return nil, false, nil
+}
+
+// onDo runs block for on:do:, catching the exceptions of the class args[0]
+// names, or of its subclasses, with the handler block args[1]. The class
+// can be a built-in one or a subclass of Error defined in smog.
+//
+// The handler receives the exception, if it takes an argument, and what it
+// answers is the answer of on:do:; return: leaves the handler early with
+// its argument instead. Other exceptions go on up the call stack to the
+// next handler. The block has already been left by the time the handler
+// runs, so the ensure: blocks inside it have run and the block can't be
+// resumed.
+//
+// Example:
+//   [10 / 0] on: ZeroDivide do: [:e | e messageText]   "'division by zero'"
+//   [#() first] on: ZeroDivide do: [:e | 0]            "fails: not a ZeroDivide"
+//   [Error signal: 'a'] on: Error do: [:e | e return: 1. 2]   "1"
+func (vm *VM) onDo(block *Block, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("on:do: expects 2 arguments, got %d", len(args))
+	}
+	class, ok := vm.exceptionClassName(args[0])
+	if !ok {
+		return nil, fmt.Errorf("on:do: first argument must be an exception class, got %s", describeValue(args[0]))
+	}
+	handler, ok := args[1].(*Block)
+	if !ok {
+		return nil, fmt.Errorf("on:do: handler must be a block")
+	}
+	if handler.ParamCount > 1 {
+		return nil, fmt.Errorf("on:do: handler block must take 0 or 1 argument(s), got %d", handler.ParamCount)
+	}
+	if block.ParamCount != 0 {
+		return nil, fmt.Errorf("on:do: receiver must be a block with no arguments, got %d", block.ParamCount)
+	}
+
+	result, err := vm.executeBlock(block, []interface{}{})
+	if err == nil {
+		return result, nil
+	}
+	e, ok := exceptionFrom(err)
+	if !ok || !vm.handles(e, class) {
+		return nil, err
+	}
+
+	// return: unwinds to a home of its own, which no method or block
+	// shares, the way a ^ unwinds to its method
+	home := &VM{}
+	outer := e.handlerHome
+	e.handlerHome = home
+	defer func() { e.handlerHome = outer }()
+
+	handlerArgs := []interface{}{}
+	if handler.ParamCount == 1 {
+		handlerArgs = append(handlerArgs, e.value())
+	}
+	result, err = vm.executeBlock(handler, handlerArgs)
+	if nlr, ok := err.(*NonLocalReturn); ok && nlr.HomeContext == home {
+		return nlr.Value, nil
+	}
+	return result, err
+}
@@ -0,0 +1,159 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestOnDoCatchesMatchingExceptions tests that on:do: answers the
+// handler's value for exceptions of the class it names or a subclass,
+// including runtime errors
+func TestOnDoCatchesMatchingExceptions(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"[10 / 0] on: Error do: [:e | e messageText]", "division by zero"},
+		{"[10 / 0] on: ZeroDivide do: [:e | e description]", "ZeroDivide: division by zero"},
+		{"[10 // 0] on: ZeroDivide do: [:e | e class]", builtinClasses["ZeroDivide"]},
+		{"[Error signal: 'no fuel'] on: Error do: [:e | e messageText]", "no fuel"},
+		{"[Error new signal: 'no fuel'] on: Error do: [:e | e description]", "Error: no fuel"},
+		{"[Error signal] on: Error do: [:e | e messageText]", "Error"},
+		{"[ZeroDivide signal: 'made up'] on: Error do: [:e | e messageText]", "made up"},
+		{"[nil foo] on: Error do: [:e | e messageText]",
+			"nil does not understand 'foo' (the receiver was nil; was a variable used before it was assigned?)"},
+		{"[#(1 2) at: 5] on: Error do: [:e | e messageText]", "array index out of bounds: 5"},
+		// A handler without an argument, and no exception at all
+		{"[1 / 0] on: ZeroDivide do: ['caught']", "caught"},
+		{"[6 / 2] on: ZeroDivide do: [:e | 0]", int64(3)},
+		// return: leaves the handler with its argument
+		{"[Error signal: 'a'] on: Error do: [:e | e return: 1. 2]", int64(1)},
+		{"[Error signal: 'a'] on: Error do: [:e | #(1 2) do: [:x | e return: x * 10]. 0]", int64(10)},
+		{"[Error signal: 'a'] on: Error do: [:e | e return]", nil},
+		// Signaled from inside a method, several calls deep
+		{`Object subclass: #Tank [
+    | fuel |
+    burn: n [ fuel := 0. ^self check: n ]
+    check: n [ n > fuel ifTrue: [^Error signal: 'no fuel']. ^n ]
+]
+[Tank new burn: 5] on: Error do: [:e | e messageText]`, "no fuel"},
+		// The protected block's ensure: runs before the handler
+		{"| log | log := ''. [[Error signal: 'x'] ensure: [log := log , 'ensure ']] on: Error do: [:e | log := log , 'handler']. log",
+			"ensure handler"},
+		// A ^ in the protected block isn't an exception
+		{`Object subclass: #Early [ find [ [^'found'] on: Error do: [:e | ^'handled']. ^'missed' ] ]
+Early new find`, "found"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestOnDoPropagatesOtherExceptions tests that exceptions a handler
+// doesn't name, and those signaled again with pass, reach the next
+// handler out or stop the program
+func TestOnDoPropagatesOtherExceptions(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"[[Error signal: 'inner'] on: ZeroDivide do: [:e | 'wrong']] on: Error do: [:e | 'outer ' , e messageText]",
+			"outer inner"},
+		{"[[1 / 0] on: ZeroDivide do: [:e | e pass]] on: Error do: [:e | e description]",
+			"ZeroDivide: division by zero"},
+		{"[[1 / 0] on: ZeroDivide do: [:e | e signal: 'again']] on: ZeroDivide do: [:e | e messageText]", "again"},
+		// An error in the handler itself is a new exception
+		{"[[1 / 0] on: ZeroDivide do: [:e | nil bar]] on: Error do: [:e | e class]", builtinClasses["Error"]},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "[Error signal: 'no fuel'] on: ZeroDivide do: [:e | 'wrong']. 'not reached'")
+	var signaled *SmogException
+	if !errors.As(err, &signaled) || signaled.Exception.MessageText != "no fuel" {
+		t.Fatalf("Expected the exception to stop the program, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "Error: no fuel") {
+		t.Errorf("Expected the error to describe the exception, got %q", err.Error())
+	}
+
+	// A ZeroDivide passed on is still a ZeroDivideError to an embedder
+	err = runSourceError(t, "[1 / 0] on: ZeroDivide do: [:e | e pass]")
+	var zeroDivide *ZeroDivideError
+	if !errors.As(err, &zeroDivide) {
+		t.Errorf("Expected a ZeroDivideError, got %v", err)
+	}
+
+	// A division by zero with no handler is unchanged
+	err = runSourceError(t, "1 / 0")
+	if errors.As(err, &signaled) || !errors.As(err, &zeroDivide) {
+		t.Errorf("Expected a plain ZeroDivideError, got %v", err)
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"[1] on: 'Error' do: [:e | e]", "on:do: first argument must be an exception class, got a String"},
+		{"[1] on: Set do: [:e | e]", "on:do: first argument must be an exception class, got the class Set"},
+		{"[1] on: Error do: 5", "on:do: handler must be a block"},
+		{"[1] on: Error do: [:a :b | a]", "on:do: handler block must take 0 or 1 argument(s), got 2"},
+		{"| saved | [Error signal] on: Error do: [:e | saved := e]. saved return: 1", "return: sent to an Error outside its handler"},
+		{"Error signal: #(1)", "signal: argument must be a String, got an Array"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestOnDoCatchesSmogSubclassesOfError tests that instances of classes
+// defined with "Error subclass:" are signaled and caught like the
+// built-in exceptions, and that their handler receives the instance
+func TestOnDoCatchesSmogSubclassesOfError(t *testing.T) {
+	classes := `
+Error subclass: #MyError [
+    | code |
+    code [ ^code ]
+    code: n [ code := n ]
+]
+MyError subclass: #SubError [ ]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"[MyError new signal: 'x'] on: Error do: [:e | e messageText]", "x"},
+		{"[MyError new signal: 'x'] on: MyError do: [:e | e messageText]", "x"},
+		{"[MyError signal: 'x'] on: MyError do: [:e | e description]", "MyError: x"},
+		{"[(MyError new code: 7; yourself) signal] on: MyError do: [:e | e code]", int64(7)},
+		{"[SubError signal: 'y'] on: MyError do: [:e | e class printString]", "SubError"},
+		{"[MyError signal: 'z'] on: MyError do: [:e | e return: 5. 6]", int64(5)},
+		// Handlers for other classes let it through
+		{"[[MyError signal: 'z'] on: ZeroDivide do: [:e | 1]] on: MyError do: [:e | 2]", int64(2)},
+		{"[[MyError signal: 'z'] on: SubError do: [:e | 1]] on: Error do: [:e | 2]", int64(2)},
+		{"[[1 / 0] on: MyError do: [:e | 1]] on: ZeroDivide do: [:e | 2]", int64(2)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, classes+"MyError signal: 'unhandled'")
+	if err == nil || !strings.HasPrefix(err.Error(), "MyError: unhandled") {
+		t.Errorf("Expected the unhandled exception to stop the program, got %v", err)
+	}
+	err = runSourceError(t, "Object subclass: #Plain [ ]\n[1] on: Plain do: [:e | e]")
+	if err == nil || !strings.Contains(err.Error(), "on:do: first argument must be an exception class") {
+		t.Errorf("Expected a class that isn't an Error to be rejected, got %v", err)
+	}
+}
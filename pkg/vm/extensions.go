@@ -0,0 +1,164 @@
+// Package vm - class extensions
+package vm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// extensibleTypes are the built-in types "Name extend [ ... ]" can add
+// methods to, beyond the classes defined in smog code.
+var extensibleTypes = map[string]bool{
+	"UndefinedObject": true, "Boolean": true, "Integer": true, "Float": true,
+	"Fraction": true, "String": true, "Array": true, "Block": true,
+	"ByteArray": true, "Bag": true, "Set": true, "IdentitySet": true,
+	"Dictionary": true, "IdentityDictionary": true, "Point": true,
+	"Interval": true, "Hasher": true, "WriteStream": true, "ReadStream": true, "Context": true,
+}
+
+// typeName answers the name of the built-in type of value, as written in
+// an extension, or "" for an Instance or a class, whose methods are found
+// through their class instead.
+func typeName(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "UndefinedObject"
+	case bool:
+		return "Boolean"
+	case int64:
+		return "Integer"
+	case float64:
+		return "Float"
+	case *big.Rat:
+		return "Fraction"
+	case string:
+		return "String"
+	case *Array:
+		return "Array"
+	case *Block:
+		return "Block"
+	case *ByteArray:
+		return "ByteArray"
+	case *Bag:
+		return "Bag"
+	case *Set:
+		return val.className()
+	case *Dictionary:
+		return val.className()
+	case map[interface{}]interface{}:
+		return "Dictionary"
+	case Point:
+		return "Point"
+	case Interval:
+		return "Interval"
+	case *Hasher:
+		return "Hasher"
+	case *WriteStream:
+		return "WriteStream"
+	case *ReadStream:
+		return "ReadStream"
+	case *Context:
+		return "Context"
+	}
+	return ""
+}
+
+// extendClass adds the methods of an extension to the class it names.
+//
+// A class defined in smog code gets the methods directly, so its
+// instances and subclasses find them like any other method. A built-in
+// type keeps them in vm.extensions, where send() looks before trying the
+// type's primitives. Either way a method replaces one with the same
+// selector that an earlier extension or the class itself defined.
+func (vm *VM) extendClass(extension *bytecode.ClassDefinition) error {
+	if class, ok := vm.classes[extension.Name]; ok {
+		class.Methods = mergeMethods(class.Methods, extension.Methods)
+		vm.layoutTable().invalidate()
+		return nil
+	}
+	if !extensibleTypes[extension.Name] {
+		return fmt.Errorf("cannot extend %s: no class or built-in type has that name", extension.Name)
+	}
+	if vm.extensions == nil {
+		vm.extensions = make(map[string]*bytecode.ClassDefinition)
+	}
+	class, ok := vm.extensions[extension.Name]
+	if !ok {
+		class = &bytecode.ClassDefinition{
+			Name:           extension.Name,
+			ClassVarValues: make(map[string]interface{}),
+		}
+		vm.extensions[extension.Name] = class
+	}
+	class.Methods = mergeMethods(class.Methods, extension.Methods)
+	return nil
+}
+
+// mergeMethods answers methods with added appended, each replacing any
+// method of the same selector.
+func mergeMethods(methods, added []*bytecode.MethodDefinition) []*bytecode.MethodDefinition {
+	merged := append([]*bytecode.MethodDefinition{}, methods...)
+	for _, method := range added {
+		replaced := false
+		for i, existing := range merged {
+			if existing.Selector == method.Selector {
+				merged[i] = method
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, method)
+		}
+	}
+	return merged
+}
+
+// copyClass answers a copy of class that can be changed without changing
+// class: its method lists and class variable values are its own. The
+// methods themselves are shared, since nothing changes a compiled method.
+func copyClass(class *bytecode.ClassDefinition) *bytecode.ClassDefinition {
+	copied := *class
+	copied.Methods = append([]*bytecode.MethodDefinition{}, class.Methods...)
+	copied.ClassMethods = append([]*bytecode.MethodDefinition{}, class.ClassMethods...)
+	copied.ClassVarValues = make(map[string]interface{}, len(class.ClassVarValues))
+	for name, value := range class.ClassVarValues {
+		copied.ClassVarValues[name] = value
+	}
+	return &copied
+}
+
+// hasExtension reports whether a method added to the built-in type called
+// name has selector, so a fast path that skips send() can tell when it has
+// to send the message after all.
+func (vm *VM) hasExtension(name, selector string) bool {
+	class, ok := vm.extensions[name]
+	if !ok {
+		return false
+	}
+	for _, method := range class.Methods {
+		if method.Selector == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// sendExtension runs the extension method for selector on a built-in
+// receiver. The handled result is false when the receiver's type has no
+// such method, letting send() go on to the primitives.
+func (vm *VM) sendExtension(receiver interface{}, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	class, ok := vm.extensions[typeName(receiver)]
+	if !ok {
+		return nil, false, nil
+	}
+	for _, method := range class.Methods {
+		if method.Selector == selector {
+			result, err := vm.runMethod(receiver, class, method, args)
+			return result, true, err
+		}
+	}
+	return nil, false, nil
+}
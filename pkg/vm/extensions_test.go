@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLookupOrder tests that every built-in type resolves a message to a
+// user method first, then a primitive, then doesNotUnderstand
+func TestLookupOrder(t *testing.T) {
+	prefix := `Integer extend [ double [ ^self * 2 ] printString [ ^'mine' ] ]
+String extend [ shout [ ^self , '!' ] size [ ^0 ] ]
+Array extend [ second [ ^self at: 2 ] size [ ^'big' ] ]
+Boolean extend [ toggled [ ^self = false ] ]
+UndefinedObject extend [ orZero [ ^0 ] ]
+Block extend [ twice [ self value. ^self value ] ]
+ByteArray extend [ first [ ^self at: 1 ] ]
+Set extend [ isSet [ ^true ] ]
+Dictionary extend [ isSet [ ^false ] ]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Only a primitive
+		{"3 + 4", int64(7)},
+		{"'abc' , 'd'", "abcd"},
+		{"#(1 2 3) at: 1", int64(1)},
+		// Only a user method
+		{"3 double", int64(6)},
+		{"'hey' shout", "hey!"},
+		{"#(1 2 3) second", int64(2)},
+		{"true toggled", false},
+		{"nil orZero", int64(0)},
+		{"| n | n := 0. [n := n + 1] twice", int64(2)},
+		{"'hi' asByteArray first", int64(104)},
+		{"Set new isSet", true},
+		{"#{1 -> 2} isSet", false},
+		// Both, and the user method wins
+		{"3 printString", "mine"},
+		{"'hello' size", int64(0)},
+		{"#(1 2 3) size", "big"},
+		// Extension methods are found from blocks and methods too
+		{"#(1 2 3) collect: [:x | x double]", &Array{Elements: []interface{}{int64(2), int64(4), int64(6)}}},
+		{"Object subclass: #Doubler [ run: n [ ^n double ] ]\nDoubler new run: 5", int64(10)},
+		// Other types keep their own primitives
+		{"2.5 printString", "2.5"},
+		{"'abc' asByteArray size", int64(3)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, prefix+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"3 triple", "unknown message: triple"},
+		{"2.5 double", "unknown message: double"},
+		{"#(1 2) third", "unknown message: third"},
+		{"nil orOne", "nil does not understand"},
+		{"3 double: 2", "unknown message: double:"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, prefix+tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestExtensionsBeatFastPaths tests that redefining , for strings or
+// value for blocks takes effect where the compiler would otherwise fold
+// string literals or call a block literal directly
+func TestExtensionsBeatFastPaths(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"String extend [ , other [ ^'joined' ] ]\n'a' , 'b'", "joined"},
+		// The extension may come after the code it affects
+		{"| s | s := 'a' , 'b'.\nString extend [ , other [ ^'joined' ] ]\ns", "joined"},
+		{"Block extend [ value [ ^'mine' ] ]\n[3] value", "mine"},
+		{"Block extend [ value: x [ ^x * 100 ] ]\n[:x | x] value: 2", int64(200)},
+		// Other value messages still call the block directly
+		{"Block extend [ value [ ^'mine' ] ]\n[:x | x + 1] value: 2", int64(3)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestLookupOrderInstances tests that instances resolve messages in the
+// same order as built-in types
+func TestLookupOrderInstances(t *testing.T) {
+	prefix := "Object subclass: #Thing [ size [ ^42 ] own [ ^'own' ] ]\n"
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"Thing new printString", "a Thing"},
+		{"Thing new own", "own"},
+		{"Thing new size", int64(42)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, prefix+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+	if err := runSourceError(t, prefix+"Thing new bogus"); err == nil || !strings.Contains(err.Error(), "does not understand message 'bogus'") {
+		t.Errorf("Expected a does not understand error, got %v", err)
+	}
+}
+
+func TestClassExtensions(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// super in an extension method reaches the primitive it overrides
+		{"Array extend [ size [ | n | n := super size. ^n * 10 ] ]\n#(1 2) size", int64(20)},
+		{"Integer extend [ printString [ | s | s := super printString. ^'#', s ] ]\n7 printString", "#7"},
+		// A later extension replaces an earlier method of the same name
+		{"Integer extend [ one [ ^1 ] ]\nInteger extend [ one [ ^'one' ] ]\n5 one", "one"},
+		// A class defined in smog code gets the methods, with its fields
+		{"Object subclass: #Counter [ | count | count [ ^count ] ]\nCounter extend [ bump [ count := 5 ] ]\nCounter new bump count", int64(5)},
+		// Subclasses inherit them, even when the extension comes first
+		{"Counter extend [ answer [ ^42 ] ]\nObject subclass: #Counter [ ]\nCounter subclass: #Sub [ ]\nSub new answer", int64(42)},
+		// Prelude classes can be extended too
+		{"OrderedCollection extend [ second [ ^self at: 2 ] ]\n(OrderedCollection new add: 1; add: 2; yourself) second", int64(2)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "Nonesuch extend [ foo [ ^1 ] ]\n3")
+	if err == nil || !strings.Contains(err.Error(), "cannot extend Nonesuch: no class or built-in type has that name") {
+		t.Errorf("Expected an error extending an unknown class, got %v", err)
+	}
+	// super reaches the primitives even when the extension method is
+	// called from inside another class's method
+	err = runSourceError(t, "Object subclass: #A [ go [ ^3 foo ] ]\nInteger extend [ foo [ | n | n := super bar. ^n ] ]\nA new go")
+	if err == nil || !strings.Contains(err.Error(), "unknown message: bar") {
+		t.Errorf("Expected super to reach the primitives, got %v", err)
+	}
+}
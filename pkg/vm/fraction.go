@@ -0,0 +1,118 @@
+// Package vm - Fraction value type
+package vm
+
+import (
+	"math/big"
+)
+
+// Fractions are exact rationals written as literals like 1/2 and
+// represented at runtime as *big.Rat values in lowest terms.
+//
+// Arithmetic between fractions and integers stays exact. A result that
+// reduces to a whole number becomes a plain integer again, so 1/2 + 1/2
+// is the Integer 1, not the Fraction 1/1.
+//
+// Fraction values are never mutated: every operation allocates a new
+// big.Rat, so literals can safely share the constant in the pool.
+//
+// Example:
+//   1/2 + 1/3        "5/6"
+//   3/4 * 4          "3"
+//   (2/3) numerator  "2"
+//   1/3 < 1/2        "true"
+
+// normalizeFraction returns r as an int64 if it is a whole number that
+// fits, and as a *big.Rat otherwise.
+func normalizeFraction(r *big.Rat) interface{} {
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return r
+}
+
+// isFraction reports whether v is a Fraction.
+func isFraction(v interface{}) bool {
+	_, ok := v.(*big.Rat)
+	return ok
+}
+
+// fractionOperands converts a and b to rationals for exact arithmetic.
+// It succeeds only when at least one side is a Fraction and the other is
+// a Fraction or an Integer; floats and other types are left to the
+// ordinary numeric operations.
+func fractionOperands(a, b interface{}) (x, y *big.Rat, ok bool) {
+	if !isFraction(a) && !isFraction(b) {
+		return nil, nil, false
+	}
+	x, okA := toRat(a)
+	y, okB := toRat(b)
+	return x, y, okA && okB
+}
+
+// toRat converts an int64 or *big.Rat to a *big.Rat.
+func toRat(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case *big.Rat:
+		return n, true
+	}
+	return nil, false
+}
+
+// fractionArithmetic applies an exact +, -, * or / to a and b. It returns
+// handled=false if neither operand is a Fraction.
+func fractionArithmetic(selector string, a, b interface{}) (result interface{}, handled bool, err error) {
+	x, y, ok := fractionOperands(a, b)
+	if !ok {
+		return nil, false, nil
+	}
+	r := new(big.Rat)
+	switch selector {
+	case "+":
+		r.Add(x, y)
+	case "-":
+		r.Sub(x, y)
+	case "*":
+		r.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return nil, true, &ZeroDivideError{Selector: "/", Dividend: a}
+		}
+		r.Quo(x, y)
+	default:
+		return nil, false, nil
+	}
+	return normalizeFraction(r), true, nil
+}
+
+// fractionCompare compares a and b exactly, returning -1, 0 or +1. It
+// returns handled=false if neither operand is a Fraction.
+func fractionCompare(a, b interface{}) (cmp int, handled bool) {
+	x, y, ok := fractionOperands(a, b)
+	if !ok {
+		return 0, false
+	}
+	return x.Cmp(y), true
+}
+
+// sendFraction handles messages sent to a Fraction.
+func (vm *VM) sendFraction(r *big.Rat, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "numerator":
+		return normalizeFraction(new(big.Rat).SetInt(r.Num())), true, nil
+	case "denominator":
+		return normalizeFraction(new(big.Rat).SetInt(r.Denom())), true, nil
+	case "asFloat":
+		f, _ := r.Float64()
+		return f, true, nil
+	case "reciprocal":
+		if r.Sign() == 0 {
+			return nil, true, &ZeroDivideError{Selector: "reciprocal", Dividend: int64(1)}
+		}
+		return normalizeFraction(new(big.Rat).Inv(r)), true, nil
+	case "negated":
+		return normalizeFraction(new(big.Rat).Neg(r)), true, nil
+	}
+	return nil, false, nil
+}
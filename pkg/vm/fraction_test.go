@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// Fractions are written as literals such as 1/2r, or come from dividing
+// integers with exact division. Most of these tests divide, so they run
+// with DivideExact; / is an ordinary binary message, so those fractions
+// are parenthesized wherever precedence matters.
+
+// TestFractionLiterals tests fraction literals, which are exact in every
+// division mode
+func TestFractionLiterals(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`1/2r + 1/3r`, "5/6"},
+		{`10/4r`, "5/2"},
+		{`-3/4r * 2`, "-3/2"},
+		{`1/2r / 3`, "1/6"},
+		{`3 - 1/4r`, "11/4"},
+		{`(2/3r) printString`, "2/3"},
+		{`4/2r`, "2"},
+	}
+
+	for _, mode := range []DivisionMode{DivideTruncate, DivideExact} {
+		for _, tt := range tests {
+			var got string
+			switch r := runWithDivision(t, mode, tt.source).(type) {
+			case *big.Rat:
+				got = r.String()
+			case int64:
+				got = big.NewRat(r, 1).RatString()
+			case string:
+				got = r
+			default:
+				t.Errorf("mode %d, %s: expected a number, got %T", mode, tt.source, r)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("mode %d, %s: expected %s, got %s", mode, tt.source, tt.expected, got)
+			}
+		}
+	}
+
+	// A literal is the same Fraction that exact division answers
+	if result := runWithDivision(t, DivideExact, `1/2r = (1 / 2)`); result != true {
+		t.Errorf("Expected 1/2r = (1 / 2), got %v", result)
+	}
+	// Without the r, 1/2 is still division
+	if result := runSource(t, `1/2`); result != int64(0) {
+		t.Errorf("Expected 1/2 to divide, got %v", result)
+	}
+}
+
+// TestFractionArithmetic tests exact rational arithmetic
+func TestFractionArithmetic(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`(1/2) + (1/3)`, "5/6"},
+		{`(1/2) - (1/3)`, "1/6"},
+		{`(2/3) * (3/4)`, "1/2"},
+		{`(1/2) / (1/4)`, "2"},
+		{`(1/2) + 1`, "3/2"},
+		{`1 - (1/4)`, "3/4"},
+		{`3 * 1/4`, "3/4"},
+		{`(1/2) / 3`, "1/6"},
+		{`1/2/3`, "1/6"},
+		{`(-1/2) + (1/4)`, "-1/4"},
+		{`7 - (-1/2)`, "15/2"},
+		// Left to right, like every binary message
+		{`1/2 + 1/3`, "1/2"},
+	}
+
+	for _, tt := range tests {
+		result := runWithDivision(t, DivideExact, tt.source)
+		var got string
+		switch r := result.(type) {
+		case *big.Rat:
+			got = r.String()
+		case int64:
+			got = big.NewRat(r, 1).RatString()
+		default:
+			t.Errorf("%s: expected a number, got %T", tt.source, result)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.source, tt.expected, got)
+		}
+	}
+}
+
+// TestFractionReduction tests that results are kept in lowest terms and
+// that whole results become integers
+func TestFractionReduction(t *testing.T) {
+	if result, ok := runWithDivision(t, DivideExact, `2/4`).(*big.Rat); !ok || result.String() != "1/2" {
+		t.Errorf("Expected 2/4 to reduce to 1/2, got %v", result)
+	}
+	if result := runWithDivision(t, DivideExact, `(6/8) numerator`); result != int64(3) {
+		t.Errorf("Expected numerator 3, got %v", result)
+	}
+	if result := runWithDivision(t, DivideExact, `(6/8) denominator`); result != int64(4) {
+		t.Errorf("Expected denominator 4, got %v", result)
+	}
+
+	// Whole numbers are integers, not fractions with denominator 1
+	for _, source := range []string{`4/2`, `(1/2) + (1/2)`, `(3/4) * 4`, `(1/3) reciprocal`} {
+		result := runWithDivision(t, DivideExact, source)
+		if _, ok := result.(int64); !ok {
+			t.Errorf("%s: expected an Integer, got %T (%v)", source, result, result)
+		}
+	}
+}
+
+// TestFractionComparison tests equality and ordering
+func TestFractionComparison(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`(1/3) < (1/2)`, true},
+		{`(1/2) > (2/3)`, false},
+		{`(1/2) <= (2/4)`, true},
+		{`(3/2) >= 1`, true},
+		{`(1/2) < 1`, true},
+		{`(2/4) = (1/2)`, true},
+		{`(1/2) = (1/3)`, false},
+		{`(1/2) ~= (1/3)`, true},
+		{`(1/2) isFraction`, true},
+		{`(1/2) isNumber`, true},
+		{`3 isFraction`, false},
+		{`(1/4) asFloat`, 0.25},
+		{`(1/2) negated = (-1/2)`, true},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestFractionErrors tests division by zero and mixing with floats
+func TestFractionErrors(t *testing.T) {
+	for _, source := range []string{
+		`(1/2) / 0`,
+		`(1/2) + 'a'`,
+		`(1/2) + 0.5`,
+	} {
+		if err := runWithDivisionError(t, DivideExact, source); err == nil {
+			t.Errorf("%s: expected error, got nil", source)
+		}
+	}
+
+	// 10/0 is a division like any other, so it fails when it runs, with
+	// the same error as 10 / 0
+	for _, mode := range []DivisionMode{DivideTruncate, DivideExact} {
+		var zeroDivide *ZeroDivideError
+		if err := runWithDivisionError(t, mode, `10/0`); !errors.As(err, &zeroDivide) {
+			t.Errorf("mode %d: expected a ZeroDivideError, got %v", mode, err)
+		}
+	}
+}
+
+// TestFractionInCollections tests that equal fractions are the same element
+func TestFractionInCollections(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`| b | b := Bag new. b add: 1/2; add: 2/4. b occurrencesOf: 1/2`, int64(2)},
+		{`#((1/2) (3/4)) = #((2/4) (6/8))`, true},
+		{`#{(1/2) -> 'half'} includesKey: (2/4)`, true},
+		// Elements are added with +, so Fractions stay exact
+		{`{1/2. 1/3} sum printString`, "5/6"},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
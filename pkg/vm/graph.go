@@ -0,0 +1,159 @@
+// Package vm - walking object graphs that may contain cycles
+package vm
+
+// identitySet records objects by identity while walking an object graph.
+//
+// Arrays, dictionaries and instances can refer to themselves, directly or
+// through other objects, so any operation that follows references —
+// printing, comparing, copying, generating JSON — keeps the objects it is
+// currently inside in an identitySet and stops when it meets one again.
+// The zero value is an empty set; the map is only allocated once
+// something is added, so walks over plain values cost nothing extra.
+type identitySet map[interface{}]bool
+
+// add records v and reports whether it was newly added. False means v is
+// already being visited: the walk has gone round a cycle.
+func (s *identitySet) add(v interface{}) bool {
+	if (*s)[v] {
+		return false
+	}
+	if *s == nil {
+		*s = make(identitySet)
+	}
+	(*s)[v] = true
+	return true
+}
+
+// remove forgets v once the walk has finished with it.
+func (s *identitySet) remove(v interface{}) {
+	delete(*s, v)
+}
+
+// comparisonPair identifies two objects being compared with =, so a
+// comparison that reaches the same pair again can be recognized.
+type comparisonPair struct {
+	a, b interface{}
+}
+
+// formatElement renders v inside a collection's String. Nested Arrays, Bags,
+// Sets and Dictionaries share path, so a collection that contains itself is
+// shown as "..." instead of being printed forever. Every other value prints
+// as formatValue prints it inside an Array.
+func formatElement(v interface{}, path *identitySet, limits printLimits) string {
+	switch c := v.(type) {
+	case *Dictionary:
+		return c.format(path, limits)
+	case *Bag:
+		return c.format(path, limits)
+	case *Set:
+		return c.format(path, limits)
+	}
+	return formatValue(v, path, limits)
+}
+
+// deepCopy answers a copy of v that shares no mutable structure with it.
+//
+// Arrays, Dictionaries, Bags, Sets and instances are copied along with
+// everything they refer to; other values (numbers, strings, blocks,
+// classes) are immutable or shared and are returned as they are. copies
+// maps each object already copied to its copy, so an object reached twice
+// is copied once and cycles in the original become the same cycles in
+// the copy.
+//
+// Example:
+//   | a b |
+//   a := {1. nil}.
+//   a at: 2 put: a.
+//   b := a deepCopy.
+//   (b at: 2) == b   "true"
+//   b == a           "false"
+func deepCopy(v interface{}, copies map[interface{}]interface{}) interface{} {
+	switch orig := v.(type) {
+	case *Array, *Dictionary, *Bag, *Set, *Instance:
+		if copied, ok := copies[orig]; ok {
+			return copied
+		}
+	}
+
+	switch orig := v.(type) {
+	case *Array:
+		result := &Array{Elements: make([]interface{}, len(orig.Elements))}
+		copies[orig] = result
+		for i, elem := range orig.Elements {
+			result.Elements[i] = deepCopy(elem, copies)
+		}
+		return result
+	case *Dictionary:
+		result := &Dictionary{table: orig.table.empty()}
+		copies[orig] = result
+		for i, key := range orig.table.keys {
+			result.AtPut(deepCopy(key, copies), deepCopy(orig.values[i], copies))
+		}
+		return result
+	case *Bag:
+		result := newBag()
+		copies[orig] = result
+		for i, key := range orig.table.keys {
+			result.Add(deepCopy(key, copies), orig.counts[i])
+		}
+		return result
+	case *Set:
+		result := &Set{table: orig.table.empty()}
+		copies[orig] = result
+		for _, key := range orig.table.keys {
+			result.table.insert(deepCopy(key, copies))
+		}
+		return result
+	case *Instance:
+		result := &Instance{Class: orig.Class, Fields: make([]interface{}, len(orig.Fields))}
+		copies[orig] = result
+		for i, field := range orig.Fields {
+			result.Fields[i] = deepCopy(field, copies)
+		}
+		return result
+	}
+	return v
+}
+
+// shallowCopy answers a new collection or instance holding the same
+// elements or field values as v, for copy and shallowCopy. Adding to or
+// removing from the copy leaves the original as it was, but the elements
+// themselves are shared, so mutating one is seen through both; deepCopy
+// copies those too. Values without mutable structure are answered as
+// they are.
+//
+// Example:
+//   | a b |
+//   a := {#(1 2). 3}.
+//   b := a copy.
+//   b at: 2 put: 4.        "a is still {#(1 2). 3}"
+//   (b at: 1) == (a at: 1) "true"
+func shallowCopy(v interface{}) interface{} {
+	switch orig := v.(type) {
+	case *Array:
+		return &Array{Elements: append([]interface{}{}, orig.Elements...)}
+	case *ByteArray:
+		return &ByteArray{Bytes: append([]byte{}, orig.Bytes...)}
+	case *Dictionary:
+		result := &Dictionary{table: orig.table.empty()}
+		for i, key := range orig.table.keys {
+			result.AtPut(key, orig.values[i])
+		}
+		return result
+	case *Bag:
+		result := newBag()
+		for i, key := range orig.table.keys {
+			result.Add(key, orig.counts[i])
+		}
+		return result
+	case *Set:
+		result := &Set{table: orig.table.empty()}
+		for _, key := range orig.table.keys {
+			result.table.insert(key)
+		}
+		return result
+	case *Instance:
+		return &Instance{Class: orig.Class, Fields: append([]interface{}{}, orig.Fields...)}
+	}
+	return v
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+// cycleSource builds two nodes that refer to each other and two arrays
+// that contain each other.
+const cycleSource = `
+Object subclass: #Node [
+    | value next |
+    value [ ^value ]
+    value: v [ value := v ]
+    next [ ^next ]
+    next: n [ next := n ]
+]
+| a b x y c d z |
+a := Node new. a value: 1.
+b := Node new. b value: 2.
+a next: b. b next: a.
+x := {1. nil}. y := {2. x}. x at: 2 put: y.
+`
+
+func TestDeepCopyPreservesCycles(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"c := a deepCopy. c == a", false},
+		{"c := a deepCopy. c next == b", false},
+		{"c := a deepCopy. c next next == c", true},
+		{"c := a deepCopy. c next value", int64(2)},
+		// The copy is independent of the original
+		{"c := a deepCopy. c next value: 99. b value", int64(2)},
+		{"c := x deepCopy. ((c at: 2) at: 2) == c", true},
+		{"c := x deepCopy. (c at: 2) == y", false},
+		{"d := #{'k' -> {1. 2}}. c := d deepCopy. (c at: 'k') at: 1 put: 9. (d at: 'k') at: 1", int64(1)},
+		// Values without mutable structure are answered as they are
+		{"'abc' deepCopy", "abc"},
+		{"3 deepCopy", int64(3)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, cycleSource+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+func TestCyclicStructuresTerminate(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"x printString", "#(1 #(2 #(...)))"},
+		{"y printString", "#(2 #(1 #(...)))"},
+		{"x = x deepCopy", true},
+		{"x = y", false},
+		{"d := #{'a' -> 1}. d at: 'me' put: d. d printString", "a Dictionary('a'->1 'me'->a Dictionary(...))"},
+		{"d := #{'a' -> 1}. d at: 'me' put: d. d = d deepCopy", true},
+		// A structure that is repeated but not cyclic prints in full
+		{"z := {1}. {z. z} printString", "#(#(1) #(1))"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, cycleSource+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, cycleSource+"nil jsonGenerate: x")
+	if err == nil || !strings.Contains(err.Error(), "cannot generate JSON for a cyclic structure") {
+		t.Errorf("Expected a cyclic structure error generating JSON, got %v", err)
+	}
+}
+
+func TestShallowCopy(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Changing the copy's membership leaves the original alone
+		{"c := x copy. c at: 1 put: 9. x at: 1", int64(1)},
+		{"c := {1. 2} copy. c , #(3). c size", int64(2)},
+		{"d := #{'a' -> 1}. c := d copy. c at: 'b' put: 2. d size", int64(1)},
+		{"d := #{'a' -> 1}. c := d copy. c at: 'a' put: 5. d at: 'a'", int64(1)},
+		{"z := Set new add: 1; yourself. c := z shallowCopy. c add: 2. z size", int64(1)},
+		{"z := Set new add: 1; yourself. c := z copy. c remove: 1. z includes: 1", true},
+		{"z := Bag new add: 1; add: 1; yourself. c := z copy. c add: 1. z occurrencesOf: 1", int64(2)},
+		{"c := (ByteArray fromHex: '0102') copy. c at: 1 put: 5. c", &ByteArray{Bytes: []byte{5, 2}}},
+		{"c := a copy. c value: 99. a value", int64(1)},
+		// The elements are shared, not copied
+		{"c := x copy. c == x", false},
+		{"c := x copy. (c at: 2) == y", true},
+		{"d := #{'k' -> {1. 2}}. c := d copy. (c at: 'k') at: 1 put: 9. (d at: 'k') at: 1", int64(9)},
+		{"c := a shallowCopy. c next == b", true},
+		// Values without mutable structure are answered as they are
+		{"'abc' copy", "abc"},
+		{"3 shallowCopy", int64(3)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, cycleSource+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestOrderedCollectionCopy tests that a copy of the prelude's
+// OrderedCollection gets its own storage
+func TestOrderedCollectionCopy(t *testing.T) {
+	setUp := `
+| a b e |
+e := {7}.
+a := OrderedCollection new. a add: e; add: 2.
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"b := a copy. b add: 3. a size", int64(2)},
+		{"b := a copy. b removeFirst. a first == e", true},
+		{"b := a shallowCopy. b at: 2 put: 5. a at: 2", int64(2)},
+		{"b := a copy. b first == e", true},
+		{"b := a copy. b size", int64(2)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, setUp+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
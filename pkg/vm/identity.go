@@ -0,0 +1,71 @@
+// Package vm - identity hashes
+package vm
+
+import (
+	"reflect"
+)
+
+// identityHashes hands out the numbers answered by identityHash.
+//
+// Each object gets the next number the first time it is asked for, so a
+// run of a program always assigns the same numbers, and an object keeps
+// its number for as long as the VM exists. Objects are keyed the way ==
+// compares them: arrays, instances and other reference objects by
+// pointer, and numbers, strings, booleans and nil by value, so two values
+// are == exactly when their identity hashes are equal.
+//
+// The table holds on to every object that has been hashed, so hashing
+// many short-lived objects keeps them from being collected.
+type identityHashes struct {
+	hashes map[interface{}]int64
+	next   int64
+}
+
+// mapIdentity keys a Go map by its address; maps can't be map keys.
+type mapIdentity uintptr
+
+// identityKey answers a Go map key that two values share exactly when
+// they are ==: the value itself, or the address of a Go map.
+func identityKey(v interface{}) interface{} {
+	if isMap(v) {
+		return mapIdentity(reflect.ValueOf(v).Pointer())
+	}
+	return v
+}
+
+// hashOf answers v's identity hash, assigning one if v has none yet.
+func (h *identityHashes) hashOf(v interface{}) int64 {
+	key := identityKey(v)
+	if hash, ok := h.hashes[key]; ok {
+		return hash
+	}
+	if h.hashes == nil {
+		h.hashes = make(map[interface{}]int64)
+	}
+	h.next++
+	h.hashes[key] = h.next
+	return h.next
+}
+
+// identityTable answers the VM's identity hash table, creating it for
+// VMs not made with New. Blocks and methods share their caller's table.
+func (vm *VM) identityTable() *identityHashes {
+	if vm.identities == nil {
+		vm.identities = &identityHashes{}
+	}
+	return vm.identities
+}
+
+// identityHash answers the identityHash message: a positive integer that
+// is the same every time it is asked of the same object and different
+// for objects that aren't ==. Unlike hash, which equal values share,
+// two equal but distinct arrays have different identity hashes.
+//
+// Example:
+//   | a |
+//   a := {1. 2}.
+//   a identityHash = a identityHash         "true"
+//   a identityHash = {1. 2} identityHash    "false"
+func (vm *VM) identityHash(v interface{}) int64 {
+	return vm.identityTable().hashOf(v)
+}
@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"testing"
+)
+
+func TestIdentityHash(t *testing.T) {
+	classes := `
+Object subclass: #Node [
+    hashFrom: aBlock [ ^aBlock value ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// The same object answers the same number every time
+		{"| a | a := {1. 2}. a identityHash = a identityHash", true},
+		{"| n | n := Node new. n identityHash = n identityHash", true},
+		// Distinct objects differ, even when they are equal
+		{"| a b | a := {1. 2}. b := {1. 2}. a = b", true},
+		{"| a b | a := {1. 2}. b := {1. 2}. a identityHash = b identityHash", false},
+		{"Node new identityHash = Node new identityHash", false},
+		// Values that are == share an identity hash
+		{"3 identityHash = 3 identityHash", true},
+		{"nil identityHash = nil identityHash", true},
+		{"3 identityHash = 4 identityHash", false},
+		// Numbers are handed out in order, starting from 1
+		{"| a b | a := {1}. b := {2}. {a identityHash. b identityHash. a identityHash}",
+			&Array{Elements: []interface{}{int64(1), int64(2), int64(1)}}},
+		// Blocks and methods share their caller's numbering
+		{"| a h | a := {1}. h := a identityHash. ([:x | x identityHash] value: a) = h", true},
+		{"| a n h | a := {1}. n := Node new. h := a identityHash. (n hashFrom: [a identityHash]) = h", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestIdentityHashOfGoMaps tests that maps passed in by embedders are
+// hashed by address, since Go maps can't be used as map keys
+func TestIdentityHashOfGoMaps(t *testing.T) {
+	v := New()
+	m := map[string]interface{}{"a": int64(1)}
+	other := map[string]interface{}{"a": int64(1)}
+	if v.identityHash(m) != v.identityHash(m) {
+		t.Error("Expected a map to keep its identity hash")
+	}
+	if v.identityHash(m) == v.identityHash(other) {
+		t.Error("Expected distinct maps to have different identity hashes")
+	}
+}
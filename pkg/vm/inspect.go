@@ -0,0 +1,123 @@
+// Package vm - text inspector
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// inspectString renders value the way the inspect message prints it: a
+// header naming the object's class, then one indented line for each part
+// of it. An instance lists its fields by name, collections list their
+// elements or entries, and a class lists its class variables. Values
+// without parts, such as numbers and strings, show themselves.
+//
+// Unlike printString, nothing is left out for being too long, but each
+// part is shown with printString, so nested collections are still cut
+// short by the print limits (see SetPrintLimits).
+//
+// Example:
+//   (Account new deposit: 100) inspectString
+//     -> "an Account\n  balance: 100\n  owner: nil"
+//   #(10 'a') inspectString
+//     -> "an Array (2 elements)\n  1: 10\n  2: 'a'"
+func (vm *VM) inspectString(value interface{}) string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		b.WriteString("\n  ")
+		fmt.Fprintf(&b, format, args...)
+	}
+
+	switch v := value.(type) {
+	case *Instance:
+		b.WriteString(withArticle(v.Class.Name))
+		for i, name := range vm.allFieldNames(v.Class) {
+			if i < len(v.Fields) {
+				line("%s: %s", name, vm.printString(v.Fields[i]))
+			}
+		}
+	case *bytecode.ClassDefinition:
+		b.WriteString("the class " + v.Name)
+		for _, name := range v.ClassVariables {
+			line("%s: %s", name, vm.printString(v.ClassVarValues[name]))
+		}
+	case *BuiltinClass:
+		b.WriteString("the class " + v.Name)
+	case *Array:
+		b.WriteString(counted("an Array", len(v.Elements), "element"))
+		for i, elem := range v.Elements {
+			line("%d: %s", i+1, vm.printString(elem))
+		}
+	case *ByteArray:
+		b.WriteString(counted("a ByteArray", len(v.Bytes), "element"))
+		for i, elem := range v.Bytes {
+			line("%d: %d", i+1, elem)
+		}
+	case *Dictionary:
+		b.WriteString(counted(withArticle(v.className()), v.Len(), "entry"))
+		for i, key := range v.Keys() {
+			line("%s -> %s", vm.printString(key), vm.printString(v.values[i]))
+		}
+	case map[interface{}]interface{}:
+		// Go maps have no order, so show the entries sorted by key
+		b.WriteString(counted("a Dictionary", len(v), "entry"))
+		entries := make([]string, 0, len(v))
+		for key, val := range v {
+			entries = append(entries, vm.printString(key)+" -> "+vm.printString(val))
+		}
+		sort.Strings(entries)
+		for _, entry := range entries {
+			line("%s", entry)
+		}
+	case *Set, *Bag:
+		var elements []interface{}
+		v.(Enumerable).forEach(func(elem interface{}) error {
+			elements = append(elements, elem)
+			return nil
+		})
+		b.WriteString(counted(describeValue(v), len(elements), "element"))
+		for _, elem := range elements {
+			line("%s", vm.printString(elem))
+		}
+	case Point:
+		b.WriteString("a Point")
+		line("x: %s", vm.printString(v.X))
+		line("y: %s", vm.printString(v.Y))
+	case Interval:
+		b.WriteString(counted("an Interval", int(v.Size()), "element"))
+		line("from: %d", v.From)
+		line("to: %d", v.To)
+		line("by: %d", v.Step)
+	case *big.Rat:
+		b.WriteString("a Fraction")
+		line("numerator: %s", v.Num())
+		line("denominator: %s", v.Denom())
+	case nil:
+		b.WriteString("nil")
+	default:
+		header := describeValue(v)
+		if name := typeName(v); name != "" {
+			header = withArticle(name)
+		}
+		b.WriteString(header)
+		if shown := vm.printString(v); shown != header {
+			line("self: %s", shown)
+		}
+	}
+	return b.String()
+}
+
+// counted answers a header such as "an Array (3 elements)".
+func counted(header string, n int, noun string) string {
+	if n != 1 {
+		if strings.HasSuffix(noun, "y") {
+			noun = strings.TrimSuffix(noun, "y") + "ie"
+		}
+		noun += "s"
+	}
+	return fmt.Sprintf("%s (%d %s)", header, n, noun)
+}
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"testing"
+)
+
+// TestInspectInstance tests that inspecting an instance lists every field,
+// inherited ones first, with its value
+func TestInspectInstance(t *testing.T) {
+	classes := `Object subclass: #Account [
+    | balance owner |
+    setUp [ balance := 100. owner := 'Ann' ]
+]
+Account subclass: #Savings [ | rate | ]
+`
+	expected := "a Savings\n  balance: 100\n  owner: 'Ann'\n  rate: nil\n"
+	if got := runSourceOutput(t, classes+"Savings new setUp inspect"); got != expected {
+		t.Errorf("Expected inspect to print %q, got %q", expected, got)
+	}
+	if got := runSource(t, classes+"Savings new setUp inspectString"); got != expected[:len(expected)-1] {
+		t.Errorf("Expected inspectString to answer %q, got %q", expected[:len(expected)-1], got)
+	}
+
+	// inspect answers the receiver, so it can be dropped into an expression
+	if got := runSource(t, classes+"| a | a := Account new. a inspect == a"); got != true {
+		t.Errorf("Expected inspect to answer the receiver, got %v", got)
+	}
+}
+
+func TestInspectString(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"#(10 'a' #(1 2)) inspectString", "an Array (3 elements)\n  1: 10\n  2: 'a'\n  3: #(1 2)"},
+		{"#(7) inspectString", "an Array (1 element)\n  1: 7"},
+		{"#() inspectString", "an Array (0 elements)"},
+		{"#{'a' -> 1. 'b' -> 2} inspectString", "a Dictionary (2 entries)\n  'a' -> 1\n  'b' -> 2"},
+		{"(Set new add: 3; yourself) inspectString", "a Set (1 element)\n  3"},
+		{"'hi' asByteArray inspectString", "a ByteArray (2 elements)\n  1: 104\n  2: 105"},
+		{"(3 @ 4) inspectString", "a Point\n  x: 3\n  y: 4"},
+		{"42 inspectString", "an Integer\n  self: 42"},
+		{"'hi' inspectString", "a String\n  self: 'hi'"},
+		{"true inspectString", "a Boolean\n  self: true"},
+		{"nil inspectString", "nil"},
+		{"[:x | x] inspectString", "a Block"},
+		{"Object subclass: #Tally [ <| total |> <bump [ total := 3 ]> ]\nTally bump. Tally inspectString", "the class Tally\n  total: 3"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.source, tt.expected, result)
+		}
+	}
+
+	expected := "a Fraction\n  numerator: 1\n  denominator: 2"
+	if result := runWithDivision(t, DivideExact, "(1/2) inspectString"); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
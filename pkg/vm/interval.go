@@ -0,0 +1,166 @@
+// Package vm - arithmetic progressions
+package vm
+
+import (
+	"fmt"
+)
+
+// Interval is the sequence of integers from From to To, counting by Step,
+// created with to: and to:by: on an integer.
+//
+// An interval whose step runs away from its end is empty rather than an
+// error, so (5 to: 1) has no elements; counting down needs a negative
+// step, as in (5 to: 1 by: -1). The elements are worked out as they are
+// visited, so even a huge interval takes no memory. Like a Point, an
+// interval is a value: two with the same bounds and step are equal.
+//
+// Example:
+//   (1 to: 5) asArray             "#(1 2 3 4 5)"
+//   (1 to: 10 by: 3) asArray      "#(1 4 7 10)"
+//   (5 to: 1 by: -1) asArray      "#(5 4 3 2 1)"
+//   (5 to: 1) isEmpty             "true"
+//   (1 to: 4) inject: 0 into: [:sum :i | sum + i]   "10"
+//   1 to: 3 do: [:i | i println]
+type Interval struct {
+	From int64 // First element
+	To   int64 // Last possible element; it is reached only if Step lands on it
+	Step int64 // Difference between elements, never zero
+}
+
+// String returns the interval the way it is written, as in (1 to: 5) or
+// (5 to: 1 by: -1).
+func (i Interval) String() string {
+	if i.Step == 1 {
+		return fmt.Sprintf("(%d to: %d)", i.From, i.To)
+	}
+	return fmt.Sprintf("(%d to: %d by: %d)", i.From, i.To, i.Step)
+}
+
+// Size answers how many elements the interval has.
+func (i Interval) Size() int64 {
+	if i.Step > 0 && i.To >= i.From {
+		return (i.To-i.From)/i.Step + 1
+	}
+	if i.Step < 0 && i.To <= i.From {
+		return (i.From-i.To)/-i.Step + 1
+	}
+	return 0
+}
+
+// at answers the element at 0-based position n, which must be less than
+// Size.
+func (i Interval) at(n int64) int64 {
+	return i.From + n*i.Step
+}
+
+// forEach visits the elements in order.
+func (i Interval) forEach(visit func(elem interface{}) error) error {
+	for n, size := int64(0), i.Size(); n < size; n++ {
+		if err := visit(i.at(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newInterval answers from to: to by: step. The bounds and step must be
+// integers and the step can't be zero.
+func newInterval(selector string, from interface{}, to interface{}, step interface{}) (Interval, error) {
+	start, ok1 := from.(int64)
+	stop, ok2 := to.(int64)
+	by, ok3 := step.(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return Interval{}, fmt.Errorf("%s bounds and step must be integers, got %s, %s and %s",
+			selector, describeValue(from), describeValue(to), describeValue(step))
+	}
+	if by == 0 {
+		return Interval{}, fmt.Errorf("%s step must not be zero", selector)
+	}
+	return Interval{From: start, To: stop, Step: by}, nil
+}
+
+// sendInterval handles messages sent to an Interval. The enumeration
+// messages (do:, collect:, inject:into: and so on) come from
+// sendEnumerable.
+func (vm *VM) sendInterval(i Interval, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "size":
+		return i.Size(), true, nil
+	case "isEmpty":
+		return i.Size() == 0, true, nil
+	case "notEmpty":
+		return i.Size() > 0, true, nil
+	case "first", "last":
+		size := i.Size()
+		if size == 0 {
+			return nil, true, fmt.Errorf("%s: interval %s is empty", selector, i)
+		}
+		if selector == "first" {
+			return i.From, true, nil
+		}
+		return i.at(size - 1), true, nil
+	case "at:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("at: expects 1 argument, got %d", len(args))
+		}
+		index, ok := args[0].(int64)
+		if !ok {
+			return nil, true, fmt.Errorf("at: index must be an integer, got %s", describeValue(args[0]))
+		}
+		if index < 1 || index > i.Size() {
+			return nil, true, fmt.Errorf("interval index out of bounds: %d", index)
+		}
+		return i.at(index - 1), true, nil
+	case "includes:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("includes: expects 1 argument, got %d", len(args))
+		}
+		n, ok := args[0].(int64)
+		if !ok || i.Size() == 0 || (n-i.From)%i.Step != 0 {
+			return false, true, nil
+		}
+		position := (n - i.From) / i.Step
+		return position >= 0 && position < i.Size(), true, nil
+	case "asArray":
+		elements, _ := collectionElements(i)
+		return &Array{Elements: elements}, true, nil
+	case "from":
+		return i.From, true, nil
+	case "to":
+		return i.To, true, nil
+	case "by":
+		return i.Step, true, nil
+	}
+	return nil, false, nil
+}
+
+// intervalDo runs block for each element of the interval, for to:do: and
+// to:by:do:, and answers the receiver.
+func (vm *VM) intervalDo(i Interval, selector string, block interface{}, receiver interface{}) (interface{}, error) {
+	body, err := blockArg(selector, []interface{}{block}, 1)
+	if err != nil {
+		return nil, err
+	}
+	err = i.forEach(func(elem interface{}) error {
+		_, err := vm.executeBlock(body, []interface{}{elem})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receiver, nil
+}
+
+// copyBounds checks the from and to of copyFrom:to: against a sequence of
+// size elements and answers the 0-based slice bounds to copy. A to before
+// from is an empty copy, whatever the bounds, so copying a range that
+// shrinks to nothing never fails.
+func copyBounds(size int, from, to int64) (int, int, error) {
+	if to < from {
+		return 0, 0, nil
+	}
+	if from < 1 || to > int64(size) {
+		return 0, 0, fmt.Errorf("copyFrom:to: range %d to %d out of bounds for size %d", from, to, size)
+	}
+	return int(from - 1), int(to), nil
+}
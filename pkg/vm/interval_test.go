@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+// ints builds an Array of integers for expected results.
+func ints(values ...int64) *Array {
+	elements := make([]interface{}, len(values))
+	for i, v := range values {
+		elements[i] = v
+	}
+	return &Array{Elements: elements}
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Empty: the step runs away from the end
+		{"(5 to: 1) asArray", ints()},
+		{"(5 to: 1) size", int64(0)},
+		{"(5 to: 1) isEmpty", true},
+		{"(1 to: 5 by: -1) asArray", ints()},
+		{"(1 to: 0) collect: [:i | i]", ints()},
+		// Single element
+		{"(1 to: 1) asArray", ints(1)},
+		{"(3 to: 3 by: -1) asArray", ints(3)},
+		{"(1 to: 2 by: 5) asArray", ints(1)},
+		// Ascending and descending
+		{"(1 to: 5) asArray", ints(1, 2, 3, 4, 5)},
+		{"(5 to: 1 by: -1) asArray", ints(5, 4, 3, 2, 1)},
+		{"(1 to: 10 by: 3) asArray", ints(1, 4, 7, 10)},
+		{"(10 to: 1 by: -4) asArray", ints(10, 6, 2)},
+		{"(-2 to: 2) asArray", ints(-2, -1, 0, 1, 2)},
+		{"(1 to: 10 by: 3) size", int64(4)},
+		{"(10 to: 1 by: -4) last", int64(2)},
+		{"(10 to: 1 by: -4) at: 2", int64(6)},
+		{"(1 to: 10 by: 3) includes: 7", true},
+		{"(1 to: 10 by: 3) includes: 8", false},
+		{"(5 to: 1 by: -1) includes: 0", false},
+		// The enumeration protocol
+		{"(1 to: 4) inject: 0 into: [:sum :i | sum + i]", int64(10)},
+		{"(1 to: 4) collect: [:i | i * i]", ints(1, 4, 9, 16)},
+		{"(1 to: 10) select: [:i | i > 8]", ints(9, 10)},
+		{"(1 to: 100) sum", int64(5050)},
+		{"#(0) , (1 to: 2)", ints(0, 1, 2)},
+		// Printing and equality
+		{"(1 to: 5) printString", "(1 to: 5)"},
+		{"(5 to: 1 by: -1) printString", "(5 to: 1 by: -1)"},
+		{"(1 to: 3) = (1 to: 3)", true},
+		{"(1 to: 3) = (1 to: 3 by: 2)", false},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"1 to: 5 by: 0", "to:by: step must not be zero"},
+		{"1 to: 'a'", "to: bounds and step must be integers"},
+		{"(5 to: 1) first", "first: interval (5 to: 1) is empty"},
+		{"(1 to: 3) at: 4", "interval index out of bounds: 4"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+func TestToDo(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| sum | sum := 0. 1 to: 4 do: [:i | sum := sum + i]. sum", int64(10)},
+		{"| seen | seen := #(). 5 to: 1 by: -2 do: [:i | seen := seen , {i}]. seen", ints(5, 3, 1)},
+		{"| count | count := 0. 5 to: 1 do: [:i | count := count + 1]. count", int64(0)},
+		// to:do: answers the receiver, and ^ leaves the loop
+		{"3 to: 4 do: [:i | i]", int64(3)},
+		{"1 to: 10 do: [:i | i = 4 ifTrue: [^i * 100]]. 0", int64(400)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "1 to: 3 do: [:a :b | a]")
+	if err == nil || !strings.Contains(err.Error(), "to:do: block must take 1 argument(s)") {
+		t.Errorf("Expected a block arity error, got %v", err)
+	}
+}
+
+func TestCopyFromTo(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"#(1 2 3 4) copyFrom: 2 to: 3", ints(2, 3)},
+		{"#(1 2 3 4) copyFrom: 1 to: 4", ints(1, 2, 3, 4)},
+		{"#(1 2 3 4) copyFrom: 4 to: 4", ints(4)},
+		// from > to is empty, even outside the bounds
+		{"#(1 2 3 4) copyFrom: 3 to: 2", ints()},
+		{"#(1 2 3 4) copyFrom: 5 to: 4", ints()},
+		{"#() copyFrom: 1 to: 0", ints()},
+		{"#(1 2) copyFrom: 9 to: -1", ints()},
+		{"'smalltalk' copyFrom: 6 to: 9", "talk"},
+		{"'héllo' copyFrom: 2 to: 3", "él"},
+		{"'abc' copyFrom: 3 to: 1", ""},
+		// The copy is independent of the original
+		{"| a b | a := #(1 2 3). b := a copyFrom: 1 to: 2. b at: 1 put: 9. a", ints(1, 2, 3)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"#(1 2 3) copyFrom: 0 to: 2", "copyFrom:to: range 0 to 2 out of bounds for size 3"},
+		{"#(1 2 3) copyFrom: 2 to: 4", "copyFrom:to: range 2 to 4 out of bounds for size 3"},
+		{"'abc' copyFrom: -1 to: 2", "copyFrom:to: range -1 to 2 out of bounds for size 3"},
+		{"#(1 2 3) copyFrom: 'a' to: 2", "copyFrom:to: indices must be integers"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package vm - internal invariant checks
+package vm
+
+import (
+	"fmt"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// InvariantError reports bytecode or VM state that breaks an assumption
+// the VM relies on, such as an instruction popping more values than the
+// stack holds. It is only produced while invariant checks are enabled and
+// always points at a bug in the compiler, the VM, or a hand-made .sg file
+// rather than in the smog program being run.
+type InvariantError struct {
+	IP      int             // Instruction being executed (-1 if none had started)
+	Op      bytecode.Opcode // Opcode of that instruction
+	Message string          // What was violated
+}
+
+// Error implements the error interface.
+func (e *InvariantError) Error() string {
+	if e.IP < 0 {
+		return "VM invariant violated: " + e.Message
+	}
+	return fmt.Sprintf("VM invariant violated at instruction %d (%s): %s", e.IP, e.Op, e.Message)
+}
+
+// SetInvariantChecks turns the VM's internal consistency checks on or off.
+//
+// With checks on, Run verifies before every instruction that the stack
+// pointer and instruction pointer are in range and that the stack holds
+// enough values for the instruction, blocks are checked for a home
+// context before they run, and a panic inside Run is recovered. Each
+// violation is reported as an *InvariantError instead of a Go panic or a
+// silently wrong result.
+//
+// The checks cost time on every instruction, so they are off by default.
+// They are meant for developing the compiler and VM themselves. Blocks and
+// methods run by this VM inherit the setting.
+func (vm *VM) SetInvariantChecks(enabled bool) {
+	vm.checks = enabled
+}
+
+// violation builds an InvariantError for the current instruction of bc.
+func (vm *VM) violation(bc *bytecode.Bytecode, format string, args ...interface{}) *InvariantError {
+	err := &InvariantError{IP: -1, Message: fmt.Sprintf(format, args...)}
+	if bc != nil && vm.ip >= 0 && vm.ip < len(bc.Instructions) {
+		err.IP = vm.ip
+		err.Op = bc.Instructions[vm.ip].Op
+	}
+	return err
+}
+
+// checkInstruction verifies the VM state before the instruction at vm.ip
+// of bc executes.
+func (vm *VM) checkInstruction(bc *bytecode.Bytecode) error {
+	if vm.ip < 0 || vm.ip >= len(bc.Instructions) {
+		return vm.violation(bc, "instruction pointer %d outside 0..%d", vm.ip, len(bc.Instructions)-1)
+	}
+	if vm.sp < 0 || vm.sp > len(vm.stack) {
+		return vm.violation(bc, "stack pointer %d outside 0..%d", vm.sp, len(vm.stack))
+	}
+
+	inst := bc.Instructions[vm.ip]
+	needed := 0
+	switch inst.Op {
+	case bytecode.OpPop, bytecode.OpDup, bytecode.OpStoreLocal, bytecode.OpStoreGlobal,
+		bytecode.OpStoreField, bytecode.OpStoreClassVar:
+		needed = 1
+	case bytecode.OpSend, bytecode.OpSuperSend:
+		_, argCount, err := bytecode.UnpackSendOperand(inst.Operand)
+		if err != nil {
+			return vm.violation(bc, "%v", err)
+		}
+		needed = argCount + 1
+	case bytecode.OpCallBlock:
+		if inst.Operand < 0 || inst.Operand > bytecode.ArgCountMask {
+			return vm.violation(bc, "argument count %d out of range", inst.Operand)
+		}
+		needed = inst.Operand + 1
+	case bytecode.OpMakeArray:
+		if inst.Operand < 0 {
+			return vm.violation(bc, "negative element count %d", inst.Operand)
+		}
+		needed = inst.Operand
+	case bytecode.OpMakeDictionary:
+		if inst.Operand < 0 {
+			return vm.violation(bc, "negative pair count %d", inst.Operand)
+		}
+		needed = 2 * inst.Operand
+	case bytecode.OpNonLocalReturn:
+		if vm.homeContext == vm {
+			return vm.violation(bc, "block's home context is the block itself")
+		}
+	}
+	if needed > vm.sp {
+		return vm.violation(bc, "needs %d stack values but the stack holds %d", needed, vm.sp)
+	}
+	return nil
+}
+
+// checkBlock verifies that block can be run.
+func checkBlock(block *Block) error {
+	switch {
+	case block.Bytecode == nil:
+		return &InvariantError{IP: -1, Message: "block has no bytecode"}
+	case block.HomeContext == nil:
+		return &InvariantError{IP: -1, Message: "block has no home context for non-local returns"}
+	case block.ParamCount < 0 || block.ParentLocalCount < 0:
+		return &InvariantError{IP: -1, Message: fmt.Sprintf("block has negative parameter (%d) or parent local (%d) count",
+			block.ParamCount, block.ParentLocalCount)}
+	}
+	return nil
+}
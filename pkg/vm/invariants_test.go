@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// sendOperand packs a SEND operand, failing the test on bad input.
+func sendOperand(t *testing.T, selectorIdx, argCount int) int {
+	t.Helper()
+	operand, err := bytecode.PackSendOperand(selectorIdx, argCount)
+	if err != nil {
+		t.Fatalf("PackSendOperand failed: %v", err)
+	}
+	return operand
+}
+
+func TestInvariantChecksRejectMalformedBytecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		bc      *bytecode.Bytecode
+		ip      int
+		message string
+	}{
+		{
+			name: "pop from empty stack",
+			bc: &bytecode.Bytecode{
+				Instructions: []bytecode.Instruction{{Op: bytecode.OpPop}},
+			},
+			ip:      0,
+			message: "needs 1 stack values but the stack holds 0",
+		},
+		{
+			name: "negative array size",
+			bc: &bytecode.Bytecode{
+				Instructions: []bytecode.Instruction{{Op: bytecode.OpMakeArray, Operand: -1}},
+			},
+			ip:      0,
+			message: "negative element count -1",
+		},
+		{
+			name: "send with missing arguments",
+			bc: &bytecode.Bytecode{
+				Instructions: []bytecode.Instruction{
+					{Op: bytecode.OpPush, Operand: 0},
+					{Op: bytecode.OpSend, Operand: sendOperand(t, 1, 2)},
+				},
+				Constants: []interface{}{int64(1), "between:and:"},
+			},
+			ip:      1,
+			message: "needs 3 stack values but the stack holds 1",
+		},
+		{
+			name: "dictionary with missing values",
+			bc: &bytecode.Bytecode{
+				Instructions: []bytecode.Instruction{
+					{Op: bytecode.OpPushNil},
+					{Op: bytecode.OpPushNil},
+					{Op: bytecode.OpMakeDictionary, Operand: 2},
+				},
+			},
+			ip:      2,
+			message: "needs 4 stack values but the stack holds 2",
+		},
+		{
+			name: "panic inside a primitive",
+			bc: &bytecode.Bytecode{
+				Instructions: []bytecode.Instruction{
+					{Op: bytecode.OpPush, Operand: 0},
+					{Op: bytecode.OpSend, Operand: sendOperand(t, 1, 0)},
+				},
+				Constants: []interface{}{(*Array)(nil), "size"},
+			},
+			ip:      1,
+			message: "panic:",
+		},
+	}
+
+	for _, tt := range tests {
+		v := New()
+		v.SetInvariantChecks(true)
+		err := v.Run(tt.bc)
+
+		var invErr *InvariantError
+		if !errors.As(err, &invErr) {
+			t.Errorf("%s: expected an InvariantError, got %v", tt.name, err)
+			continue
+		}
+		if invErr.IP != tt.ip || invErr.Op != tt.bc.Instructions[tt.ip].Op {
+			t.Errorf("%s: expected violation at %d (%s), got %d (%s)",
+				tt.name, tt.ip, tt.bc.Instructions[tt.ip].Op, invErr.IP, invErr.Op)
+		}
+		if !strings.Contains(invErr.Message, tt.message) {
+			t.Errorf("%s: expected message containing %q, got %q", tt.name, tt.message, invErr.Message)
+		}
+	}
+}
+
+func TestInvariantChecksRejectNilBytecodeAndBadBlocks(t *testing.T) {
+	v := New()
+	v.SetInvariantChecks(true)
+
+	var invErr *InvariantError
+	if err := v.Run(nil); !errors.As(err, &invErr) {
+		t.Errorf("Expected an InvariantError running nil bytecode, got %v", err)
+	}
+
+	code := &bytecode.Bytecode{Instructions: []bytecode.Instruction{{Op: bytecode.OpPushNil}}}
+	for _, block := range []*Block{
+		{Bytecode: nil, HomeContext: v},
+		{Bytecode: code, HomeContext: nil},
+	} {
+		if _, err := v.executeBlock(block, nil); !errors.As(err, &invErr) {
+			t.Errorf("Expected an InvariantError running %+v, got %v", block, err)
+		}
+	}
+
+	// The blocks are fine once they have code and a home context
+	if _, err := v.executeBlock(&Block{Bytecode: code, HomeContext: v}, nil); err != nil {
+		t.Errorf("Expected a well-formed block to run, got %v", err)
+	}
+}
+
+// TestInvariantChecksAcceptCompiledCode tests that code produced by the
+// compiler never trips the checks, including inside blocks and methods
+func TestInvariantChecksAcceptCompiledCode(t *testing.T) {
+	source := `
+Object subclass: #Counter [
+    | count |
+    increment [ count := (count = nil ifTrue: [0] ifFalse: [count]) + 1. ^self ]
+    count [ ^count ]
+    firstOver: n in: items [ items do: [:each | each > n ifTrue: [^each]]. ^nil ]
+]
+| c total |
+c := Counter new.
+c increment; increment.
+total := 0.
+#(1 2 3) do: [:x | total := total + x].
+{c count. total. c firstOver: 1 in: #(1 5 9). #{'a' -> 1} size}
+`
+	program, err := parser.New(source).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	v := New()
+	v.SetInvariantChecks(true)
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("Expected compiled code to pass the checks, got %v", err)
+	}
+	expected := &Array{Elements: []interface{}{int64(2), int64(6), int64(5), int64(1)}}
+	if result := v.StackTop(); !valuesEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
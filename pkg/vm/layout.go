@@ -0,0 +1,67 @@
+// Package vm - instance layouts
+package vm
+
+import (
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// classLayout is the arrangement of an instance's fields: the names of
+// every instance variable, superclass fields first, and where the class's
+// own fields start. It also records the initialize method new sends, if
+// the class or a superclass defines one.
+type classLayout struct {
+	names  []string // All field names, in Instance.Fields order
+	offset int      // Index of the class's first own field
+
+	initialize      *bytecode.MethodDefinition // initialize, or nil if there is none
+	initializeClass *bytecode.ClassDefinition  // The class that defines initialize
+}
+
+// classLayouts caches the layout of each class, so allocating an instance
+// doesn't walk the class hierarchy every time.
+//
+// A layout depends on every superclass, so defining a class, which may
+// replace one that others inherit from, forgets all of them, and so does
+// adding methods to a class with extend or compile:, which may change the
+// initialize its subclasses inherit; they are worked out again the next
+// time they are needed. Like the identity
+// hashes, the table is shared by a VM and the VMs it runs blocks and
+// methods in, since they share the class registry.
+type classLayouts struct {
+	layouts map[*bytecode.ClassDefinition]*classLayout
+}
+
+// invalidate forgets every layout.
+func (t *classLayouts) invalidate() {
+	t.layouts = nil
+}
+
+// layoutTable answers the VM's layout cache, creating it for a VM that
+// was not made by New.
+func (vm *VM) layoutTable() *classLayouts {
+	if vm.layouts == nil {
+		vm.layouts = &classLayouts{}
+	}
+	return vm.layouts
+}
+
+// layout answers the layout of class, working it out if it isn't cached.
+func (vm *VM) layout(class *bytecode.ClassDefinition) *classLayout {
+	table := vm.layoutTable()
+	if layout, ok := table.layouts[class]; ok {
+		return layout
+	}
+	var names []string
+	if class.SuperClass != "" && class.SuperClass != "Object" {
+		if superClass, exists := vm.classes[class.SuperClass]; exists {
+			names = append(names, vm.layout(superClass).names...)
+		}
+	}
+	layout := &classLayout{names: append(names, class.Fields...), offset: len(names)}
+	layout.initialize, layout.initializeClass = vm.lookupMethod(class, "initialize")
+	if table.layouts == nil {
+		table.layouts = make(map[*bytecode.ClassDefinition]*classLayout)
+	}
+	table.layouts[class] = layout
+	return layout
+}
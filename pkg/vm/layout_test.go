@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// runOn compiles source and runs it on v, keeping the classes of earlier
+// runs.
+func runOn(t testing.TB, v *VM, source string) interface{} {
+	t.Helper()
+
+	program, err := parser.New(source).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if err := v.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	return v.StackTop()
+}
+
+// TestLayoutAfterRedefinition tests that the cached field layout of a
+// class follows a superclass being redefined with more fields
+func TestLayoutAfterRedefinition(t *testing.T) {
+	v := New()
+	runOn(t, v, `Object subclass: #Shape [ | name | ]
+Shape subclass: #Circle [ | radius | ]
+Circle new`)
+	circle := v.classes["Circle"]
+	if n := v.countAllFields(circle); n != 2 {
+		t.Fatalf("Expected Circle to have 2 fields, got %d", n)
+	}
+	if offset := v.getFieldOffset(circle); offset != 1 {
+		t.Errorf("Expected Circle's fields to start at 1, got %d", offset)
+	}
+
+	// Circle is unchanged, but the Shape it inherits from now has more fields
+	result := runOn(t, v, `Object subclass: #Shape [ | name color origin | ]
+Circle new inspectString`)
+	expected := "a Circle\n  name: nil\n  color: nil\n  origin: nil\n  radius: nil"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+	if n := v.countAllFields(circle); n != 4 {
+		t.Errorf("Expected Circle to have 4 fields after redefining Shape, got %d", n)
+	}
+	if offset := v.getFieldOffset(circle); offset != 3 {
+		t.Errorf("Expected Circle's fields to start at 3, got %d", offset)
+	}
+
+	// Reset forgets the classes, and their layouts with them
+	v.Reset()
+	runOn(t, v, `Object subclass: #Circle [ | radius | ]
+Circle new`)
+	if n := v.countAllFields(v.classes["Circle"]); n != 1 {
+		t.Errorf("Expected a redefined Circle to have 1 field, got %d", n)
+	}
+}
+
+// BenchmarkNewInstance measures allocating instances of a class three
+// levels deep, with and without running a program around it
+func BenchmarkNewInstance(b *testing.B) {
+	classes := `Object subclass: #Base [ | a b | ]
+Base subclass: #Middle [ | c d | ]
+Middle subclass: #Leaf [ | e | ]
+`
+	b.Run("newInstance", func(b *testing.B) {
+		v := New()
+		runOn(b, v, classes+"nil")
+		leaf := v.classes["Leaf"]
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := v.newInstance(leaf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Program", func(b *testing.B) {
+		program, err := parser.New(classes + "1 to: 1000 do: [:i | Leaf new]").Parse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := New().Run(bc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
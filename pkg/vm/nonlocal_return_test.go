@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kristofer/smog/pkg/compiler"
@@ -276,3 +277,216 @@ r1 + r2
 		t.Errorf("Expected 50, got %d", resultInt)
 	}
 }
+
+// TestReturnFromEitherBranch tests that ^ in both ifTrue: and ifFalse:
+// branches returns the right value and leaves the caller's stack balanced.
+func TestReturnFromEitherBranch(t *testing.T) {
+	source := `
+Object subclass: #Chooser [
+    pick: b [ b ifTrue: [^1] ifFalse: [^2] ]
+]
+
+| c |
+c := Chooser new.
+(c pick: true) * 10 + (c pick: false)
+`
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	vm := New()
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("Runtime error: %v", err)
+	}
+
+	if result := vm.StackTop(); result != int64(12) {
+		t.Errorf("Expected 12, got %v", result)
+	}
+	if vm.sp != 1 {
+		t.Errorf("Expected exactly 1 value left on the stack, got %d", vm.sp)
+	}
+}
+
+// TestReturnEarlyFromLoop tests that ^ inside a whileTrue: body exits the
+// method immediately, and that repeated early returns don't leak stack slots.
+func TestReturnEarlyFromLoop(t *testing.T) {
+	source := `
+Object subclass: #Finder [
+    find: n [
+        | i |
+        i := 0.
+        [i < 100] whileTrue: [
+            i := i + 1.
+            i = n ifTrue: [^i * 10]
+        ].
+        ^0
+    ]
+]
+
+| f sum |
+f := Finder new.
+sum := 0.
+#(1 2 3 4 5 6 7 8) do: [:k | sum := sum + (f find: k)].
+sum + (f find: 1000)
+`
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	vm := New()
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("Runtime error: %v", err)
+	}
+
+	// 10 + 20 + ... + 80 = 360, and find: 1000 runs off the end and returns 0
+	if result := vm.StackTop(); result != int64(360) {
+		t.Errorf("Expected 360, got %v", result)
+	}
+	if vm.sp != 1 {
+		t.Errorf("Expected exactly 1 value left on the stack, got %d", vm.sp)
+	}
+}
+
+// TestTopLevelReturn tests that ^ at the top level of a program ends it,
+// leaving the returned value as the program's result, whether it is
+// written directly or inside a block.
+func TestTopLevelReturn(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"^7. 99.", int64(7)},
+		{"| x | x := 1. ^x + 1. x := 50. x", int64(2)},
+		{"#(1 2 3) do: [:x | x = 2 ifTrue: [^x * 100]]. 99", int64(200)},
+		{"#(1 2 3) do: [:x | [:y | ^y] value: x]. 99", int64(1)},
+		{"false ifTrue: [^1]. 2", int64(2)},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(tt.source).Parse()
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", tt.source, err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.source, err)
+		}
+
+		vm := New()
+		if err := vm.Run(bc); err != nil {
+			t.Fatalf("%s: runtime error: %v", tt.source, err)
+		}
+		if result := vm.StackTop(); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+		if vm.sp != 1 {
+			t.Errorf("%s: expected exactly 1 value left on the stack, got %d", tt.source, vm.sp)
+		}
+	}
+}
+
+// TestEnsure tests that ensure: runs its cleanup block however the
+// protected block ends, and ifCurtailed: only when it ends early
+func TestEnsure(t *testing.T) {
+	prefix := `Object subclass: #Resource [
+    | log |
+    log [ ^log ]
+    note: s [ log := log isNil ifTrue: [s] ifFalse: [log , s] ]
+    answer [ ^[self note: 'body '. 42] ensure: [self note: 'cleanup'] ]
+    early: x [ [self note: 'body '. ^x] ensure: [self note: 'cleanup']. ^0 ]
+    nested: x [ [[^x] ensure: [self note: 'inner ']] ensure: [self note: 'outer']. ^0 ]
+    fromLoop [ #(1 2 3) do: [:i | [i = 2 ifTrue: [^i]] ensure: [self note: i printString]]. ^0 ]
+    curtailed: x [ [x > 0 ifTrue: [^x]. 0] ifCurtailed: [self note: 'curtailed']. ^-1 ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// A normal finish answers the block's value, after the cleanup
+		{"[3 + 4] ensure: [nil]", int64(7)},
+		{"| r | r := Resource new. r answer", int64(42)},
+		{"| r | r := Resource new. r answer. r log", "body cleanup"},
+		// A ^ out of the protected block runs the cleanup, then returns
+		{"| r | r := Resource new. r early: 5", int64(5)},
+		{"| r | r := Resource new. r early: 5. r log", "body cleanup"},
+		{"| r | r := Resource new. r nested: 9", int64(9)},
+		{"| r | r := Resource new. r nested: 9. r log", "inner outer"},
+		{"| r | r := Resource new. r fromLoop", int64(2)},
+		{"| r | r := Resource new. r fromLoop. r log", "12"},
+		// ifCurtailed: only runs its block when the ^ cuts the block short
+		{"| r | r := Resource new. r curtailed: 3", int64(3)},
+		{"| r | r := Resource new. r curtailed: 3. r log", "curtailed"},
+		{"| r | r := Resource new. r curtailed: 0", int64(-1)},
+		{"| r | r := Resource new. r curtailed: 0. r log", nil},
+		// A ^ at the top level leaves the program after the cleanup
+		{"| n | n := 0. [^n] ensure: [n := 1]. 99", int64(0)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, prefix+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	// Returning from a saved context unwinds through the cleanup too
+	finder := `Object subclass: #Finder [
+    find [ | here | here := thisContext. self deeper: here. ^'missing' ]
+    deeper: ctx [ [ctx return: 'found'] ensure: ['unwound' println] ]
+]
+Finder new find println`
+	if output := runSourceOutput(t, finder); output != "unwound\nfound\n" {
+		t.Errorf("Expected the cleanup to run before the context returned, got %q", output)
+	}
+
+	// A failure in the protected block still runs the cleanup, then propagates
+	program, _ := parser.New("[nil foo] ensure: ['cleanup' println]").Parse()
+	bc, _ := compiler.New().Compile(program)
+	var out strings.Builder
+	v := New()
+	v.SetOutput(&out)
+	err := v.Run(bc)
+	if err == nil || !strings.Contains(err.Error(), "nil does not understand 'foo'") {
+		t.Errorf("Expected the block's error to propagate past ensure:, got %v", err)
+	}
+	if out.String() != "cleanup\n" {
+		t.Errorf("Expected the cleanup to run when the block fails, got %q", out.String())
+	}
+
+	// A failure in the cleanup propagates past ensure:, even when the
+	// protected block returned with ^ or failed first
+	errorTests := []string{
+		"[1] ensure: [nil cleanupFailed]",
+		prefix + "Object subclass: #Leaky [ run [ [^1] ensure: [nil cleanupFailed]. ^2 ] ]\nLeaky new run",
+		"[nil foo] ensure: [nil cleanupFailed]",
+		"[[1] ensure: [nil cleanupFailed]] ensure: [nil]",
+	}
+	for _, source := range errorTests {
+		err := runSourceError(t, source)
+		if err == nil || !strings.Contains(err.Error(), "nil does not understand 'cleanupFailed'") {
+			t.Errorf("%s: expected the cleanup's error, got %v", source, err)
+		}
+	}
+
+	err = runSourceError(t, "[:x | x] ensure: [nil]")
+	if err == nil || !strings.Contains(err.Error(), "ensure: receiver must be a block with no arguments, got 1") {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+	err = runSourceError(t, "[1] ensure: 2")
+	if err == nil || !strings.Contains(err.Error(), "ensure: argument must be a block") {
+		t.Errorf("Expected a block argument error, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package vm - Point value type
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Point is a two-dimensional coordinate created with the binary @ selector.
+//
+// Points are values, not objects with identity: they are stored and passed
+// by value, so two points with equal coordinates compare equal with = and
+// can be used interchangeably as dictionary keys.
+//
+// Example:
+//   | p |
+//   p := 3 @ 4.
+//   p x              "3"
+//   (p + (1 @ 1))    "4@5"
+//   (p * 2)          "6@8"
+//   (p dist: 0 @ 0)  "5.0"
+type Point struct {
+	X interface{} // Horizontal coordinate (int64 or float64)
+	Y interface{} // Vertical coordinate (int64 or float64)
+}
+
+// String returns the point in Smalltalk's x@y notation.
+func (p Point) String() string {
+	return fmt.Sprintf("%v@%v", p.X, p.Y)
+}
+
+// makePoint implements the @ binary message on numbers.
+func (vm *VM) makePoint(x, y interface{}) (interface{}, error) {
+	if !isNumber(x) || !isNumber(y) {
+		return nil, fmt.Errorf("cannot make a Point from %s and %s", describeValue(x), describeValue(y))
+	}
+	return Point{X: x, Y: y}, nil
+}
+
+// isNumber reports whether v is one of the VM's numeric types.
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// pointOperands splits the argument of a Point arithmetic message into
+// x and y operands. A Point argument combines coordinate-wise, while a
+// plain number is applied to both coordinates.
+//
+// Example:
+//   (1 @ 2) + (3 @ 4)  -> x operand 3, y operand 4
+//   (1 @ 2) * 10       -> x operand 10, y operand 10
+func pointOperands(arg interface{}) (x, y interface{}, err error) {
+	switch a := arg.(type) {
+	case Point:
+		return a.X, a.Y, nil
+	case int64, float64:
+		return a, a, nil
+	}
+	return nil, nil, fmt.Errorf("cannot combine a Point and %s", describeValue(arg))
+}
+
+// pointArithmetic applies op to both coordinates of p and arg.
+func (vm *VM) pointArithmetic(p Point, arg interface{}, op func(a, b interface{}) (interface{}, error)) (interface{}, error) {
+	ax, ay, err := pointOperands(arg)
+	if err != nil {
+		return nil, err
+	}
+	x, err := op(p.X, ax)
+	if err != nil {
+		return nil, err
+	}
+	y, err := op(p.Y, ay)
+	if err != nil {
+		return nil, err
+	}
+	return Point{X: x, Y: y}, nil
+}
+
+// pointHash returns a hash of the point's coordinates.
+// Equal points always have equal hashes.
+func pointHash(p Point) int64 {
+	return 31*numberHash(p.X) + numberHash(p.Y)
+}
+
+// numberHash returns a hash for an int64 or float64 value.
+func numberHash(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(math.Float64bits(n))
+	}
+	return 0
+}
+
+// toFloat converts an int64 or float64 to float64.
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// sendPoint handles messages sent to a Point.
+func (vm *VM) sendPoint(p Point, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "x":
+		return p.X, true, nil
+	case "y":
+		return p.Y, true, nil
+	case "hash":
+		return pointHash(p), true, nil
+	case "+", "-", "*":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("%s expects 1 argument, got %d", selector, len(args))
+		}
+		op := vm.add
+		switch selector {
+		case "-":
+			op = vm.subtract
+		case "*":
+			op = vm.multiply
+		}
+		result, err := vm.pointArithmetic(p, args[0], op)
+		return result, true, err
+	case "dist:":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("dist: expects 1 argument, got %d", len(args))
+		}
+		other, ok := args[0].(Point)
+		if !ok {
+			return nil, true, fmt.Errorf("dist: argument must be a Point")
+		}
+		dx := toFloat(p.X) - toFloat(other.X)
+		dy := toFloat(p.Y) - toFloat(other.Y)
+		return math.Sqrt(dx*dx + dy*dy), true, nil
+	}
+	return nil, false, nil
+}
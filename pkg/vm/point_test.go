@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"testing"
+)
+
+// TestPointConstruction tests creating points with @ and reading coordinates
+func TestPointConstruction(t *testing.T) {
+	result := runSource(t, `3 @ 4`)
+	if result != (Point{X: int64(3), Y: int64(4)}) {
+		t.Fatalf("Expected 3@4, got %v", result)
+	}
+	if s := result.(Point).String(); s != "3@4" {
+		t.Errorf("Expected printString 3@4, got %s", s)
+	}
+
+	if result := runSource(t, `(3 @ 4) x`); result != int64(3) {
+		t.Errorf("Expected x = 3, got %v", result)
+	}
+	if result := runSource(t, `(3 @ 4) y`); result != int64(4) {
+		t.Errorf("Expected y = 4, got %v", result)
+	}
+
+	if err := runSourceError(t, `'a' @ 4`); err == nil {
+		t.Error("Expected error for non-numeric coordinate")
+	}
+}
+
+// TestPointEquality tests value equality and hashing
+func TestPointEquality(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`(3 @ 4) = (3 @ 4)`, true},
+		{`(3 @ 4) = (4 @ 3)`, false},
+		{`(3 @ 4) ~= (3 @ 5)`, true},
+		{`(3 @ 4) hash = (3 @ 4) hash`, true},
+		{`(3 @ 4) hash = (4 @ 3) hash`, false},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestPointArithmetic tests +, -, * and dist:
+func TestPointArithmetic(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{`(1 @ 2) + (3 @ 4)`, Point{X: int64(4), Y: int64(6)}},
+		{`(5 @ 5) - (1 @ 2)`, Point{X: int64(4), Y: int64(3)}},
+		{`(1 @ 2) * 3`, Point{X: int64(3), Y: int64(6)}},
+		{`(2 @ 3) * (4 @ 5)`, Point{X: int64(8), Y: int64(15)}},
+		{`(1.5 @ 2.5) + 1.0`, Point{X: 2.5, Y: 3.5}},
+		{`(3 @ 4) dist: (0 @ 0)`, 5.0},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, `(1 @ 2) + 'x'`); err == nil {
+		t.Error("Expected error adding a string to a Point")
+	}
+}
+
+// TestPointAsDictionaryKey tests that equal points collapse to a single key
+func TestPointAsDictionaryKey(t *testing.T) {
+	result := runSource(t, `#{(3 @ 4) -> 'a'. (1 @ 1) -> 'b'. (3 @ 4) -> 'c'}`)
+	dict, ok := result.(*Dictionary)
+	if !ok {
+		t.Fatalf("Expected dictionary, got %T", result)
+	}
+	if dict.Len() != 2 {
+		t.Errorf("Expected 2 distinct keys, got %d: %v", dict.Len(), dict)
+	}
+	if value, ok := dict.At(Point{X: int64(3), Y: int64(4)}); !ok || value != "c" {
+		t.Errorf("Expected to find 3@4 -> c by value, got %v", dict)
+	}
+	if value, _ := dict.At(Point{X: int64(1), Y: int64(1)}); value != "b" {
+		t.Errorf("Expected 1@1 -> b, got %v", dict)
+	}
+}
@@ -0,0 +1,73 @@
+// Package vm - the standard prelude
+package vm
+
+import (
+	_ "embed"
+	"fmt"
+	"sync"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// preludeSource is smog code run before every program, defining classes
+// such as OrderedCollection and TestCase. Standard classes that don't need
+// VM support belong here rather than in Go.
+//
+//go:embed prelude.smog
+var preludeSource string
+
+var (
+	preludeOnce sync.Once
+	preludeCode *bytecode.Bytecode
+	preludeErr  error
+)
+
+// compiledPrelude compiles the prelude the first time it is needed and
+// answers the same bytecode after that.
+func compiledPrelude() (*bytecode.Bytecode, error) {
+	preludeOnce.Do(func() {
+		program, err := parser.New(preludeSource).Parse()
+		if err != nil {
+			preludeErr = fmt.Errorf("prelude: %v", err)
+			return
+		}
+		preludeCode, preludeErr = compiler.New().Compile(program)
+		if preludeErr != nil {
+			preludeErr = fmt.Errorf("prelude: %v", preludeErr)
+		}
+	})
+	return preludeCode, preludeErr
+}
+
+// SetPrelude selects whether the prelude is loaded before the first Run.
+// It is on for a VM made with New. Turning it off leaves the globals empty
+// apart from the built-in classes.
+//
+// Example:
+//   v := vm.New()
+//   v.SetPrelude(false)  // OrderedCollection is now undefined
+func (vm *VM) SetPrelude(enabled bool) {
+	vm.prelude = enabled
+}
+
+// LoadPrelude defines the prelude's classes in the VM's globals. Run calls
+// it before running anything, unless SetPrelude(false) was called or the
+// prelude has already been loaded. Classes a program defines later
+// replace prelude classes of the same name.
+func (vm *VM) LoadPrelude() error {
+	vm.prelude = false
+	vm.hasPrelude = true
+	code, err := compiledPrelude()
+	if err != nil {
+		return err
+	}
+	preludeVM := New()
+	preludeVM.prelude = false
+	preludeVM.globals = vm.globals
+	preludeVM.classes = vm.classes
+	preludeVM.extensions = vm.extensions
+	preludeVM.out = vm.out
+	return preludeVM.Run(code)
+}
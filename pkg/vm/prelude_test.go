@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+func TestPreludeClasses(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| l | l := OrderedCollection new. l add: 2; add: 3; addFirst: 1. l asArray",
+			&Array{Elements: []interface{}{int64(1), int64(2), int64(3)}}},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3). l removeFirst + l removeLast", int64(4)},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3). l removeLast. l size", int64(2)},
+		{"OrderedCollection new isEmpty", true},
+		{"| l | l := OrderedCollection new. l add: 5. l includes: 5", true},
+		// Growing past the initial capacity at either end
+		{"| l | l := OrderedCollection new. 1 to: 1000 do: [:i | l add: i]. l size + l last", int64(2000)},
+		{"| l | l := OrderedCollection new. 1 to: 20 do: [:i | l addFirst: i]. l add: 0. l first * 100 + (l at: 20) + l last", int64(2001)},
+		{"| l | l := OrderedCollection new. 1 to: 20 do: [:i | l add: i]. 1 to: 15 do: [:i | l removeFirst]. l add: 21. l asArray",
+			&Array{Elements: []interface{}{int64(16), int64(17), int64(18), int64(19), int64(20), int64(21)}}},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3). l at: 2 put: 9. l inject: 0 into: [:a :b | a + b]", int64(13)},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3 4). (l removeAt: 2) * 10 + l size", int64(23)},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3 4). l removeAt: 2. l asArray",
+			&Array{Elements: []interface{}{int64(1), int64(3), int64(4)}}},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3 4). l reject: [:x | x \\\\ 2 = 0]",
+			&Array{Elements: []interface{}{int64(1), int64(3)}}},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2 3 4). l detect: [:x | x > 2]", int64(3)},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2). l detect: [:x | x > 2]", nil},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2). l anySatisfy: [:x | x > 1]", true},
+		{"| l | l := OrderedCollection new. l addAll: #(1 2). l allSatisfy: [:x | x > 1]", false},
+		{"| t | t := TestCase new. t assert: 1 + 1 equals: 2. t deny: false. t summary", "2 passed, 0 failed"},
+		{"| t | t := TestCase new. t assert: 1 equals: 2. t failures first", "expected 2, got 1"},
+		// Prelude classes can be subclassed like any other
+		{`TestCase subclass: #Checks [ run [ self assert: 3 > 2. ^self allPassed ] ]
+Checks new run`, true},
+		// A program's own class replaces the prelude's
+		{"Object subclass: #TestCase [ summary [ ^'mine' ] ]\nTestCase new summary", "mine"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, "OrderedCollection new removeFirst"); err == nil {
+		t.Error("Expected removeFirst on an empty OrderedCollection to fail")
+	}
+	if err := runSourceError(t, "| l | l := OrderedCollection new. l addAll: #(1 2). l removeFirst. l at: 2"); err == nil {
+		t.Error("Expected at: past the last element to fail")
+	}
+	if err := runSourceError(t, "| l | l := OrderedCollection new. l add: 1. l removeAt: 2"); err == nil {
+		t.Error("Expected removeAt: past the last element to fail")
+	}
+}
+
+func TestSetPrelude(t *testing.T) {
+	program, err := parser.New("OrderedCollection new").Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	v := New()
+	v.SetPrelude(false)
+	err = v.Run(bc)
+	if err == nil || !strings.Contains(err.Error(), "undefined global variable: OrderedCollection") {
+		t.Errorf("Expected OrderedCollection to be undefined without the prelude, got %v", err)
+	}
+
+	// It can still be loaded explicitly
+	if err := v.LoadPrelude(); err != nil {
+		t.Fatalf("LoadPrelude failed: %v", err)
+	}
+	if err := v.Run(bc); err != nil {
+		t.Errorf("Expected OrderedCollection after LoadPrelude, got %v", err)
+	}
+}
+
+// TestPreludeClassesBelongToEachVM tests that changing a prelude class in
+// one VM leaves the class every other VM sees as it was
+func TestPreludeClassesBelongToEachVM(t *testing.T) {
+	changed := `OrderedCollection extend [ answer [ ^42 ] ]
+OrderedCollection compile: 'size [ ^99 ]'.
+OrderedCollection new answer + OrderedCollection new size`
+	if result := runSource(t, changed); result != int64(141) {
+		t.Fatalf("Expected the changed class to answer 141, got %v", result)
+	}
+
+	if result := runSource(t, "OrderedCollection new size"); result != int64(0) {
+		t.Errorf("Expected a fresh VM's OrderedCollection to be empty, got size %v", result)
+	}
+	if err := runSourceError(t, "OrderedCollection new answer"); err == nil {
+		t.Error("Expected a fresh VM's OrderedCollection not to understand answer")
+	}
+}
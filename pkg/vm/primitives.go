@@ -1,8 +1,8 @@
 // Package vm implements stdlib primitives for the virtual machine.
 //
 // This file contains VM primitive implementations for standard library
-// functionality including HTTP, crypto, compression, file I/O, JSON, regex,
-// date/time, and random number generation.
+// functionality including HTTP, crypto, compression, file I/O, JSON, string
+// formatting, regex, date/time, and random number generation.
 package vm
 
 import (
@@ -11,20 +11,26 @@ import (
 	"compress/gzip"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // HTTP Primitives
@@ -61,6 +67,54 @@ func (vm *VM) httpPost(url string, body string) (string, error) {
 	return string(respBody), nil
 }
 
+// urlEncode percent-encodes a string for use in a URL query
+func (vm *VM) urlEncode(data string) string {
+	return url.QueryEscape(data)
+}
+
+// urlDecode decodes a percent-encoded string
+func (vm *VM) urlDecode(data string) (string, error) {
+	decoded, err := url.QueryUnescape(data)
+	if err != nil {
+		return "", fmt.Errorf("URL decode failed: %v", err)
+	}
+	return decoded, nil
+}
+
+// queryString builds an escaped a=1&b=2 query string from a dictionary.
+// Keys are sorted so the output is deterministic; array values repeat the key.
+func (vm *VM) queryString(dict interface{}) (string, error) {
+	values := url.Values{}
+	add := func(key string, value interface{}) {
+		if arr, ok := value.(*Array); ok {
+			for _, elem := range arr.Elements {
+				values.Add(key, fmt.Sprint(elem))
+			}
+			return
+		}
+		values.Add(key, fmt.Sprint(value))
+	}
+
+	switch d := dict.(type) {
+	case *Dictionary:
+		for i, k := range d.Keys() {
+			add(fmt.Sprint(k), d.values[i])
+		}
+	case map[string]interface{}:
+		for k, v := range d {
+			add(k, v)
+		}
+	case map[interface{}]interface{}:
+		for k, v := range d {
+			add(fmt.Sprint(k), v)
+		}
+	default:
+		return "", fmt.Errorf("queryString: argument must be a dictionary")
+	}
+
+	return values.Encode(), nil
+}
+
 // Crypto Primitives
 
 // aesEncrypt encrypts data using AES-256
@@ -158,6 +212,14 @@ func (vm *VM) sha256Hash(data string) string {
 	return fmt.Sprintf("%x", hash)
 }
 
+// sha256Bytes computes a SHA-256 hash as a ByteArray of its 32 raw bytes,
+// for when the digest is fed to something that wants bytes rather than
+// the hex string sha256: answers.
+func sha256Bytes(data string) *ByteArray {
+	hash := sha256.Sum256([]byte(data))
+	return &ByteArray{Bytes: hash[:]}
+}
+
 // sha512Hash computes SHA-512 hash
 func (vm *VM) sha512Hash(data string) string {
 	hash := sha512.Sum512([]byte(data))
@@ -170,6 +232,62 @@ func (vm *VM) md5Hash(data string) string {
 	return fmt.Sprintf("%x", hash)
 }
 
+// hmacSha256 computes an HMAC-SHA256 message authentication code (hex)
+func (vm *VM) hmacSha256(data string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// Limits on pbkdf2:salt:iterations:length:, so a mistyped argument is an
+// error rather than a key that takes hours or all of memory to derive.
+const (
+	maxPBKDF2Iterations = 10000000
+	maxPBKDF2Length     = 1024
+)
+
+// pbkdf2Sha256 derives a key from a password using PBKDF2 with HMAC-SHA256 (RFC 8018).
+// Returns the derived key of the requested length in bytes as a hex string.
+func (vm *VM) pbkdf2Sha256(password string, salt string, iterations int64, length int64) (string, error) {
+	if iterations < 1 || iterations > maxPBKDF2Iterations {
+		return "", fmt.Errorf("pbkdf2 iterations must be between 1 and %d, got %d", maxPBKDF2Iterations, iterations)
+	}
+	if length < 1 || length > maxPBKDF2Length {
+		return "", fmt.Errorf("pbkdf2 length must be between 1 and %d, got %d", maxPBKDF2Length, length)
+	}
+
+	prf := hmac.New(sha256.New, []byte(password))
+	hashLen := prf.Size()
+	numBlocks := (int(length) + hashLen - 1) / hashLen
+
+	derived := make([]byte, 0, numBlocks*hashLen)
+	var counter [4]byte
+	u := make([]byte, hashLen)
+	t := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write([]byte(salt))
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		// Un = PRF(password, Un-1); T = U1 ^ U2 ^ ... ^ Uc
+		for i := int64(1); i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		derived = append(derived, t...)
+	}
+
+	return fmt.Sprintf("%x", derived[:length]), nil
+}
+
 // base64Encode encodes data to base64
 func (vm *VM) base64Encode(data string) string {
 	return base64.StdEncoding.EncodeToString([]byte(data))
@@ -184,6 +302,16 @@ func (vm *VM) base64Decode(data string) (string, error) {
 	return string(decoded), nil
 }
 
+// base64DecodeBytes decodes base64 data into a ByteArray, keeping bytes
+// that aren't valid UTF-8
+func (vm *VM) base64DecodeBytes(data string) (*ByteArray, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %v", err)
+	}
+	return &ByteArray{Bytes: decoded}, nil
+}
+
 // Compression Primitives
 
 // zipCompress compresses data using ZIP
@@ -294,6 +422,24 @@ func (vm *VM) fileWrite(path string, content string) error {
 	return nil
 }
 
+// fileReadBytes reads entire file contents as raw bytes
+func (vm *VM) fileReadBytes(path string) (*ByteArray, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	return &ByteArray{Bytes: content}, nil
+}
+
+// fileWriteBytes writes raw bytes to a file
+func (vm *VM) fileWriteBytes(path string, data []byte) error {
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	return nil
+}
+
 // fileExists checks if a file exists
 func (vm *VM) fileExists(path string) bool {
 	_, err := os.Stat(path)
@@ -311,72 +457,381 @@ func (vm *VM) fileDelete(path string) error {
 
 // JSON Primitives
 
-// jsonParse parses JSON string to a value
+// jsonParse parses JSON string to a value.
+// Objects become Dictionaries with their keys in document order.
 func (vm *VM) jsonParse(data string) (interface{}, error) {
-	var result interface{}
-	err := json.Unmarshal([]byte(data), &result)
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	result, err := vm.decodeJSONValue(dec)
+	if err == nil {
+		// Anything after the value other than whitespace is an error
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = fmt.Errorf("unexpected data after top-level value")
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JSON: %v", err)
 	}
-	return vm.convertJSONValue(result), nil
+	return result, nil
+}
+
+// decodeJSONValue reads the next JSON value from dec and converts it to VM
+// types. It walks the token stream instead of unmarshalling into a Go map,
+// which would lose the order of object keys.
+func (vm *VM) decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			dict := newDictionary()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := vm.decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				if err := dict.AtPut(keyTok.(string), value); err != nil {
+					return nil, err
+				}
+			}
+			_, err := dec.Token() // closing }
+			return dict, err
+		case '[':
+			elements := []interface{}{}
+			for dec.More() {
+				elem, err := vm.decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, elem)
+			}
+			_, err := dec.Token() // closing ]
+			return &Array{Elements: elements}, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		// Whole numbers written with a fraction or exponent are integers too
+		if f == float64(int64(f)) {
+			return int64(f), nil
+		}
+		return f, nil
+	default:
+		// string, bool or nil
+		return t, nil
+	}
 }
 
 // jsonGenerate generates JSON string from a value
 func (vm *VM) jsonGenerate(value interface{}) (string, error) {
-	data, err := json.Marshal(vm.convertToJSONValue(value))
+	converted, err := vm.convertToJSONValue(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate JSON: %v", err)
+	}
+	return string(data), nil
+}
+
+// jsonGeneratePretty generates indented JSON string from a value.
+// Each nesting level is indented by indent spaces.
+func (vm *VM) jsonGeneratePretty(value interface{}, indent int64) (string, error) {
+	if indent < 0 {
+		return "", fmt.Errorf("JSON indent must be non-negative, got %d", indent)
+	}
+	converted, err := vm.convertToJSONValue(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(converted, "", strings.Repeat(" ", int(indent)))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate JSON: %v", err)
 	}
 	return string(data), nil
 }
 
-// convertJSONValue converts JSON value to VM types
-func (vm *VM) convertJSONValue(value interface{}) interface{} {
+// convertToJSONValue converts VM types to JSON-compatible values.
+// JSON has no way to refer back to an enclosing value, so an array or
+// dictionary that contains itself is reported as an error.
+func (vm *VM) convertToJSONValue(value interface{}) (interface{}, error) {
+	var path identitySet
+	return vm.convertToJSONValueOn(value, &path)
+}
+
+// convertToJSONValueOn converts value, with path holding the arrays and
+// dictionaries it is nested inside.
+func (vm *VM) convertToJSONValueOn(value interface{}, path *identitySet) (interface{}, error) {
 	switch v := value.(type) {
-	case float64:
-		// JSON numbers are float64, convert to int64 if whole number
-		if v == float64(int64(v)) {
-			return int64(v)
-		}
-		return v
-	case []interface{}:
-		// Convert to Array
-		elements := make([]interface{}, len(v))
-		for i, elem := range v {
-			elements[i] = vm.convertJSONValue(elem)
+	case *Array, *Dictionary:
+		if !path.add(v) {
+			return nil, fmt.Errorf("cannot generate JSON for a cyclic structure")
 		}
-		return &Array{Elements: elements}
-	case map[string]interface{}:
-		// Keep as map for now (Dictionary type not yet implemented)
-		result := make(map[string]interface{})
-		for k, val := range v {
-			result[k] = vm.convertJSONValue(val)
-		}
-		return result
-	default:
-		return v
+		defer path.remove(v)
 	}
-}
 
-// convertToJSONValue converts VM types to JSON-compatible values
-func (vm *VM) convertToJSONValue(value interface{}) interface{} {
+	var err error
 	switch v := value.(type) {
 	case *Array:
 		result := make([]interface{}, len(v.Elements))
 		for i, elem := range v.Elements {
-			result[i] = vm.convertToJSONValue(elem)
+			if result[i], err = vm.convertToJSONValueOn(elem, path); err != nil {
+				return nil, err
+			}
 		}
-		return result
+		return result, nil
 	case map[string]interface{}:
 		// Handle map (used when Dictionary type not yet implemented)
 		result := make(map[string]interface{})
 		for k, val := range v {
-			result[k] = vm.convertToJSONValue(val)
+			if result[k], err = vm.convertToJSONValueOn(val, path); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case *Dictionary:
+		// Dictionaries keep their key order; JSON object keys are strings
+		result := &jsonObject{}
+		for i, k := range v.Keys() {
+			converted, err := vm.convertToJSONValueOn(v.values[i], path)
+			if err != nil {
+				return nil, err
+			}
+			result.keys = append(result.keys, fmt.Sprint(k))
+			result.values = append(result.values, converted)
+		}
+		return result, nil
+	case map[interface{}]interface{}:
+		// Go maps passed in by embedders use arbitrary keys; JSON object keys are strings
+		result := make(map[string]interface{})
+		for k, val := range v {
+			if result[fmt.Sprint(k)], err = vm.convertToJSONValueOn(val, path); err != nil {
+				return nil, err
+			}
 		}
-		return result
+		return result, nil
 	default:
-		return v
+		return v, nil
+	}
+}
+
+// jsonObject is a JSON object whose keys are written in a fixed order,
+// unlike a Go map, whose keys encoding/json sorts.
+type jsonObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// MarshalJSON writes the object's members in order.
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Number Conversion Primitives
+
+// asHexString formats an integer as a lowercase hexadecimal string
+func (vm *VM) asHexString(n int64) string {
+	return strconv.FormatInt(n, 16)
+}
+
+// digits answers the base-10 digits of an integer, most significant
+// first. The sign is dropped, so -42 has the digits #(4 2).
+func digits(n int64) *Array {
+	text := strings.TrimPrefix(strconv.FormatInt(n, 10), "-")
+	elements := make([]interface{}, len(text))
+	for i, digit := range text {
+		elements[i] = int64(digit - '0')
+	}
+	return &Array{Elements: elements}
+}
+
+// withThousands formats an integer with a comma between each group of
+// three digits, as in 1,000,000 or -12,345.
+func withThousands(n int64) string {
+	text := strconv.FormatInt(n, 10)
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	var b strings.Builder
+	for i, digit := range text {
+		if i > 0 && (len(text)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String()
+}
+
+// hexStringAsInteger parses a hexadecimal string (with optional 0x prefix)
+func (vm *VM) hexStringAsInteger(s string) (int64, error) {
+	digits := s
+	negative := strings.HasPrefix(digits, "-")
+	if negative {
+		digits = digits[1:]
+	}
+	digits = strings.TrimPrefix(strings.TrimPrefix(digits, "0x"), "0X")
+	if negative {
+		digits = "-" + digits
+	}
+	n, err := strconv.ParseInt(digits, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex string: '%s'", s)
+	}
+	return n, nil
+}
+
+// asIntegerBase parses a string as an integer in the given base (2-36)
+func (vm *VM) asIntegerBase(s string, base int64) (int64, error) {
+	if base < 2 || base > 36 {
+		return 0, fmt.Errorf("base must be between 2 and 36, got %d", base)
+	}
+	n, err := strconv.ParseInt(s, int(base), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base %d integer: '%s'", base, s)
+	}
+	return n, nil
+}
+
+// String Formatting Primitives
+
+// padString pads s with fill up to width characters. The padding goes on
+// the left when left is true (right-justifying s), otherwise on the right.
+// Widths are counted in runes, and strings already at least width long
+// are returned unchanged.
+func (vm *VM) padString(s string, width int64, fill string, left bool) string {
+	missing := int(width) - utf8.RuneCountInString(s)
+	if missing <= 0 {
+		return s
+	}
+	padding := strings.Repeat(fill, missing)
+	if left {
+		return padding + s
+	}
+	return s + padding
+}
+
+// centerString pads s on both sides with fill up to width characters.
+// When the padding can't be split evenly, the extra character goes on
+// the right.
+func (vm *VM) centerString(s string, width int64, fill string) string {
+	missing := int(width) - utf8.RuneCountInString(s)
+	if missing <= 0 {
+		return s
+	}
+	left := missing / 2
+	return strings.Repeat(fill, left) + s + strings.Repeat(fill, missing-left)
+}
+
+// truncateString shortens s to at most width characters (runes).
+func (vm *VM) truncateString(s string, width int64) string {
+	runes := []rune(s)
+	if int64(len(runes)) <= width {
+		return s
+	}
+	return string(runes[:width])
+}
+
+// String Editing Primitives
+
+// insertString answers a copy of s with inserted placed before the
+// character at the 1-based index. An index one past the end appends.
+// Indices count characters (runes), not bytes.
+func (vm *VM) insertString(s string, inserted string, index int64) (string, error) {
+	runes := []rune(s)
+	if index < 1 || index > int64(len(runes))+1 {
+		return "", fmt.Errorf("insert:at: index %d out of bounds for string of size %d", index, len(runes))
+	}
+	return string(runes[:index-1]) + inserted + string(runes[index-1:]), nil
+}
+
+// removeStringRange answers a copy of s without the characters from
+// index from to index to, inclusive and 1-based. An empty range, where
+// to is from - 1, answers s unchanged.
+func (vm *VM) removeStringRange(s string, from, to int64) (string, error) {
+	runes := []rune(s)
+	if from < 1 || to > int64(len(runes)) || from > to+1 {
+		return "", fmt.Errorf("removeFrom:to: range %d to %d out of bounds for string of size %d", from, to, len(runes))
+	}
+	return string(runes[:from-1]) + string(runes[to:]), nil
+}
+
+// repeatString answers s repeated count times, for repeat: and *. Zero
+// repeats answer the empty string; a negative count is an error, as it
+// is for Array repeat:, and so is a result longer than maxElements bytes.
+func (vm *VM) repeatString(s string, selector string, count int64) (string, error) {
+	if count < 0 {
+		return "", fmt.Errorf("%s count must be a non-negative integer, got %d", selector, count)
+	}
+	if len(s) > 0 && count > maxElements/int64(len(s)) {
+		return "", fmt.Errorf("%s result would be larger than the maximum of %d characters", selector, maxElements)
+	}
+	return strings.Repeat(s, int(count)), nil
+}
+
+// uppercaseFirst answers a copy of s with its first character (rune)
+// converted to upper case.
+func (vm *VM) uppercaseFirst(s string) string {
+	first, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
 	}
+	return string(unicode.ToUpper(first)) + s[size:]
+}
+
+// splitLines answers the lines of s, for lines and asLines. Lines end at
+// a newline, which isn't part of the line, and a newline at the very end
+// doesn't start another, empty line. A carriage return just before a
+// newline is dropped too, so text with Windows (CRLF) line endings splits
+// the same way; a carriage return anywhere else is kept. The empty
+// string has no lines.
+//
+// Example:
+//   splitLines("a\n\nb\n")   -> ["a", "", "b"]
+//   splitLines("a\r\nb")     -> ["a", "b"]
+func splitLines(s string) []interface{} {
+	if s == "" {
+		return []interface{}{}
+	}
+	parts := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	lines := make([]interface{}, len(parts))
+	for i, line := range parts {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
 }
 
 // Regular Expression Primitives
@@ -447,12 +902,18 @@ func (vm *VM) randomFloat() (float64, error) {
 }
 
 // randomBytes generates random bytes
-func (vm *VM) randomBytes(length int64) (string, error) {
+func (vm *VM) randomBytes(length int64) (*ByteArray, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("randomBytes: length must not be negative, got %d", length)
+	}
+	if length > maxElements {
+		return nil, fmt.Errorf("randomBytes: length %d is larger than the maximum of %d", length, maxElements)
+	}
 	bytes := make([]byte, length)
 	if _, err := io.ReadFull(rand.Reader, bytes); err != nil {
-		return "", fmt.Errorf("failed to generate random bytes: %v", err)
+		return nil, fmt.Errorf("failed to generate random bytes: %v", err)
 	}
-	return base64.StdEncoding.EncodeToString(bytes), nil
+	return &ByteArray{Bytes: bytes}, nil
 }
 
 // Date and Time Primitives
@@ -186,8 +186,8 @@ func TestPrimitivesViaSend(t *testing.T) {
 		if err != nil {
 			t.Fatalf("randomBytes: failed: %v", err)
 		}
-		if _, ok := bytes.(string); !ok {
-			t.Errorf("randomBytes: should return string, got %T", bytes)
+		if b, ok := bytes.(*ByteArray); !ok || len(b.Bytes) != 16 {
+			t.Errorf("randomBytes: should return a ByteArray of 16 bytes, got %v", bytes)
 		}
 	})
 
@@ -63,6 +63,68 @@ func TestCryptoPrimitives(t *testing.T) {
 	}
 }
 
+// TestKeyedCryptoPrimitives tests HMAC and PBKDF2 against known test vectors
+func TestKeyedCryptoPrimitives(t *testing.T) {
+	vm := &VM{}
+
+	// RFC 4231 test case 2
+	mac := vm.hmacSha256("what do ya want for nothing?", "Jefe")
+	if mac != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("Unexpected HMAC-SHA256: %s", mac)
+	}
+
+	// PBKDF2-HMAC-SHA256 vectors (RFC 7914 section 11 and common published vectors)
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int64
+		length     int64
+		expected   string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+	for _, tt := range tests {
+		key, err := vm.pbkdf2Sha256(tt.password, tt.salt, tt.iterations, tt.length)
+		if err != nil {
+			t.Fatalf("pbkdf2 failed: %v", err)
+		}
+		if key != tt.expected {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, tt.length, key, tt.expected)
+		}
+	}
+
+	// Truncated output is a prefix of the full key
+	short, err := vm.pbkdf2Sha256("password", "salt", 1, 20)
+	if err != nil || short != tests[0].expected[:40] {
+		t.Errorf("Unexpected truncated pbkdf2 output: %s (%v)", short, err)
+	}
+
+	for _, bad := range []struct{ iterations, length int64 }{
+		{0, 32}, {1 << 40, 32}, {1, 0}, {1, 1 << 40},
+	} {
+		if _, err := vm.pbkdf2Sha256("password", "salt", bad.iterations, bad.length); err == nil {
+			t.Errorf("Expected error for %d iterations and length %d", bad.iterations, bad.length)
+		}
+	}
+	err = runSourceError(t, "nil pbkdf2: 'pw' salt: 's' iterations: 1 length: 1000000000000")
+	if err == nil || !strings.Contains(err.Error(), "pbkdf2 length must be between 1 and 1024") {
+		t.Errorf("Expected a length error, got %v", err)
+	}
+
+	// Via send
+	result, err := vm.send(nil, "hmacSha256:key:", []interface{}{"what do ya want for nothing?", "Jefe"})
+	if err != nil || result != mac {
+		t.Errorf("hmacSha256:key: via send returned %v (%v)", result, err)
+	}
+	result, err = vm.send(nil, "pbkdf2:salt:iterations:length:", []interface{}{"password", "salt", int64(1), int64(32)})
+	if err != nil || result != tests[0].expected {
+		t.Errorf("pbkdf2:salt:iterations:length: via send returned %v (%v)", result, err)
+	}
+}
+
 // TestCompressionPrimitives tests the compression primitives
 func TestCompressionPrimitives(t *testing.T) {
 	vm := &VM{}
@@ -144,6 +206,332 @@ func TestJSONPrimitives(t *testing.T) {
 	}
 }
 
+// TestJSONPrettyPrimitives tests indented JSON generation
+func TestJSONPrettyPrimitives(t *testing.T) {
+	vm := &VM{}
+
+	value := map[string]interface{}{
+		"name": "Alice",
+		"tags": &Array{Elements: []interface{}{"a", int64(1)}},
+		"address": map[interface{}]interface{}{
+			"city": "Paris",
+		},
+	}
+
+	pretty, err := vm.jsonGeneratePretty(value, 2)
+	if err != nil {
+		t.Fatalf("JSON pretty generate failed: %v", err)
+	}
+	expected := `{
+  "address": {
+    "city": "Paris"
+  },
+  "name": "Alice",
+  "tags": [
+    "a",
+    1
+  ]
+}`
+	if pretty != expected {
+		t.Errorf("Unexpected pretty JSON:\n%s\nexpected:\n%s", pretty, expected)
+	}
+
+	// Round-trip back through jsonParse
+	parsed, err := vm.jsonParse(pretty)
+	if err != nil {
+		t.Fatalf("JSON parse of pretty output failed: %v", err)
+	}
+	obj, ok := parsed.(*Dictionary)
+	if !ok {
+		t.Fatalf("Expected Dictionary, got %T", parsed)
+	}
+	if name, _ := obj.At("name"); name != "Alice" {
+		t.Errorf("Expected name Alice, got %v", name)
+	}
+	tagsValue, _ := obj.At("tags")
+	tags, ok := tagsValue.(*Array)
+	if !ok || len(tags.Elements) != 2 || tags.Elements[1] != int64(1) {
+		t.Errorf("Unexpected tags after round-trip: %v", tagsValue)
+	}
+
+	// Custom indent width
+	four, err := vm.jsonGeneratePretty(&Array{Elements: []interface{}{true}}, 4)
+	if err != nil {
+		t.Fatalf("JSON pretty generate failed: %v", err)
+	}
+	if four != "[\n    true\n]" {
+		t.Errorf("Unexpected 4-space output: %q", four)
+	}
+
+	if _, err := vm.jsonGeneratePretty(nil, -1); err == nil {
+		t.Error("Expected error for negative indent")
+	}
+}
+
+// TestURLPrimitives tests URL encoding, decoding, and query strings
+func TestURLPrimitives(t *testing.T) {
+	vm := &VM{}
+
+	encoded := vm.urlEncode("a b&c=d/é?")
+	if encoded != "a+b%26c%3Dd%2F%C3%A9%3F" {
+		t.Errorf("Unexpected URL encoding: %s", encoded)
+	}
+
+	decoded, err := vm.urlDecode(encoded)
+	if err != nil {
+		t.Fatalf("URL decode failed: %v", err)
+	}
+	if decoded != "a b&c=d/é?" {
+		t.Errorf("URL round-trip failed: %s", decoded)
+	}
+
+	if _, err := vm.urlDecode("%zz"); err == nil {
+		t.Error("Expected error decoding malformed escape")
+	}
+
+	query, err := vm.queryString(map[interface{}]interface{}{
+		"q":    "smog lang",
+		"page": int64(2),
+		"a&b":  "x=y",
+	})
+	if err != nil {
+		t.Fatalf("queryString failed: %v", err)
+	}
+	if query != "a%26b=x%3Dy&page=2&q=smog+lang" {
+		t.Errorf("Unexpected query string: %s", query)
+	}
+
+	if _, err := vm.queryString("not a dict"); err == nil {
+		t.Error("Expected error for non-dictionary argument")
+	}
+}
+
+// TestNumberConversionPrimitives tests hex and base conversions
+func TestNumberConversionPrimitives(t *testing.T) {
+	vm := &VM{}
+
+	for _, n := range []int64{0, 255, 4096, -42, 9223372036854775807} {
+		hex, err := vm.send(n, "asHexString", nil)
+		if err != nil {
+			t.Fatalf("asHexString failed: %v", err)
+		}
+		back, err := vm.send(hex, "hexStringAsInteger", nil)
+		if err != nil {
+			t.Fatalf("hexStringAsInteger failed: %v", err)
+		}
+		if back != n {
+			t.Errorf("Hex round-trip of %d gave %v (via %v)", n, back, hex)
+		}
+	}
+
+	if hex := vm.asHexString(255); hex != "ff" {
+		t.Errorf("Expected ff, got %s", hex)
+	}
+	if n, err := vm.hexStringAsInteger("0xFF"); err != nil || n != 255 {
+		t.Errorf("Expected 255 from 0xFF, got %d (%v)", n, err)
+	}
+	if _, err := vm.hexStringAsInteger("xyz"); err == nil {
+		t.Error("Expected error for malformed hex")
+	}
+
+	n, err := vm.send(nil, "asInteger:base:", []interface{}{"1010", int64(2)})
+	if err != nil || n != int64(10) {
+		t.Errorf("Expected 10 from binary 1010, got %v (%v)", n, err)
+	}
+	if _, err := vm.asIntegerBase("z", 10); err == nil {
+		t.Error("Expected error for invalid digit")
+	}
+	if _, err := vm.asIntegerBase("1", 1); err == nil {
+		t.Error("Expected error for base out of range")
+	}
+}
+
+// TestStringFormattingPrimitives tests padding, centering and truncation
+func TestStringFormattingPrimitives(t *testing.T) {
+	vm := &VM{}
+
+	tests := []struct {
+		receiver string
+		selector string
+		args     []interface{}
+		expected string
+	}{
+		{"hi", "padRightTo:", []interface{}{int64(5)}, "hi   "},
+		{"hi", "padLeftTo:", []interface{}{int64(5)}, "   hi"},
+		{"42", "padLeftTo:with:", []interface{}{int64(5), "0"}, "00042"},
+		{"ab", "padRightTo:with:", []interface{}{int64(4), "."}, "ab.."},
+		{"hi", "center:", []interface{}{int64(6)}, "  hi  "},
+		{"hi", "center:", []interface{}{int64(5)}, " hi  "},
+		{"hi", "center:with:", []interface{}{int64(6), "*"}, "**hi**"},
+		{"hello", "truncateTo:", []interface{}{int64(3)}, "hel"},
+		{"hello", "truncateTo:", []interface{}{int64(0)}, ""},
+		// Longer than the target: padding is a no-op, truncation cuts
+		{"toolong", "padRightTo:", []interface{}{int64(3)}, "toolong"},
+		{"toolong", "padLeftTo:", []interface{}{int64(3)}, "toolong"},
+		{"toolong", "center:", []interface{}{int64(3)}, "toolong"},
+		{"hi", "truncateTo:", []interface{}{int64(10)}, "hi"},
+		// Widths count characters, not bytes
+		{"héllo", "padRightTo:", []interface{}{int64(6)}, "héllo "},
+		{"日本語", "truncateTo:", []interface{}{int64(2)}, "日本"},
+		{"x", "padLeftTo:with:", []interface{}{int64(3), "·"}, "··x"},
+	}
+
+	for _, tt := range tests {
+		result, err := vm.send(tt.receiver, tt.selector, tt.args)
+		if err != nil {
+			t.Errorf("'%s' %s %v failed: %v", tt.receiver, tt.selector, tt.args, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("'%s' %s %v: expected %q, got %q", tt.receiver, tt.selector, tt.args, tt.expected, result)
+		}
+	}
+
+	for _, bad := range []struct {
+		selector string
+		args     []interface{}
+	}{
+		{"padLeftTo:", []interface{}{"5"}},
+		{"padLeftTo:with:", []interface{}{int64(5), "ab"}},
+		{"center:with:", []interface{}{int64(5), int64(0)}},
+		{"truncateTo:", []interface{}{int64(-1)}},
+		{"padRightTo:", []interface{}{int64(-3)}},
+	} {
+		if _, err := vm.send("hi", bad.selector, bad.args); err == nil {
+			t.Errorf("%s %v: expected error, got nil", bad.selector, bad.args)
+		}
+	}
+
+	// The same messages work from source code
+	if result := runSource(t, `'7' padLeftTo: 3 with: '0'`); result != "007" {
+		t.Errorf("Expected '007', got %v", result)
+	}
+
+	for _, source := range []string{
+		"'hi' padLeftTo: 1000000000000",
+		"'hi' center: 1000000000000 with: '*'",
+		"'hi' truncateTo: -1",
+	} {
+		err := runSourceError(t, source)
+		if err == nil || !strings.Contains(err.Error(), "width must be between 0 and 268435456") {
+			t.Errorf("%s: expected a width error, got %v", source, err)
+		}
+	}
+}
+
+// TestStringConcatenation tests , on strings, both folded by the
+// compiler and sent at runtime
+func TestStringConcatenation(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`'Hello' , ', ' , 'world'`, "Hello, world"},
+		{`| name | name := 'smog'. 'Hello ' , name`, "Hello smog"},
+		{`| name | name := 'smog'. name , '!' , '!'`, "smog!!"},
+		{`'' , ''`, ""},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, `| n | n := 3. 'a' , n`); err == nil {
+		t.Error("Expected error concatenating a non-string")
+	}
+}
+
+func TestStringEditing(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`'a-b-c' copyReplaceAll: '-' with: ', '`, "a, b, c"},
+		{`'banana' copyReplaceAll: 'an' with: ''`, "ba"},
+		{`'abc' copyReplaceAll: 'x' with: 'y'`, "abc"},
+		{`'smog' insert: '>' at: 1`, ">smog"},
+		{`'smog' insert: '-' at: 3`, "sm-og"},
+		{`'smog' insert: '!' at: 5`, "smog!"},
+		{`'' insert: 'x' at: 1`, "x"},
+		{`'smalltalk' removeFrom: 1 to: 5`, "talk"},
+		{`'smalltalk' removeFrom: 6 to: 9`, "small"},
+		{`'smalltalk' removeFrom: 3 to: 2`, "smalltalk"},
+		// Indices count characters, not bytes
+		{`'héllo' insert: 'X' at: 3`, "héXllo"},
+		{`'日本語' removeFrom: 2 to: 2`, "日語"},
+		// The receiver is left unchanged
+		{`| s | s := 'abc'. s insert: 'x' at: 1. s removeFrom: 1 to: 2. s`, "abc"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{`'smog' insert: 'x' at: 0`, "index 0 out of bounds"},
+		{`'smog' insert: 'x' at: 6`, "index 6 out of bounds"},
+		{`'smog' removeFrom: 0 to: 2`, "range 0 to 2 out of bounds"},
+		{`'smog' removeFrom: 2 to: 5`, "range 2 to 5 out of bounds"},
+		{`'smog' removeFrom: 4 to: 2`, "range 4 to 2 out of bounds"},
+		{`'smog' copyReplaceAll: '' with: 'x'`, "cannot replace an empty string"},
+		{`'smog' copyReplaceAll: 1 with: 'x'`, "arguments must be Strings"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+func TestStringRepeatAndCapitalize(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`'ab' repeat: 3`, "ababab"},
+		{`'ab' * 3`, "ababab"},
+		{`'ab' repeat: 0`, ""},
+		{`'' * 5`, ""},
+		{`'é' * 2`, "éé"},
+		{`'smog' asUppercaseFirst`, "Smog"},
+		{`'Smog' asUppercaseFirst`, "Smog"},
+		{`'' asUppercaseFirst`, ""},
+		{`'élan vital' asUppercaseFirst`, "Élan vital"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{`'ab' repeat: -1`, "repeat: count must be a non-negative integer, got -1"},
+		{`'ab' * -2`, "* count must be a non-negative integer, got -2"},
+		{`'ab' repeat: 'x'`, "repeat: count must be an integer"},
+		{`'ab' repeat: 1000000000000`, "repeat: result would be larger than the maximum of 268435456 characters"},
+		{`'ab' * 4611686018427387904`, "* result would be larger than the maximum"},
+		{`'ab' * 1.5`, "* count must be an integer"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
 // TestRegexPrimitives tests the regex primitives
 func TestRegexPrimitives(t *testing.T) {
 	vm := &VM{}
@@ -220,8 +608,8 @@ func TestRandomPrimitives(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Random bytes failed: %v", err)
 	}
-	if len(bytes) == 0 {
-		t.Error("Random bytes returned empty string")
+	if len(bytes.Bytes) != 16 {
+		t.Errorf("Random bytes returned %d bytes, want 16", len(bytes.Bytes))
 	}
 }
 
@@ -324,3 +712,33 @@ func TestFileIOPrimitives(t *testing.T) {
 		t.Error("fileExists returned true for deleted file")
 	}
 }
+
+// TestDigitsAndThousands tests splitting integers into digits and
+// grouping them for printing
+func TestDigitsAndThousands(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"1234 digits", ints(1, 2, 3, 4)},
+		{"7 digits", ints(7)},
+		{"0 digits", ints(0)},
+		{"1000 digits", ints(1, 0, 0, 0)},
+		{"-42 digits", ints(4, 2)},
+		{"9223372036854775807 digits size", int64(19)},
+		{"(4096 digits) inject: 0 into: [:sum :d | sum + d]", int64(19)},
+		{"0 printStringWithThousands", "0"},
+		{"999 printStringWithThousands", "999"},
+		{"1000 printStringWithThousands", "1,000"},
+		{"1000000 printStringWithThousands", "1,000,000"},
+		{"1234567 printStringWithThousands", "1,234,567"},
+		{"-12345 printStringWithThousands", "-12,345"},
+		{"-999 printStringWithThousands", "-999"},
+		{"9223372036854775807 printStringWithThousands", "9,223,372,036,854,775,807"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
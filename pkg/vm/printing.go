@@ -0,0 +1,241 @@
+// Package vm - printString rendering
+package vm
+
+import (
+	"strconv"
+	"fmt"
+	"strings"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+)
+
+// Default limits on how much of a collection printString shows.
+const (
+	DefaultPrintElements = 100 // Elements shown per collection
+	DefaultPrintDepth    = 10  // Collections shown nested inside each other
+)
+
+// printLimits bounds how much of a large or deeply nested collection is
+// rendered. A zero limit means no limit. When printing for a VM it also
+// carries how instances with their own printString or printOn: render.
+type printLimits struct {
+	elements int                            // Most elements shown per collection
+	depth    int                            // Most collections shown nested inside each other
+	instance func(*Instance) (string, bool) // Renders an instance its own way (see printInstance); nil outside a VM
+}
+
+// SetPrintLimits bounds what printString and displayString show of
+// collections, so printing a huge array in the REPL or a log doesn't
+// flood the output.
+//
+// A collection with more than elements elements shows the first ones
+// followed by "...", and collections nested more than depth deep show as
+// "#(...)" (or "a Dictionary(...)" and so on). Zero or a negative number
+// removes that limit. New VMs use DefaultPrintElements and
+// DefaultPrintDepth; blocks and methods run by this VM share the setting.
+//
+// Example:
+//   v := vm.New()
+//   v.SetPrintLimits(3, 0)  // #(1 2 3 4 5) printString -> "#(1 2 3 ...)"
+func (vm *VM) SetPrintLimits(elements, depth int) {
+	vm.printing = printLimits{elements: max(elements, 0), depth: max(depth, 0)}
+}
+
+// shown answers how many of n elements the limits allow printing.
+func (l printLimits) shown(n int) int {
+	if l.elements > 0 && n > l.elements {
+		return l.elements
+	}
+	return n
+}
+
+// tooDeep reports whether a collection inside the ones on path is nested
+// too deeply to print.
+func (l printLimits) tooDeep(path *identitySet) bool {
+	return l.depth > 0 && len(*path) >= l.depth
+}
+
+// join joins the rendered parts of a collection of n elements, adding
+// "..." when the limits left some out.
+func (l printLimits) join(parts []string, n int) string {
+	if len(parts) < n {
+		parts = append(parts, "...")
+	}
+	return strings.Join(parts, " ")
+}
+
+// printString renders a value the way the printString message answers it:
+// as source-like text a programmer would recognize.
+//
+// Strings are quoted (with embedded quotes doubled), arrays list their
+// elements' printStrings, and instances and other objects without a
+// literal form, such as blocks and streams, name their class with an
+// article. Classes print as their name. Collections are cut short
+// according to the VM's print limits (see SetPrintLimits).
+//
+// Example:
+//   'abc' printString       -> "'abc'"
+//   #(1 'a') printString    -> "#(1 'a')"
+//   Account new printString -> "an Account"
+func (vm *VM) printString(value interface{}) string {
+	var path identitySet
+	return vm.printStringOn(value, &path)
+}
+
+// displayString renders a value for showing to a user rather than a
+// programmer: like printString, except that a string is shown as its
+// contents without quotes.
+//
+// Example:
+//   'abc' displayString    -> "abc"
+//   #('abc') displayString -> "#('abc')"
+func (vm *VM) displayString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return vm.printString(value)
+}
+
+// printStringOn renders value, showing an array already on path (one that
+// contains itself), or nested deeper than the print limits allow, as
+// "#(...)".
+func (vm *VM) printStringOn(value interface{}, path *identitySet) string {
+	limits := vm.printing
+	limits.instance = vm.printInstance
+	return formatValue(value, path, limits)
+}
+
+// printInstance renders an instance whose class says how it prints,
+// wherever it turns up: on its own, inside a collection, or as an inspected
+// field. A class that defines printString is sent it; otherwise one that
+// defines printOn: is handed a new WriteStream and what it writes is the
+// result. It reports false when the class defines neither, so the
+// instance prints as "an Account", and when the method fails or its
+// printString doesn't answer a String, so a broken print method can't
+// stop a collection from printing.
+//
+// Example:
+//   Object subclass: #Money [
+//       | cents |
+//       printOn: aStream [ aStream nextPutAll: '$'; print: cents ]
+//   ]
+//   {Money new cents: 5} printString   -> "#($5)"
+func (vm *VM) printInstance(inst *Instance) (string, bool) {
+	if method, _ := vm.lookupMethod(inst.Class, "printString"); method != nil {
+		result, err := vm.send(inst, "printString", nil)
+		s, ok := result.(string)
+		return s, err == nil && ok
+	}
+	return vm.printOnString(inst)
+}
+
+// printOnString renders inst through its class's printOn:, reporting
+// false if the class doesn't define one or it fails.
+func (vm *VM) printOnString(inst *Instance) (string, bool) {
+	if method, _ := vm.lookupMethod(inst.Class, "printOn:"); method == nil {
+		return "", false
+	}
+	stream := newWriteStream()
+	if _, err := vm.send(inst, "printOn:", []interface{}{stream}); err != nil {
+		return "", false
+	}
+	return stream.Contents(), true
+}
+
+// printOn answers receiver printOn: aStream for a receiver without a
+// printOn: of its own: it writes the receiver's printString to the
+// WriteStream and answers the receiver. An instance is written the way
+// Object prints it, as "an Account", so a printOn: method can begin with
+// super printOn: aStream and add to it.
+func (vm *VM) printOn(receiver interface{}, args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("printOn: expects 1 argument, got %d", len(args))
+	}
+	stream, ok := args[0].(*WriteStream)
+	if !ok {
+		return nil, fmt.Errorf("printOn: argument must be a WriteStream, got %s", describeValue(args[0]))
+	}
+	if inst, ok := receiver.(*Instance); ok {
+		stream.contents.WriteString(withArticle(inst.Class.Name))
+	} else {
+		stream.contents.WriteString(vm.printString(receiver))
+	}
+	return receiver, nil
+}
+
+// basicPrintString answers the printString primitive: value's printString
+// without sending printString to value itself. The primitive is only
+// reached when the receiver's class has no printString of its own, or
+// from one that does through super printString, so sending it again
+// would never finish. An instance still prints through its printOn:, and
+// its fields and elements print their own way.
+func (vm *VM) basicPrintString(value interface{}) string {
+	if inst, ok := value.(*Instance); ok {
+		if s, ok := vm.printOnString(inst); ok {
+			return s
+		}
+		return withArticle(inst.Class.Name)
+	}
+	return vm.printString(value)
+}
+
+// formatValue renders value the way printStringOn does, within limits.
+func formatValue(value interface{}, path *identitySet, limits printLimits) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case *Array:
+		if limits.tooDeep(path) || !path.add(v) {
+			return "#(...)"
+		}
+		defer path.remove(v)
+		parts := make([]string, 0, len(v.Elements))
+		for _, elem := range v.Elements[:limits.shown(len(v.Elements))] {
+			parts = append(parts, formatValue(elem, path, limits))
+		}
+		return "#(" + limits.join(parts, len(v.Elements)) + ")"
+	case *Dictionary, *Bag, *Set:
+		return formatElement(v, path, limits)
+	case *Instance:
+		if limits.instance != nil {
+			if s, ok := limits.instance(v); ok {
+				return s
+			}
+		}
+		return withArticle(v.Class.Name)
+	case *bytecode.ClassDefinition:
+		return v.Name
+	case *Block:
+		return "a Block"
+	case float64:
+		return formatFloat(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// withArticle prefixes name with "a" or "an", as in "an Account".
+func withArticle(name string) string {
+	if name != "" && strings.ContainsRune("AEIOUaeiou", rune(name[0])) {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// formatFloat renders a Float so that it never looks like an Integer: a
+// whole number keeps a ".0", so 2.0 prints as 2.0 and #(1 2.0 3) prints
+// as it was written. Other floats print in Go's shortest form, which
+// reads back as the same number.
+//
+// Example:
+//   formatFloat(2)     -> "2.0"
+//   formatFloat(2.5)   -> "2.5"
+//   formatFloat(1e21)  -> "1e+21"
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
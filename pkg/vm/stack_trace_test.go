@@ -1,9 +1,11 @@
 package vm
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
+	"github.com/kristofer/smog/pkg/bytecode"
 	"github.com/kristofer/smog/pkg/compiler"
 	"github.com/kristofer/smog/pkg/parser"
 )
@@ -54,6 +56,20 @@ x / y
 	if !strings.Contains(errMsg, "Stack trace:") {
 		t.Errorf("Expected stack trace in error message, got: %v", errMsg)
 	}
+
+	// The top-level frame points at the failing send, not the start
+	sendIP := -1
+	for i, inst := range bc.Instructions {
+		if inst.Op == bytecode.OpSend {
+			sendIP = i
+		}
+	}
+	if len(runtimeErr.StackTrace) == 0 || runtimeErr.StackTrace[0].Name != "main program" {
+		t.Fatalf("Expected the trace to start with the main program, got %v", runtimeErr.StackTrace)
+	}
+	if ip := runtimeErr.StackTrace[0].IP; ip != sendIP {
+		t.Errorf("Expected the main program frame at IP %d, got %d", sendIP, ip)
+	}
 }
 
 // TestStackTraceWithNestedCalls tests stack traces with nested message sends
@@ -120,6 +136,70 @@ obj method1
 	}
 }
 
+// TestRuntimeErrorFields tests that an embedder can inspect a failed run
+// through the RuntimeError's fields instead of its message
+func TestRuntimeErrorFields(t *testing.T) {
+	classes := `
+Object subclass: #Account [
+    | balance |
+    share: n [ ^self split: n ]
+    split: n [ ^100 / n ]
+]
+`
+	tests := []struct {
+		name     string
+		source   string
+		selector string
+		message  string
+		frames   []string // Selectors from the top level down to the failure
+	}{
+		{"division by zero", classes + "Account new share: 0", "/",
+			"division by zero", []string{"share:", "split:", "/"}},
+		{"doesNotUnderstand", classes + "Account new withdraw: 5", "withdraw:",
+			"instance of Account does not understand message 'withdraw:'", []string{"withdraw:"}},
+		{"inside a block", "#(1 2) do: [:x | nil frobnicate]", "frobnicate",
+			"nil does not understand 'frobnicate'", []string{"do:", "frobnicate"}},
+	}
+	for _, tt := range tests {
+		err := runSourceError(t, tt.source)
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) {
+			t.Errorf("%s: expected a *RuntimeError, got %T: %v", tt.name, err, err)
+			continue
+		}
+		if rtErr.Selector != tt.selector {
+			t.Errorf("%s: expected selector %q, got %q", tt.name, tt.selector, rtErr.Selector)
+		}
+		if !strings.HasPrefix(rtErr.Message, tt.message) {
+			t.Errorf("%s: expected message starting %q, got %q", tt.name, tt.message, rtErr.Message)
+		}
+		var frames []string
+		for _, frame := range rtErr.StackTrace {
+			if frame.Selector != "" {
+				frames = append(frames, frame.Selector)
+			}
+		}
+		if strings.Join(frames, " ") != strings.Join(tt.frames, " ") {
+			t.Errorf("%s: expected frames %v, got %v", tt.name, tt.frames, frames)
+		}
+	}
+
+	// The typed cause is still reachable
+	var zeroDivide *ZeroDivideError
+	if err := runSourceError(t, classes+"Account new share: 0"); !errors.As(err, &zeroDivide) || zeroDivide.Dividend != int64(100) {
+		t.Errorf("Expected a ZeroDivideError dividing 100, got %v", err)
+	}
+
+	// Errors raised outside a message send are RuntimeErrors too
+	var rtErr *RuntimeError
+	if err := runSourceError(t, "Missing new"); !errors.As(err, &rtErr) || rtErr.Selector != "" {
+		t.Errorf("Expected a RuntimeError without a selector, got %T: %v", err, err)
+	}
+	if err := runSourceError(t, "| x | x := 1. Missing new"); !errors.As(err, &rtErr) || rtErr.StackTrace[0].IP == 0 {
+		t.Errorf("Expected the main program frame past IP 0, got %v", err)
+	}
+}
+
 // TestNoStackTraceOnSuccess tests that successful execution doesn't create stack traces
 func TestNoStackTraceOnSuccess(t *testing.T) {
 	source := `
@@ -0,0 +1,211 @@
+// Package vm - string streams
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WriteStream builds up a string piece by piece.
+//
+// Appending to a stream is cheaper than concatenating strings with , in a
+// loop, since each , copies everything built so far. String
+// streamContents: creates a stream, hands it to a block and answers what
+// the block wrote.
+//
+// Example:
+//   String streamContents: [:s |
+//       s nextPutAll: 'x = '; print: 42; nextPut: '!']   "'x = 42!'"
+//   String streamContents: [:s | s << 'n = ' << 3]   "'n = 3'"
+type WriteStream struct {
+	contents strings.Builder // Everything written so far
+}
+
+// newWriteStream creates an empty WriteStream.
+func newWriteStream() *WriteStream {
+	return &WriteStream{}
+}
+
+// Contents returns everything written so far.
+func (w *WriteStream) Contents() string {
+	return w.contents.String()
+}
+
+// String returns a printable description of the stream.
+func (w *WriteStream) String() string {
+	return "a WriteStream"
+}
+
+// streamContents answers String streamContents: block, running block with
+// a new WriteStream and answering the string it wrote.
+func (vm *VM) streamContents(args []interface{}) (interface{}, error) {
+	block, err := blockArg("streamContents:", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	stream := newWriteStream()
+	if _, err := vm.executeBlock(block, []interface{}{stream}); err != nil {
+		return nil, err
+	}
+	return stream.Contents(), nil
+}
+
+// sendWriteStream handles messages sent to a WriteStream. The writing
+// messages answer their argument, like other collections' add:, so they
+// are usually sent in a cascade.
+func (vm *VM) sendWriteStream(w *WriteStream, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "nextPutAll:", "nextPut:":
+		// nextPut: takes a single character, which smog writes as a
+		// one-character string
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("%s expects 1 argument, got %d", selector, len(args))
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, true, fmt.Errorf("%s argument must be a String, got %s", selector, describeValue(args[0]))
+		}
+		if selector == "nextPut:" && utf8.RuneCountInString(s) != 1 {
+			return nil, true, fmt.Errorf("nextPut: argument must be a single character, got %s", vm.printString(s))
+		}
+		w.contents.WriteString(s)
+		return args[0], true, nil
+	case "print:":
+		// Append the argument's printString: s print: 'a' writes 'a' with quotes
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("print: expects 1 argument, got %d", len(args))
+		}
+		w.contents.WriteString(vm.printString(args[0]))
+		return args[0], true, nil
+	case "<<":
+		// Append a string as it is and anything else as its printString,
+		// answering the stream so appends chain: s << 'n = ' << 3
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("<< expects 1 argument, got %d", len(args))
+		}
+		if s, ok := args[0].(string); ok {
+			w.contents.WriteString(s)
+		} else {
+			w.contents.WriteString(vm.printString(args[0]))
+		}
+		return w, true, nil
+	case "cr":
+		w.contents.WriteString("\n")
+		return w, true, nil
+	case "space":
+		w.contents.WriteString(" ")
+		return w, true, nil
+	case "tab":
+		w.contents.WriteString("\t")
+		return w, true, nil
+	case "contents":
+		return w.Contents(), true, nil
+	}
+	return nil, false, nil
+}
+
+// ReadStream reads a string one character at a time, for writing simple
+// tokenizers and parsers in smog. It is created with ReadStream on:, and
+// works on characters (runes) rather than bytes, so accented letters and
+// other multi-byte characters count as one. Characters are answered as
+// one-character strings, the same way smog writes them.
+//
+// Example:
+//   | s |
+//   s := ReadStream on: 'name=smog;kind=language'.
+//   s upTo: '='    "'name'"
+//   s upTo: ';'    "'smog'"
+//   s next         "'k'"
+//   s peek         "'i'"
+//   s upToEnd      "'ind=language'"
+//   s atEnd        "true"
+type ReadStream struct {
+	runes    []rune // The characters being read
+	position int    // Index of the next character to read
+}
+
+// newReadStream creates a ReadStream at the start of s.
+func newReadStream(s string) *ReadStream {
+	return &ReadStream{runes: []rune(s)}
+}
+
+// AtEnd reports whether every character has been read.
+func (r *ReadStream) AtEnd() bool {
+	return r.position >= len(r.runes)
+}
+
+// String returns a printable description of the stream.
+func (r *ReadStream) String() string {
+	return "a ReadStream"
+}
+
+// readStreamOn answers ReadStream on: aString.
+func readStreamOn(args []interface{}) (*ReadStream, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("on: expects 1 argument, got %d", len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("on: argument must be a String, got %s", describeValue(args[0]))
+	}
+	return newReadStream(s), nil
+}
+
+// sendReadStream handles messages sent to a ReadStream. next and peek
+// answer nil at the end, and upTo: answers everything left when the
+// delimiter never comes, so a loop can stop by checking atEnd.
+func (vm *VM) sendReadStream(r *ReadStream, selector string, args []interface{}) (result interface{}, handled bool, err error) {
+	switch selector {
+	case "next", "peek":
+		if r.AtEnd() {
+			return nil, true, nil
+		}
+		c := string(r.runes[r.position])
+		if selector == "next" {
+			r.position++
+		}
+		return c, true, nil
+	case "upTo:":
+		// Read up to the delimiter, which is read too but not answered
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("upTo: expects 1 argument, got %d", len(args))
+		}
+		delimiter, ok := args[0].(string)
+		if !ok || utf8.RuneCountInString(delimiter) != 1 {
+			return nil, true, fmt.Errorf("upTo: argument must be a single character, got %s", vm.printString(args[0]))
+		}
+		d, _ := utf8.DecodeRuneInString(delimiter)
+		start := r.position
+		for !r.AtEnd() && r.runes[r.position] != d {
+			r.position++
+		}
+		token := string(r.runes[start:r.position])
+		if !r.AtEnd() {
+			r.position++
+		}
+		return token, true, nil
+	case "upToEnd":
+		rest := string(r.runes[r.position:])
+		r.position = len(r.runes)
+		return rest, true, nil
+	case "skipSeparators":
+		// Skip spaces, tabs and newlines
+		for !r.AtEnd() && unicode.IsSpace(r.runes[r.position]) {
+			r.position++
+		}
+		return r, true, nil
+	case "atEnd":
+		return r.AtEnd(), true, nil
+	case "position":
+		// How many characters have been read
+		return int64(r.position), true, nil
+	case "reset":
+		r.position = 0
+		return r, true, nil
+	case "contents":
+		return string(r.runes), true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimesCollect(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"5 timesCollect: [:i | i * i]", &Array{Elements: []interface{}{int64(1), int64(4), int64(9), int64(16), int64(25)}}},
+		{"3 timesCollect: [:i | i printString]", &Array{Elements: []interface{}{"1", "2", "3"}}},
+		{"0 timesCollect: [:i | i]", &Array{Elements: []interface{}{}}},
+		{"-2 timesCollect: [:i | i]", &Array{Elements: []interface{}{}}},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "3 timesCollect: [42]")
+	if err == nil || !strings.Contains(err.Error(), "block must take 1 argument") {
+		t.Errorf("Expected an error for a block without a parameter, got %v", err)
+	}
+}
+
+func TestStreamContents(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"String streamContents: [:s | s nextPutAll: 'a'; nextPutAll: 'b']", "ab"},
+		{"String streamContents: [:s | ]", ""},
+		{"String streamContents: [:s | s nextPutAll: 'x = '; print: 42; nextPut: '!']", "x = 42!"},
+		{"String streamContents: [:s | s print: 'q'; space; print: #(1 2)]", "'q' #(1 2)"},
+		{"String streamContents: [:s | s nextPutAll: 'a'; cr; tab; nextPutAll: 'b']", "a\n\tb"},
+		{"String streamContents: [:s | #('x' 'y' 'z') do: [:each | s nextPutAll: each]]", "xyz"},
+		{"String streamContents: [:s | s nextPut: 'é']", "é"},
+		// A stream can also be created directly
+		{"| w | w := WriteStream new. w nextPutAll: 'hi'. w contents", "hi"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"String streamContents: [:s | s nextPutAll: 3]", "argument must be a String"},
+		{"String streamContents: [:s | s nextPut: 'ab']", "single character"},
+		{"String streamContents: ['no stream']", "block must take 1 argument"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	// Tokenize key=value pairs with upTo:, stopping at atEnd
+	tokenize := `| s pairs |
+s := ReadStream on: 'name=smog;kind=language;year=2024'.
+pairs := #().
+[s atEnd] whileFalse: [pairs := pairs , {s upTo: '='. s upTo: ';'}].
+pairs`
+	expected := &Array{Elements: []interface{}{"name", "smog", "kind", "language", "year", "2024"}}
+	if result := runSource(t, tokenize); !valuesEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"(ReadStream on: 'ab') next", "a"},
+		{"| s | s := ReadStream on: 'ab'. s next. s next", "b"},
+		{"| s | s := ReadStream on: 'ab'. s next; next. s next", nil},
+		{"| s | s := ReadStream on: 'ab'. s peek. s peek", "a"},
+		{"| s | s := ReadStream on: 'a'. s next. s peek", nil},
+		// Characters, not bytes
+		{"| s | s := ReadStream on: 'héllo'. s next. s next", "é"},
+		{"| s | s := ReadStream on: 'héllo'. s upTo: 'l'", "hé"},
+		// atEnd
+		{"(ReadStream on: '') atEnd", true},
+		{"(ReadStream on: 'x') atEnd", false},
+		{"| s | s := ReadStream on: 'x'. s next. s atEnd", true},
+		{"| s | s := ReadStream on: 'a=1'. s upTo: '='. s atEnd", false},
+		{"| s | s := ReadStream on: 'a=1'. s upTo: '='. s upTo: ';'. s atEnd", true},
+		// upTo: reads past the delimiter, or to the end without one
+		{"| s | s := ReadStream on: 'a;b'. s upTo: ';'. s next", "b"},
+		{"| s | s := ReadStream on: 'abc'. s upTo: ';'", "abc"},
+		{"| s | s := ReadStream on: ';b'. s upTo: ';'", ""},
+		{"| s | s := ReadStream on: 'abc'. s upToEnd. s upTo: ';'", ""},
+		// skipSeparators
+		{"| s | s := ReadStream on: '  \t\nword'. s skipSeparators. s next", "w"},
+		{"| s | s := ReadStream on: 'one two'. s upTo: ' '. s skipSeparators. s upToEnd", "two"},
+		{"| s | s := ReadStream on: '   '. s skipSeparators. s atEnd", true},
+		// position, reset and contents
+		{"| s | s := ReadStream on: 'abc'. s next; next. s position", int64(2)},
+		{"| s | s := ReadStream on: 'abc'. s upToEnd. s reset. s next", "a"},
+		{"| s | s := ReadStream on: 'abc'. s next. s contents", "abc"},
+		{"(ReadStream on: 'x') printString", "a ReadStream"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"ReadStream on: 42", "on: argument must be a String, got"},
+		{"(ReadStream on: 'a') upTo: 'ab'", "upTo: argument must be a single character, got 'ab'"},
+		{"(ReadStream on: 'a') bogus", "unknown message: bogus"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringPrimitives(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// size counts characters, not bytes
+		{"'hello' size", int64(5)},
+		{"'héllo' size", int64(5)},
+		{"'日本語' size", int64(3)},
+		{"'' size", int64(0)},
+		// at: is 1-based and answers a one-character string
+		{"'hello' at: 1", "h"},
+		{"'hello' at: 5", "o"},
+		{"'héllo' at: 2", "é"},
+		{"'日本語' at: 3", "語"},
+		// , concatenates
+		{"'foo' , 'bar'", "foobar"},
+		{"'é' , 'à' , ''", "éà"},
+		{"| a | a := 'x'. (a , 'y') size", int64(2)},
+		// Case conversion
+		{"'Hello World' asUppercase", "HELLO WORLD"},
+		{"'Hello World' asLowercase", "hello world"},
+		{"'éLAN' asLowercase", "élan"},
+		// reversed keeps multi-byte characters intact
+		{"'hello' reversed", "olleh"},
+		{"'héllo' reversed", "olléh"},
+		{"'' reversed", ""},
+		{"'a' reversed", "a"},
+		// Numbers still add
+		{"3 + 4", int64(7)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"'hello' at: 0", "string index out of bounds: 0"},
+		{"'hello' at: 6", "string index out of bounds: 6"},
+		{"'héllo' at: 6", "string index out of bounds: 6"},
+		{"'' at: 1", "string index out of bounds: 1"},
+		{"'hello' at: 'a'", "at: index must be an integer"},
+		{"'hello' , 3", ", argument must be a String"},
+		// , is not +, and + is not ,
+		{"'hello' + 'world'", "+ not understood by a String"},
+		{"3 , 4", "unknown message: ,"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// strs builds an Array of strings for expected results.
+func strs(values ...string) *Array {
+	elements := make([]interface{}, len(values))
+	for i, v := range values {
+		elements[i] = v
+	}
+	return &Array{Elements: elements}
+}
+
+func TestStringLines(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"'one\ntwo' lines", strs("one", "two")},
+		// A trailing newline ends the last line without starting another
+		{"'one\ntwo\n' lines", strs("one", "two")},
+		{"'one\n\n' lines", strs("one", "")},
+		{"'\n' lines", strs("")},
+		{"'' lines", strs()},
+		{"'no newline' asLines", strs("no newline")},
+		// Empty lines in the middle are kept
+		{"'a\n\nb' lines", strs("a", "", "b")},
+		// CRLF endings split like LF ones; a lone CR is part of the line
+		{"'one\r\ntwo\r\n' lines", strs("one", "two")},
+		{"'a\rb\nc' lines", strs("a\rb", "c")},
+		{"'日本\n語' lines", strs("日本", "語")},
+		// withLineNumbersDo: passes each 1-based number and line
+		{"| out | out := ''. 'a\nb\n' withLineNumbersDo: [:n :line | out := out , n printString , line]. out", "1a2b"},
+		{"| count | count := 0. '' withLineNumbersDo: [:n :line | count := count + 1]. count", int64(0)},
+		{"'x\ny' withLineNumbersDo: [:n :line | n]", "x\ny"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "'a' withLineNumbersDo: [:line | line]")
+	if err == nil || !strings.Contains(err.Error(), "withLineNumbersDo: block must take 2 argument(s)") {
+		t.Errorf("Expected a block arity error, got %v", err)
+	}
+}
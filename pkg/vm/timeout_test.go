@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+)
+
+// TestValueWithTimeoutFastBlock tests that a quick block returns normally
+func TestValueWithTimeoutFastBlock(t *testing.T) {
+	result := runSource(t, `[3 + 4] valueWithTimeout: 1000`)
+	if result != int64(7) {
+		t.Errorf("Expected 7, got %v", result)
+	}
+}
+
+// TestValueWithTimeoutSlowBlock tests that a runaway block is aborted
+func TestValueWithTimeoutSlowBlock(t *testing.T) {
+	start := time.Now()
+	err := runSourceError(t, `[[true] whileTrue: [nil]] valueWithTimeout: 50`)
+	if err == nil {
+		t.Fatal("Expected timeout error, got nil")
+	}
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected *TimeoutError, got %T: %v", err, err)
+	}
+	if timeoutErr.Limit != 50*time.Millisecond {
+		t.Errorf("Expected 50ms limit, got %v", timeoutErr.Limit)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Timeout took too long to fire: %v", elapsed)
+	}
+}
+
+// TestValueWithTimeoutInsideMethod tests that the deadline reaches nested method calls
+func TestValueWithTimeoutInsideMethod(t *testing.T) {
+	err := runSourceError(t, `
+		Object subclass: #Spinner [
+			spin [ [true] whileTrue: [nil] ]
+		]
+		[Spinner new spin] valueWithTimeout: 50
+	`)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected *TimeoutError, got %v", err)
+	}
+}
+
+// TestValueWithTimeoutRejectsBlockWithArgs tests argument validation
+func TestValueWithTimeoutRejectsBlockWithArgs(t *testing.T) {
+	if err := runSourceError(t, `[:x | x] valueWithTimeout: 10`); err == nil {
+		t.Error("Expected error for block with parameters")
+	}
+}
+
+// TestValueWithTimeoutIsCatchable tests that on:do: can recover from a
+// timeout, as a Timeout or any Error, once valueWithTimeout: reports it
+func TestValueWithTimeoutIsCatchable(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"[[[true] whileTrue: [nil]] valueWithTimeout: 20] on: Timeout do: [:e | 'too slow']", "too slow"},
+		{"[[[true] whileTrue: [nil]] valueWithTimeout: 20] on: Error do: [:e | e class]", builtinClasses["Timeout"]},
+		{"[[[true] whileTrue: [nil]] valueWithTimeout: 20] on: ZeroDivide do: [:e | 0]. 1", nil},
+		// A handler inside the block can't keep it running past the limit
+		{"[[[[true] whileTrue: [nil]] on: Error do: [:e | 'inside']] valueWithTimeout: 20] on: Timeout do: [:e | 'outside']", "outside"},
+	}
+	for _, tt := range tests {
+		if tt.expected == nil {
+			var timeoutErr *TimeoutError
+			if err := runSourceError(t, tt.source); !errors.As(err, &timeoutErr) {
+				t.Errorf("%s: expected the timeout to pass the handler, got %v", tt.source, err)
+			}
+			continue
+		}
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestValueWithTimeoutWaitsForBlock tests that a timed-out block has stopped
+// before valueWithTimeout: returns, so it never touches globals while the
+// caller is using them. Run with -race to check there is no data race.
+func TestValueWithTimeoutWaitsForBlock(t *testing.T) {
+	result := runSource(t, `
+		Count := 0.
+		[[[true] whileTrue: [Count := Count + 1]] valueWithTimeout: 20] on: Timeout do: [:e | nil].
+		Count := 0.
+		1 to: 1000 do: [:i | Count := Count + 1].
+		Count`)
+	if result != int64(1000) {
+		t.Errorf("Expected 1000, got %v", result)
+	}
+
+	// A block inside a long primitive when the deadline passes stops once the
+	// primitive returns, before its next assignment, and only then does
+	// valueWithTimeout: report the timeout
+	slow := `nil pbkdf2: 'password' salt: 'salt' iterations: 200000 length: 32`
+	start := time.Now()
+	runSource(t, slow)
+	primitive := time.Since(start)
+
+	start = time.Now()
+	result = runSource(t, `
+		Count := 0.
+		[[`+slow+`. Count := -1] valueWithTimeout: 1] on: Timeout do: [:e | nil].
+		Count := Count + 1.
+		Count`)
+	if result != int64(1) {
+		t.Errorf("Expected the assignment after the deadline not to run, got %v", result)
+	}
+	if elapsed := time.Since(start); elapsed < primitive/2 {
+		t.Errorf("Expected valueWithTimeout: to wait for the primitive (%v), returned after %v", primitive, elapsed)
+	}
+}
+
+// TestRunContext tests that cancelling the context stops a program stuck
+// in a loop, even one inside a method, and that the VM can run again
+func TestRunContext(t *testing.T) {
+	compile := func(source string) *bytecode.Bytecode {
+		program, err := parser.New(source).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		return bc
+	}
+	v := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := v.RunContext(ctx, compile(`Object subclass: #Spinner [ spin [ [true] whileTrue: [nil] ] ]
+Spinner new spin`))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the program to be cancelled, got %v", err)
+	}
+
+	if err := v.Run(compile("3 + 4")); err != nil || v.StackTop() != int64(7) {
+		t.Errorf("Expected 7 after the cancelled run, got %v (%v)", v.StackTop(), err)
+	}
+}
@@ -74,9 +74,23 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/kristofer/smog/pkg/bytecode"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
 )
 
 // VM represents the virtual machine that executes bytecode.
@@ -117,12 +131,43 @@ type VM struct {
 	currentClass *bytecode.ClassDefinition            // Current class context (for super sends)
 	fieldOffset  int                                  // Offset for field indices (for inheritance)
 	classes      map[string]*bytecode.ClassDefinition // Registered classes by name
+	extensions   map[string]*bytecode.ClassDefinition // Methods added to built-in types, by type name (see extendClass)
 	homeContext  *VM                                  // Home context for non-local returns (nil for methods, set for blocks)
+	running      bool                                 // Whether Run is executing, so a Context knows it can be returned to
+	scope        *VM                                  // Activation a block was created in, holding the local slots below base (nil outside blocks)
+	base         int                                  // First local slot of a block's own frame; lower slots are in scope
 	callStack    []StackFrame                         // Call stack for debugging and error reporting
 	ip           int                                  // Current instruction pointer (for error reporting)
-	debugger     *Debugger                            // Optional debugger for interactive debugging
+	debugging    *debugging                           // The debugger, if any, shared with blocks and methods
+	depth        int                                  // Method and block activations this VM is nested in (0 for the top level)
+	ctx          context.Context                      // Cancellation context for valueWithTimeout: (nil when unbounded)
+	out          io.Writer                            // Destination for print and println (nil means os.Stdout)
+	division     DivisionMode                         // What / answers for integers that don't divide evenly
+	checks       bool                                 // Verify internal invariants while running (see SetInvariantChecks)
+	printing     printLimits                          // How much of a collection printString shows (see SetPrintLimits)
+	identities   *identityHashes                      // identityHash numbers assigned so far, shared with blocks and methods
+	layouts      *classLayouts                        // Field layouts of the classes, shared with blocks and methods
+	prelude      bool                                 // Load the prelude at the start of the next Run (see SetPrelude)
+	hasPrelude   bool                                 // The prelude's classes are in the globals, so Reset loads them again
 }
 
+// DivisionMode selects what the / message answers when one integer does
+// not divide another evenly. Exact quotients (6 / 3) are always integers,
+// and // always floors, whatever the mode.
+type DivisionMode int
+
+const (
+	// DivideTruncate truncates toward zero: 5 / 2 = 2. This is the default,
+	// kept for compatibility with existing programs.
+	DivideTruncate DivisionMode = iota
+
+	// DivideExact answers a Fraction: 5 / 2 = 5/2.
+	DivideExact
+
+	// DivideFloat answers a Float: 5 / 2 = 2.5.
+	DivideFloat
+)
+
 // New creates a new virtual machine instance.
 //
 // Initializes:
@@ -131,19 +176,87 @@ type VM struct {
 //   - Local variable array with 256 slots
 //   - Empty global variable map
 //   - Empty class registry
+//   - The prelude, loaded by the first Run (see SetPrelude)
 //
 // The VM is reusable - you can call Run() multiple times on the same VM.
 // Global variables and registered classes persist across runs, but the 
-// stack and locals are reset.
+// stack and locals are reset. Call Reset to forget them too.
 func New() *VM {
 	return &VM{
-		stack:     make([]interface{}, 1024),
-		sp:        0,
-		locals:    make([]interface{}, 256),
-		globals:   make(map[string]interface{}),
-		classes:   make(map[string]*bytecode.ClassDefinition),
-		callStack: make([]StackFrame, 0, 64), // Preallocate space for 64 frames
+		stack:      make([]interface{}, 1024),
+		sp:         0,
+		locals:     make([]interface{}, 256),
+		globals:    make(map[string]interface{}),
+		classes:    make(map[string]*bytecode.ClassDefinition),
+		extensions: make(map[string]*bytecode.ClassDefinition),
+		callStack:  make([]StackFrame, 0, 64), // Preallocate space for 64 frames
+		printing:   printLimits{elements: DefaultPrintElements, depth: DefaultPrintDepth},
+		identities: &identityHashes{},
+		layouts:    &classLayouts{},
+		prelude:    true,
+	}
+}
+
+// SetOutput redirects print and println to w.
+//
+// By default output goes to os.Stdout. Embedders and tests can pass a
+// bytes.Buffer to capture what a program prints.
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.out = w
+}
+
+// SetDivisionMode selects how / treats integers that don't divide evenly.
+//
+// Example:
+//   v := vm.New()
+//   v.SetDivisionMode(vm.DivideExact)  // 5 / 2 now answers 5/2
+func (vm *VM) SetDivisionMode(mode DivisionMode) {
+	vm.division = mode
+}
+
+// Reset clears everything a program left behind: globals, classes,
+// extensions of built-in types, the stack and locals. The next Run starts
+// as if on a new VM, loading the prelude again if it was loaded before.
+// Settings made with SetOutput, SetDivisionMode, SetPrintLimits,
+// SetInvariantChecks and the debugger are kept.
+//
+// Run keeps globals between calls so a REPL can build on earlier input;
+// Reset lets one VM run independent programs instead.
+//
+// Example:
+//   v.Run(first)   // first assigns total := 5
+//   v.Reset()
+//   v.Run(second)  // total is undefined again
+func (vm *VM) Reset() {
+	for i := range vm.stack {
+		vm.stack[i] = nil
+	}
+	vm.sp = 0
+	for i := range vm.locals {
+		vm.locals[i] = nil
+	}
+	vm.globals = make(map[string]interface{})
+	vm.classes = make(map[string]*bytecode.ClassDefinition)
+	vm.extensions = make(map[string]*bytecode.ClassDefinition)
+	vm.constants = nil
+	vm.self = nil
+	vm.currentClass = nil
+	vm.fieldOffset = 0
+	vm.callStack = vm.callStack[:0]
+	vm.identities = &identityHashes{}
+	vm.layouts = &classLayouts{}
+	if vm.hasPrelude {
+		vm.prelude = true
+		vm.hasPrelude = false
+	}
+}
+
+// output returns the writer used by print and println.
+func (vm *VM) output() io.Writer {
+	if vm.out == nil {
+		return os.Stdout
 	}
+	return vm.out
 }
 
 // Run executes bytecode on the virtual machine.
@@ -179,7 +292,39 @@ func New() *VM {
 //     fmt.Println("Runtime error:", err)
 //   }
 //   result := vm.StackTop() // Get the final result
-func (vm *VM) Run(bc *bytecode.Bytecode) error {
+func (vm *VM) Run(bc *bytecode.Bytecode) (err error) {
+	if vm.checks {
+		if bc == nil {
+			return &InvariantError{IP: -1, Message: "no bytecode to run"}
+		}
+		// Report a panic caused by malformed bytecode as a broken invariant
+		defer func() {
+			if r := recover(); r != nil {
+				err = vm.violation(bc, "panic: %v", r)
+			}
+		}()
+	}
+
+	// A ^ inside a block returns from the context that created the block.
+	// When that is this VM, as for a block written at the top level of a
+	// program, stop here and make the returned value the result, the same
+	// as a ^ outside any block.
+	defer func() {
+		if nlr, ok := err.(*NonLocalReturn); ok && nlr.HomeContext == vm {
+			vm.sp = 0
+			err = vm.push(nlr.Value)
+		}
+	}()
+
+	if vm.prelude {
+		if err := vm.LoadPrelude(); err != nil {
+			return err
+		}
+	}
+
+	vm.running = true
+	defer func() { vm.running = false }()
+
 	// Reset stack pointer to 0 (empty stack)
 	vm.sp = 0
 	
@@ -209,14 +354,39 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 	// Use defer to ensure frame is popped even on error
 	defer vm.popFrame()
 
+	// Report every other error as a RuntimeError. This runs before the
+	// frame is popped, so the error's stack trace still includes it.
+	defer func() {
+		if err == nil {
+			return
+		}
+		switch err.(type) {
+		case *RuntimeError, *NonLocalReturn, *InvariantError:
+			return
+		}
+		err = vm.runtimeErrorFrom(err)
+	}()
+
 	// Main execution loop
 	// Process instructions sequentially using instruction pointer (ip)
 	for vm.ip = 0; vm.ip < len(bc.Instructions); vm.ip++ {
 		inst := bc.Instructions[vm.ip]
 
+		// Stop promptly if a valueWithTimeout: deadline has passed
+		if vm.ctx != nil && vm.ctx.Err() != nil {
+			return vm.ctx.Err()
+		}
+
+		if vm.checks {
+			if err := vm.checkInstruction(bc); err != nil {
+				return err
+			}
+		}
+
 		// Check for debugger breakpoints
-		if vm.debugger != nil && vm.debugger.ShouldPause() {
-			if !vm.debugger.InteractivePrompt(bc) {
+		if debugger := vm.debugState().debugger; debugger != nil {
+			debugger.setFrame(vm, bc)
+			if debugger.ShouldPause() && !debugger.InteractivePrompt(bc) {
 				// User chose to quit
 				return fmt.Errorf("debugging session terminated")
 			}
@@ -301,10 +471,11 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			// Operand: local variable slot index
 			//
 			// Example: LOAD_LOCAL 0 loads locals[0]
-			if inst.Operand < 0 || inst.Operand >= len(vm.locals) {
+			frame := vm.localFrame(inst.Operand)
+			if inst.Operand < 0 || inst.Operand >= len(frame.locals) {
 				return fmt.Errorf("local variable index out of bounds: %d", inst.Operand)
 			}
-			if err := vm.push(vm.locals[inst.Operand]); err != nil {
+			if err := vm.push(frame.locals[inst.Operand]); err != nil {
 				return err
 			}
 
@@ -316,14 +487,15 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			// because assignments return their value in smog.
 			//
 			// Example: STORE_LOCAL 0 stores to locals[0]
-			if inst.Operand < 0 || inst.Operand >= len(vm.locals) {
+			frame := vm.localFrame(inst.Operand)
+			if inst.Operand < 0 || inst.Operand >= len(frame.locals) {
 				return fmt.Errorf("local variable index out of bounds: %d", inst.Operand)
 			}
 			val, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			vm.locals[inst.Operand] = val
+			frame.locals[inst.Operand] = val
 			// Push the value back (assignment returns the value)
 			if err := vm.push(val); err != nil {
 				return err
@@ -345,9 +517,21 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			if !ok {
 				return fmt.Errorf("expected string constant for global name")
 			}
+			if name == "thisContext" {
+				// The running method activation, which globals can't shadow
+				if err := vm.push(vm.thisContext()); err != nil {
+					return err
+				}
+				continue
+			}
 			val, ok := vm.globals[name]
 			if !ok {
-				return fmt.Errorf("undefined global variable: %s", name)
+				// Fall back to built-in classes (user globals shadow them)
+				class, isBuiltin := lookupBuiltinClass(name)
+				if !isBuiltin {
+					return fmt.Errorf("undefined global variable: %s", name)
+				}
+				val = class
 			}
 			if err := vm.push(val); err != nil {
 				return err
@@ -395,11 +579,13 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			// Decode operand using bit manipulation
 			// High bits: selector index in constant pool
 			// Low 8 bits: argument count
-			selectorIdx := inst.Operand >> bytecode.SelectorIndexShift
-			argCount := inst.Operand & bytecode.ArgCountMask
+			selectorIdx, argCount, err := bytecode.UnpackSendOperand(inst.Operand)
+			if err != nil {
+				return vm.runtimeError(err.Error())
+			}
 
 			// Get the selector string from constants
-			if selectorIdx < 0 || selectorIdx >= len(vm.constants) {
+			if selectorIdx >= len(vm.constants) {
 				return vm.runtimeError(fmt.Sprintf("selector index out of bounds: %d", selectorIdx))
 			}
 			selector, ok := vm.constants[selectorIdx].(string)
@@ -431,18 +617,69 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			// Execute the message send
 			result, err := vm.send(receiver, selector, args)
 			
+			// Wrap the error while the send's frame is still on the
+			// call stack, so the trace and the error's Selector show it.
+			// NonLocalReturn errors are preserved without wrapping.
+			if _, isNonLocal := err.(*NonLocalReturn); err != nil && !isNonLocal {
+				err = vm.runtimeErrorFrom(err)
+			}
+
 			// Pop call frame
 			vm.popFrame()
 			
 			if err != nil {
-				// Preserve NonLocalReturn errors without wrapping
-				if _, isNonLocal := err.(*NonLocalReturn); isNonLocal {
-					return err
+				return err
+			}
+
+			// Push result onto stack
+			if err := vm.push(result); err != nil {
+				return vm.runtimeError(err.Error())
+			}
+
+		case bytecode.OpCallBlock:
+			// CALL_BLOCK: Evaluate a block with arguments
+			// Operand: argument count
+			//
+			// Fast path for `aBlock value: arg ...` when the compiler knows
+			// the receiver is a block literal. Behaves exactly like sending
+			// the matching value message, including arity errors, and
+			// sends it when "Block extend [...]" has redefined it.
+			//
+			// Stack before: [block, arg1, arg2, ..., argN]
+			// Stack after:  [result]
+			argCount := inst.Operand
+			if argCount < 0 || argCount > bytecode.ArgCountMask {
+				return vm.runtimeError(fmt.Sprintf("invalid block argument count: %d", argCount))
+			}
+			args := make([]interface{}, argCount)
+			for i := argCount - 1; i >= 0; i-- {
+				arg, err := vm.pop()
+				if err != nil {
+					return vm.runtimeError(err.Error())
 				}
+				args[i] = arg
+			}
+			receiver, err := vm.pop()
+			if err != nil {
 				return vm.runtimeError(err.Error())
 			}
 
-			// Push result onto stack
+			selector := bytecode.BlockValueSelector(argCount)
+			vm.pushFrame("message send", selector)
+			var result interface{}
+			if block, ok := receiver.(*Block); ok && !vm.hasExtension("Block", selector) {
+				result, err = vm.executeBlock(block, args)
+			} else {
+				result, err = vm.send(receiver, selector, args)
+			}
+			if _, isNonLocal := err.(*NonLocalReturn); err != nil && !isNonLocal {
+				err = vm.runtimeErrorFrom(err)
+			}
+			vm.popFrame()
+
+			if err != nil {
+				return err
+			}
 			if err := vm.push(result); err != nil {
 				return vm.runtimeError(err.Error())
 			}
@@ -455,11 +692,13 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			// current class context, allowing proper super message sends.
 
 			// Decode operand (same as OpSend)
-			selectorIdx := inst.Operand >> bytecode.SelectorIndexShift
-			argCount := inst.Operand & bytecode.ArgCountMask
+			selectorIdx, argCount, err := bytecode.UnpackSendOperand(inst.Operand)
+			if err != nil {
+				return err
+			}
 
 			// Get the selector string from constants
-			if selectorIdx < 0 || selectorIdx >= len(vm.constants) {
+			if selectorIdx >= len(vm.constants) {
 				return fmt.Errorf("selector index out of bounds: %d", selectorIdx)
 			}
 			selector, ok := vm.constants[selectorIdx].(string)
@@ -483,18 +722,25 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 				return err
 			}
 
-			// Super sends only work on instances with a current class context
-			instance, ok := receiver.(*Instance)
-			if !ok {
-				return fmt.Errorf("super can only be used within instance methods")
-			}
+			// Super sends only work on instances with a current class context,
+			// except in a method added to a built-in type, where super
+			// reaches the type's primitive
+			var result interface{}
+			if vm.currentClass != nil && vm.extensions[typeName(receiver)] == vm.currentClass {
+				result, err = vm.sendPrimitive(receiver, selector, args)
+			} else {
+				instance, ok := receiver.(*Instance)
+				if !ok {
+					return fmt.Errorf("super can only be used within instance methods")
+				}
 
-			if vm.currentClass == nil {
-				return fmt.Errorf("super used without class context")
-			}
+				if vm.currentClass == nil {
+					return fmt.Errorf("super used without class context")
+				}
 
-			// Dispatch to superclass method
-			result, err := vm.superSend(instance, selector, args)
+				// Dispatch to superclass method
+				result, err = vm.superSend(instance, selector, args)
+			}
 			if err != nil {
 				return err
 			}
@@ -539,6 +785,8 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 				// If we're in a block (vm.homeContext is set), use that
 				// Otherwise, use the current VM (we're in a method)
 				HomeContext:      vm.homeContext,
+				// Capture this activation, whose variables the block's code refers to
+				Scope:            vm,
 			}
 			
 			// If homeContext is nil, we're in a method or top-level, so set it to current VM
@@ -589,37 +837,35 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 			//
 			// Process:
 			//   1. Pop 2N elements from stack (N key-value pairs)
-			//   2. Create a map/dictionary object containing them
+			//   2. Create a Dictionary containing them, in source order
 			//   3. Push the dictionary onto the stack
 			//
 			// Stack before: [key1, value1, key2, value2, ..., keyN, valueN]
 			// Stack after:  [dictionary]
 			//
-			// Note: In Go, map keys must be comparable types (no slices, maps, or functions).
-			// Using non-comparable types as dictionary keys will cause a runtime panic.
-			// This is a known limitation of the current implementation.
+			// The Dictionary remembers insertion order, so the pairs are
+			// added in the order they were written. A repeated key keeps
+			// its first position and its last value. A key that can't be
+			// compared (a Go slice or function from an embedder) is a
+			// runtime error rather than a Go panic.
 
 			pairCount := inst.Operand
 
-			// Create the dictionary map
-			dict := make(map[interface{}]interface{})
-
 			// Pop key-value pairs (in reverse order)
-			for i := pairCount - 1; i >= 0; i-- {
-				// Pop value first, then key (they're pushed in key, value order)
+			pairs := make([]interface{}, 2*pairCount)
+			for i := 2*pairCount - 1; i >= 0; i-- {
 				value, err := vm.pop()
 				if err != nil {
 					return err
 				}
-				key, err := vm.pop()
-				if err != nil {
-					return err
+				pairs[i] = value
+			}
+
+			dict := newDictionary()
+			for i := 0; i < len(pairs); i += 2 {
+				if err := dict.AtPut(pairs[i], pairs[i+1]); err != nil {
+					return fmt.Errorf("dictionary literal: %v", err)
 				}
-				
-				// Note: No validation of key type here. Using non-comparable types
-				// (slices, maps, functions) will cause a panic.
-				// TODO: Add key type validation or use a custom map implementation
-				dict[key] = value
 			}
 
 			// Push dictionary onto stack
@@ -644,12 +890,86 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 					inst.Operand, vm.constants[inst.Operand])
 			}
 
-			// Register the class in the global class registry
+			// Register the class in the global class registry. It may
+			// replace a class others inherit from, so their cached
+			// layouts are out of date. The VM gets a copy of its own,
+			// since extend and compile: change the registered class and
+			// the bytecode may be run again, by this VM or another: every
+			// VM runs the same compiled prelude.
+			classDef = copyClass(classDef)
 			vm.classes[classDef.Name] = classDef
+			vm.layoutTable().invalidate()
 
 			// Also register the class as a global variable so it can be referenced
 			vm.globals[classDef.Name] = classDef
 
+		case bytecode.OpExtendClass:
+			// EXTEND_CLASS: Add methods to an existing class or built-in type
+			// Operand: index into constant pool for ClassDefinition
+			if inst.Operand < 0 || inst.Operand >= len(vm.constants) {
+				return fmt.Errorf("constant index out of bounds: %d", inst.Operand)
+			}
+			extension, ok := vm.constants[inst.Operand].(*bytecode.ClassDefinition)
+			if !ok {
+				return fmt.Errorf("expected ClassDefinition at constant[%d], got %T",
+					inst.Operand, vm.constants[inst.Operand])
+			}
+			if err := vm.extendClass(extension); err != nil {
+				return vm.runtimeError(err.Error())
+			}
+
+		case bytecode.OpNewObject:
+			// NEW_OBJECT: Instantiate a class by name
+			// Operand: index of class name in constant pool
+			//
+			// Fast path for `ClassName new`. The compiler only emits it for
+			// names that referred to a class at compile time, but globals
+			// can be reassigned, so anything other than a user class gets
+			// a real `new` message instead.
+			if inst.Operand < 0 || inst.Operand >= len(vm.constants) {
+				return fmt.Errorf("constant index out of bounds: %d", inst.Operand)
+			}
+			name, ok := vm.constants[inst.Operand].(string)
+			if !ok {
+				return fmt.Errorf("expected string constant for class name")
+			}
+			var result interface{}
+			if classDef, ok := vm.globals[name].(*bytecode.ClassDefinition); ok {
+				// Field defaults run code, so they can fail like a send
+				vm.pushFrame("message send", "new")
+				instance, err := vm.newInstance(classDef)
+				if err != nil {
+					err = vm.runtimeErrorFrom(err)
+				}
+				vm.popFrame()
+				if err != nil {
+					return err
+				}
+				result = instance
+			} else {
+				receiver, ok := vm.globals[name]
+				if !ok {
+					class, isBuiltin := lookupBuiltinClass(name)
+					if !isBuiltin {
+						return fmt.Errorf("undefined global variable: %s", name)
+					}
+					receiver = class
+				}
+				vm.pushFrame("message send", "new")
+				var err error
+				result, err = vm.send(receiver, "new", nil)
+				if err != nil {
+					err = vm.runtimeErrorFrom(err)
+				}
+				vm.popFrame()
+				if err != nil {
+					return err
+				}
+			}
+			if err := vm.push(result); err != nil {
+				return vm.runtimeError(err.Error())
+			}
+
 		case bytecode.OpLoadField:
 			// LOAD_FIELD: Load an instance variable onto the stack
 			// Operand: field index
@@ -796,24 +1116,46 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 	return nil
 }
 
+// RunContext runs bc like Run, but stops at the next instruction once ctx
+// is done and returns ctx.Err(). Every VM the program creates, for method
+// calls and blocks, checks the same context, so a program stuck in a loop
+// anywhere stops.
+//
+// A program blocked inside a primitive such as httpGet: only stops once
+// that primitive returns.
+//
+// Example:
+//
+//   ctx, cancel := context.WithCancel(context.Background())
+//   go func() { <-changed; cancel() }()
+//   err := v.RunContext(ctx, bc)
+func (vm *VM) RunContext(ctx context.Context, bc *bytecode.Bytecode) error {
+	saved := vm.ctx
+	vm.ctx = ctx
+	defer func() { vm.ctx = saved }()
+	return vm.Run(bc)
+}
+
 // send executes a message send operation.
 //
 // This method implements the message dispatch mechanism - the core of
 // object-oriented programming in smog. When a message is sent to an object,
 // this method determines what action to take.
 //
-// Current Implementation:
-//   This is a simplified implementation that handles only primitive operations.
-//   In a full Smalltalk-style implementation, this would:
-//     1. Look up the receiver's class
-//     2. Search for a method matching the selector
-//     3. Execute the method in a new context
-//     4. Return the result
+// Lookup Order:
+//   Every receiver resolves a message the same way:
+//     1. A method written in smog: found in the class hierarchy of an
+//        Instance, or added to a built-in type with "Integer extend [...]"
+//     2. A primitive the VM implements for the receiver
+//     3. Otherwise a "does not understand" error
+//   So a user method wins over a primitive of the same name, and any
+//   selector the user didn't define still reaches the primitive.
 //
 // Primitive Operations:
-//   For now, we handle these selectors as built-in primitives:
+//   Among the selectors handled as built-in primitives are:
 //     - Arithmetic: +, -, *, /
-//     - Comparison: <, >, <=, >=, =, ~=
+//     - Comparison: <, >, <=, >=, =, ~=, ==
+//     - Point construction: @
 //     - I/O: print, println
 //
 // Parameters:
@@ -829,6 +1171,20 @@ func (vm *VM) Run(bc *bytecode.Bytecode) error {
 //   send(5, "+", [3]) -> 8
 //   send("Hello", "println", []) -> "Hello" (and prints it)
 func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (interface{}, error) {
+	// Methods added to the receiver's built-in type come before its primitives
+	if len(vm.extensions) > 0 {
+		if result, handled, err := vm.sendExtension(receiver, selector, args); handled {
+			return result, err
+		}
+	}
+	return vm.sendPrimitive(receiver, selector, args)
+}
+
+// sendPrimitive dispatches a message the way send() does once no method
+// added with "Name extend [...]" matched: to an Instance's class, or to
+// the primitives of a built-in receiver. A super send in an extension
+// method calls it directly, to reach the primitive the method overrides.
+func (vm *VM) sendPrimitive(receiver interface{}, selector string, args []interface{}) (interface{}, error) {
 	// Check if receiver is a Block and selector is 'value' or starts with 'value:'
 	if block, ok := receiver.(*Block); ok {
 		// Match 'value' (no args) or 'value:' with varying arg counts
@@ -838,6 +1194,26 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 
 		// Handle whileTrue: and whileFalse:
 		switch selector {
+		case "valueWithTimeout:":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("valueWithTimeout: expects 1 argument (milliseconds), got %d", len(args))
+			}
+			ms, ok := args[0].(int64)
+			if !ok || ms < 0 {
+				return nil, fmt.Errorf("valueWithTimeout: argument must be a non-negative integer")
+			}
+			return vm.executeBlockWithTimeout(block, time.Duration(ms)*time.Millisecond)
+
+		case "ensure:", "ifCurtailed:":
+			cleanup, err := blockArg(selector, args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return vm.executeBlockEnsuring(block, cleanup, selector == "ensure:")
+
+		case "on:do:":
+			return vm.onDo(block, args)
+
 		case "whileTrue:":
 			if len(args) != 1 {
 				return nil, fmt.Errorf("whileTrue: expects 1 argument (block), got %d", len(args))
@@ -969,6 +1345,204 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 				}
 			}
 			return nil, nil
+		case "timesCollect:":
+			// An array of the block's results for 1 to the receiver:
+			//   3 timesCollect: [:i | i * i]  -> #(1 4 9)
+			block, err := blockArg(selector, args, 1)
+			if err != nil {
+				return nil, err
+			}
+			results := &Array{Elements: []interface{}{}}
+			for i := int64(1); i <= num; i++ {
+				result, err := vm.executeBlock(block, []interface{}{i})
+				if err != nil {
+					return nil, err
+				}
+				results.Elements = append(results.Elements, result)
+			}
+			return results, nil
+		case "asHexString":
+			return vm.asHexString(num), nil
+		case "digits":
+			// 1234 digits -> #(1 2 3 4)
+			return digits(num), nil
+		case "printStringWithThousands":
+			// 1000000 printStringWithThousands -> '1,000,000'
+			return withThousands(num), nil
+		case "to:", "to:by:":
+			// An Interval: 1 to: 5, or 10 to: 1 by: -3
+			if len(args) != strings.Count(selector, ":") {
+				return nil, fmt.Errorf("%s expects %d argument(s), got %d", selector, strings.Count(selector, ":"), len(args))
+			}
+			step := interface{}(int64(1))
+			if selector == "to:by:" {
+				step = args[1]
+			}
+			return newInterval(selector, num, args[0], step)
+		case "to:do:", "to:by:do:":
+			// A counting loop: 1 to: 3 do: [:i | i println]
+			if len(args) != strings.Count(selector, ":") {
+				return nil, fmt.Errorf("%s expects %d argument(s), got %d", selector, strings.Count(selector, ":"), len(args))
+			}
+			step := interface{}(int64(1))
+			if selector == "to:by:do:" {
+				step = args[1]
+			}
+			interval, err := newInterval(selector, num, args[0], step)
+			if err != nil {
+				return nil, err
+			}
+			return vm.intervalDo(interval, selector, args[len(args)-1], receiver)
+		}
+	}
+
+	// Check if receiver is a String and handle string messages
+	if str, ok := receiver.(string); ok {
+		switch selector {
+		case "size":
+			// Characters, not bytes: 'héllo' size -> 5
+			return int64(utf8.RuneCountInString(str)), nil
+		case "at:":
+			// The character at a 1-based index, as a one-character string
+			if len(args) != 1 {
+				return nil, fmt.Errorf("at: expects 1 argument, got %d", len(args))
+			}
+			idx, ok := args[0].(int64)
+			if !ok {
+				return nil, fmt.Errorf("at: index must be an integer, got %s", describeValue(args[0]))
+			}
+			runes := []rune(str)
+			if idx < 1 || idx > int64(len(runes)) {
+				return nil, fmt.Errorf("string index out of bounds: %d", idx)
+			}
+			return string(runes[idx-1]), nil
+		case "asUppercase":
+			return strings.ToUpper(str), nil
+		case "asLowercase":
+			return strings.ToLower(str), nil
+		case "reversed":
+			// Reverse the characters, so multi-byte ones stay intact
+			runes := []rune(str)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return string(runes), nil
+		case "hexStringAsInteger":
+			return vm.hexStringAsInteger(str)
+		case ",":
+			// Concatenation: 'Hello' , ' world' -> 'Hello world'
+			if len(args) != 1 {
+				return nil, fmt.Errorf(", expects 1 argument, got %d", len(args))
+			}
+			other, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf(", argument must be a String, got %s", describeValue(args[0]))
+			}
+			return str + other, nil
+		case "padLeftTo:", "padRightTo:", "center:", "truncateTo:",
+			"padLeftTo:with:", "padRightTo:with:", "center:with:":
+			// Justification for tabular output; widths count characters, not bytes
+			width, fill, err := widthAndFill(selector, args)
+			if err != nil {
+				return nil, err
+			}
+			switch selector {
+			case "padLeftTo:", "padLeftTo:with:":
+				return vm.padString(str, width, fill, true), nil
+			case "padRightTo:", "padRightTo:with:":
+				return vm.padString(str, width, fill, false), nil
+			case "center:", "center:with:":
+				return vm.centerString(str, width, fill), nil
+			default:
+				return vm.truncateString(str, width), nil
+			}
+		case "copyReplaceAll:with:":
+			// 'a-b-c' copyReplaceAll: '-' with: ', ' -> 'a, b, c'
+			if len(args) != 2 {
+				return nil, fmt.Errorf("copyReplaceAll:with: expects 2 arguments, got %d", len(args))
+			}
+			old, ok1 := args[0].(string)
+			replacement, ok2 := args[1].(string)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("copyReplaceAll:with: arguments must be Strings, got %s and %s",
+					describeValue(args[0]), describeValue(args[1]))
+			}
+			if old == "" {
+				return nil, fmt.Errorf("copyReplaceAll:with: cannot replace an empty string")
+			}
+			return strings.ReplaceAll(str, old, replacement), nil
+		case "insert:at:":
+			// 'smog' insert: 'X' at: 3 -> 'smXog'
+			if len(args) != 2 {
+				return nil, fmt.Errorf("insert:at: expects 2 arguments, got %d", len(args))
+			}
+			inserted, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("insert:at: first argument must be a String, got %s", describeValue(args[0]))
+			}
+			index, ok := args[1].(int64)
+			if !ok {
+				return nil, fmt.Errorf("insert:at: index must be an integer, got %s", describeValue(args[1]))
+			}
+			return vm.insertString(str, inserted, index)
+		case "repeat:", "*":
+			// 'ab' repeat: 3 -> 'ababab', and so does 'ab' * 3
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%s expects 1 argument, got %d", selector, len(args))
+			}
+			count, ok := args[0].(int64)
+			if !ok {
+				return nil, fmt.Errorf("%s count must be an integer, got %s", selector, describeValue(args[0]))
+			}
+			return vm.repeatString(str, selector, count)
+		case "asUppercaseFirst":
+			// 'smog' asUppercaseFirst -> 'Smog'
+			return vm.uppercaseFirst(str), nil
+		case "lines", "asLines":
+			// 'one\ntwo\n' lines -> #('one' 'two'), with CRLF endings too
+			return &Array{Elements: splitLines(str)}, nil
+		case "withLineNumbersDo:":
+			// Each line with its 1-based number: [:n :line | ...]
+			block, err := blockArg(selector, args, 2)
+			if err != nil {
+				return nil, err
+			}
+			for i, line := range splitLines(str) {
+				if _, err := vm.executeBlock(block, []interface{}{int64(i + 1), line}); err != nil {
+					return nil, err
+				}
+			}
+			return str, nil
+		case "asByteArray":
+			// The string's UTF-8 encoding: 'é' asByteArray -> #[195 169]
+			return &ByteArray{Bytes: []byte(str)}, nil
+		case "copyFrom:to:":
+			// 'smalltalk' copyFrom: 6 to: 9 -> 'talk', and empty when to < from
+			if len(args) != 2 {
+				return nil, fmt.Errorf("copyFrom:to: expects 2 arguments, got %d", len(args))
+			}
+			from, ok1 := args[0].(int64)
+			to, ok2 := args[1].(int64)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("copyFrom:to: indices must be integers")
+			}
+			runes := []rune(str)
+			start, end, err := copyBounds(len(runes), from, to)
+			if err != nil {
+				return nil, err
+			}
+			return string(runes[start:end]), nil
+		case "removeFrom:to:":
+			// 'smalltalk' removeFrom: 1 to: 5 -> 'talk'
+			if len(args) != 2 {
+				return nil, fmt.Errorf("removeFrom:to: expects 2 arguments, got %d", len(args))
+			}
+			from, ok1 := args[0].(int64)
+			to, ok2 := args[1].(int64)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("removeFrom:to: indices must be integers")
+			}
+			return vm.removeStringRange(str, from, to)
 		}
 	}
 
@@ -991,7 +1565,8 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 			}
 			return array.Elements[idx-1], nil
 		case "at:put:":
-			// Array element assignment (1-based like Smalltalk)
+			// Array element assignment (1-based like Smalltalk). Answers
+			// the stored value, like every collection mutator
 			if len(args) != 2 {
 				return nil, fmt.Errorf("at:put: expects 2 arguments, got %d", len(args))
 			}
@@ -1005,81 +1580,382 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 			value := args[1]
 			array.Elements[idx-1] = value
 			return value, nil
-		case "do:":
-			// Iterate over array elements with a block
+		case "keysAndValuesDo:", "doWithIndex:", "withIndexDo:":
+			// Iterate with the 1-based index of each element:
+			//   #(a b) keysAndValuesDo: [:i :e | ...]  -> (1, a), (2, b)
+			//   #(a b) doWithIndex: [:e :i | ...]      -> (a, 1), (b, 2)
+			// withIndexDo: is an alias for doWithIndex:.
 			if len(args) != 1 {
-				return nil, fmt.Errorf("do: expects 1 argument (block), got %d", len(args))
+				return nil, fmt.Errorf("%s expects 1 argument (block), got %d", selector, len(args))
 			}
 			block, ok := args[0].(*Block)
 			if !ok {
-				return nil, fmt.Errorf("do: argument must be a block")
+				return nil, fmt.Errorf("%s argument must be a block", selector)
 			}
-			for _, elem := range array.Elements {
-				_, err := vm.executeBlock(block, []interface{}{elem})
-				if err != nil {
+			if block.ParamCount != 2 {
+				return nil, fmt.Errorf("%s block must take 2 arguments, got %d", selector, block.ParamCount)
+			}
+			indexFirst := selector == "keysAndValuesDo:"
+			for i, elem := range array.Elements {
+				blockArgs := []interface{}{elem, int64(i + 1)}
+				if indexFirst {
+					blockArgs = []interface{}{int64(i + 1), elem}
+				}
+				if _, err := vm.executeBlock(block, blockArgs); err != nil {
 					return nil, err
 				}
 			}
 			return array, nil
-		}
-	}
-
-	// Check if receiver is a ClassDefinition (class object)
-	if classDef, ok := receiver.(*bytecode.ClassDefinition); ok {
-		switch selector {
-		case "new":
-			// Create a new instance of the class
-			// Allocate fields for this class and all superclasses
-			totalFields := vm.countAllFields(classDef)
-			instance := &Instance{
-				Class:  classDef,
-				Fields: make([]interface{}, totalFields),
+		case "keysDo:", "valuesDo:":
+			// keysDo: passes each 1-based index; valuesDo: passes each element (like do:)
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%s expects 1 argument (block), got %d", selector, len(args))
 			}
-			return instance, nil
-		default:
-			// Look up class method
-			return vm.executeClassMethod(classDef, selector, args)
-		}
-	}
-
-	// Check if receiver is an Instance (object instance)
-	if instance, ok := receiver.(*Instance); ok {
-		// Look up method in the instance's class
-		return vm.executeMethod(instance, selector, args)
-	}
-
-	// Handle primitive operations
-	// These are built directly into the VM for efficiency
-	switch selector {
-	case "+":
-		return vm.add(receiver, args[0])
-	case "-":
-		return vm.subtract(receiver, args[0])
-	case "*":
-		return vm.multiply(receiver, args[0])
-	case "/":
-		return vm.divide(receiver, args[0])
-	case "<":
-		return vm.lessThan(receiver, args[0])
-	case ">":
-		return vm.greaterThan(receiver, args[0])
-	case "<=":
-		return vm.lessOrEqual(receiver, args[0])
-	case ">=":
+			block, ok := args[0].(*Block)
+			if !ok {
+				return nil, fmt.Errorf("%s argument must be a block", selector)
+			}
+			for i, elem := range array.Elements {
+				arg := elem
+				if selector == "keysDo:" {
+					arg = int64(i + 1)
+				}
+				if _, err := vm.executeBlock(block, []interface{}{arg}); err != nil {
+					return nil, err
+				}
+			}
+			return array, nil
+		case "do:separatedBy:":
+			// Iterate like do:, running the separator block between elements:
+			//   #(1 2 3) do: [:e | e print] separatedBy: [', ' print]  -> 1, 2, 3
+			if len(args) != 2 {
+				return nil, fmt.Errorf("do:separatedBy: expects 2 arguments (blocks), got %d", len(args))
+			}
+			block, ok := args[0].(*Block)
+			if !ok {
+				return nil, fmt.Errorf("do:separatedBy: first argument must be a block")
+			}
+			separator, ok := args[1].(*Block)
+			if !ok {
+				return nil, fmt.Errorf("do:separatedBy: second argument must be a block")
+			}
+			for i, elem := range array.Elements {
+				if i > 0 {
+					if _, err := vm.executeBlock(separator, []interface{}{}); err != nil {
+						return nil, err
+					}
+				}
+				if _, err := vm.executeBlock(block, []interface{}{elem}); err != nil {
+					return nil, err
+				}
+			}
+			return array, nil
+		case "reduce:":
+			// Fold the elements with a two-argument block, seeding the
+			// accumulator with the first element:
+			//   #(1 2 3 4) reduce: [:a :b | a + b]  -> ((1 + 2) + 3) + 4 = 10
+			// A single element is returned as-is; an empty array is an error
+			// because there is no value to start from.
+			if len(args) != 1 {
+				return nil, fmt.Errorf("reduce: expects 1 argument (block), got %d", len(args))
+			}
+			block, ok := args[0].(*Block)
+			if !ok {
+				return nil, fmt.Errorf("reduce: argument must be a block")
+			}
+			if block.ParamCount != 2 {
+				return nil, fmt.Errorf("reduce: block must take 2 arguments, got %d", block.ParamCount)
+			}
+			if len(array.Elements) == 0 {
+				return nil, fmt.Errorf("reduce: cannot reduce an empty collection")
+			}
+			acc := array.Elements[0]
+			for _, elem := range array.Elements[1:] {
+				result, err := vm.executeBlock(block, []interface{}{acc, elem})
+				if err != nil {
+					return nil, err
+				}
+				acc = result
+			}
+			return acc, nil
+		case "sort", "sort:":
+			// Sort the array in place and answer it. sort orders elements
+			// with <, so integers, floats and fractions mix freely;
+			// sort: takes a block answering whether its first argument
+			// belongs before its second:
+			//   #(3 1 2.5) sort                        -> #(1 2.5 3)
+			//   #(3 1 2) sort: [:a :b | a > b]         -> #(3 2 1)
+			var block *Block
+			if selector == "sort:" {
+				var err error
+				if block, err = blockArg(selector, args, 2); err != nil {
+					return nil, err
+				}
+			}
+			if err := vm.sortElements(array.Elements, block); err != nil {
+				return nil, err
+			}
+			return array, nil
+		case ",":
+			// Concatenation into a new array; the receiver is unchanged:
+			//   #(1 2) , #(3 4)  -> #(1 2 3 4)
+			// Any collection can be appended, in its iteration order.
+			if len(args) != 1 {
+				return nil, fmt.Errorf(", expects 1 argument, got %d", len(args))
+			}
+			other, ok := collectionElements(args[0])
+			if !ok {
+				return nil, fmt.Errorf(", argument must be a collection, got %s", describeValue(args[0]))
+			}
+			elements := make([]interface{}, 0, len(array.Elements)+len(other))
+			elements = append(elements, array.Elements...)
+			elements = append(elements, other...)
+			return &Array{Elements: elements}, nil
+		case "copyFrom:to:":
+			// #(1 2 3 4) copyFrom: 2 to: 3 -> #(2 3), and empty when to < from
+			if len(args) != 2 {
+				return nil, fmt.Errorf("copyFrom:to: expects 2 arguments, got %d", len(args))
+			}
+			from, ok1 := args[0].(int64)
+			to, ok2 := args[1].(int64)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("copyFrom:to: indices must be integers")
+			}
+			start, end, err := copyBounds(len(array.Elements), from, to)
+			if err != nil {
+				return nil, err
+			}
+			return &Array{Elements: append([]interface{}{}, array.Elements[start:end]...)}, nil
+		case "repeat:":
+			// Replication into a new array:
+			//   #(1 2) repeat: 3  -> #(1 2 1 2 1 2)
+			// Zero repeats answer an empty array.
+			if len(args) != 1 {
+				return nil, fmt.Errorf("repeat: expects 1 argument, got %d", len(args))
+			}
+			count, ok := args[0].(int64)
+			if !ok || count < 0 {
+				return nil, fmt.Errorf("repeat: count must be a non-negative integer")
+			}
+			if len(array.Elements) > 0 && count > maxElements/int64(len(array.Elements)) {
+				return nil, fmt.Errorf("repeat: result would be larger than the maximum of %d elements", maxElements)
+			}
+			elements := []interface{}{}
+			for i := int64(0); i < count; i++ {
+				elements = append(elements, array.Elements...)
+			}
+			return &Array{Elements: elements}, nil
+		}
+	}
+
+	// Check if receiver is a ClassDefinition (class object)
+	if classDef, ok := receiver.(*bytecode.ClassDefinition); ok {
+		switch selector {
+		case "new":
+			// Create a new instance of the class
+			return vm.newInstance(classDef)
+		case "methodsInProtocol:":
+			// Selectors of the class's own methods filed under a protocol:
+			// Account methodsInProtocol: 'accessing' -> #('balance')
+			if len(args) != 1 {
+				return nil, fmt.Errorf("methodsInProtocol: expects 1 argument, got %d", len(args))
+			}
+			protocol, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("methodsInProtocol: argument must be a String, got %s", describeValue(args[0]))
+			}
+			selectors := &Array{Elements: []interface{}{}}
+			for _, method := range classDef.Methods {
+				if method.Protocol == protocol {
+					selectors.Elements = append(selectors.Elements, method.Selector)
+				}
+			}
+			return selectors, nil
+		case "compile:":
+			// Add or replace a method while the program runs:
+			// Counter compile: 'increment [ count := count + 2 ]'
+			if len(args) != 1 {
+				return nil, fmt.Errorf("compile: expects 1 argument, got %d", len(args))
+			}
+			source, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("compile: argument must be a String, got %s", describeValue(args[0]))
+			}
+			return vm.compileMethod(classDef, source)
+		default:
+			// Look up class method
+			return vm.executeClassMethod(classDef, selector, args)
+		}
+	}
+
+	// Check if receiver is an Instance (object instance)
+	if instance, ok := receiver.(*Instance); ok {
+		// Look up method in the instance's class. This comes before the
+		// generic primitives below, so user-defined binary methods such
+		// as + win; primitives are only tried when no method is found.
+		return vm.executeMethod(instance, selector, args)
+	}
+
+	// Check if receiver is a built-in class or one of its instances
+	if class, ok := receiver.(*BuiltinClass); ok {
+		if result, handled, err := vm.sendBuiltinClass(class, selector, args); handled {
+			return result, err
+		}
+	}
+	if hasher, ok := receiver.(*Hasher); ok {
+		if result, handled, err := vm.sendHasher(hasher, selector, args); handled {
+			return result, err
+		}
+	}
+	if stream, ok := receiver.(*WriteStream); ok {
+		if result, handled, err := vm.sendWriteStream(stream, selector, args); handled {
+			return result, err
+		}
+	}
+	if stream, ok := receiver.(*ReadStream); ok {
+		if result, handled, err := vm.sendReadStream(stream, selector, args); handled {
+			return result, err
+		}
+	}
+	if context, ok := receiver.(*Context); ok {
+		if result, handled, err := vm.sendContext(context, selector, args); handled {
+			return result, err
+		}
+	}
+	if exception, ok := receiver.(*Exception); ok {
+		if result, handled, err := vm.sendException(exception, selector, args); handled {
+			return result, err
+		}
+	}
+	if bag, ok := receiver.(*Bag); ok {
+		if result, handled, err := vm.sendBag(bag, selector, args); handled {
+			return result, err
+		}
+	}
+	if set, ok := receiver.(*Set); ok {
+		if result, handled, err := vm.sendSet(set, selector, args); handled {
+			return result, err
+		}
+	}
+	if dict, ok := receiver.(*Dictionary); ok {
+		if result, handled, err := vm.sendDictionary(dict, selector, args); handled {
+			return result, err
+		}
+	}
+	if byteArray, ok := receiver.(*ByteArray); ok {
+		if result, handled, err := vm.sendByteArray(byteArray, selector, args); handled {
+			return result, err
+		}
+	}
+	if interval, ok := receiver.(Interval); ok {
+		if result, handled, err := vm.sendInterval(interval, selector, args); handled {
+			return result, err
+		}
+	}
+	// do:, collect:, select: and the rest of the enumeration protocol,
+	// shared by every collection
+	if collection, ok := receiver.(Enumerable); ok {
+		if result, handled, err := vm.sendEnumerable(collection, selector, args); handled {
+			return result, err
+		}
+	}
+
+	// Check if receiver is a Point (created with @)
+	if point, ok := receiver.(Point); ok {
+		if result, handled, err := vm.sendPoint(point, selector, args); handled {
+			return result, err
+		}
+	}
+
+	// Check if receiver is a Fraction (exact rational such as 1/2)
+	if fraction, ok := receiver.(*big.Rat); ok {
+		if result, handled, err := vm.sendFraction(fraction, selector, args); handled {
+			return result, err
+		}
+	}
+
+	// Handle primitive operations
+	// These are built directly into the VM for efficiency
+	switch selector {
+	case "+":
+		return vm.add(receiver, args[0])
+	case "-":
+		return vm.subtract(receiver, args[0])
+	case "*":
+		return vm.multiply(receiver, args[0])
+	case "/":
+		return vm.divide(receiver, args[0])
+	case "//":
+		return vm.floorDivide(receiver, args[0])
+	case "%", "\\\\":
+		return vm.modulo(selector, receiver, args[0])
+	case "<":
+		return vm.lessThan(receiver, args[0])
+	case ">":
+		return vm.greaterThan(receiver, args[0])
+	case "<=":
+		return vm.lessOrEqual(receiver, args[0])
+	case ">=":
 		return vm.greaterOrEqual(receiver, args[0])
 	case "=":
 		return vm.equal(receiver, args[0])
 	case "~=":
 		return vm.notEqual(receiver, args[0])
+	case "==":
+		return vm.identical(receiver, args[0])
+	case "@":
+		return vm.makePoint(receiver, args[0])
 	case "println":
-		// Print the receiver followed by a newline
-		fmt.Println(receiver)
+		// Print the receiver's displayString followed by a newline
+		fmt.Fprintln(vm.output(), vm.displayString(receiver))
 		// Return the receiver (allows method chaining)
 		return receiver, nil
 	case "print":
-		// Print the receiver without a newline
-		fmt.Print(receiver)
+		// Print the receiver's displayString without a newline
+		fmt.Fprint(vm.output(), vm.displayString(receiver))
 		return receiver, nil
+	case "yourself":
+		// Answer the receiver itself, typically to end a cascade:
+		//   OrderedCollection new add: 1; add: 2; yourself
+		return receiver, nil
+	case "halt":
+		// Pause in the debugger before the next instruction
+		return vm.halt(receiver), nil
+	case "printString":
+		// Source-like text for the receiver: 'abc' printString -> "'abc'"
+		return vm.basicPrintString(receiver), nil
+	case "printOn:":
+		// Write the receiver's printString to a WriteStream, as Object's
+		// printOn: does, so a printOn: method can start with super printOn:
+		return vm.printOn(receiver, args)
+	case "identityHash":
+		// A number unique to this object: {1} identityHash ~= {1} identityHash
+		return vm.identityHash(receiver), nil
+	case "displayString":
+		// printString without quotes around strings: 'abc' displayString -> "abc"
+		return vm.displayString(receiver), nil
+	case "inspect", "inspectString":
+		// A multi-line dump of the receiver's class and parts. inspect
+		// prints it and answers the receiver; inspectString answers it.
+		if selector == "inspectString" {
+			return vm.inspectString(receiver), nil
+		}
+		fmt.Fprintln(vm.output(), vm.inspectString(receiver))
+		return receiver, nil
+	case "deepCopy":
+		// A copy sharing no arrays, collections or instances with the receiver
+		return deepCopy(receiver, make(map[interface{}]interface{})), nil
+	case "copy", "shallowCopy":
+		// A new collection or instance sharing the receiver's elements
+		return shallowCopy(receiver), nil
+	case "isInteger", "isFloat", "isNumber", "isFraction", "isString", "isNil", "notNil", "isBoolean", "isArray":
+		return typePredicate(receiver, selector), nil
+	case "caseOf:", "caseOf:otherwise:":
+		// A switch statement: 2 caseOf: #{1 -> ['one']. 2 -> ['two']} -> 'two'
+		return vm.caseOf(receiver, selector, args)
+	case "ifNil:", "ifNotNil:", "ifNil:ifNotNil:", "ifNotNil:ifNil:":
+		// nil ifNil: [42] -> 42, 5 ifNotNil: [:x | x + 1] -> 6
+		return vm.ifNil(receiver, selector, args)
 
 	// HTTP primitives
 	case "httpGet:":
@@ -1103,6 +1979,32 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		}
 		return vm.httpPost(url, body)
 
+	case "urlEncode:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("urlEncode: expects 1 argument")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("urlEncode: argument must be a string")
+		}
+		return vm.urlEncode(data), nil
+
+	case "urlDecode:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("urlDecode: expects 1 argument")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("urlDecode: argument must be a string")
+		}
+		return vm.urlDecode(data)
+
+	case "queryString:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("queryString: expects 1 argument")
+		}
+		return vm.queryString(args[0])
+
 	// Crypto primitives
 	case "aesEncrypt:key:":
 		if len(args) != 2 {
@@ -1133,19 +2035,29 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		if len(args) != 1 {
 			return nil, fmt.Errorf("sha256: expects 1 argument")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("sha256: argument must be a string")
+			return nil, fmt.Errorf("sha256: argument must be a string or a ByteArray")
 		}
 		return vm.sha256Hash(data), nil
 
+	case "sha256Bytes:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("sha256Bytes: expects 1 argument")
+		}
+		data, ok := textOrBytes(args[0])
+		if !ok {
+			return nil, fmt.Errorf("sha256Bytes: argument must be a string or a ByteArray")
+		}
+		return sha256Bytes(data), nil
+
 	case "sha512:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("sha512: expects 1 argument")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("sha512: argument must be a string")
+			return nil, fmt.Errorf("sha512: argument must be a string or a ByteArray")
 		}
 		return vm.sha512Hash(data), nil
 
@@ -1153,19 +2065,43 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		if len(args) != 1 {
 			return nil, fmt.Errorf("md5: expects 1 argument")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("md5: argument must be a string")
+			return nil, fmt.Errorf("md5: argument must be a string or a ByteArray")
 		}
 		return vm.md5Hash(data), nil
 
+	case "hmacSha256:key:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("hmacSha256:key: expects 2 arguments")
+		}
+		data, ok1 := args[0].(string)
+		key, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("hmacSha256:key: arguments must be strings")
+		}
+		return vm.hmacSha256(data, key), nil
+
+	case "pbkdf2:salt:iterations:length:":
+		if len(args) != 4 {
+			return nil, fmt.Errorf("pbkdf2:salt:iterations:length: expects 4 arguments")
+		}
+		password, ok1 := args[0].(string)
+		salt, ok2 := args[1].(string)
+		iterations, ok3 := args[2].(int64)
+		length, ok4 := args[3].(int64)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("pbkdf2:salt:iterations:length: expects password and salt strings and integer iterations and length")
+		}
+		return vm.pbkdf2Sha256(password, salt, iterations, length)
+
 	case "base64Encode:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("base64Encode: expects 1 argument")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("base64Encode: argument must be a string")
+			return nil, fmt.Errorf("base64Encode: argument must be a string or a ByteArray")
 		}
 		return vm.base64Encode(data), nil
 
@@ -1179,6 +2115,17 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		}
 		return vm.base64Decode(data)
 
+	case "base64DecodeBytes:":
+		// Like base64Decode:, answering the decoded bytes as a ByteArray
+		if len(args) != 1 {
+			return nil, fmt.Errorf("base64DecodeBytes: expects 1 argument")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("base64DecodeBytes: argument must be a string")
+		}
+		return vm.base64DecodeBytes(data)
+
 	// Compression primitives
 	case "zipCompress:":
 		if len(args) != 1 {
@@ -1246,6 +2193,35 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		}
 		return nil, nil
 
+	case "fileReadBytes:":
+		// Read a file unchanged, answering a ByteArray
+		if len(args) != 1 {
+			return nil, fmt.Errorf("fileReadBytes: expects 1 argument")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("fileReadBytes: path must be a string")
+		}
+		return vm.fileReadBytes(path)
+
+	case "fileWriteBytes:content:":
+		// Write a ByteArray, or an Array of integers 0-255, unchanged
+		if len(args) != 2 {
+			return nil, fmt.Errorf("fileWriteBytes:content: expects 2 arguments")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("fileWriteBytes:content: path must be a string")
+		}
+		data, err := bytesArg("fileWriteBytes:content:", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := vm.fileWriteBytes(path, data); err != nil {
+			return nil, err
+		}
+		return nil, nil
+
 	case "fileExists:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("fileExists: expects 1 argument")
@@ -1287,6 +2263,34 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		}
 		return vm.jsonGenerate(args[0])
 
+	case "jsonGeneratePretty:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("jsonGeneratePretty: expects 1 argument")
+		}
+		return vm.jsonGeneratePretty(args[0], 2)
+
+	case "jsonGenerate:indent:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("jsonGenerate:indent: expects 2 arguments")
+		}
+		indent, ok := args[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("jsonGenerate:indent: indent must be an integer")
+		}
+		return vm.jsonGeneratePretty(args[0], indent)
+
+	// Number conversion primitives
+	case "asInteger:base:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("asInteger:base: expects 2 arguments")
+		}
+		str, ok1 := args[0].(string)
+		base, ok2 := args[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("asInteger:base: expects a string and an integer base")
+		}
+		return vm.asIntegerBase(str, base)
+
 	// Regex primitives
 	case "regexMatch:text:":
 		if len(args) != 2 {
@@ -1434,6 +2438,12 @@ func (vm *VM) send(receiver interface{}, selector string, args []interface{}) (i
 		return vm.timeSecond(timestamp), nil
 
 	default:
+		if receiver == nil {
+			// The messages nil understands (isNil, printString, ...) have
+			// been handled above; anything else is usually a variable that
+			// was never assigned
+			return nil, fmt.Errorf("nil does not understand '%s' (the receiver was nil; was a variable used before it was assigned?)", selector)
+		}
 		return nil, fmt.Errorf("unknown message: %s", selector)
 	}
 }
@@ -1465,6 +2475,16 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 			return nil, fmt.Errorf("not a primitive")
 		}
 		return vm.divide(receiver, args[0])
+	case "//":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.floorDivide(receiver, args[0])
+	case "%", "\\\\":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.modulo(selector, receiver, args[0])
 	case "<":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
@@ -1495,15 +2515,83 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 			return nil, fmt.Errorf("not a primitive")
 		}
 		return vm.notEqual(receiver, args[0])
+	case "==":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.identical(receiver, args[0])
+	case "@":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.makePoint(receiver, args[0])
 	case "println":
-		// Print the receiver followed by a newline
-		fmt.Println(receiver)
+		// Print the receiver's displayString followed by a newline
+		fmt.Fprintln(vm.output(), vm.displayString(receiver))
 		// Return the receiver (allows method chaining)
 		return receiver, nil
 	case "print":
-		// Print the receiver without a newline
-		fmt.Print(receiver)
+		// Print the receiver's displayString without a newline
+		fmt.Fprint(vm.output(), vm.displayString(receiver))
+		return receiver, nil
+	case "yourself":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return receiver, nil
+	case "halt":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.halt(receiver), nil
+	case "printString":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.basicPrintString(receiver), nil
+	case "printOn:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.printOn(receiver, args)
+	case "identityHash":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.identityHash(receiver), nil
+	case "displayString":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.displayString(receiver), nil
+	case "inspect", "inspectString":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		if selector == "inspectString" {
+			return vm.inspectString(receiver), nil
+		}
+		fmt.Fprintln(vm.output(), vm.inspectString(receiver))
 		return receiver, nil
+	case "deepCopy":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return deepCopy(receiver, make(map[interface{}]interface{})), nil
+	case "copy", "shallowCopy":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return shallowCopy(receiver), nil
+	case "isInteger", "isFloat", "isNumber", "isFraction", "isString", "isNil", "notNil", "isBoolean", "isArray":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return typePredicate(receiver, selector), nil
+	case "caseOf:", "caseOf:otherwise:":
+		return vm.caseOf(receiver, selector, args)
+	case "ifNil:", "ifNotNil:", "ifNil:ifNotNil:", "ifNotNil:ifNil:":
+		return vm.ifNil(receiver, selector, args)
 	
 	// File I/O primitives
 	case "read:":
@@ -1556,6 +2644,35 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		}
 		return nil, nil
 	
+	case "fileReadBytes:":
+		// Read a file unchanged, answering a ByteArray
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("fileReadBytes: path must be a string")
+		}
+		return vm.fileReadBytes(path)
+	
+	case "fileWriteBytes:content:":
+		// Write a ByteArray, or an Array of integers 0-255, unchanged
+		if len(args) != 2 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("fileWriteBytes:content: path must be a string")
+		}
+		data, err := bytesArg("fileWriteBytes:content:", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := vm.fileWriteBytes(path, data); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	
 	case "exists:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
@@ -1621,6 +2738,34 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		}
 		return vm.jsonGenerate(args[0])
 	
+	case "jsonGeneratePretty:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.jsonGeneratePretty(args[0], 2)
+	
+	case "jsonGenerate:indent:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		indent, ok := args[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("jsonGenerate:indent: indent must be an integer")
+		}
+		return vm.jsonGeneratePretty(args[0], indent)
+	
+	// Number conversion primitives
+	case "asInteger:base:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		str, ok1 := args[0].(string)
+		base, ok2 := args[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("asInteger:base: expects a string and an integer base")
+		}
+		return vm.asIntegerBase(str, base)
+	
 	// Regex primitives
 	case "regexMatch:text:":
 		if len(args) != 2 {
@@ -1852,6 +2997,33 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		}
 		return vm.timeSecond(timestamp), nil
 	
+	// URL primitives
+	case "urlEncode:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("urlEncode: argument must be a string")
+		}
+		return vm.urlEncode(data), nil
+	
+	case "urlDecode:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("urlDecode: argument must be a string")
+		}
+		return vm.urlDecode(data)
+	
+	case "queryString:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		return vm.queryString(args[0])
+	
 	// Crypto primitives
 	case "aesEncrypt:key:":
 		if len(args) != 2 {
@@ -1882,19 +3054,29 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("sha256: argument must be a string")
+			return nil, fmt.Errorf("sha256: argument must be a string or a ByteArray")
 		}
 		return vm.sha256Hash(data), nil
 	
+	case "sha256Bytes:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		data, ok := textOrBytes(args[0])
+		if !ok {
+			return nil, fmt.Errorf("sha256Bytes: argument must be a string or a ByteArray")
+		}
+		return sha256Bytes(data), nil
+	
 	case "sha512:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("sha512: argument must be a string")
+			return nil, fmt.Errorf("sha512: argument must be a string or a ByteArray")
 		}
 		return vm.sha512Hash(data), nil
 	
@@ -1902,19 +3084,43 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("md5: argument must be a string")
+			return nil, fmt.Errorf("md5: argument must be a string or a ByteArray")
 		}
 		return vm.md5Hash(data), nil
 	
+	case "hmacSha256:key:":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		data, ok1 := args[0].(string)
+		key, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("hmacSha256:key: arguments must be strings")
+		}
+		return vm.hmacSha256(data, key), nil
+	
+	case "pbkdf2:salt:iterations:length:":
+		if len(args) != 4 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		password, ok1 := args[0].(string)
+		salt, ok2 := args[1].(string)
+		iterations, ok3 := args[2].(int64)
+		length, ok4 := args[3].(int64)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("pbkdf2:salt:iterations:length: expects password and salt strings and integer iterations and length")
+		}
+		return vm.pbkdf2Sha256(password, salt, iterations, length)
+	
 	case "base64Encode:":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("not a primitive")
 		}
-		data, ok := args[0].(string)
+		data, ok := textOrBytes(args[0])
 		if !ok {
-			return nil, fmt.Errorf("base64Encode: argument must be a string")
+			return nil, fmt.Errorf("base64Encode: argument must be a string or a ByteArray")
 		}
 		return vm.base64Encode(data), nil
 	
@@ -1928,6 +3134,17 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 		}
 		return vm.base64Decode(data)
 	
+	case "base64DecodeBytes:":
+		// Like base64Decode:, answering the decoded bytes as a ByteArray
+		if len(args) != 1 {
+			return nil, fmt.Errorf("not a primitive")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("base64DecodeBytes: argument must be a string")
+		}
+		return vm.base64DecodeBytes(data)
+	
 	// Compression primitives
 	case "zipCompress:":
 		if len(args) != 1 {
@@ -1975,11 +3192,95 @@ func (vm *VM) tryPrimitive(receiver interface{}, selector string, args []interfa
 	}
 }
 
+// executeBlockWithTimeout runs a zero-argument block, aborting it with a
+// *TimeoutError if it does not finish within limit.
+//
+// The block runs on its own goroutine under a context deadline. Every VM
+// created while running it inherits the context and checks it before each
+// instruction, so the block stops at its next instruction once the deadline
+// passes. Side effects performed before that point (assignments, output,
+// files written) are not rolled back. A block blocked inside a long-running
+// primitive such as httpGet: only stops once that primitive returns, and
+// the timeout is only reported then: the block shares globals and classes
+// with the caller, so the caller must not carry on while it still runs.
+func (vm *VM) executeBlockWithTimeout(block *Block, limit time.Duration) (interface{}, error) {
+	if block.ParamCount != 0 {
+		return nil, fmt.Errorf("valueWithTimeout: block must take no arguments, got %d", block.ParamCount)
+	}
+
+	parent := vm.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, limit)
+	defer cancel()
+
+	runner := *vm
+	runner.ctx = ctx
+
+	type blockResult struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan blockResult, 1)
+	go func() {
+		value, err := runner.executeBlock(block, []interface{}{})
+		done <- blockResult{value, err}
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil && errors.Is(result.err, context.DeadlineExceeded) && parent.Err() == nil {
+			return nil, &TimeoutError{Limit: limit}
+		}
+		return result.value, result.err
+	case <-ctx.Done():
+		// Wait for the block to stop, since it shares globals with the caller
+		<-done
+		if parent.Err() != nil {
+			// An enclosing valueWithTimeout: expired; let it report the timeout
+			return nil, parent.Err()
+		}
+		return nil, &TimeoutError{Limit: limit}
+	}
+}
+
+// executeBlockEnsuring runs a zero-argument block and then cleanup, for
+// ensure: (always is true) and ifCurtailed: (always is false, so cleanup
+// only runs if the block doesn't finish normally).
+//
+// The block leaves early when it fails or when a ^ inside it returns from
+// its method; either way cleanup runs before the error or the return goes
+// on up the call stack. If cleanup fails itself, its error is the one that
+// propagates, replacing whatever the block answered or raised.
+//
+// Example:
+//   process [
+//       [^self compute] ensure: [log close]   "log is closed, then compute's answer is returned"
+//   ]
+func (vm *VM) executeBlockEnsuring(block *Block, cleanup *Block, always bool) (interface{}, error) {
+	if block.ParamCount != 0 {
+		selector := "ifCurtailed:"
+		if always {
+			selector = "ensure:"
+		}
+		return nil, fmt.Errorf("%s receiver must be a block with no arguments, got %d", selector, block.ParamCount)
+	}
+	result, err := vm.executeBlock(block, []interface{}{})
+	if always || err != nil {
+		if _, cleanupErr := vm.executeBlock(cleanup, []interface{}{}); cleanupErr != nil {
+			return nil, cleanupErr
+		}
+	}
+	return result, err
+}
+
 // executeBlock executes a block with the given arguments.
 //
 // Process:
 //   1. Check argument count matches parameter count
-//   2. Create a new VM instance for the block execution
+//   2. Create a new VM instance for the block execution, with a frame of
+//      its own for the block's parameters and temporaries
 //   3. Set up parameters as local variables BEFORE calling Run()
 //   4. Run the block's bytecode
 //   5. Return the result
@@ -1996,43 +3297,36 @@ func (vm *VM) executeBlock(block *Block, args []interface{}) (interface{}, error
 	if len(args) != block.ParamCount {
 		return nil, fmt.Errorf("block expects %d arguments, got %d", block.ParamCount, len(args))
 	}
+	if vm.checks {
+		if err := checkBlock(block); err != nil {
+			return nil, err
+		}
+	}
+
+	// The block runs in the activation that created it, not the one
+	// calling it: a block returned from a method keeps using that
+	// method's variables and self. Blocks made without a scope use the
+	// caller's
+	scope := block.Scope
+	if scope == nil {
+		scope = vm
+	}
 
-	// Create a new VM for block execution
-	// Blocks share the parent's locals array to support closures
-	// This allows blocks to access and modify variables from the enclosing scope
-	blockVM := &VM{
-		stack:       make([]interface{}, 1024),
-		sp:          0,
-		locals:      vm.locals,  // Share locals with parent for closure support
-		globals:     vm.globals, // Share globals with parent VM
-		constants:   block.Bytecode.Constants, // Will be overwritten by Run() anyway
-		classes:     vm.classes, // Share class registry
-		self:        vm.self,    // Share self reference
-		homeContext: block.HomeContext, // Set the home context for non-local returns
-	}
-
-	// Block parameters are stored starting at the parent's local count
-	// The compiler allocated them at slots starting from parent's localCount
-	// We use the ParentLocalCount stored in the block
-	parentLocalCount := block.ParentLocalCount
-	requiredSize := parentLocalCount + block.ParamCount
-	
-	if cap(vm.locals) < requiredSize {
-		// Need to expand capacity
-		newLocals := make([]interface{}, requiredSize)
-		copy(newLocals, vm.locals)
-		vm.locals = newLocals
-		blockVM.locals = newLocals  // Share the new array with blockVM
-	} else if len(vm.locals) < requiredSize {
-		// Just extend the slice
-		vm.locals = vm.locals[:requiredSize]
-		blockVM.locals = vm.locals  // Ensure blockVM has the extended slice
-	}
-
-	// Set block parameters in the locals array
-	// They start at parentLocalCount
+	// Create a new VM for block execution. Each call gets a frame of its
+	// own for the block's parameters and temporaries, the slots from
+	// ParentLocalCount on; the slots below it are the variables of the
+	// enclosing activations, reached through scope (see localFrame)
+	blockVM := vm.child(scope.self, scope.currentClass)
+	blockVM.constants = block.Bytecode.Constants // Will be overwritten by Run() anyway
+	blockVM.homeContext = block.HomeContext // Set the home context for non-local returns
+	blockVM.scope = scope               // Where the captured variables live
+	blockVM.base = block.ParentLocalCount
+
+	if required := block.ParentLocalCount + block.ParamCount; required > len(blockVM.locals) {
+		blockVM.locals = make([]interface{}, required)
+	}
 	for i, arg := range args {
-		blockVM.locals[parentLocalCount+i] = arg
+		blockVM.locals[block.ParentLocalCount+i] = arg
 	}
 
 	// Execute the block bytecode
@@ -2048,9 +3342,6 @@ func (vm *VM) executeBlock(block *Block, args []interface{}) (interface{}, error
 		return nil, err
 	}
 
-	// Restore locals length to what it was before (cleanup block parameters)
-	vm.locals = vm.locals[:parentLocalCount]
-
 	// Return the top value from the block's stack
 	result := blockVM.StackTop()
 	if result == nil {
@@ -2060,6 +3351,22 @@ func (vm *VM) executeBlock(block *Block, args []interface{}) (interface{}, error
 	return result, nil
 }
 
+// localFrame answers the VM that holds local slot i: this VM for its own
+// variables, or, for a slot below a block's base, the activation the
+// block was created in (or one further out, for nested blocks).
+//
+// Example:
+//   | total |                    "slot 0, in the top-level VM"
+//   #(1 2) do: [:x |             "slot 1, in each call's own frame"
+//       total := x ]             "slot 0, reached through scope"
+func (vm *VM) localFrame(i int) *VM {
+	frame := vm
+	for i < frame.base && frame.scope != nil {
+		frame = frame.scope
+	}
+	return frame
+}
+
 // Primitive operations for arithmetic and comparison.
 //
 // These implement the basic mathematical and logical operations that form
@@ -2069,7 +3376,9 @@ func (vm *VM) executeBlock(block *Block, args []interface{}) (interface{}, error
 //   3. Returns the result or an error
 //
 // Type Support:
-//   Currently supports int64 and float64 for numeric operations.
+//   Currently supports int64, float64 and Fractions for numeric operations.
+//   An Integer combined with a Float is converted to a Float first (see
+//   intFloatOperands), so 2 + 3.5 is 5.5.
 //   A full implementation would use polymorphic method dispatch instead.
 
 // add implements the + binary message.
@@ -2077,15 +3386,26 @@ func (vm *VM) executeBlock(block *Block, args []interface{}) (interface{}, error
 // Supported types:
 //   - int64 + int64 -> int64
 //   - float64 + float64 -> float64
+//   - int64 + float64, either way round -> float64
+//   - Fraction + Fraction or Integer -> exact Fraction (or int64 if whole)
 //
 // Examples:
 //   add(5, 3) -> 8
 //   add(2.5, 1.5) -> 4.0
+//   add(2, 3.5) -> 5.5
 //
 // Errors:
-//   - Type mismatch (e.g., int + float)
-//   - Unsupported types
+//   - Unsupported types (e.g., int + string)
 func (vm *VM) add(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("+", a); err != nil {
+		return nil, err
+	}
+	if result, handled, err := fractionArithmetic("+", a, b); handled {
+		return result, err
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		return x + y, nil
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
@@ -2096,7 +3416,7 @@ func (vm *VM) add(a, b interface{}) (interface{}, error) {
 			return aVal + bVal, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot add %T and %T", a, b)
+	return nil, fmt.Errorf("cannot add %s and %s", describeValue(a), describeValue(b))
 }
 
 // subtract implements the - binary message.
@@ -2104,75 +3424,351 @@ func (vm *VM) add(a, b interface{}) (interface{}, error) {
 // Supported types:
 //   - int64 - int64 -> int64
 //   - float64 - float64 -> float64
+//   - int64 - float64, either way round -> float64
 func (vm *VM) subtract(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("-", a); err != nil {
+		return nil, err
+	}
+	if result, handled, err := fractionArithmetic("-", a, b); handled {
+		return result, err
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		return x - y, nil
+	}
+	switch aVal := a.(type) {
+	case int64:
+		if bVal, ok := b.(int64); ok {
+			return aVal - bVal, nil
+		}
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			return aVal - bVal, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot subtract %s and %s", describeValue(a), describeValue(b))
+}
+
+// multiply implements the * binary message.
+//
+// Supported types:
+//   - int64 * int64 -> int64
+//   - float64 * float64 -> float64
+//   - int64 * float64, either way round -> float64
+func (vm *VM) multiply(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("*", a); err != nil {
+		return nil, err
+	}
+	if result, handled, err := fractionArithmetic("*", a, b); handled {
+		return result, err
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		return x * y, nil
+	}
+	switch aVal := a.(type) {
+	case int64:
+		if bVal, ok := b.(int64); ok {
+			return aVal * bVal, nil
+		}
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			return aVal * bVal, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot multiply %s and %s", describeValue(a), describeValue(b))
+}
+
+// divide implements the / binary message.
+//
+// Supported types:
+//   - int64 / int64 -> int64 when it divides evenly; otherwise truncated,
+//     a Fraction or a Float depending on the VM's DivisionMode
+//   - float64 / float64 -> float64
+//   - int64 / float64, either way round -> float64, so 7 / 2.0
+//     is 3.5 whatever the DivisionMode
+//   - Fraction / Fraction or Integer -> exact Fraction (or int64 if whole)
+//
+// Errors:
+//   - Division by zero, a ZeroDivideError. This includes Float division:
+//     2.5 / 0.0 signals rather than answering infinity, the same as 5 / 0
+//   - Unsupported types
+func (vm *VM) divide(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("/", a); err != nil {
+		return nil, err
+	}
+	if result, handled, err := fractionArithmetic("/", a, b); handled {
+		return result, err
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		if y == 0 {
+			return nil, &ZeroDivideError{Selector: "/", Dividend: a}
+		}
+		return x / y, nil
+	}
+	switch aVal := a.(type) {
+	case int64:
+		if bVal, ok := b.(int64); ok {
+			if bVal == 0 {
+				return nil, &ZeroDivideError{Selector: "/", Dividend: a}
+			}
+			if aVal%bVal == 0 {
+				return aVal / bVal, nil
+			}
+			switch vm.division {
+			case DivideExact:
+				return normalizeFraction(big.NewRat(aVal, bVal)), nil
+			case DivideFloat:
+				return float64(aVal) / float64(bVal), nil
+			}
+			return aVal / bVal, nil
+		}
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			if bVal == 0 {
+				return nil, &ZeroDivideError{Selector: "/", Dividend: a}
+			}
+			return aVal / bVal, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot divide %s and %s", describeValue(a), describeValue(b))
+}
+
+// floorDivide implements the // binary message: division rounded down
+// toward negative infinity, whatever the DivisionMode.
+//
+// Supported types:
+//   - int64 // int64 -> int64
+//   - float64 // float64 -> int64, and the same when an int64 is mixed
+//     with a float64
+//   - Fraction // Fraction or Integer -> int64
+//
+// Examples:
+//   7 // 2   -> 3
+//   -7 // 2  -> -4   (where 7 / 2 truncates to -3)
+//   7.5 // 2 -> 3
+func (vm *VM) floorDivide(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("//", a); err != nil {
+		return nil, err
+	}
+	if x, y, ok := fractionOperands(a, b); ok {
+		if y.Sign() == 0 {
+			return nil, &ZeroDivideError{Selector: "//", Dividend: a}
+		}
+		q := new(big.Rat).Quo(x, y)
+		floor := new(big.Int).Div(q.Num(), q.Denom()) // Euclidean; denominator is positive
+		return normalizeFraction(new(big.Rat).SetInt(floor)), nil
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		if y == 0 {
+			return nil, &ZeroDivideError{Selector: "//", Dividend: a}
+		}
+		return floatQuotient(a, b, x/y)
+	}
+	switch aVal := a.(type) {
+	case int64:
+		if bVal, ok := b.(int64); ok {
+			if bVal == 0 {
+				return nil, &ZeroDivideError{Selector: "//", Dividend: a}
+			}
+			q := aVal / bVal
+			if (aVal%bVal != 0) && ((aVal < 0) != (bVal < 0)) {
+				q--
+			}
+			return q, nil
+		}
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			if bVal == 0 {
+				return nil, &ZeroDivideError{Selector: "//", Dividend: a}
+			}
+			return floatQuotient(a, b, aVal/bVal)
+		}
+	}
+	return nil, fmt.Errorf("cannot divide %s and %s", describeValue(a), describeValue(b))
+}
+
+// floatQuotient rounds the quotient of a // b down to an Integer, failing
+// when it lies outside the int64 range.
+func floatQuotient(a, b interface{}, q float64) (interface{}, error) {
+	q = math.Floor(q)
+	if math.IsNaN(q) || q < math.MinInt64 || q >= math.MaxInt64 {
+		return nil, fmt.Errorf("%v // %v is too large for an Integer", a, b)
+	}
+	return int64(q), nil
+}
+
+// modulo implements the % binary message and its Smalltalk spelling \\:
+// the remainder left by //. It is a floored modulo, so a nonzero result
+// takes the sign of the divisor, as in Smalltalk, rather than the sign of
+// the dividend, as Go's % operator does. That keeps
+// (a // b) * b + (a % b) = a for every a and b.
+//
+// Supported types:
+//   - int64 % int64 -> int64
+//   - float64 % float64 -> float64, and the same when an int64 is mixed
+//     with a float64
+//   - Fraction % Fraction or Integer -> exact Fraction (or int64 if whole)
+//
+// Examples:
+//   7 % 3    -> 1
+//   -7 % 3   -> 2    (where Go's -7 % 3 is -1)
+//   7 % -3   -> -2
+//   5.5 % 2  -> 1.5
+//
+// Errors:
+//   - Division by zero, a ZeroDivideError
+//   - Unsupported types
+func (vm *VM) modulo(selector string, a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver(selector, a); err != nil {
+		return nil, err
+	}
+	if x, y, ok := fractionOperands(a, b); ok {
+		if y.Sign() == 0 {
+			return nil, &ZeroDivideError{Selector: selector, Dividend: a}
+		}
+		q := new(big.Rat).Quo(x, y)
+		floor := new(big.Int).Div(q.Num(), q.Denom()) // Euclidean; denominator is positive
+		r := new(big.Rat).Mul(y, new(big.Rat).SetInt(floor))
+		return normalizeFraction(r.Sub(x, r)), nil
+	}
+	if x, y, ok := intFloatOperands(a, b); ok {
+		return floatModulo(selector, a, x, y)
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
-			return aVal - bVal, nil
+			if bVal == 0 {
+				return nil, &ZeroDivideError{Selector: selector, Dividend: a}
+			}
+			r := aVal % bVal
+			if r != 0 && (r < 0) != (bVal < 0) {
+				r += bVal
+			}
+			return r, nil
 		}
 	case float64:
 		if bVal, ok := b.(float64); ok {
-			return aVal - bVal, nil
+			return floatModulo(selector, a, aVal, bVal)
 		}
 	}
-	return nil, fmt.Errorf("cannot subtract %T and %T", a, b)
+	return nil, fmt.Errorf("cannot take the modulo of %s and %s", describeValue(a), describeValue(b))
 }
 
-// multiply implements the * binary message.
-//
-// Supported types:
-//   - int64 * int64 -> int64
-//   - float64 * float64 -> float64
-func (vm *VM) multiply(a, b interface{}) (interface{}, error) {
+// floatModulo answers the floored modulo of x and y for modulo, where
+// dividend is the receiver as it was sent, for the division by zero
+// error.
+func floatModulo(selector string, dividend interface{}, x, y float64) (interface{}, error) {
+	if y == 0 {
+		return nil, &ZeroDivideError{Selector: selector, Dividend: dividend}
+	}
+	r := math.Mod(x, y)
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r, nil
+}
+
+// checkNumericReceiver returns a TypeError unless receiver is a number,
+// so that true + 1 reports "+ not understood by true" instead of a
+// message about mismatched operand types.
+func checkNumericReceiver(selector string, receiver interface{}) error {
+	switch receiver.(type) {
+	case int64, float64, *big.Rat:
+		return nil
+	}
+	return &TypeError{Selector: selector, Receiver: receiver}
+}
+
+// sortElements sorts elements in place, stably. Without a block, elements
+// are ordered by sending <; with one, the block is called with two
+// elements and must answer true when the first belongs before the second.
+// The first failed comparison stops the sort and is returned.
+func (vm *VM) sortElements(elements []interface{}, block *Block) error {
+	var sortErr error
+	sort.SliceStable(elements, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		var result interface{}
+		if block != nil {
+			result, sortErr = vm.executeBlock(block, []interface{}{elements[i], elements[j]})
+		} else {
+			result, sortErr = vm.send(elements[i], "<", []interface{}{elements[j]})
+		}
+		if sortErr != nil {
+			return false
+		}
+		before, ok := result.(bool)
+		if !ok {
+			sortErr = fmt.Errorf("sort: comparison must answer a boolean, got %s", describeValue(result))
+		}
+		return before
+	})
+	return sortErr
+}
+
+// mixedFloatOperands converts a and b to floats when one is a Float and
+// the other an Integer or Fraction, so that 1 < 2.5 compares the numbers'
+// values. It reports false for any other pair.
+func mixedFloatOperands(a, b interface{}) (x, y float64, ok bool) {
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if aFloat == bFloat {
+		return 0, 0, false
+	}
+	x, okA := numberAsFloat(a)
+	y, okB := numberAsFloat(b)
+	return x, y, okA && okB
+}
+
+// intFloatOperands converts a and b to floats when one is an Integer and
+// the other a Float, so that 2 + 3.5 answers 5.5. Fractions are left out:
+// arithmetic mixing one with a Float is an error rather than silently
+// giving up exactness. It reports false for any other pair.
+func intFloatOperands(a, b interface{}) (x, y float64, ok bool) {
 	switch aVal := a.(type) {
 	case int64:
-		if bVal, ok := b.(int64); ok {
-			return aVal * bVal, nil
+		if bVal, isFloat := b.(float64); isFloat {
+			return float64(aVal), bVal, true
 		}
 	case float64:
-		if bVal, ok := b.(float64); ok {
-			return aVal * bVal, nil
+		if bVal, isInt := b.(int64); isInt {
+			return aVal, float64(bVal), true
 		}
 	}
-	return nil, fmt.Errorf("cannot multiply %T and %T", a, b)
+	return 0, 0, false
 }
 
-// divide implements the / binary message.
-//
-// Supported types:
-//   - int64 / int64 -> int64 (integer division)
-//   - float64 / float64 -> float64
-//
-// Errors:
-//   - Division by zero
-//   - Type mismatch
-func (vm *VM) divide(a, b interface{}) (interface{}, error) {
-	switch aVal := a.(type) {
+// numberAsFloat converts an int64, float64 or *big.Rat to a float64.
+func numberAsFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
 	case int64:
-		if bVal, ok := b.(int64); ok {
-			if bVal == 0 {
-				return nil, fmt.Errorf("division by zero")
-			}
-			return aVal / bVal, nil
-		}
+		return float64(n), true
 	case float64:
-		if bVal, ok := b.(float64); ok {
-			if bVal == 0 {
-				return nil, fmt.Errorf("division by zero")
-			}
-			return aVal / bVal, nil
-		}
+		return n, true
+	case *big.Rat:
+		f, _ := n.Float64()
+		return f, true
 	}
-	return nil, fmt.Errorf("cannot divide %T and %T", a, b)
+	return 0, false
 }
 
 // Comparison operations return boolean values.
 //
 // These implement the relational operators that allow comparing values.
-// All return true or false.
+// All return true or false. An Integer or Fraction compared with a Float
+// is converted to a Float first.
 
 // lessThan implements the < binary message.
 func (vm *VM) lessThan(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("<", a); err != nil {
+		return nil, err
+	}
+	if cmp, handled := fractionCompare(a, b); handled {
+		return cmp < 0, nil
+	}
+	if x, y, ok := mixedFloatOperands(a, b); ok {
+		return x < y, nil
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
@@ -2183,11 +3779,20 @@ func (vm *VM) lessThan(a, b interface{}) (interface{}, error) {
 			return aVal < bVal, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot compare %T and %T", a, b)
+	return nil, fmt.Errorf("cannot compare %s and %s", describeValue(a), describeValue(b))
 }
 
 // greaterThan implements the > binary message.
 func (vm *VM) greaterThan(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver(">", a); err != nil {
+		return nil, err
+	}
+	if cmp, handled := fractionCompare(a, b); handled {
+		return cmp > 0, nil
+	}
+	if x, y, ok := mixedFloatOperands(a, b); ok {
+		return x > y, nil
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
@@ -2198,11 +3803,20 @@ func (vm *VM) greaterThan(a, b interface{}) (interface{}, error) {
 			return aVal > bVal, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot compare %T and %T", a, b)
+	return nil, fmt.Errorf("cannot compare %s and %s", describeValue(a), describeValue(b))
 }
 
 // lessOrEqual implements the <= binary message.
 func (vm *VM) lessOrEqual(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver("<=", a); err != nil {
+		return nil, err
+	}
+	if cmp, handled := fractionCompare(a, b); handled {
+		return cmp <= 0, nil
+	}
+	if x, y, ok := mixedFloatOperands(a, b); ok {
+		return x <= y, nil
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
@@ -2213,11 +3827,20 @@ func (vm *VM) lessOrEqual(a, b interface{}) (interface{}, error) {
 			return aVal <= bVal, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot compare %T and %T", a, b)
+	return nil, fmt.Errorf("cannot compare %s and %s", describeValue(a), describeValue(b))
 }
 
 // greaterOrEqual implements the >= binary message.
 func (vm *VM) greaterOrEqual(a, b interface{}) (interface{}, error) {
+	if err := checkNumericReceiver(">=", a); err != nil {
+		return nil, err
+	}
+	if cmp, handled := fractionCompare(a, b); handled {
+		return cmp >= 0, nil
+	}
+	if x, y, ok := mixedFloatOperands(a, b); ok {
+		return x >= y, nil
+	}
 	switch aVal := a.(type) {
 	case int64:
 		if bVal, ok := b.(int64); ok {
@@ -2228,22 +3851,341 @@ func (vm *VM) greaterOrEqual(a, b interface{}) (interface{}, error) {
 			return aVal >= bVal, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot compare %T and %T", a, b)
+	return nil, fmt.Errorf("cannot compare %s and %s", describeValue(a), describeValue(b))
 }
 
 // equal implements the = binary message.
 //
-// Uses Go's == operator, which handles most types correctly.
-// Returns true if the values are equal, false otherwise.
+// Arrays and dictionaries compare by contents, recursively, so
+// #(1 #(2 3)) = #(1 #(2 3)) is true. Numbers compare by value whatever
+// their kind, so 1/2 = 2/4 and 1 = 1.0 are true. Everything else uses
+// Go's == operator: strings, booleans and Points compare by value,
+// and objects (instances, blocks, classes) by identity.
 func (vm *VM) equal(a, b interface{}) (interface{}, error) {
-	return a == b, nil
+	return valuesEqual(a, b), nil
 }
 
 // notEqual implements the ~= binary message.
 //
 // Complement of equal - returns true if values are different.
 func (vm *VM) notEqual(a, b interface{}) (interface{}, error) {
-	return a != b, nil
+	return !valuesEqual(a, b), nil
+}
+
+// identical implements the == binary message.
+//
+// Unlike =, this never looks inside collections: two arrays are
+// identical only if they are the same array.
+//
+// Example:
+//   | a |
+//   a := #(1 2).
+//   a = #(1 2)    "true"
+//   a == #(1 2)   "false"
+//   a == a        "true"
+func (vm *VM) identical(a, b interface{}) (interface{}, error) {
+	if isMap(a) || isMap(b) {
+		// Go maps can't be compared with ==, so compare their addresses
+		return isMap(a) && isMap(b) && reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer(), nil
+	}
+	return a == b, nil
+}
+
+// caseOf answers caseOf: and caseOf:otherwise:, a switch statement. The
+// first argument is a Dictionary mapping values to blocks; the block stored
+// under the key equal (=) to the receiver is run and its result answered.
+// When no key matches, caseOf:otherwise: runs and answers its otherwise
+// block, while caseOf: fails.
+//
+// Example:
+//   | n |
+//   n := 2.
+//   n caseOf: #{1 -> ['one']. 2 -> ['two']}                    "'two'"
+//   5 caseOf: #{1 -> ['one']. 2 -> ['two']} otherwise: ['many'] "'many'"
+func (vm *VM) caseOf(receiver interface{}, selector string, args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s expects a Dictionary of cases", selector)
+	}
+	cases, ok := args[0].(*Dictionary)
+	if !ok {
+		return nil, fmt.Errorf("%s cases must be a Dictionary, got %s", selector, describeValue(args[0]))
+	}
+	var otherwise *Block
+	if len(args) > 1 {
+		block, err := blockArg(selector, args[1:], 0)
+		if err != nil {
+			return nil, err
+		}
+		otherwise = block
+	}
+
+	branch, found := cases.At(receiver)
+	if !found {
+		if otherwise == nil {
+			return nil, fmt.Errorf("caseOf: no case for %s", vm.printString(receiver))
+		}
+		return vm.executeBlock(otherwise, nil)
+	}
+	block, ok := branch.(*Block)
+	if !ok || block.ParamCount != 0 {
+		return nil, fmt.Errorf("%s case for %s must be a block without arguments", selector, vm.printString(receiver))
+	}
+	return vm.executeBlock(block, nil)
+}
+
+// ifNil answers ifNil:, ifNotNil:, ifNil:ifNotNil: and ifNotNil:ifNil:,
+// which run one block or the other depending on whether the receiver is
+// nil. The ifNil: block takes no arguments; the ifNotNil: block is passed
+// the receiver if it takes one. A receiver with no matching block is
+// answered unchanged, so nil ifNotNil: answers nil and 5 ifNil: answers 5.
+//
+// Example:
+//   nil ifNil: [42]                          "42"
+//   5 ifNotNil: [:x | x + 1]                 "6"
+//   x ifNil: ['none'] ifNotNil: [:v | v printString]
+func (vm *VM) ifNil(receiver interface{}, selector string, args []interface{}) (interface{}, error) {
+	var nilBlock, notNilBlock interface{}
+	switch selector {
+	case "ifNil:", "ifNotNil:":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument (block), got %d", selector, len(args))
+		}
+		if selector == "ifNil:" {
+			nilBlock = args[0]
+		} else {
+			notNilBlock = args[0]
+		}
+	default:
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments (blocks), got %d", selector, len(args))
+		}
+		nilBlock, notNilBlock = args[0], args[1]
+		if selector == "ifNotNil:ifNil:" {
+			nilBlock, notNilBlock = args[1], args[0]
+		}
+	}
+
+	if receiver == nil {
+		if nilBlock == nil {
+			return nil, nil
+		}
+		block, err := blockArg(selector, []interface{}{nilBlock}, 0)
+		if err != nil {
+			return nil, err
+		}
+		return vm.executeBlock(block, nil)
+	}
+	if notNilBlock == nil {
+		return receiver, nil
+	}
+	block, ok := notNilBlock.(*Block)
+	if !ok {
+		return nil, fmt.Errorf("%s argument must be a block", selector)
+	}
+	switch block.ParamCount {
+	case 0:
+		return vm.executeBlock(block, nil)
+	case 1:
+		return vm.executeBlock(block, []interface{}{receiver})
+	}
+	return nil, fmt.Errorf("%s block must take 0 or 1 argument(s), got %d", selector, block.ParamCount)
+}
+
+// typePredicate answers a type-testing message such as isInteger by
+// checking the receiver's Go type. Every value understands these, so
+// generic code can branch on type without risking a runtime error.
+//
+// Example:
+//   3 isNumber     -> true
+//   3.5 isInteger  -> false
+//   nil isNil      -> true
+func typePredicate(receiver interface{}, selector string) bool {
+	switch selector {
+	case "isInteger":
+		_, ok := receiver.(int64)
+		return ok
+	case "isFloat":
+		_, ok := receiver.(float64)
+		return ok
+	case "isNumber":
+		return isNumber(receiver) || isFraction(receiver)
+	case "isFraction":
+		return isFraction(receiver)
+	case "isString":
+		_, ok := receiver.(string)
+		return ok
+	case "isNil":
+		return receiver == nil
+	case "notNil":
+		return receiver != nil
+	case "isBoolean":
+		_, ok := receiver.(bool)
+		return ok
+	case "isArray":
+		_, ok := receiver.(*Array)
+		return ok
+	}
+	return false
+}
+
+// valuesEqual reports whether a and b are equal by value.
+func valuesEqual(a, b interface{}) bool {
+	var comparing identitySet
+	return valuesEqualIn(a, b, &comparing)
+}
+
+// valuesEqualIn compares a and b, recording in comparing the pairs of
+// arrays and dictionaries whose elements are being compared. Meeting a
+// pair again means both sides have gone round a cycle in step; nothing
+// unequal has been found along the way, so the pair is taken as equal.
+func valuesEqualIn(a, b interface{}, comparing *identitySet) bool {
+	switch aVal := a.(type) {
+	case *Array:
+		bVal, ok := b.(*Array)
+		if !ok {
+			return false
+		}
+		if aVal == bVal {
+			return true
+		}
+		if len(aVal.Elements) != len(bVal.Elements) {
+			return false
+		}
+		pair := comparisonPair{aVal, bVal}
+		if !comparing.add(pair) {
+			return true
+		}
+		defer comparing.remove(pair)
+		for i := range aVal.Elements {
+			if !valuesEqualIn(aVal.Elements[i], bVal.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *ByteArray:
+		bVal, ok := b.(*ByteArray)
+		return ok && bytes.Equal(aVal.Bytes, bVal.Bytes)
+	case *Dictionary:
+		bVal, ok := b.(*Dictionary)
+		if !ok || aVal.Len() != bVal.Len() {
+			return false
+		}
+		pair := comparisonPair{aVal, bVal}
+		if !comparing.add(pair) {
+			return true
+		}
+		defer comparing.remove(pair)
+		for i, key := range aVal.Keys() {
+			other, found := bVal.At(key)
+			if !found || !valuesEqualIn(aVal.values[i], other, comparing) {
+				return false
+			}
+		}
+		return true
+	case map[interface{}]interface{}:
+		bVal, ok := b.(map[interface{}]interface{})
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for key, value := range aVal {
+			other, found := bVal[key]
+			if !found || !valuesEqualIn(value, other, comparing) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bVal, ok := b.(map[string]interface{})
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for key, value := range aVal {
+			other, found := bVal[key]
+			if !found || !valuesEqualIn(value, other, comparing) {
+				return false
+			}
+		}
+		return true
+	case int64:
+		if bVal, ok := b.(int64); ok {
+			return aVal == bVal
+		}
+		return numbersEqual(aVal, b)
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			return aVal == bVal
+		}
+		return numbersEqual(aVal, b)
+	case *big.Rat:
+		return numbersEqual(aVal, b)
+	}
+	if isMap(b) {
+		return false
+	}
+	return a == b
+}
+
+// numbersEqual reports whether a and b are numbers of exactly the same
+// value, whatever their types: 1 = 1.0 and 1/2 = 0.5 are true, while
+// 1/3 and 0.3333333333333333 differ. Non-finite Floats equal no other
+// kind of number.
+func numbersEqual(a, b interface{}) bool {
+	ra, ok := exactRational(a)
+	if !ok {
+		return false
+	}
+	rb, ok := exactRational(b)
+	return ok && ra.Cmp(rb) == 0
+}
+
+// exactRational answers the exact value of an Integer, Fraction or finite
+// Float as a big.Rat, or false for anything else.
+func exactRational(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case float64:
+		r := new(big.Rat).SetFloat64(n)
+		return r, r != nil
+	case *big.Rat:
+		return n, true
+	}
+	return nil, false
+}
+
+// isMap reports whether v is one of the map types used for dictionaries
+// and parsed JSON objects.
+func isMap(v interface{}) bool {
+	switch v.(type) {
+	case map[interface{}]interface{}, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// widthAndFill checks the arguments of the string justification messages.
+// The first argument is the target width; the optional with: argument is
+// a single-character fill string, defaulting to a space.
+func widthAndFill(selector string, args []interface{}) (width int64, fill string, err error) {
+	expected := strings.Count(selector, ":")
+	if len(args) != expected {
+		return 0, "", fmt.Errorf("%s expects %d argument(s), got %d", selector, expected, len(args))
+	}
+	width, ok := args[0].(int64)
+	if !ok {
+		return 0, "", fmt.Errorf("%s width must be an integer", selector)
+	}
+	if width < 0 || width > maxElements {
+		return 0, "", fmt.Errorf("%s width must be between 0 and %d, got %d", selector, maxElements, width)
+	}
+	fill = " "
+	if len(args) == 2 {
+		fill, ok = args[1].(string)
+		if !ok || utf8.RuneCountInString(fill) != 1 {
+			return 0, "", fmt.Errorf("%s fill must be a single-character string", selector)
+		}
+	}
+	return width, fill, nil
 }
 
 // Stack manipulation methods.
@@ -2328,11 +4270,21 @@ func (vm *VM) StackTop() interface{} {
 //   - ParamCount: Number of parameters the block expects
 //   - ParentLocalCount: Number of locals in the parent context (for closure support)
 //   - HomeContext: The VM context where the block was created (for non-local returns)
+//   - Scope: The VM whose locals and self the block captured
+//
+// Scope is what makes a block a closure. It is the activation that
+// created the block: a method, the top level, or one call of an outer
+// block. The block reads and writes the variables of that activation, and
+// through it those of the activations around it, wherever it is called
+// from, even after the defining method has returned. Each call of the
+// block gets its own parameters and temporaries, so blocks created in a
+// loop each keep the values of their own iteration.
 type Block struct {
 	Bytecode         *bytecode.Bytecode // The block's compiled code
 	ParamCount       int                // Number of parameters
 	ParentLocalCount int                // Number of locals in parent context
 	HomeContext      *VM                // The VM context that created this block (for non-local returns)
+	Scope            *VM                // The activation whose variables the block captured (nil means the caller's)
 }
 
 // NonLocalReturn is a special error type used to implement non-local returns.
@@ -2385,27 +4337,123 @@ type Array struct {
 type Instance struct {
 	Class  *bytecode.ClassDefinition // The class this is an instance of
 	Fields []interface{}              // Instance variable values
+
+	exception *Exception // Exception state, for a subclass of Error (see exceptionOf)
+}
+
+// newInstance allocates an instance of class with every field set to nil,
+// then to its default value for fields declared with one, and finally
+// sends it initialize if the class or a superclass defines that method.
+//
+// Fields are allocated for this class and all its superclasses. This is
+// what both `new` and the NEW_OBJECT fast path produce. The answer is the
+// instance, whatever initialize returns. The initialize method is found
+// once and kept in the class layout, so new doesn't search the hierarchy.
+//
+// Example:
+//   Object subclass: #Counter [
+//       | count |
+//       initialize [ count := 0 ]
+//   ]
+//   Counter new   "an instance whose count is 0"
+func (vm *VM) newInstance(class *bytecode.ClassDefinition) (*Instance, error) {
+	instance := &Instance{
+		Class:  class,
+		Fields: make([]interface{}, vm.countAllFields(class)),
+	}
+	if err := vm.applyFieldDefaults(instance, class); err != nil {
+		return nil, err
+	}
+	if layout := vm.layout(class); layout.initialize != nil {
+		if _, err := vm.runMethod(instance, layout.initializeClass, layout.initialize, nil); err != nil {
+			return nil, err
+		}
+	}
+	return instance, nil
+}
+
+// applyFieldDefaults runs the field defaults of class and its superclasses
+// on a new instance, superclasses first, so a subclass default can use an
+// inherited field.
+func (vm *VM) applyFieldDefaults(instance *Instance, class *bytecode.ClassDefinition) error {
+	if class.SuperClass != "" && class.SuperClass != "Object" {
+		if superClass, exists := vm.classes[class.SuperClass]; exists {
+			if err := vm.applyFieldDefaults(instance, superClass); err != nil {
+				return err
+			}
+		}
+	}
+	if class.FieldDefaults == nil {
+		return nil
+	}
+	_, err := vm.runMethod(instance, class, class.FieldDefaults, nil)
+	return err
 }
 
 // count AllFields counts total fields in class hierarchy.
 //
 // This counts all instance variables from this class and all superclasses.
-// Fields are ordered from superclass to subclass.
+// Fields are ordered from superclass to subclass. The count comes from the
+// cached layout, so it doesn't walk the hierarchy on every new.
 func (vm *VM) countAllFields(class *bytecode.ClassDefinition) int {
-	total := len(class.Fields)
-	currentClass := class
-	
-	// Walk up the hierarchy counting fields
-	for currentClass.SuperClass != "" && currentClass.SuperClass != "Object" {
-		superClass, exists := vm.classes[currentClass.SuperClass]
-		if !exists {
-			break
+	return len(vm.layout(class).names)
+}
+
+// allFieldNames returns the names of all instance variables of a class,
+// ordered from superclass to subclass to match Instance.Fields. The slice
+// is shared with the layout cache and must not be modified; appending to
+// it makes a copy.
+func (vm *VM) allFieldNames(class *bytecode.ClassDefinition) []string {
+	names := vm.layout(class).names
+	return names[:len(names):len(names)]
+}
+
+// compileMethod implements the compile: class message: it compiles the
+// source of one method, written as it would be inside the class body, and
+// installs it in class, replacing any method with the same selector. It
+// answers the method's selector.
+//
+// Methods are looked up on every send, so instances that already exist use
+// the new method from their next send on. The method is compiled against
+// the class's instance and class variables as they are now.
+//
+// Example:
+//   Counter compile: 'increment [ count := count + 2 ]'   "'increment'"
+//   Counter compile: '<zero [ ^self new ]>'                "a class method"
+func (vm *VM) compileMethod(class *bytecode.ClassDefinition, source string) (interface{}, error) {
+	method, isClassMethod, err := parser.New(source).ParseMethod()
+	if err != nil {
+		return nil, fmt.Errorf("compile: %v", err)
+	}
+
+	var fields []string
+	if !isClassMethod {
+		fields = vm.allFieldNames(class)
+	}
+	def, err := compiler.New().CompileMethod(method, fields, class.ClassVariables)
+	if err != nil {
+		return nil, fmt.Errorf("compile: failed to compile method %s: %v", method.Name, err)
+	}
+
+	if isClassMethod {
+		class.ClassMethods = replaceMethod(class.ClassMethods, def)
+	} else {
+		class.Methods = replaceMethod(class.Methods, def)
+		vm.layoutTable().invalidate()
+	}
+	return def.Selector, nil
+}
+
+// replaceMethod replaces the method in methods with def's selector, or
+// adds def if there is none.
+func replaceMethod(methods []*bytecode.MethodDefinition, def *bytecode.MethodDefinition) []*bytecode.MethodDefinition {
+	for i, method := range methods {
+		if method.Selector == def.Selector {
+			methods[i] = def
+			return methods
 		}
-		total += len(superClass.Fields)
-		currentClass = superClass
 	}
-	
-	return total
+	return append(methods, def)
 }
 
 // getFieldOffset calculates the field offset for a class in the inheritance hierarchy.
@@ -2413,20 +4461,7 @@ func (vm *VM) countAllFields(class *bytecode.ClassDefinition) int {
 // This returns the starting index for this class's fields in the instance field array.
 // Superclass fields come first, so the offset is the sum of all superclass field counts.
 func (vm *VM) getFieldOffset(class *bytecode.ClassDefinition) int {
-	offset := 0
-	currentClass := class
-	
-	// Walk up the hierarchy counting superclass fields
-	for currentClass.SuperClass != "" && currentClass.SuperClass != "Object" {
-		superClass, exists := vm.classes[currentClass.SuperClass]
-		if !exists {
-			break
-		}
-		offset += len(superClass.Fields)
-		currentClass = superClass
-	}
-	
-	return offset
+	return vm.layout(class).offset
 }
 
 // lookupMethod searches for a method in a class and its superclass chain.
@@ -2489,22 +4524,31 @@ func (vm *VM) lookupMethod(class *bytecode.ClassDefinition, selector string) (*b
 //   - The method's return value
 //   - Error if method not found or execution fails
 func (vm *VM) superSend(instance *Instance, selector string, args []interface{}) (interface{}, error) {
-	// Get the superclass of the current class context
-	if vm.currentClass.SuperClass == "" || vm.currentClass.SuperClass == "Object" {
-		return nil, fmt.Errorf("class %s has no superclass to send '%s' to", 
-			vm.currentClass.Name, selector)
-	}
-
-	superClass, exists := vm.classes[vm.currentClass.SuperClass]
-	if !exists {
-		return nil, fmt.Errorf("superclass %s not found for class %s", 
-			vm.currentClass.SuperClass, vm.currentClass.Name)
+	// Look up the method starting from the superclass of the current class
+	// context. Object has no user-defined methods, so a class whose
+	// superclass is Object only inherits primitives.
+	var method *bytecode.MethodDefinition
+	var class *bytecode.ClassDefinition
+	if vm.currentClass.SuperClass != "" && vm.currentClass.SuperClass != "Object" {
+		superClass, exists := vm.classes[vm.currentClass.SuperClass]
+		if !exists {
+			return nil, fmt.Errorf("superclass %s not found for class %s", 
+				vm.currentClass.SuperClass, vm.currentClass.Name)
+		}
+		method, class = vm.lookupMethod(superClass, selector)
 	}
 
-	// Look up the method starting from superclass
-	method, class := vm.lookupMethod(superClass, selector)
-
 	if method == nil {
+		// The selector is inherited only as a primitive, as when a class
+		// overrides printString and calls super printString, or from Error
+		if e := vm.exceptionOf(instance); e != nil {
+			if result, handled, err := vm.sendException(e, selector, args); handled {
+				return result, err
+			}
+		}
+		if result, err := vm.tryPrimitive(instance, selector, args); err == nil {
+			return result, nil
+		}
 		return nil, fmt.Errorf("superclass of %s does not understand message '%s'", 
 			vm.currentClass.Name, selector)
 	}
@@ -2515,13 +4559,8 @@ func (vm *VM) superSend(instance *Instance, selector string, args []interface{})
 			selector, len(method.Parameters), len(args))
 	}
 
-	// Create a new VM for method execution
-	methodVM := New()
-	methodVM.globals = vm.globals       // Share global variables
-	methodVM.classes = vm.classes       // Share class registry
-	methodVM.self = instance            // Set self to the instance
-	methodVM.currentClass = class       // Set class context to where method was found
-	// No field offset needed - methods are compiled with all fields
+	// Create a new VM for method execution to isolate its stack and locals
+	methodVM := vm.child(instance, class)
 
 	// Set up method parameters as local variables
 	for i, arg := range args {
@@ -2541,7 +4580,8 @@ func (vm *VM) superSend(instance *Instance, selector string, args []interface{})
 			// Otherwise, propagate it further up
 			return nil, nlr
 		}
-		return nil, fmt.Errorf("error in super method %s: %w", selector, err)
+		// The error already carries the method's frames in its stack trace
+		return nil, err
 	}
 
 	// Return the result (top of stack)
@@ -2577,7 +4617,13 @@ func (vm *VM) executeMethod(instance *Instance, selector string, args []interfac
 	method, class := vm.lookupMethod(instance.Class, selector)
 
 	if method == nil {
-		// Method not found in class hierarchy - try primitives
+		// Method not found in class hierarchy - try what a subclass of
+		// Error inherits from it, then primitives
+		if e := vm.exceptionOf(instance); e != nil {
+			if result, handled, err := vm.sendException(e, selector, args); handled {
+				return result, err
+			}
+		}
 		result, err := vm.tryPrimitive(instance, selector, args)
 		if err == nil {
 			// Primitive handled it
@@ -2588,19 +4634,23 @@ func (vm *VM) executeMethod(instance *Instance, selector string, args []interfac
 			instance.Class.Name, selector)
 	}
 
+	return vm.runMethod(instance, class, method, args)
+}
+
+// runMethod runs a method found in class with self bound to receiver.
+//
+// The receiver is usually an Instance of class, but a method added to a
+// built-in type with "Integer extend [ ... ]" runs with self bound to the
+// integer, string or other value it was sent to.
+func (vm *VM) runMethod(receiver interface{}, class *bytecode.ClassDefinition, method *bytecode.MethodDefinition, args []interface{}) (interface{}, error) {
 	// Check argument count
 	if len(args) != len(method.Parameters) {
 		return nil, fmt.Errorf("method %s expects %d arguments, got %d", 
-			selector, len(method.Parameters), len(args))
+			method.Selector, len(method.Parameters), len(args))
 	}
 
 	// Create a new VM for method execution to isolate its stack and locals
-	methodVM := New()
-	methodVM.globals = vm.globals       // Share global variables
-	methodVM.classes = vm.classes       // Share class registry
-	methodVM.self = instance            // Set self to the instance
-	methodVM.currentClass = class       // Set current class context for super sends
-	// No field offset needed - methods are compiled with all fields
+	methodVM := vm.child(receiver, class)
 
 	// Set up method parameters as local variables
 	for i, arg := range args {
@@ -2625,7 +4675,8 @@ func (vm *VM) executeMethod(instance *Instance, selector string, args []interfac
 			// Otherwise, propagate it further up (shouldn't normally happen in well-formed code)
 			return nil, nlr
 		}
-		return nil, fmt.Errorf("error in method %s: %w", selector, err)
+		// The error already carries the method's frames in its stack trace
+		return nil, err
 	}
 
 	// Return the result (top of stack)
@@ -2661,7 +4712,16 @@ func (vm *VM) executeClassMethod(classDef *bytecode.ClassDefinition, selector st
 	}
 
 	if method == nil {
-		// Class method not found
+		// Class method not found - try signal: for a subclass of Error,
+		// then primitives (e.g. isNil, println)
+		if _, ok := vm.exceptionClassName(classDef); ok {
+			if result, handled, err := vm.sendExceptionSubclass(classDef, selector, args); handled {
+				return result, err
+			}
+		}
+		if result, err := vm.tryPrimitive(classDef, selector, args); err == nil {
+			return result, nil
+		}
 		return nil, fmt.Errorf("class %s does not understand class message '%s'", 
 			classDef.Name, selector)
 	}
@@ -2672,12 +4732,8 @@ func (vm *VM) executeClassMethod(classDef *bytecode.ClassDefinition, selector st
 			selector, len(method.Parameters), len(args))
 	}
 
-	// Create a new VM for method execution
-	methodVM := New()
-	methodVM.globals = vm.globals       // Share global variables
-	methodVM.classes = vm.classes       // Share class registry
-	methodVM.self = classDef            // Set self to the class
-	methodVM.currentClass = classDef    // Set class context
+	// Create a new VM for method execution to isolate its stack and locals
+	methodVM := vm.child(classDef, classDef)
 
 	// Set up method parameters as local variables
 	for i, arg := range args {
@@ -2697,7 +4753,8 @@ func (vm *VM) executeClassMethod(classDef *bytecode.ClassDefinition, selector st
 			// Otherwise, propagate it further up
 			return nil, nlr
 		}
-		return nil, fmt.Errorf("error in class method %s: %w", selector, err)
+		// The error already carries the method's frames in its stack trace
+		return nil, err
 	}
 
 	// Return the result (top of stack)
@@ -2709,6 +4766,37 @@ func (vm *VM) executeClassMethod(classDef *bytecode.ClassDefinition, selector st
 	return nil, nil
 }
 
+// child creates the VM a method or block called from vm runs in, with
+// self and currentClass bound for its code.
+//
+// The child has a stack and locals of its own, and shares everything else
+// with vm: globals, classes and extensions, the identity hashes and
+// layouts, and the settings (output, division, print limits, invariant
+// checks, debugger and any valueWithTimeout: deadline). It is one call
+// deeper, so the debugger can step over it. Every nested VM is made here,
+// so none of them misses a setting.
+func (vm *VM) child(self interface{}, class *bytecode.ClassDefinition) *VM {
+	return &VM{
+		stack:        make([]interface{}, 1024),
+		locals:       make([]interface{}, 256),
+		globals:      vm.globals,
+		classes:      vm.classes,
+		extensions:   vm.extensions,
+		self:         self,
+		currentClass: class,
+		callStack:    make([]StackFrame, 0, 64),
+		debugging:    vm.debugState(),
+		depth:        vm.depth + 1,
+		ctx:          vm.ctx,
+		out:          vm.out,
+		division:     vm.division,
+		checks:       vm.checks,
+		printing:     vm.printing,
+		identities:   vm.identityTable(),
+		layouts:      vm.layoutTable(),
+	}
+}
+
 // GetGlobal retrieves a global variable by name.
 //
 // This is primarily for testing purposes.
@@ -2724,7 +4812,14 @@ func (vm *VM) GetGlobal(name string) interface{} {
 
 // pushFrame adds a new call frame to the call stack.
 // This is used for stack trace generation.
+//
+// The frame below it, such as the "main program" frame, is left at the
+// instruction making the call, so every frame of the trace points at the
+// instruction it was running.
 func (vm *VM) pushFrame(name, selector string) {
+	if n := len(vm.callStack); n > 0 {
+		vm.callStack[n-1].IP = vm.ip
+	}
 	frame := StackFrame{
 		Name:     name,
 		Selector: selector,
@@ -2751,19 +4846,52 @@ func (vm *VM) runtimeError(message string) error {
 		stack[len(stack)-1].IP = vm.ip
 	}
 	
-	return newRuntimeError(message, stack)
+	rtErr := newRuntimeError(message, stack)
+	if len(stack) > 0 {
+		rtErr.Selector = stack[len(stack)-1].Selector
+	}
+	return rtErr
+}
+
+// runtimeErrorFrom creates a runtime error from an underlying error.
+// The original error is kept as the Cause so typed errors (such as
+// *TimeoutError) can still be recovered with errors.As.
+//
+// An error from a method or block run by a nested VM is already a
+// RuntimeError holding that VM's frames. Rather than wrapping it, its
+// frames are appended to this VM's, keeping the innermost message and
+// selector.
+func (vm *VM) runtimeErrorFrom(err error) error {
+	rtErr := vm.runtimeError(err.Error()).(*RuntimeError)
+	inner, ok := err.(*RuntimeError)
+	if !ok {
+		rtErr.Cause = err
+		return rtErr
+	}
+	rtErr.Message = inner.Message
+	rtErr.Cause = inner.Cause
+	if inner.Selector != "" {
+		rtErr.Selector = inner.Selector
+	}
+	// The nested VM's first frame is its "main program" frame, which the
+	// send frame on this VM's stack already names
+	if len(inner.StackTrace) > 0 {
+		rtErr.StackTrace = append(rtErr.StackTrace, inner.StackTrace[1:]...)
+	}
+	return rtErr
 }
 
 // EnableDebugger creates and enables a debugger for this VM.
 func (vm *VM) EnableDebugger() *Debugger {
-	if vm.debugger == nil {
-		vm.debugger = NewDebugger(vm)
+	state := vm.debugState()
+	if state.debugger == nil {
+		state.debugger = NewDebugger(vm)
 	}
-	vm.debugger.Enable()
-	return vm.debugger
+	state.debugger.Enable()
+	return state.debugger
 }
 
 // GetDebugger returns the debugger instance if debugging is enabled.
 func (vm *VM) GetDebugger() *Debugger {
-	return vm.debugger
+	return vm.debugState().debugger
 }
@@ -1,8 +1,13 @@
 package vm
 
 import (
+"errors"
+"math/big"
+"reflect"
+"strings"
 "testing"
 
+"github.com/kristofer/smog/pkg/bytecode"
 "github.com/kristofer/smog/pkg/compiler"
 "github.com/kristofer/smog/pkg/parser"
 )
@@ -400,3 +405,1806 @@ if len(array.Elements) != 3 {
 t.Errorf("Expected array with 3 elements, got %d", len(array.Elements))
 }
 }
+
+func TestVMArrayReduce(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"#(1 2 3 4) reduce: [ :a :b | a + b ]", int64(10)},
+		{"#(2 3 4) reduce: [ :a :b | a * b ]", int64(24)},
+		{"#(10 2 3) reduce: [ :a :b | a - b ]", int64(5)},
+		{"#(7) reduce: [ :a :b | a + b ]", int64(7)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestVMArrayReduceErrors(t *testing.T) {
+	for _, input := range []string{
+		"#() reduce: [ :a :b | a + b ]",
+		"#(1 2) reduce: [ :a | a ]",
+		"#(1 2) reduce: 3",
+	} {
+		if err := runSourceError(t, input); err == nil {
+			t.Errorf("%s: expected error, got nil", input)
+		}
+	}
+}
+
+func TestVMArrayConcatenation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"(#(1 2) , #(3 4)) = #(1 2 3 4)", true},
+		{"(#(1 2) , #()) = #(1 2)", true},
+		{"(#() , #(1 2)) = #(1 2)", true},
+		{"(#(1) , #(2) , #(3 #(4))) = #(1 2 3 #(4))", true},
+		{"(#(1) , (Set new add: 2; yourself)) = #(1 2)", true},
+		{"(#(0) , #{'a' -> 1. 'b' -> 2}) = #(0 1 2)", true},
+		{"(#(1 2) repeat: 3) = #(1 2 1 2 1 2)", true},
+		{"(#(1 2) repeat: 0) = #()", true},
+		{"(#() repeat: 5) = #()", true},
+		// The receiver is left unchanged
+		{"| a | a := #(1 2). a , #(3). a repeat: 2. a = #(1 2)", true},
+		{"| a | a := #(1 2). (a , #()) == a", false},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+
+	for _, input := range []string{
+		"#(1 2) , 3",
+		"#(1 2) , 'ab'",
+		"#(1 2) repeat: -1",
+		"#(1 2) repeat: 'x'",
+	} {
+		if err := runSourceError(t, input); err == nil {
+			t.Errorf("%s: expected error, got nil", input)
+		}
+	}
+	err := runSourceError(t, "#(1 2) , 3")
+	if err == nil || !strings.Contains(err.Error(), "argument must be a collection") {
+		t.Errorf("Expected a collection type error, got %v", err)
+	}
+	err = runSourceError(t, "#(1 2) repeat: 1000000000000")
+	if err == nil || !strings.Contains(err.Error(), "repeat: result would be larger than the maximum") {
+		t.Errorf("Expected a size error, got %v", err)
+	}
+}
+
+func TestVMMixedNumberComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 < 2.5", true},
+		{"2.5 < 1", false},
+		{"3 > 2.5", true},
+		{"2.5 >= 3", false},
+		{"3 <= 3.0", true},
+		{"3.0 >= 3", true},
+		{"(1/2) < 0.75", true},
+		{"0.5 <= (1/2)", true},
+		// = compares the values of numbers, not their kinds
+		{"1 = 1.0", true},
+		{"1.0 = 1", true},
+		{"1 ~= 1.0", false},
+		{"(1/2) = 0.5", true},
+		{"0.5 = (2/4)", true},
+		{"(1/3) = (1 / 3.0)", false},
+		{"1 = 1.5", false},
+		{"#(1 2) = #(1.0 2.0)", true},
+		{"9007199254740993 = 9007199254740992.0", false},
+	}
+
+	// Exact division, so that 1/2 is a Fraction
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// TestVMMixedNumberKeys tests that numbers which are = find the same
+// entry of a Dictionary, Set or Bag, whatever their kinds
+func TestVMMixedNumberKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"#{1 -> 'one'} at: 1.0", "one"},
+		{"#{1.0 -> 'one'} at: 1", "one"},
+		{"#{0.5 -> 'half'} at: (1/2)", "half"},
+		{"#{(1/2) -> 'half'} at: 0.5", "half"},
+		{"#{(1/3) -> 'third'} at: (1 / 3.0) ifAbsent: ['none']", "none"},
+		{"| d | d := #{}. d at: 2 put: 'a'; at: 2.0 put: 'b'. d size", int64(1)},
+		{"| d | d := #{}. d at: 2 put: 'a'; at: 2.0 put: 'b'. d at: 2", "b"},
+		{"#{1 -> 'one'} includesKey: 1.0", true},
+		{"(Set new add: 1; add: 1.0; add: (2/2); yourself) size", int64(1)},
+		{"(Set new add: 0.5; yourself) includes: (1/2)", true},
+		{"(Bag new add: 3; add: 3.0; yourself) occurrencesOf: 3", int64(2)},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.input); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestVMArraySort(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"#(3 1 2) sort = #(1 2 3)", true},
+		{"#(3 1 2.5 0.5 2) sort = #(0.5 1 2 2.5 3)", true},
+		{"#(1 (1/3) 0.25) sort = #(0.25 (1/3) 1)", true},
+		{"#() sort = #()", true},
+		{"(#(3 1 2) sort: [:a :b | a > b]) = #(3 2 1)", true},
+		// Sorting happens in place
+		{"| a | a := #(2 1). a sort. a = #(1 2)", true},
+		// Equal elements keep their order
+		{"(#(#(1 'b') #(0 'x') #(1 'a')) sort: [:a :b | (a at: 1) < (b at: 1)]) = #(#(0 'x') #(1 'b') #(1 'a'))", true},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+
+	for _, input := range []string{
+		"#(1 'a') sort",
+		"#(1 2) sort: [:a :b | 3]",
+		"#(1 2) sort: [:a | true]",
+	} {
+		if err := runSourceError(t, input); err == nil {
+			t.Errorf("%s: expected error, got nil", input)
+		}
+	}
+}
+
+func TestVMRejectsCorruptSendOperand(t *testing.T) {
+	for _, op := range []bytecode.Opcode{bytecode.OpSend, bytecode.OpSuperSend} {
+		bc := &bytecode.Bytecode{
+			Instructions: []bytecode.Instruction{
+				{Op: bytecode.OpPush, Operand: 0},
+				{Op: op, Operand: -256},
+			},
+			Constants: []interface{}{int64(1)},
+		}
+
+		err := New().Run(bc)
+		if err == nil || !strings.Contains(err.Error(), "invalid send operand") {
+			t.Errorf("%s: expected invalid send operand error, got %v", op, err)
+		}
+	}
+}
+
+func TestVMArrayEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"#(1 2 3) = #(1 2 3)", true},
+		{"#(1 2 3) = #(1 2 4)", false},
+		{"#(1 2) = #(1 2 3)", false},
+		{"#(1 2 3) = #(1 2)", false},
+		{"#() = #()", true},
+		{"#(1 #(2 3)) = #(1 #(2 3))", true},
+		{"#(1 #(2 3)) = #(1 #(2 4))", false},
+		{"#('a' true nil) = #('a' true nil)", true},
+		{"#(1 2) = 3", false},
+		{"3 = #(3)", false},
+		{"#(1 2) ~= #(1 2)", false},
+		{"#(1 2) ~= #(2 1)", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestVMIdentity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"| a | a := #(1 2). a == a", true},
+		{"#(1 2) == #(1 2)", false},
+		{"3 == 3", true},
+		{"'abc' == 'abc'", true},
+		{"| d | d := #{1 -> 2}. d == d", true},
+		{"#{1 -> 2} == #{1 -> 2}", false},
+		{"#{1 -> 2} = #{1 -> 2}", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestVMArrayDoSeparatedBy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#() do: [ :e | e print ] separatedBy: [ ', ' print ]", ""},
+		{"#(1) do: [ :e | e print ] separatedBy: [ ', ' print ]", "1"},
+		{"#(1 2 3) do: [ :e | e print ] separatedBy: [ ', ' print ]", "1, 2, 3"},
+		{"#('a' 'b') do: [ :e | e println ] separatedBy: [ '--' println ]", "a\n--\nb\n"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+
+		var out strings.Builder
+		vm := New()
+		vm.SetOutput(&out)
+		if err := vm.Run(bc); err != nil {
+			t.Fatalf("%s: VM error: %v", tt.input, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%s: expected output %q, got %q", tt.input, tt.expected, out.String())
+		}
+		if _, ok := vm.StackTop().(*Array); !ok {
+			t.Errorf("%s: expected do:separatedBy: to return the array, got %T", tt.input, vm.StackTop())
+		}
+	}
+
+	if err := runSourceError(t, "#(1 2) do: [ :e | e ] separatedBy: 3"); err == nil {
+		t.Error("Expected error for non-block separator")
+	}
+}
+
+func TestVMSetOutputReachesMethodsAndBlocks(t *testing.T) {
+	input := `Object subclass: #Greeter [
+    greet [ 'hello' println ]
+]
+Greeter new greet.
+#(1 2) do: [ :e | e print ]`
+
+	p := parser.New(input)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	var out strings.Builder
+	vm := New()
+	vm.SetOutput(&out)
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	if out.String() != "hello\n12" {
+		t.Errorf("Expected captured output %q, got %q", "hello\n12", out.String())
+	}
+}
+
+func TestVMArrayIndexedIteration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#(10 20 30) keysAndValuesDo: [ :i :e | i print. '=' print. e print. ' ' print ]", "1=10 2=20 3=30 "},
+		{"#(10 20 30) doWithIndex: [ :e :i | e print. '@' print. i print. ' ' print ]", "10@1 20@2 30@3 "},
+		{"#('a' 'b') withIndexDo: [ :e :i | e print. i print ]", "a1b2"},
+		{"#('a' 'b' 'c') keysDo: [ :i | i print ]", "123"},
+		{"#('a' 'b' 'c') valuesDo: [ :e | e print ]", "abc"},
+		{"#() keysAndValuesDo: [ :i :e | 'never' print ]", ""},
+		{"#() doWithIndex: [ :e :i | 'never' print ]", ""},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tt.input)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+
+		var out strings.Builder
+		vm := New()
+		vm.SetOutput(&out)
+		if err := vm.Run(bc); err != nil {
+			t.Fatalf("%s: VM error: %v", tt.input, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%s: expected output %q, got %q", tt.input, tt.expected, out.String())
+		}
+	}
+
+	// The index is an integer usable for arithmetic and at:
+	result := runSource(t, "| arr sum | arr := #(5 6 7). sum := 0. arr keysAndValuesDo: [ :i :e | sum := sum + (i * e) - (arr at: i) ]. sum")
+	if result != int64(5+2*6+3*7-18) {
+		t.Errorf("Expected %d, got %v", 5+2*6+3*7-18, result)
+	}
+
+	if err := runSourceError(t, "#(1 2) keysAndValuesDo: [ :e | e ]"); err == nil {
+		t.Error("Expected error for one-argument block")
+	}
+}
+
+func TestVMTypePredicates(t *testing.T) {
+	// One representative value of every runtime type
+	values := []struct {
+		name   string
+		source string
+		is     string // the one predicate that should be true ("" for none)
+	}{
+		{"integer", "42", "isInteger"},
+		{"float", "3.5", "isFloat"},
+		{"string", "'hi'", "isString"},
+		{"nil", "nil", "isNil"},
+		{"boolean", "true", "isBoolean"},
+		{"array", "#(1 2)", "isArray"},
+		{"block", "[ 1 ]", ""},
+		{"point", "(3 @ 4)", ""},
+		{"dictionary", "#{1 -> 2}", ""},
+		{"instance", "Thing new", ""},
+		{"class", "Thing", ""},
+	}
+	predicates := []string{"isInteger", "isFloat", "isString", "isNil", "isBoolean", "isArray"}
+
+	for _, v := range values {
+		for _, predicate := range predicates {
+			input := "Object subclass: #Thing [ foo [ ^1 ] ]\n" + v.source + " " + predicate
+			expected := predicate == v.is
+			if result := runSource(t, input); result != expected {
+				t.Errorf("%s %s: expected %v, got %v", v.name, predicate, expected, result)
+			}
+		}
+	}
+
+	for input, expected := range map[string]bool{
+		"42 isNumber":      true,
+		"3.5 isNumber":     true,
+		"'42' isNumber":    false,
+		"nil isNumber":     false,
+		"#(1) isNumber":    false,
+		"(1 @ 2) isNumber": false,
+	} {
+		if result := runSource(t, input); result != expected {
+			t.Errorf("%s: expected %v, got %v", input, expected, result)
+		}
+	}
+}
+
+func TestVMTypePredicateOverride(t *testing.T) {
+	// User classes can still answer predicates themselves
+	result := runSource(t, `Object subclass: #Money [ isNumber [ ^true ] ]
+Money new isNumber`)
+	if result != true {
+		t.Errorf("Expected overridden isNumber to return true, got %v", result)
+	}
+}
+
+// TestVMNilTests tests notNil and the ifNil: family of messages on nil
+// and on receivers of every kind
+func TestVMNilTests(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"nil ifNil: [42]", int64(42)},
+		{"5 ifNotNil: [:x | x + 1]", int64(6)},
+		{"nil notNil", false},
+		{"0 notNil", true},
+		{"false notNil", true},
+		// A receiver with no block to run is answered unchanged
+		{"5 ifNil: [42]", int64(5)},
+		{"nil ifNotNil: [:x | x + 1]", nil},
+		// The ifNotNil: block may ignore the receiver
+		{"'hi' ifNotNil: ['seen']", "seen"},
+		{"nil ifNil: ['none'] ifNotNil: [:x | x size]", "none"},
+		{"'abc' ifNil: ['none'] ifNotNil: [:x | x size]", int64(3)},
+		{"#(1 2) ifNotNil: [:x | x size] ifNil: [0]", int64(2)},
+		{"nil ifNotNil: [:x | x size] ifNil: [0]", int64(0)},
+		{"#{'a' -> 1} ifNotNil: [:d | d at: 'a']", int64(1)},
+		{"[7] ifNotNil: [:b | b value]", int64(7)},
+		{"(3 @ 4) notNil", true},
+		{"| x | x ifNil: [x := 3]. x", int64(3)},
+		{"Object subclass: #Thing [ ]\nThing new ifNil: [0] ifNotNil: [:t | 1]", int64(1)},
+		// A class can still answer these itself
+		{"Object subclass: #Null [ isNil [ ^true ] ifNil: aBlock [ ^aBlock value ] ]\nNull new ifNil: ['null']", "null"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		{"nil ifNil: 3", "ifNil: argument must be a block"},
+		{"nil ifNil: [:x | x]", "ifNil: block must take 0 argument(s), got 1"},
+		{"5 ifNotNil: 3", "ifNotNil: argument must be a block"},
+		{"5 ifNil: [0] ifNotNil: [:a :b | a]", "ifNil:ifNotNil: block must take 0 or 1 argument(s), got 2"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestVMNewObjectMatchesSend tests that the NEW_OBJECT fast path builds
+// the same instance as sending new
+func TestVMNewObjectMatchesSend(t *testing.T) {
+	classes := `Object subclass: #Animal [
+    | name legs |
+    name [ ^name ]
+    legs [ ^legs ]
+]
+Animal subclass: #Dog [
+    | breed |
+    name: aName [ name := aName ]
+    breed [ ^breed ]
+]
+`
+	// Dog new compiles to NEW_OBJECT; Dog yourself new is a real send
+	fast := runSource(t, classes+`Dog new`)
+	slow := runSource(t, classes+`Dog yourself new`)
+
+	for _, result := range []interface{}{fast, slow} {
+		instance, ok := result.(*Instance)
+		if !ok {
+			t.Fatalf("Expected *Instance, got %T", result)
+		}
+		if instance.Class.Name != "Dog" {
+			t.Errorf("Expected a Dog, got %s", instance.Class.Name)
+		}
+		if len(instance.Fields) != 3 {
+			t.Errorf("Expected 3 fields (2 inherited), got %d", len(instance.Fields))
+		}
+		for i, field := range instance.Fields {
+			if field != nil {
+				t.Errorf("Expected field %d to start nil, got %v", i, field)
+			}
+		}
+	}
+
+	// Instances from the fast path dispatch like any other
+	if result := runSource(t, classes+`| d | d := Dog new. d name: 'Rex'. d name`); result != "Rex" {
+		t.Errorf("Expected Rex, got %v", result)
+	}
+	if result := runSource(t, classes+`Dog new legs isNil`); result != true {
+		t.Errorf("Expected inherited field to be nil, got %v", result)
+	}
+}
+
+// TestVMNewSendsInitialize tests that new, by the NEW_OBJECT fast path or
+// as a message, sends initialize when the class or a superclass defines it
+func TestVMNewSendsInitialize(t *testing.T) {
+	classes := `Object subclass: #Tally [
+    | count = 10 log |
+    initialize [ count := count + 1. log := 'init' ]
+    count [ ^count ]
+    log [ ^log ]
+]
+Tally subclass: #SubTally [ ]
+Object subclass: #Other [
+    initialize [ ^42 ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// initialize runs once, after the field defaults
+		{"Tally new count", int64(11)},
+		{"Tally yourself new count", int64(11)},
+		{"Tally new log", "init"},
+		{"SubTally new count", int64(11)},
+		{"SubTally yourself new log", "init"},
+		// new answers the instance, not what initialize returns
+		{"Other new printString", "an Other"},
+		{"Other yourself new == 42", false},
+		// An initialize added after instances were made is sent from then on,
+		// to subclasses too
+		{"Object subclass: #Late [ | n | n [ ^n ] ]\nLate subclass: #Later [ ]\nLater new n. Late compile: 'initialize [ n := 5 ]'. Later new n", int64(5)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	// So is one added with extend by a later program run in the same VM
+	v := New()
+	for _, source := range []string{
+		"Object subclass: #Late [ ]\nInits := 0. Late new",
+		"Late extend [ initialize [ Inits := Inits + 1 ] ]\nLate new. Inits",
+	} {
+		program, err := parser.New(source).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		if err := v.Run(bc); err != nil {
+			t.Fatalf("Runtime error: %v", err)
+		}
+	}
+	if result := v.StackTop(); result != int64(1) {
+		t.Errorf("Expected the extended initialize to be sent, got %v", result)
+	}
+
+	// An error in initialize is an error in new
+	err := runSourceError(t, "Object subclass: #Broken [ initialize [ ^nil foo ] ]\nBroken new")
+	if err == nil || !strings.Contains(err.Error(), "foo") {
+		t.Errorf("Expected initialize's error from new, got %v", err)
+	}
+}
+
+// TestVMNewObjectFallsBackToSend tests NEW_OBJECT when the class name has
+// been reassigned to something else since compile time
+func TestVMNewObjectFallsBackToSend(t *testing.T) {
+	result := runSource(t, `Object subclass: #Thing [ ]
+Thing := Bag.
+Thing new`)
+	if _, ok := result.(*Bag); !ok {
+		t.Errorf("Expected new to be sent to Bag, got %T", result)
+	}
+
+	err := runSourceError(t, `Object subclass: #Thing [ ]
+Thing := 3.
+Thing new`)
+	if err == nil || !strings.Contains(err.Error(), "new") {
+		t.Errorf("Expected does-not-understand error for 3 new, got %v", err)
+	}
+}
+
+// TestVMCallBlockMatchesSend tests that CALL_BLOCK (block literal
+// receivers) and SEND (block in a variable) give the same results
+func TestVMCallBlockMatchesSend(t *testing.T) {
+	tests := []struct {
+		block string
+		call  string
+	}{
+		{"[42]", "value"},
+		{"[:x | x * 2]", "value: 21"},
+		{"[:a :b | a - b]", "value: 10 value: 3"},
+		{"[:a :b :c | #(a b c) size]", "value: 1 value: 2 value: 3"},
+	}
+
+	for _, tt := range tests {
+		fast := runSource(t, tt.block+" "+tt.call)
+		slow := runSource(t, "| blk | blk := "+tt.block+". blk "+tt.call)
+		if fast != slow {
+			t.Errorf("%s %s: CALL_BLOCK gave %v, send gave %v", tt.block, tt.call, fast, slow)
+		}
+	}
+}
+
+// TestVMCallBlockArityMismatch tests that CALL_BLOCK reports the same
+// arity errors as sending value messages
+func TestVMCallBlockArityMismatch(t *testing.T) {
+	tests := []struct {
+		block string
+		call  string
+	}{
+		{"[:x | x]", "value"},
+		{"[42]", "value: 1"},
+		{"[:a :b | a]", "value: 1"},
+	}
+
+	for _, tt := range tests {
+		fast := runSourceError(t, tt.block+" "+tt.call)
+		slow := runSourceError(t, "| blk | blk := "+tt.block+". blk "+tt.call)
+		if fast == nil || slow == nil {
+			t.Fatalf("%s %s: expected arity errors, got %v and %v", tt.block, tt.call, fast, slow)
+		}
+		if !strings.Contains(fast.Error(), "block expects") {
+			t.Errorf("%s %s: unexpected error %v", tt.block, tt.call, fast)
+		}
+		// Compare messages only; the stack traces point at different IPs
+		fastMsg := strings.SplitN(fast.Error(), "\n", 2)[0]
+		slowMsg := strings.SplitN(slow.Error(), "\n", 2)[0]
+		if fastMsg != slowMsg {
+			t.Errorf("%s %s: CALL_BLOCK error %q differs from send error %q", tt.block, tt.call, fastMsg, slowMsg)
+		}
+	}
+}
+
+// TestVMCallBlockNonBlockReceiver tests CALL_BLOCK on hand-built bytecode
+// whose receiver isn't a block: it falls back to sending the value message
+func TestVMCallBlockNonBlockReceiver(t *testing.T) {
+	bc := &bytecode.Bytecode{
+		Instructions: []bytecode.Instruction{
+			{Op: bytecode.OpPush, Operand: 0},
+			{Op: bytecode.OpPush, Operand: 1},
+			{Op: bytecode.OpCallBlock, Operand: 1},
+		},
+		Constants: []interface{}{int64(3), int64(4)},
+	}
+	err := New().Run(bc)
+	if err == nil || !strings.Contains(err.Error(), "value:") {
+		t.Errorf("Expected does-not-understand value:, got %v", err)
+	}
+
+	bc.Instructions[2].Operand = -1
+	if err := New().Run(bc); err == nil || !strings.Contains(err.Error(), "invalid block argument count") {
+		t.Errorf("Expected invalid argument count error, got %v", err)
+	}
+}
+
+// TestVMCallBlockNonLocalReturn tests that ^ inside a directly-called
+// block literal still returns from the enclosing method
+func TestVMCallBlockNonLocalReturn(t *testing.T) {
+	result := runSource(t, `Object subclass: #Finder [
+    find [ [:x | ^x] value: 7. ^0 ]
+]
+Finder new find`)
+	if result != int64(7) {
+		t.Errorf("Expected 7, got %v", result)
+	}
+}
+
+// runWithDivision compiles and runs source with the given division mode.
+func runWithDivision(t *testing.T, mode DivisionMode, source string) interface{} {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	vm := New()
+	vm.SetDivisionMode(mode)
+	if err := vm.Run(bc); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	return vm.StackTop()
+}
+
+// runWithDivisionError compiles and runs source with the given division
+// mode, returning the runtime error.
+func runWithDivisionError(t *testing.T, mode DivisionMode, source string) error {
+	t.Helper()
+
+	program, err := parser.New(source).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	vm := New()
+	vm.SetDivisionMode(mode)
+	return vm.Run(bc)
+}
+
+// TestVMMixedNumberArrayLiteral tests that a literal array holds each
+// element as the kind of number it was written as
+func TestVMMixedNumberArrayLiteral(t *testing.T) {
+	result := runSource(t, "#(1 2.0 3)")
+	array, ok := result.(*Array)
+	if !ok {
+		t.Fatalf("Expected an Array, got %T", result)
+	}
+	expected := []interface{}{int64(1), 2.0, int64(3)}
+	if len(array.Elements) != len(expected) {
+		t.Fatalf("Expected %d elements, got %d", len(expected), len(array.Elements))
+	}
+	for i, want := range expected {
+		if got := array.Elements[i]; reflect.TypeOf(got) != reflect.TypeOf(want) || got != want {
+			t.Errorf("Element %d: expected %v (%T), got %v (%T)", i+1, want, want, got, got)
+		}
+	}
+
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"(#(1 2.0 3) at: 2) isFloat", true},
+		{"(#(1 2.0 3) at: 3) isInteger", true},
+		{"#(1 2.0 3) printString", "#(1 2.0 3)"},
+		{"#(1 2.0 3) inject: 0 into: [:sum :each | sum + each]", 6.0},
+		{"2.0 printString", "2.0"},
+		{"-0.5 printString", "-0.5"},
+		{"(2 * 1.5) printString", "3.0"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMMixedNumberArithmetic tests that an Integer combined with a Float,
+// either way round, is converted to a Float, while two Integers stay
+// Integers
+func TestVMMixedNumberArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// (int, float)
+		{"2 + 3.5", 5.5},
+		{"2 - 0.5", 1.5},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"7 // 2.0", int64(3)},
+		{"2 < 2.5", true},
+		{"2 > 2.5", false},
+		{"2 <= 2.0", true},
+		{"2 >= 2.5", false},
+		// (float, int)
+		{"3.5 + 2", 5.5},
+		{"3.5 - 2", 1.5},
+		{"1.5 * 2", 3.0},
+		{"7.0 / 2", 3.5},
+		{"-7.5 // 2", int64(-4)},
+		{"2.5 < 2", false},
+		{"2.5 > 2", true},
+		{"2.0 <= 2", true},
+		{"2.5 >= 3", false},
+		// (int, int) keeps integer arithmetic, and / truncates
+		{"2 + 3", int64(5)},
+		{"2 - 5", int64(-3)},
+		{"2 * 3", int64(6)},
+		{"7 / 2", int64(3)},
+		{"-7 / 2", int64(-3)},
+		{"2 < 3", true},
+		{"3 > 2", true},
+		{"3 <= 2", false},
+		{"3 >= 3", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.input); result != tt.expected {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", tt.input, tt.expected, tt.expected, result, result)
+		}
+	}
+
+	// Mixing in something that isn't a number is still an error
+	if err := runSourceError(t, "2 + 'a'"); err == nil || !strings.Contains(err.Error(), "cannot add") {
+		t.Errorf("Expected a cannot add error, got %v", err)
+	}
+}
+
+func TestVMDivisionModes(t *testing.T) {
+	tests := []struct {
+		source   string
+		truncate interface{}
+		exact    interface{}
+		float    interface{}
+	}{
+		{"5 / 2", int64(2), "5/2", 2.5},
+		{"-7 / 2", int64(-3), "-7/2", -3.5},
+		// Spaces make no difference: 5/2 is the same message
+		{"5/2", int64(2), "5/2", 2.5},
+		// Exact quotients are integers in every mode
+		{"6 / 3", int64(2), int64(2), int64(2)},
+		{"0 / 5", int64(0), int64(0), int64(0)},
+		// Floats and // are unaffected by the mode
+		{"5.0 / 2.0", 2.5, 2.5, 2.5},
+		{"5 / 2.0", 2.5, 2.5, 2.5},
+		{"5 // 2", int64(2), int64(2), int64(2)},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []struct {
+			mode     DivisionMode
+			expected interface{}
+		}{
+			{DivideTruncate, tt.truncate},
+			{DivideExact, tt.exact},
+			{DivideFloat, tt.float},
+		} {
+			result := runWithDivision(t, mode.mode, tt.source)
+			if r, ok := result.(*big.Rat); ok {
+				result = r.String()
+			}
+			if result != mode.expected {
+				t.Errorf("%s in mode %d: expected %v, got %v", tt.source, mode.mode, mode.expected, result)
+			}
+		}
+	}
+
+	// The default VM truncates
+	if result := runSource(t, "5 / 2"); result != int64(2) {
+		t.Errorf("Expected default mode to truncate 5 / 2 to 2, got %v", result)
+	}
+}
+
+func TestVMDivisionModeReachesMethodsAndBlocks(t *testing.T) {
+	source := `Object subclass: #Halver [
+    half: n [ ^n / 2 ]
+]
+| h |
+h := Halver new.
+[:n | (h half: n) + (n / 2)] value: 3`
+
+	result := runWithDivision(t, DivideFloat, source)
+	if result != 3.0 {
+		t.Errorf("Expected 1.5 + 1.5 = 3.0, got %v", result)
+	}
+}
+
+func TestVMFloorDivide(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"7 // 2", int64(3)},
+		{"-7 // 2", int64(-4)},
+		{"7 // -2", int64(-4)},
+		{"-7 // -2", int64(3)},
+		{"6 // 3", int64(2)},
+		{"7.5 // 2", int64(3)},
+		{"7.5 // 2.0", int64(3)},
+		{"-7.5 // 2.0", int64(-4)},
+		{"7 // 2.5", int64(2)},
+		{"(7/2) // 1", int64(3)},
+		{"(-7/2) // 1", int64(-4)},
+		{"7 // (2/3)", int64(10)},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, "7 // 0"); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Expected division by zero error, got %v", err)
+	}
+	if err := runSourceError(t, "(10000000000.0 * 10000000000.0) // 1"); err == nil || !strings.Contains(err.Error(), "too large for an Integer") {
+		t.Errorf("Expected a quotient past the Integer range to fail, got %v", err)
+	}
+}
+
+// TestVMModulo tests that % and \\ answer a floored modulo, which takes
+// the sign of the divisor
+func TestVMModulo(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"10 % 3", int64(1)},
+		{"9 % 3", int64(0)},
+		{"-7 % 3", int64(2)},
+		{"7 % -3", int64(-2)},
+		{"-7 % -3", int64(-1)},
+		{"-6 % 3", int64(0)},
+		{"10 \\\\ 3", int64(1)},
+		{"-7 \\\\ 3", int64(2)},
+		{"5.5 % 2.0", 1.5},
+		{"-5.5 % 2.0", 0.5},
+		{"5.5 % -2.0", -0.5},
+		{"7 % 2.5", 2.0},
+		{"7.5 % 2", 1.5},
+		{"(7/2) % 1", big.NewRat(1, 2)},
+		{"(-7/2) % 1", big.NewRat(1, 2)},
+		{"(7/2) % (1/3)", big.NewRat(1, 6)},
+		// Whatever the signs, (a // b) * b + (a % b) = a
+		{"((-7 // 3) * 3) + (-7 % 3)", int64(-7)},
+		{"((7 // -3) * -3) + (7 % -3)", int64(7)},
+	}
+
+	for _, tt := range tests {
+		if result := runWithDivision(t, DivideExact, tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if err := runSourceError(t, "'a' % 2"); err == nil || !strings.Contains(err.Error(), "% not understood by a String") {
+		t.Errorf("Expected a type error for a string receiver, got %v", err)
+	}
+}
+
+// TestVMDivisionByZero tests that dividing by zero signals a
+// ZeroDivideError for every kind of number, so Float division never
+// answers infinity or NaN
+func TestVMDivisionByZero(t *testing.T) {
+	tests := []struct {
+		source   string
+		selector string
+		dividend interface{}
+	}{
+		{"5 / 0", "/", int64(5)},
+		{"10/0", "/", int64(10)},
+		{"2.5 / 0.0", "/", 2.5},
+		{"-2.5 / 0.0", "/", -2.5},
+		{"0.0 / 0.0", "/", 0.0},
+		{"5 / 0.0", "/", int64(5)},
+		{"2.5 / 0", "/", 2.5},
+		{"7 // 0.0", "//", int64(7)},
+		{"7 // 0", "//", int64(7)},
+		{"7.5 // 0.0", "//", 7.5},
+		{"7 % 0", "%", int64(7)},
+		{"7.5 % 0", "%", 7.5},
+		{"7 \\\\ 0.0", "\\\\", int64(7)},
+		{"(1/2) % 0", "%", big.NewRat(1, 2)},
+		{"(1/2) / 0", "/", big.NewRat(1, 2)},
+	}
+
+	for _, tt := range tests {
+		err := runWithDivisionError(t, DivideExact, tt.source)
+		var zeroDivide *ZeroDivideError
+		if !errors.As(err, &zeroDivide) {
+			t.Errorf("%s: expected a ZeroDivideError, got %v", tt.source, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%s: expected the message to mention division by zero, got %v", tt.source, err)
+		}
+		if zeroDivide.Selector != tt.selector || !valuesEqual(zeroDivide.Dividend, tt.dividend) {
+			t.Errorf("%s: expected %v %s 0, got %v %s 0", tt.source,
+				tt.dividend, tt.selector, zeroDivide.Dividend, zeroDivide.Selector)
+		}
+	}
+}
+
+// TestVMArithmeticTypeErrors tests that arithmetic and comparisons sent to
+// non-numbers name the message and the receiver
+func TestVMArithmeticTypeErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"true + 1", "+ not understood by true"},
+		{"false - 1", "- not understood by false"},
+		{"nil * 2", "* not understood by nil"},
+		{"nil / 2", "/ not understood by nil"},
+		{"true // 2", "// not understood by true"},
+		{"nil < 3", "< not understood by nil"},
+		{"false >= 3", ">= not understood by false"},
+		{"'abc' + 1", "+ not understood by a String"},
+		{"'abc' > 'abd'", "> not understood by a String"},
+		{"#(1 2) * 2", "* not understood by an Array"},
+		{"[1] + 1", "+ not understood by a Block"},
+	}
+
+	for _, tt := range tests {
+		err := runSourceError(t, tt.source)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", tt.source)
+			continue
+		}
+		var typeErr *TypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("%s: expected a TypeError, got %T: %v", tt.source, err, err)
+			continue
+		}
+		if typeErr.Error() != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.source, tt.expected, typeErr.Error())
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("%s: expected the runtime error to start with %q, got %q", tt.source, tt.expected, err.Error())
+		}
+	}
+
+	// Numbers still accept each other
+	if result := runWithDivision(t, DivideExact, "(1 + 2) * (1/3) < 2"); result != true {
+		t.Errorf("Expected numeric receivers to keep working, got %v", result)
+	}
+}
+
+// TestVMOperandErrors tests that a number sent arithmetic with an operand it
+// can't use names both values the way smog does, not by their Go types
+func TestVMOperandErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"2 + 'a'", "cannot add an Integer and a String"},
+		{"2 - nil", "cannot subtract an Integer and nil"},
+		{"2.5 * true", "cannot multiply a Float and true"},
+		{"6 / nil", "cannot divide an Integer and nil"},
+		{"3 < 'a'", "cannot compare an Integer and a String"},
+		{"1/2r + 0.5", "cannot add a Fraction and a Float"},
+		{"3 @ 'a'", "cannot make a Point from an Integer and a String"},
+		{"(1 @ 2) + 'a'", "cannot combine a Point and a String"},
+		{"'a' , 3", ", argument must be a String, got an Integer"},
+		{"'a' , 2.5", ", argument must be a String, got a Float"},
+	}
+
+	for _, tt := range tests {
+		if err := runSourceError(t, tt.source); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.source, tt.expected, err)
+		}
+	}
+}
+
+// TestVMBraceArray tests that {...} evaluates its elements at runtime
+func TestVMBraceArray(t *testing.T) {
+	result := runSource(t, "{1 + 1. 2 * 3}")
+	arr, ok := result.(*Array)
+	if !ok {
+		t.Fatalf("Expected *Array, got %T", result)
+	}
+	if len(arr.Elements) != 2 || arr.Elements[0] != int64(2) || arr.Elements[1] != int64(6) {
+		t.Errorf("Expected {2. 6}, got %v", arr.Elements)
+	}
+
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| x | x := 5. ({x. x + 1} at: 2)", int64(6)},
+		{"{} size", int64(0)},
+		{"{'a'. {1. 2}. #(3 4)} size", int64(3)},
+		{"(({'a'. {1. 2}} at: 2) at: 2)", int64(2)},
+		{"{1 + 1. 2} = #(2 2)", true},
+		// Each evaluation builds a new array
+		{"| b | b := [{1}]. b value == b value", false},
+		// Elements are evaluated in order
+		{"| n | n := 0. ({n := n + 1. n := n * 10. n} at: 3)", int64(10)},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// vectorClass defines a class with user-defined binary methods
+const vectorClass = `
+Object subclass: #Vector [
+    | x y |
+    <x: ax y: ay [ ^Vector new setX: ax y: ay ]>
+    setX: ax y: ay [ x := ax. y := ay ]
+    x [ ^x ]
+    y [ ^y ]
+    + other [ ^Vector x: x + other x y: y + other y ]
+    < other [ ^x < other x ]
+    = other [ ^x = other x ]
+]
+Vector subclass: #Vector3 [
+    | z |
+    + other [ | sum | sum := super + other. ^sum x * 100 + sum y ]
+]
+`
+
+// TestVMUserDefinedBinarySelectors tests that binary selectors defined by a
+// class are dispatched to its methods instead of the numeric primitives
+func TestVMUserDefinedBinarySelectors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| v | v := (Vector x: 1 y: 2) + (Vector x: 10 y: 20). v x", int64(11)},
+		{"| v | v := (Vector x: 1 y: 2) + (Vector x: 10 y: 20). v y", int64(22)},
+		{"((Vector x: 1 y: 2) + (Vector x: 3 y: 4) + (Vector x: 5 y: 6)) x", int64(9)},
+		{"(Vector x: 1 y: 0) < (Vector x: 2 y: 0)", true},
+		{"(Vector x: 1 y: 0) = (Vector x: 1 y: 5)", true},
+		// Identity is not a method and still compares objects
+		{"(Vector x: 1 y: 0) == (Vector x: 1 y: 0)", false},
+		// A binary method can reach the superclass version with super
+		{"(Vector3 new setX: 1 y: 2) + (Vector x: 3 y: 4)", int64(406)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, vectorClass+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	// Selectors the class doesn't define report the class, not a TypeError
+	err := runSourceError(t, vectorClass+"(Vector x: 1 y: 2) - (Vector x: 1 y: 2)")
+	if err == nil || !strings.Contains(err.Error(), "instance of Vector does not understand message '-'") {
+		t.Errorf("Expected a does not understand error for -, got %v", err)
+	}
+}
+
+// TestVMPrintString tests the default printString rendering
+func TestVMPrintString(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"42 printString", "42"},
+		{"nil printString", "nil"},
+		{"true printString", "true"},
+		{"'abc' printString", "'abc'"},
+		{"#(1 'a' #(2 nil)) printString", "#(1 'a' #(2 nil))"},
+		{"Object subclass: #Item [ ]\nItem new printString", "an Item"},
+		{"Object subclass: #Counter [ ]\nCounter new printString", "a Counter"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// printingClasses defines classes that print their own way: Money with a
+// printString method, Tag with a printOn: that extends Object's, and Euro
+// with a printString that builds on its superclass's
+const printingClasses = `Object subclass: #Money [
+    | cents |
+    cents: c [ cents := c ]
+    printString [ ^'$' , cents printString ]
+]
+Object subclass: #Tag [
+    | name |
+    name: n [ name := n ]
+    printOn: aStream [ super printOn: aStream. aStream nextPutAll: '<' , name , '>' ]
+]
+Money subclass: #Euro [
+    printString [ | s | s := super printString. ^'EUR ' , s ]
+]
+Object subclass: #Broken [ printString [ ^42 ] ]
+Object subclass: #Item [ ]
+`
+
+// TestVMPrintStringOverrides tests that an instance whose class defines
+// printString or printOn: prints that way wherever it appears, including
+// inside collections
+func TestVMPrintStringOverrides(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"{Money new cents: 5. 1} printString", "#($5 1)"},
+		{"{Tag new name: 'x'} printString", "#(a Tag<x>)"},
+		{"{{Money new cents: 5}} printString", "#(#($5))"},
+		{"{Item new. Euro new cents: 3} printString", "#(an Item EUR $3)"},
+		{"(Tag new name: 'x') printString", "a Tag<x>"},
+		{"(Tag new name: 'x') displayString", "a Tag<x>"},
+		{"(Set new add: (Money new cents: 7); yourself) printString", "a Set($7)"},
+		{"#{'k' -> (Tag new name: 'y')} printString", "a Dictionary('k'->a Tag<y>)"},
+		{"String streamContents: [:s | s print: (Money new cents: 2); << (Tag new name: 'z')]", "$2a Tag<z>"},
+		// printOn: without an override writes the printString
+		{"String streamContents: [:s | 3 printOn: s. 'a' printOn: s. Item new printOn: s]", "3'a'an Item"},
+		// A printString that doesn't answer a String falls back to the default
+		{"{Broken new} printString", "#(a Broken)"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, printingClasses+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	output := runSourceOutput(t, printingClasses+"(Money new cents: 5) println. {Tag new name: 'x'} println.")
+	if expected := "$5\n#(a Tag<x>)\n"; output != expected {
+		t.Errorf("Expected println to use the overrides, printing %q, got %q", expected, output)
+	}
+
+	err := runSourceError(t, "3 printOn: 'not a stream'")
+	if err == nil || !strings.Contains(err.Error(), "printOn: argument must be a WriteStream") {
+		t.Errorf("Expected a WriteStream argument error, got %v", err)
+	}
+}
+
+// TestVMPrintStringLimits tests that printString cuts large and deeply
+// nested collections short, and leaves small ones whole
+func TestVMPrintStringLimits(t *testing.T) {
+	// The default limit shows the first 100 elements of a long array
+	long := runSource(t, "| a | a := #(1). 150 timesRepeat: [a := a , #(2)]. a printString")
+	expected := "#(1" + strings.Repeat(" 2", 99) + " ...)"
+	if long != expected {
+		t.Errorf("Expected a 150 element array to show 100 elements and an ellipsis, got %v", long)
+	}
+	if result := runSource(t, "#(1 2 3) printString"); result != "#(1 2 3)" {
+		t.Errorf("Expected a small array to print fully, got %v", result)
+	}
+
+	nested := &Array{Elements: []interface{}{int64(1), &Array{Elements: []interface{}{int64(2),
+		&Array{Elements: []interface{}{int64(3)}}}}}}
+	dict := newDictionary()
+	for i := int64(1); i <= 4; i++ {
+		inner := newDictionary()
+		inner.AtPut("n", i)
+		dict.AtPut(i, inner)
+	}
+	tests := []struct {
+		elements, depth int
+		value           interface{}
+		expected        string
+	}{
+		{3, 0, &Array{Elements: []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5)}}, "#(1 2 3 ...)"},
+		{3, 0, &Array{Elements: []interface{}{int64(1), int64(2), int64(3)}}, "#(1 2 3)"},
+		{0, 2, nested, "#(1 #(2 #(...)))"},
+		{0, 1, nested, "#(1 #(...))"},
+		{0, 0, nested, "#(1 #(2 #(3)))"},
+		{2, 1, dict, "a Dictionary(1->a Dictionary(...) 2->a Dictionary(...) ...)"},
+		{-1, -1, dict, "a Dictionary(1->a Dictionary('n'->1) 2->a Dictionary('n'->2) 3->a Dictionary('n'->3) 4->a Dictionary('n'->4))"},
+		{1, 0, "a long string is not a collection", "'a long string is not a collection'"},
+	}
+	for _, tt := range tests {
+		v := New()
+		v.SetPrintLimits(tt.elements, tt.depth)
+		if result := v.printString(tt.value); result != tt.expected {
+			t.Errorf("limits %d/%d: expected %q, got %q", tt.elements, tt.depth, tt.expected, result)
+		}
+	}
+}
+
+// TestVMPrintOpaqueObjects tests that objects without a literal form print
+// a readable description rather than a Go pointer, both as printString and
+// with println
+func TestVMPrintOpaqueObjects(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"[:x | x] printString", "a Block"},
+		{"[] displayString", "a Block"},
+		{"WriteStream new printString", "a WriteStream"},
+		{"(Hasher sha256) printString", "a Hasher (sha256)"},
+		{"{[1]. WriteStream new} printString", "#(a Block a WriteStream)"},
+		// Inside other collections too, as keys, values and elements
+		{"| d | d := #{}. d at: [1] put: [2]. d printString", "a Dictionary(a Block->a Block)"},
+		{"(Bag new add: [1]; add: WriteStream new; yourself) printString", "a Bag(a Block:1 a WriteStream:1)"},
+		{"(Set new add: [1]; yourself) printString", "a Set(a Block)"},
+		{"Bag printString", "Bag"},
+		{"Object subclass: #Item [ ]\nItem printString", "Item"},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	output := runSourceOutput(t, `
+Object subclass: #Item [ ]
+[:x | x] println.
+WriteStream new println.
+Item new println.
+Item println.
+#(1 'a') println.
+nil println.
+'text' print.
+`)
+	expected := "a Block\na WriteStream\nan Item\nItem\n#(1 'a')\nnil\ntext"
+	if output != expected {
+		t.Errorf("Expected println to show readable descriptions:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestVMDisplayString(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"'abc' displayString", "abc"},
+		{"42 displayString", "42"},
+		{"#('abc' 1) displayString", "#('abc' 1)"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %q, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMMethodsInProtocol tests grouping a class's methods by the
+// protocols their <category: '...'> annotations name
+func TestVMMethodsInProtocol(t *testing.T) {
+	classes := `
+Object subclass: #Account [
+    | balance |
+    balance [ <category: 'accessing'> ^balance ]
+    balance: n [ <category: 'accessing'> balance := n ]
+    deposit: n [ <category: 'operations'> balance := balance + n ]
+    reset [ balance := 0 ]
+]
+Account subclass: #Savings [
+    rate [ <category: 'accessing'> ^5 ]
+]
+`
+	tests := []struct {
+		source   string
+		expected *Array
+	}{
+		{"Account methodsInProtocol: 'accessing'", &Array{Elements: []interface{}{"balance", "balance:"}}},
+		{"Account methodsInProtocol: 'operations'", &Array{Elements: []interface{}{"deposit:"}}},
+		{"Account methodsInProtocol: 'printing'", &Array{Elements: []interface{}{}}},
+		// Only the class's own methods are listed
+		{"Savings methodsInProtocol: 'accessing'", &Array{Elements: []interface{}{"rate"}}},
+		// Protocols only organize methods; sends find them as usual
+		{"| a | a := Account new. a reset. a deposit: 3. {a balance}", &Array{Elements: []interface{}{int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMCompileRedefinesMethods tests that compile: adds and replaces
+// methods on an existing class, and that instances created before the
+// change use the new methods
+func TestVMCompileRedefinesMethods(t *testing.T) {
+	classes := `
+Object subclass: #Counter [
+    | count |
+    count [ ^count ]
+    reset [ count := 0 ]
+    increment [ count := count + 1 ]
+]
+Counter subclass: #Stepper [
+    | step |
+]
+| c s |
+c := Counter new.
+c reset.
+c increment.
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// An existing instance picks up the replaced method
+		{"Counter compile: 'increment [ count := count + 2 ]'. c increment. c count", int64(3)},
+		{"Counter compile: 'increment [ count := count + 2 ]'", "increment"},
+		// New methods, including class methods, can be added
+		{"Counter compile: 'double [ ^count * 2 ]'. c double", int64(2)},
+		{"Counter compile: '<zero [ | z | z := self new. z reset. ^z ]>'. Counter zero count", int64(0)},
+		// Subclass methods see inherited and own fields in the right slots
+		{"Stepper compile: 'step: n [ step := n ]'. Stepper compile: 'advance [ count := count + step ]'. s := Stepper new. s reset. s step: 5. s advance. s count", int64(5)},
+		// Subclasses inherit a method redefined on their superclass
+		{"s := Stepper new. s reset. Counter compile: 'increment [ count := count + 10 ]'. s increment. s count", int64(10)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []string{
+		"Counter compile: 'increment ['",
+		"Counter compile: 'increment [ ^1 ] extra'",
+		"Counter compile: 42",
+	}
+	for _, source := range errorTests {
+		if err := runSourceError(t, classes+source); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
+
+// TestVMCaseOf tests caseOf: and caseOf:otherwise: dispatch on the
+// receiver, for matches and misses
+func TestVMCaseOf(t *testing.T) {
+	classes := `
+Object subclass: #Grader [
+    grade: n [ ^n caseOf: #{1 -> [^'low']. 2 -> [^'high']} otherwise: ['none'] ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		// A match runs the block stored under the receiver
+		{"2 caseOf: #{1 -> ['one']. 2 -> ['two']}", "two"},
+		{"'b' caseOf: #{'a' -> [1]. 'b' -> [2]} otherwise: [0]", int64(2)},
+		{"#(1 2) caseOf: #{#(1 2) -> ['pair']}", "pair"},
+		{"nil caseOf: #{nil -> ['nothing']}", "nothing"},
+		// Only the matching block runs
+		{"| hits | hits := 0. 1 caseOf: #{1 -> [hits := hits + 1]. 2 -> [hits := hits + 10]}. hits", int64(1)},
+		// A miss runs otherwise
+		{"5 caseOf: #{1 -> ['one']. 2 -> ['two']} otherwise: ['many']", "many"},
+		{"Grader new caseOf: #{} otherwise: ['instance']", "instance"},
+		// ^ inside a case returns from the enclosing method
+		{"Grader new grade: 2", "high"},
+		{"Grader new grade: 7", "none"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		source  string
+		message string
+	}{
+		// A miss without otherwise is an error
+		{"3 caseOf: #{1 -> ['one']}", "caseOf: no case for 3"},
+		{"3 caseOf: #(1 2)", "cases must be a Dictionary"},
+		{"1 caseOf: #{1 -> 'one'}", "must be a block"},
+		{"3 caseOf: #{} otherwise: 'none'", "argument must be a block"},
+	}
+	for _, tt := range errorTests {
+		err := runSourceError(t, classes+tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.source, tt.message, err)
+		}
+	}
+}
+
+// TestVMSuperFallsBackToPrimitives tests that super reaches the primitive
+// implementation of a selector no superclass defines as a method
+func TestVMSuperFallsBackToPrimitives(t *testing.T) {
+	classes := `
+Object subclass: #Account [
+    | balance |
+    printString [ | inherited | inherited := super printString. ^inherited , ' with balance' ]
+    yourself [ ^super yourself ]
+    unknown [ ^super frobnicate ]
+]
+Account subclass: #SavingsAccount [
+    printString [ | inherited | inherited := super printString. ^'Savings: ' , inherited ]
+]
+Object subclass: #Plain [ ]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"Plain new printString", "a Plain"},
+		{"Account new printString", "an Account with balance"},
+		// The superclass method wins over the primitive when it exists
+		{"SavingsAccount new printString", "Savings: a SavingsAccount with balance"},
+		{"| a | a := Account new. a yourself == a", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, classes+"Account new unknown")
+	if err == nil || !strings.Contains(err.Error(), "superclass of Account does not understand message 'frobnicate'") {
+		t.Errorf("Expected a does not understand error for frobnicate, got %v", err)
+	}
+}
+
+// TestVMUserBinarySelectors tests that a binary selector a class defines
+// works like any other message: chained, cascaded and sent to super
+func TestVMUserBinarySelectors(t *testing.T) {
+	classes := `
+Object subclass: #Buffer [
+    | items |
+    items [ items isNil ifTrue: [^#()]. ^items ]
+    << item [ items := self items , {item} ]
+]
+Buffer subclass: #Tagged [
+    << item [ ^super << ('#' , item) ]
+]
+Buffer subclass: #Doubled [
+    << item [ super << item; << item ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"(Buffer new << 'a' << 'b') items", &Array{Elements: []interface{}{"a", "b"}}},
+		{"| b | b := Buffer new. b << 'a'; << 'b'. b items", &Array{Elements: []interface{}{"a", "b"}}},
+		{"(Tagged new << 'a'; << 'b'; yourself) items", &Array{Elements: []interface{}{"#a", "#b"}}},
+		{"(Doubled new << 1; yourself) items", ints(1, 1)},
+		// Every message in a super cascade starts above the current class
+		{"(Doubled new << 1; << 2; yourself) items", ints(1, 1, 2, 2)},
+		{"(String streamContents: [:s | s << 'n = ' << 3; << '!'])", "n = 3!"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "3 << 4")
+	if err == nil || !strings.Contains(err.Error(), "<<") {
+		t.Errorf("Expected an error sending << to an integer, got %v", err)
+	}
+}
+
+// TestVMFieldDefaults tests that fields declared with a default start
+// with it on every new instance, without an initialize method
+func TestVMFieldDefaults(t *testing.T) {
+	classes := `
+Object subclass: #Counter [
+    | count = 0 step = 1 label items = (Set new) |
+    bump [ count := count + step ]
+    count [ ^count ]
+    label [ ^label ]
+    items [ ^items ]
+]
+Counter subclass: #Limited [
+    | limit = (count + 10) |
+    limit [ ^limit ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"Counter new count", int64(0)},
+		{"(Counter new bump; bump; yourself) count", int64(2)},
+		{"Counter new label", nil},
+		// Defaults are worked out again for each instance
+		{"Counter new items == Counter new items", false},
+		{"| c | c := Counter new. c items add: 1. Counter new items size", int64(0)},
+		// Subclasses get the inherited defaults first, and can use them
+		{"Limited new count", int64(0)},
+		{"Limited new limit", int64(10)},
+		// new sent as a message, not just the NEW_OBJECT fast path
+		{"| class | class := Counter. class new count", int64(0)},
+		{"Counter new printString", "a Counter"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); !valuesEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	err := runSourceError(t, "Object subclass: #Broken [ | x = (nil frobnicate) | ]\nBroken new")
+	if err == nil || !strings.Contains(err.Error(), "frobnicate") {
+		t.Errorf("Expected the failing default to be reported, got %v", err)
+	}
+}
+
+// TestVMBlocksCaptureTheirDefiningScope tests that a block returned from a
+// method keeps reading and writing that method's variables and self when
+// it is called later, from elsewhere
+func TestVMBlocksCaptureTheirDefiningScope(t *testing.T) {
+	classes := `
+Object subclass: #Factory [
+    | base |
+    base: n [ base := n ]
+    counter [ | count | count := 0. ^[count := count + 1. count] ]
+    adder [ ^[:n | base := base + n. base] ]
+    summer [ | total | total := 0. ^[#(1 2 3) do: [:x | total := total + x]. total] ]
+    owner [ ^[self] ]
+]
+Object subclass: #Runner [
+    run: aBlock [ | count | count := 100. ^aBlock value ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| c | c := Factory new counter. c value. c value. c value", int64(3)},
+		// Each call of the method captures its own variables
+		{"| f c d | f := Factory new. c := f counter. d := f counter. c value. c value. d value", int64(1)},
+		// Calling the block doesn't disturb the caller's locals
+		{"| x c | x := 42. c := Factory new counter. c value. c value. x", int64(42)},
+		// Nor does running it inside another method with its own locals
+		{"| c | c := Factory new counter. c value. Runner new run: c", int64(2)},
+		{"| f a | f := Factory new. f base: 10. a := f adder. a value: 1. a value: 2", int64(13)},
+		// Blocks nested in a captured block share its scope
+		{"| s | s := Factory new summer. s value. s value", int64(12)},
+		{"| f | f := Factory new. (Runner new run: f owner) == f", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMBlockCallsHaveTheirOwnFrames tests that each call of a block gets
+// its own parameters and temporaries, so blocks created in a loop capture
+// the value of their own iteration and recursive calls don't overwrite
+// each other's arguments
+func TestVMBlockCallsHaveTheirOwnFrames(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| bs | bs := (1 to: 3) collect: [:i | [i]]. (bs collect: [:b | b value]) printString", "#(1 2 3)"},
+		{"| bs | bs := #(1 2 3) collect: [:x | | t | t := x * 10. [t]]. (bs collect: [:b | b value]) printString", "#(10 20 30)"},
+		// Captured blocks still share the variables of the enclosing method
+		{"| n bs | n := 0. bs := (1 to: 3) collect: [:i | [n := n + i]]. bs do: [:b | b value]. n", int64(6)},
+		{"| fact | fact := [:n | n <= 1 ifTrue: [1] ifFalse: [n * (fact value: n - 1)]]. fact value: 5", int64(120)},
+		{"| total | total := 0. #(1 2 3) do: [:x | #(10 20) do: [:y | total := total + (x * y)]]. total", int64(180)},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMCascadeAssignment pins how assignment, cascades and yourself work
+// together: a cascade answers its receiver whatever its last message is,
+// so the variable holds the collection rather than what add: answered
+func TestVMCascadeAssignment(t *testing.T) {
+	classes := `
+Object subclass: #OrderedCollection [
+    | items |
+    add: x [ items = nil ifTrue: [items := {}]. items := items , {x}. ^x ]
+    size [ items = nil ifTrue: [^0]. ^items size ]
+]
+`
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| c | c := OrderedCollection new add: 1; add: 2; yourself. c size", int64(2)},
+		{"| c | c := OrderedCollection new add: 1; add: 2. c size", int64(2)},
+		{"| c | c := OrderedCollection new add: 1; add: 2; yourself. c isInteger", false},
+		// Without a cascade the variable holds add:'s answer
+		{"| c | c := OrderedCollection new add: 7. c", int64(7)},
+		// yourself answers the receiver
+		{"| c | c := OrderedCollection new. c yourself == c", true},
+		{"| c d | c := OrderedCollection new. d := c add: 1; yourself. d == c", true},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, classes+tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+}
+
+// TestVMConditionalAssignment tests that a conditional's value can be
+// assigned, including the nil a single-branch conditional answers when
+// its branch doesn't run
+func TestVMConditionalAssignment(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"| x y | x := 5. y := (x > 0) ifTrue: [1] ifFalse: [-1]. y", int64(1)},
+		{"| x y | x := -5. y := (x > 0) ifTrue: [1] ifFalse: [-1]. y", int64(-1)},
+		{"| x y | x := 5. y := (x > 0) ifTrue: [1]. y", int64(1)},
+		{"| x y | x := -5. y := (x > 0) ifTrue: [1]. y", nil},
+		{"| x y | x := -5. y := (x > 0) ifFalse: ['not positive']. y", "not positive"},
+		{"| x y | x := 5. y := 0. y := (x > 0) ifFalse: [1]. y", nil},
+		// The stack is left balanced, so the following statements still see their values
+		{"| x y z | x := 5. y := (x > 0) ifTrue: [1] ifFalse: [-1]. z := y + 10. z", int64(11)},
+		// Nested conditionals
+		{"| x y | x := 0. y := (x > 0) ifTrue: ['positive'] ifFalse: [(x < 0) ifTrue: ['negative'] ifFalse: ['zero']]. y", "zero"},
+	}
+
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	// In a method, the assigned value can be returned
+	classes := `
+Object subclass: #Sign [
+    of: x [ | s | s := x < 0 ifTrue: [-1] ifFalse: [1]. ^s ]
+]
+`
+	if result := runSource(t, classes+"(Sign new of: -3) + (Sign new of: 4)"); result != int64(0) {
+		t.Errorf("Expected -1 + 1 = 0 from a method, got %v", result)
+	}
+}
+
+// TestVMNilReceiver tests that nil answers the messages every object
+// understands, and that anything else reports the nil receiver clearly
+func TestVMNilReceiver(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{"nil isNil", true},
+		{"nil printString", "nil"},
+		{"nil = nil", true},
+		{"| x | x isNil", true},
+	}
+	for _, tt := range tests {
+		if result := runSource(t, tt.source); result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.source, tt.expected, result)
+		}
+	}
+
+	if output := runSourceOutput(t, "nil println"); output != "nil\n" {
+		t.Errorf("Expected nil println to print nil, got %q", output)
+	}
+
+	for _, source := range []string{"nil foo", "| x | x foo", "| x | x foo: 1 bar: 2"} {
+		err := runSourceError(t, source)
+		if err == nil || !strings.Contains(err.Error(), "nil does not understand") ||
+			!strings.Contains(err.Error(), "used before it was assigned") {
+			t.Errorf("%s: expected a nil does not understand error, got %v", source, err)
+		}
+	}
+	err := runSourceError(t, "nil foo")
+	if err == nil || !strings.Contains(err.Error(), "nil does not understand 'foo'") {
+		t.Errorf("Expected the error to name the selector, got %v", err)
+	}
+}
+
+// TestReset tests that Reset forgets what earlier runs defined, while
+// without it globals and classes carry over to the next Run
+func TestReset(t *testing.T) {
+	compile := func(source string) *bytecode.Bytecode {
+		program, err := parser.New(source).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		bc, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		return bc
+	}
+	first := compile("Object subclass: #Tally [ answer [ ^42 ] ]\nTransferTotal := 5")
+
+	v := New()
+	if err := v.Run(first); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	if err := v.Run(compile("TransferTotal + Tally new answer")); err != nil {
+		t.Fatalf("Expected the global and class to persist, got %v", err)
+	}
+	if got := v.StackTop(); got != int64(47) {
+		t.Errorf("Expected 47, got %v", got)
+	}
+
+	v.Reset()
+	for _, source := range []string{"TransferTotal", "Tally new"} {
+		err := v.Run(compile(source))
+		if err == nil || !strings.Contains(err.Error(), "undefined global variable") {
+			t.Errorf("%s: expected an undefined global after Reset, got %v", source, err)
+		}
+	}
+
+	// The prelude and built-in classes are back, extensions are gone, and
+	// settings are kept
+	v.SetDivisionMode(DivideExact)
+	if err := v.Run(compile("Integer extend [ double [ ^self * 2 ] ]\n3 double")); err != nil {
+		t.Fatalf("VM error: %v", err)
+	}
+	v.Reset()
+	if err := v.Run(compile("(OrderedCollection new add: 1; yourself) size + (Set new size) + (1 / 2)")); err != nil {
+		t.Fatalf("Expected the prelude after Reset, got %v", err)
+	}
+	if got, ok := v.StackTop().(*big.Rat); !ok || got.String() != "3/2" {
+		t.Errorf("Expected 3/2, got %v", v.StackTop())
+	}
+	if err := v.Run(compile("3 double")); err == nil || !strings.Contains(err.Error(), "unknown message: double") {
+		t.Errorf("Expected the extension to be gone after Reset, got %v", err)
+	}
+
+	// A VM without the prelude stays without it
+	v = New()
+	v.SetPrelude(false)
+	v.Reset()
+	if err := v.Run(compile("OrderedCollection new")); err == nil {
+		t.Errorf("Expected OrderedCollection to stay undefined without the prelude")
+	}
+}
@@ -440,3 +440,110 @@ func TestEmptyFile(t *testing.T) {
 		t.Error("Expected error when loading empty file, got nil")
 	}
 }
+
+// TestReadInfoMatchesBytecode tests that the summary of a compiled .sg
+// file reports the same counts as the bytecode that was written
+func TestReadInfoMatchesBytecode(t *testing.T) {
+	source := `
+Object subclass: #Counter [
+    | count |
+    increment [ count := count + 1. ^count ]
+    <make [ ^Counter new ]>
+]
+| c total |
+c := Counter make.
+total := 0.
+#(1 2 3) do: [:each | total := total + each].
+[:x | [:y | x + y]] value: 1.
+total println.
+`
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	bc, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	tmpFile := filepath.Join(t.TempDir(), "info.sg")
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	if err := bytecode.Encode(bc, file); err != nil {
+		file.Close()
+		t.Fatalf("Encode failed: %v", err)
+	}
+	file.Close()
+
+	file, err = os.Open(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to open temp file: %v", err)
+	}
+	defer file.Close()
+	info, err := bytecode.ReadInfo(file)
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+
+	if info.Version != bytecode.FormatVersion || info.Flags != 0 {
+		t.Errorf("Expected version %d with no flags, got %d and 0x%X", bytecode.FormatVersion, info.Version, info.Flags)
+	}
+	if info.Constants != len(bc.Constants) {
+		t.Errorf("Expected %d constants, got %d", len(bc.Constants), info.Constants)
+	}
+	if info.Instructions != len(bc.Instructions) {
+		t.Errorf("Expected %d instructions, got %d", len(bc.Instructions), info.Instructions)
+	}
+	if info.HasLineTable || info.HasChecksum {
+		t.Errorf("Expected no line table or checksum in a version 1 file, got %+v", info)
+	}
+
+	// The histogram covers the top-level code, the two methods and the
+	// three blocks
+	expected := map[bytecode.Opcode]int{}
+	var collect func(code *bytecode.Bytecode)
+	collect = func(code *bytecode.Bytecode) {
+		for _, inst := range code.Instructions {
+			expected[inst.Op]++
+		}
+		for _, c := range code.Constants {
+			switch v := c.(type) {
+			case *bytecode.Bytecode:
+				collect(v)
+			case *bytecode.ClassDefinition:
+				for _, m := range append(append([]*bytecode.MethodDefinition{}, v.Methods...), v.ClassMethods...) {
+					collect(m.Code)
+				}
+			}
+		}
+	}
+	collect(bc)
+	if len(info.OpcodeCounts) != len(expected) {
+		t.Errorf("Expected %d distinct opcodes, got %d: %v", len(expected), len(info.OpcodeCounts), info.OpcodeCounts)
+	}
+	total := 0
+	for op, n := range expected {
+		total += n
+		if info.OpcodeCounts[op] != n {
+			t.Errorf("Expected %d %s, got %d", n, op, info.OpcodeCounts[op])
+		}
+	}
+	if info.TotalInstructions() != total {
+		t.Errorf("Expected %d instructions in total, got %d", total, info.TotalInstructions())
+	}
+	if info.OpcodeCounts[bytecode.OpDefineClass] != 1 || info.OpcodeCounts[bytecode.OpMakeClosure] != 3 {
+		t.Errorf("Expected 1 DEFINE_CLASS and 3 MAKE_CLOSURE, got %v", info.OpcodeCounts)
+	}
+}
+
+// TestReadInfoRejectsBadFiles tests that ReadInfo validates the header
+func TestReadInfoRejectsBadFiles(t *testing.T) {
+	for _, data := range []string{"", "NOPE", "SMOG"} {
+		if _, err := bytecode.ReadInfo(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: expected error, got nil", data)
+		}
+	}
+}
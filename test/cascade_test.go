@@ -0,0 +1,94 @@
+package test
+
+import (
+	"testing"
+)
+
+// collectionClass is a minimal growable collection whose add: answers the
+// added element, like Smalltalk's OrderedCollection.
+const collectionClass = `
+	Object subclass: #OrderedCollection [
+		| items count |
+
+		add: x [
+			items = nil ifTrue: [items := #(nil nil nil nil nil nil nil nil). count := 0].
+			count := count + 1.
+			items at: count put: x.
+			^x
+		]
+		size [ ^count ]
+		at: i [ ^items at: i ]
+	]
+`
+
+// TestCascade_KeywordMessagesShareReceiver tests that every cascade segment
+// goes to the collection rather than to the result of the previous add:.
+func TestCascade_KeywordMessagesShareReceiver(t *testing.T) {
+	result := evalSource(t, collectionClass+`
+		| c |
+		c := OrderedCollection new add: 1; add: 2; yourself.
+		c size
+	`)
+	if result != int64(2) {
+		t.Errorf("Expected collection with 2 elements, got size %v", result)
+	}
+
+	result = evalSource(t, collectionClass+`
+		(OrderedCollection new add: 1; add: 2; yourself) at: 2
+	`)
+	if result != int64(2) {
+		t.Errorf("Expected second element 2, got %v", result)
+	}
+}
+
+// TestCascade_MixedUnaryAndKeywordSegments tests cascades that interleave
+// unary, binary-argument and keyword segments.
+func TestCascade_MixedUnaryAndKeywordSegments(t *testing.T) {
+	result := evalSource(t, collectionClass+`
+		| c |
+		c := OrderedCollection new add: 10; size; add: 3 + 4; size; add: (OrderedCollection new add: 99; yourself) size; yourself.
+		(c size * 100) + (c at: 2) + (c at: 3)
+	`)
+	if result != int64(300+7+1) {
+		t.Errorf("Expected 308, got %v", result)
+	}
+}
+
+// recorderClass is a stub whose foo answers a fresh Recorder and counts
+// how often it was called, so tests can see where cascades are sent.
+const recorderClass = `
+	Object subclass: #Recorder [
+		| log calls |
+
+		foo [ calls := (calls = nil ifTrue: [0] ifFalse: [calls]) + 1. ^Recorder new ]
+		calls [ ^calls ]
+		bar: x [ log := (log = nil ifTrue: [0] ifFalse: [log]) * 10 + x ]
+		baz: x [ log := (log = nil ifTrue: [0] ifFalse: [log]) * 10 + x ]
+		log [ ^log ]
+	]
+`
+
+// TestCascade_UnaryThenKeyword tests that in obj foo bar: 1; baz: 2 both
+// keyword messages go to the result of obj foo, which is computed once.
+func TestCascade_UnaryThenKeyword(t *testing.T) {
+	result := evalSource(t, recorderClass+`
+		| obj inner |
+		obj := Recorder new.
+		inner := obj foo bar: 1; baz: 2; yourself.
+		inner log * 10 + obj calls
+	`)
+	if result != int64(121) {
+		t.Errorf("Expected both keyword messages on one foo result (log 12, 1 call), got %v", result)
+	}
+
+	// obj itself received only foo
+	result = evalSource(t, recorderClass+`
+		| obj |
+		obj := Recorder new.
+		obj foo bar: 1; baz: 2.
+		obj log
+	`)
+	if result != nil {
+		t.Errorf("Expected obj to receive no keyword messages, got log %v", result)
+	}
+}
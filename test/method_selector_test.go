@@ -0,0 +1,144 @@
+package test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/kristofer/smog/pkg/ast"
+	"github.com/kristofer/smog/pkg/compiler"
+	"github.com/kristofer/smog/pkg/parser"
+	"github.com/kristofer/smog/pkg/vm"
+)
+
+// evalSource parses, compiles, and runs source, returning the final stack value.
+func evalSource(t *testing.T, source string) interface{} {
+	t.Helper()
+
+	p := parser.New(source)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	c := compiler.New()
+	bytecode, err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	v := vm.New()
+	if err := v.Run(bytecode); err != nil {
+		t.Fatalf("Runtime error: %v", err)
+	}
+	return v.StackTop()
+}
+
+const moneyClass = `
+	Object subclass: #Money [
+		| amount |
+
+		amount [ ^amount ]
+		amount: a [ amount := a ]
+
+		+ other [
+			^Money new amount: amount + other amount
+		]
+
+		* factor [
+			| scaled |
+			scaled := amount * factor.
+			^Money new amount: scaled
+		]
+
+		< other [ ^amount < other amount ]
+
+		between: lo and: hi [
+			| low high |
+			low := lo amount.
+			high := hi amount.
+			^(amount >= low) ifTrue: [amount <= high] ifFalse: [false]
+		]
+
+		<cents: c [ ^Money new amount: c ]>
+	]
+`
+
+// TestBinaryMethod_Plus tests a user-defined binary + method.
+func TestBinaryMethod_Plus(t *testing.T) {
+	result := evalSource(t, moneyClass+`
+		| a b |
+		a := Money cents: 5.
+		b := Money cents: 7.
+		(a + b) amount
+	`)
+	if result != int64(12) {
+		t.Errorf("Expected 12, got %v", result)
+	}
+}
+
+// TestBinaryMethod_WithLocals tests that a binary method's parameter and
+// locals get distinct slots.
+func TestBinaryMethod_WithLocals(t *testing.T) {
+	result := evalSource(t, moneyClass+`
+		((Money cents: 6) * 7) amount
+	`)
+	if result != int64(42) {
+		t.Errorf("Expected 42, got %v", result)
+	}
+}
+
+// TestBinaryMethod_Less tests a binary < method, which starts like a class method.
+func TestBinaryMethod_Less(t *testing.T) {
+	result := evalSource(t, moneyClass+`
+		(Money cents: 1) < (Money cents: 2)
+	`)
+	if result != true {
+		t.Errorf("Expected true, got %v", result)
+	}
+
+	p := parser.New(moneyClass)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	class := program.Statements[0].(*ast.Class)
+	if len(class.ClassMethods) != 1 || class.ClassMethods[0].Name != "cents:" {
+		t.Errorf("Expected only cents: as a class method, got %d class methods", len(class.ClassMethods))
+	}
+	found := false
+	for _, m := range class.Methods {
+		if m.Name == "<" {
+			found = true
+			if len(m.Parameters) != 1 || m.Parameters[0] != "other" {
+				t.Errorf("Expected < to take parameter 'other', got %v", m.Parameters)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected < to be parsed as an instance method")
+	}
+}
+
+// TestKeywordMethod_BetweenAnd tests a keyword method using both parameters.
+func TestKeywordMethod_BetweenAnd(t *testing.T) {
+	tests := []struct {
+		value    int
+		expected bool
+	}{
+		{5, true},
+		{10, true},
+		{20, true},
+		{4, false},
+		{21, false},
+	}
+
+	for _, tt := range tests {
+		source := moneyClass + `
+			(Money cents: ` + strconv.Itoa(tt.value) + `) between: (Money cents: 5) and: (Money cents: 20)
+		`
+		result := evalSource(t, source)
+		if result != tt.expected {
+			t.Errorf("between:and: for %d: expected %v, got %v", tt.value, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMixedClassesAndTopLevel tests that a file defining classes and then
+// using them at top level runs end to end
+func TestMixedClassesAndTopLevel(t *testing.T) {
+	source, err := os.ReadFile("stack_test.smog")
+	if err != nil {
+		t.Fatalf("Failed to read stack_test.smog: %v", err)
+	}
+	if result := evalSource(t, string(source)); result != int64(321) {
+		t.Errorf("Expected the stack to pop 3, 2, 1 (321), got %v", result)
+	}
+
+	output := runSmogFile(t, "test/stack_test.smog")
+	if !strings.Contains(output, "Popped: 321") {
+		t.Errorf("Expected the program to print Popped: 321, got %q", output)
+	}
+}
+
+// TestClassesDefinedAfterTopLevelCode tests that every class in a file is
+// defined before top-level code runs, wherever the class is written
+func TestClassesDefinedAfterTopLevelCode(t *testing.T) {
+	result := evalSource(t, `
+		| c |
+		c := Counter new.
+		c increment; increment.
+		c count.
+
+		Object subclass: #Counter [
+			| count |
+			increment [ count := self count + 1 ]
+			count [ count isNil ifTrue: [^0]. ^count ]
+		]
+	`)
+	if result != int64(2) {
+		t.Errorf("Expected a class written after the code using it to be defined, got %v", result)
+	}
+
+	result = evalSource(t, `
+		(Puppy new name: 'Rex') describe.
+
+		Dog subclass: #Puppy [
+			describe [ ^'puppy ' , name ]
+		]
+
+		Object subclass: #Dog [
+			| name |
+			name: aName [ name := aName ]
+		]
+	`)
+	if result != "puppy Rex" {
+		t.Errorf("Expected a subclass written before its superclass to inherit its fields, got %v", result)
+	}
+}
@@ -24,6 +24,7 @@ func runSmogFile(t *testing.T, relpath string) string {
 	
 	cmd := exec.Command("go", "run", cmdPath, smogFile)
 	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "SMOG_CACHE_DIR="+t.TempDir()) // Cache bytecode away from the user's cache
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("Failed to run %s: %v\nOutput: %s", relpath, err, string(output))
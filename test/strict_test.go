@@ -0,0 +1,72 @@
+// Package test provides integration tests for smog's strict mode.
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runSmog runs the smog CLI with args and returns its stdout, stderr and
+// exit error (nil on success).
+func runSmog(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	root := filepath.Dir(wd)
+
+	cmd := exec.Command("go", append([]string{"run", filepath.Join(root, "cmd", "smog")}, args...)...)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "SMOG_CACHE_DIR="+t.TempDir()) // Cache bytecode away from the user's cache
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// TestStrictMode tests that a typo'd variable fails under run --strict but
+// runs, with a warning, without it
+func TestStrictMode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "typo.smog")
+	source := "| count |\ncount := 0.\ncuont := count + 1.\ncount println.\n"
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	warning := "Line 3, Column 1: warning: assignment to undeclared variable 'cuont'"
+
+	stdout, stderr, err := runSmog(t, "run", file)
+	if err != nil {
+		t.Fatalf("Expected the program to run without --strict, got %v\n%s", err, stderr)
+	}
+	if stdout != "0\n" {
+		t.Errorf("Expected output %q, got %q", "0\n", stdout)
+	}
+	if !strings.Contains(stderr, warning) {
+		t.Errorf("Expected warning %q, got %q", warning, stderr)
+	}
+
+	stdout, stderr, err = runSmog(t, "run", "--strict", file)
+	if err == nil {
+		t.Fatalf("Expected run --strict to fail")
+	}
+	if stdout != "" {
+		t.Errorf("Expected the program not to run, got output %q", stdout)
+	}
+	if !strings.Contains(stderr, "Compile error: strict mode: 1 warning(s)") || !strings.Contains(stderr, warning) {
+		t.Errorf("Expected a strict mode compile error, got %q", stderr)
+	}
+
+	// A clean program runs the same either way
+	if err := os.WriteFile(file, []byte("| count |\ncount := 1.\ncount println.\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+	stdout, stderr, err = runSmog(t, "run", "--strict", file)
+	if err != nil || stdout != "1\n" || stderr != "" {
+		t.Errorf("Expected a clean run under --strict, got %v, %q, %q", err, stdout, stderr)
+	}
+}